@@ -0,0 +1,316 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of go-mbali.
+//
+// go-mbali is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-mbali is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-mbali. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mbali/go-mbali/cmd/devp2p/internal/mbltest"
+	"github.com/mbali/go-mbali/common/hexutil"
+	"github.com/mbali/go-mbali/mbl/protocols/mbl"
+	"github.com/mbali/go-mbali/mbl/protocols/snap"
+	"github.com/mbali/go-mbali/p2p"
+	"github.com/mbali/go-mbali/rlp"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	captureCommand = cli.Command{
+		Name:  "capture",
+		Usage: "Commands for captured devp2p messages",
+		Subcommands: []cli.Command{
+			captureDumpCommand,
+			captureReplayCommand,
+		},
+	}
+	captureDumpCommand = cli.Command{
+		Name:      "dump",
+		Usage:     "Decodes and prints the messages of capture files",
+		ArgsUsage: "<capture-file> [ <capture-file> ... ]",
+		Action:    captureDump,
+	}
+	captureReplayCommand = cli.Command{
+		Name:      "replay",
+		Usage:     "Replays the messages of a capture file against a node",
+		ArgsUsage: "<node> <capture-file>",
+		Action:    captureReplay,
+		Flags: []cli.Flag{
+			replayEgressFlag,
+			replayTimingFlag,
+			replayWaitFlag,
+		},
+	}
+)
+
+var (
+	replayEgressFlag = cli.BoolFlag{
+		Name:  "egress",
+		Usage: "Replays the messages sent by the capturing node instead of those it received",
+	}
+	replayTimingFlag = cli.BoolFlag{
+		Name:  "timing",
+		Usage: "Preserves the delays between the captured messages",
+	}
+	replayWaitFlag = cli.DurationFlag{
+		Name:  "wait",
+		Usage: "Time to wait for responses after the last message",
+		Value: 5 * time.Second,
+	}
+)
+
+// captureMsgType describes a message type of a captured protocol.
+type captureMsgType struct {
+	name string
+	new  func() interface{}
+}
+
+// captureMsgTypes are the decodable message types, by protocol and code.
+var captureMsgTypes = map[string]map[uint64]captureMsgType{
+	"": {
+		0x00: {"Hello", func() interface{} { return new(mbltest.Hello) }},
+		0x01: {"Disconnect", func() interface{} { return new(mbltest.Disconnect) }},
+		0x02: {"Ping", func() interface{} { return new(mbltest.Ping) }},
+		0x03: {"Pong", func() interface{} { return new(mbltest.Pong) }},
+	},
+	"mbl/66": {
+		mbl.StatusMsg:                     {"Status", func() interface{} { return new(mbl.StatusPacket) }},
+		mbl.NewBlockHashesMsg:             {"NewBlockHashes", func() interface{} { return new(mbl.NewBlockHashesPacket) }},
+		mbl.TransactionsMsg:               {"Transactions", func() interface{} { return new(mbl.TransactionsPacket) }},
+		mbl.GetBlockHeadersMsg:            {"GetBlockHeaders", func() interface{} { return new(mbl.GetBlockHeadersPacket66) }},
+		mbl.BlockHeadersMsg:               {"BlockHeaders", func() interface{} { return new(mbl.BlockHeadersPacket66) }},
+		mbl.GetBlockBodiesMsg:             {"GetBlockBodies", func() interface{} { return new(mbl.GetBlockBodiesPacket66) }},
+		mbl.BlockBodiesMsg:                {"BlockBodies", func() interface{} { return new(mbl.BlockBodiesPacket66) }},
+		mbl.NewBlockMsg:                   {"NewBlock", func() interface{} { return new(mbl.NewBlockPacket) }},
+		mbl.NewPooledTransactionHashesMsg: {"NewPooledTransactionHashes", func() interface{} { return new(mbl.NewPooledTransactionHashesPacket) }},
+		mbl.GetPooledTransactionsMsg:      {"GetPooledTransactions", func() interface{} { return new(mbl.GetPooledTransactionsPacket66) }},
+		mbl.PooledTransactionsMsg:         {"PooledTransactions", func() interface{} { return new(mbl.PooledTransactionsPacket66) }},
+		mbl.GetNodeDataMsg:                {"GetNodeData", func() interface{} { return new(mbl.GetNodeDataPacket66) }},
+		mbl.NodeDataMsg:                   {"NodeData", func() interface{} { return new(mbl.NodeDataPacket66) }},
+		mbl.GetReceiptsMsg:                {"GetReceipts", func() interface{} { return new(mbl.GetReceiptsPacket66) }},
+		mbl.ReceiptsMsg:                   {"Receipts", func() interface{} { return new(mbl.ReceiptsPacket66) }},
+	},
+	"snap/1": {
+		snap.GetAccountRangeMsg:  {"GetAccountRange", func() interface{} { return new(snap.GetAccountRangePacket) }},
+		snap.AccountRangeMsg:     {"AccountRange", func() interface{} { return new(snap.AccountRangePacket) }},
+		snap.GetStorageRangesMsg: {"GetStorageRanges", func() interface{} { return new(snap.GetStorageRangesPacket) }},
+		snap.StorageRangesMsg:    {"StorageRanges", func() interface{} { return new(snap.StorageRangesPacket) }},
+		snap.GetByteCodesMsg:     {"GetByteCodes", func() interface{} { return new(snap.GetByteCodesPacket) }},
+		snap.ByteCodesMsg:        {"ByteCodes", func() interface{} { return new(snap.ByteCodesPacket) }},
+		snap.GetTrieNodesMsg:     {"GetTrieNodes", func() interface{} { return new(snap.GetTrieNodesPacket) }},
+		snap.TrieNodesMsg:        {"TrieNodes", func() interface{} { return new(snap.TrieNodesPacket) }},
+	},
+}
+
+// describeMsg returns the name of a message and its decoded content as JSON.
+func describeMsg(proto string, code uint64, payload []byte) (name, content string) {
+	typ, ok := captureMsgTypes[proto][code]
+	if !ok {
+		return fmt.Sprintf("%#02x", code), hexutil.Encode(payload)
+	}
+	name = fmt.Sprintf("%s (%#02x)", typ.name, code)
+	msg := typ.new()
+	if err := rlp.DecodeBytes(payload, msg); err != nil {
+		return name, fmt.Sprintf("invalid: %v: %s", err, hexutil.Encode(payload))
+	}
+	enc, err := json.Marshal(msg)
+	if err != nil {
+		return name, fmt.Sprintf("%+v", msg)
+	}
+	return name, string(enc)
+}
+
+// protoName returns the name of a captured protocol for display.
+func protoName(proto string) string {
+	if proto == "" {
+		return "p2p"
+	}
+	return proto
+}
+
+// readCapture reads all records of a capture file.
+func readCapture(file string) ([]*p2p.CaptureRecord, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var (
+		r       = p2p.NewCaptureReader(fd)
+		records []*p2p.CaptureRecord
+	)
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, fmt.Errorf("%s: record %d: %v", file, len(records), err)
+		}
+		records = append(records, rec)
+	}
+}
+
+func captureDump(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return fmt.Errorf("need at least one capture file as argument")
+	}
+	for _, file := range ctx.Args() {
+		records, err := readCapture(file)
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			dir := "->"
+			if rec.Ingress {
+				dir = "<-"
+			}
+			name, content := describeMsg(rec.Protocol, rec.Code, rec.Payload)
+			fmt.Printf("%s %s %s %s %d bytes\n", rec.Timestamp().Format("2006-01-02T15:04:05.000"), dir, protoName(rec.Protocol), name, len(rec.Payload))
+			fmt.Printf("    %s\n", content)
+		}
+	}
+	return nil
+}
+
+func captureReplay(ctx *cli.Context) error {
+	if ctx.NArg() < 2 {
+		return fmt.Errorf("need node and capture file as arguments")
+	}
+	n := getNodeArg(ctx)
+	records, err := readCapture(ctx.Args()[1])
+	if err != nil {
+		return err
+	}
+	// Select the subprotocol messages of the replayed direction. The base
+	// protocol messages are handled by the connection.
+	var (
+		ingress = !ctx.Bool(replayEgressFlag.Name)
+		replay  []*p2p.CaptureRecord
+		caps    []p2p.Cap
+		seen    = make(map[string]bool)
+	)
+	for _, rec := range records {
+		if rec.Ingress != ingress || rec.Protocol == "" {
+			continue
+		}
+		replay = append(replay, rec)
+		if !seen[rec.Protocol] {
+			seen[rec.Protocol] = true
+			caps = append(caps, parseCap(rec.Protocol))
+		}
+	}
+	if len(replay) == 0 {
+		return fmt.Errorf("no messages to replay")
+	}
+	conn, err := mbltest.Dial(n, caps)
+	if err != nil {
+		return fmt.Errorf("handshake failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Print the messages sent by the node while replaying, answering pings.
+	var (
+		writeMu sync.Mutex
+		closed  = make(chan error, 1)
+	)
+	write := func(code uint64, payload []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_, err := conn.Conn.Write(code, payload)
+		return err
+	}
+	go func() {
+		for {
+			code, payload, _, err := conn.Conn.Read()
+			if err != nil {
+				closed <- err
+				return
+			}
+			proto, relCode := replayProto(conn, code)
+			name, _ := describeMsg(proto, relCode, payload)
+			fmt.Printf("%s <- %s %s %d bytes\n", time.Now().Format("2006-01-02T15:04:05.000"), protoName(proto), name, len(payload))
+			switch {
+			case proto == "" && int(code) == (mbltest.Ping{}).Code():
+				write(uint64((mbltest.Pong{}).Code()), []byte{0xc0})
+			case proto == "" && int(code) == (mbltest.Disconnect{}).Code():
+				closed <- fmt.Errorf("disconnected by node")
+				return
+			}
+		}
+	}()
+
+	var sent int
+	for i, rec := range replay {
+		if ctx.Bool(replayTimingFlag.Name) && i > 0 {
+			delay := rec.Timestamp().Sub(replay[i-1].Timestamp())
+			select {
+			case <-time.After(delay):
+			case err := <-closed:
+				return fmt.Errorf("replayed %d of %d messages: %v", sent, len(replay), err)
+			}
+		}
+		offset, ok := conn.Offset(rec.Protocol)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Skipping %s message %#02x, protocol not negotiated\n", rec.Protocol, rec.Code)
+			continue
+		}
+		if err := write(offset+rec.Code, rec.Payload); err != nil {
+			return fmt.Errorf("replayed %d of %d messages: %v", sent, len(replay), err)
+		}
+		name, _ := describeMsg(rec.Protocol, rec.Code, rec.Payload)
+		fmt.Printf("%s -> %s %s %d bytes\n", time.Now().Format("2006-01-02T15:04:05.000"), rec.Protocol, name, len(rec.Payload))
+		sent++
+	}
+	select {
+	case <-time.After(ctx.Duration(replayWaitFlag.Name)):
+	case err := <-closed:
+		return fmt.Errorf("replayed %d of %d messages: %v", sent, len(replay), err)
+	}
+	fmt.Printf("Replayed %d of %d messages\n", sent, len(replay))
+	return nil
+}
+
+// parseCap parses a capability given as name/version.
+func parseCap(s string) p2p.Cap {
+	var cap p2p.Cap
+	if i := strings.LastIndexByte(s, '/'); i >= 0 {
+		cap.Name = s[:i]
+		version, _ := strconv.ParseUint(s[i+1:], 10, 32)
+		cap.Version = uint(version)
+	}
+	return cap
+}
+
+// replayProto maps an absolute message code of the replay connection to its
+// protocol.
+func replayProto(conn *mbltest.Conn, code uint64) (string, uint64) {
+	mblCap, snapCap := conn.Negotiated()
+	for _, cap := range []p2p.Cap{snapCap, mblCap} {
+		if offset, ok := conn.Offset(cap.String()); ok && code >= offset {
+			return cap.String(), code - offset
+		}
+	}
+	return "", code
+}
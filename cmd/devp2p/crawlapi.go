@@ -0,0 +1,368 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of go-mbali.
+//
+// go-mbali is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-mbali is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-mbali. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mbali/go-mbali/common/hexutil"
+	"github.com/mbali/go-mbali/core/forkid"
+	"github.com/mbali/go-mbali/p2p/enode"
+)
+
+// crawlAPI serves the contents of the crawler database as JSON:
+//
+//	/api/stats                   client, network and fork distribution of live nodes
+//	/api/forks?hash=<h>&next=<n> readiness of live nodes for the given fork ID
+//	/api/nodes                   node list, see crawlNodeFilter for the query parameters
+//	/api/nodes/<id>              a single node with its observation history
+type crawlAPI struct {
+	db   *crawlDB
+	live time.Duration
+	mux  *http.ServeMux
+	now  func() time.Time
+}
+
+func newCrawlAPI(db *crawlDB, live time.Duration) *crawlAPI {
+	api := &crawlAPI{db: db, live: live, mux: http.NewServeMux(), now: time.Now}
+	api.mux.HandleFunc("/api/stats", api.handleStats)
+	api.mux.HandleFunc("/api/forks", api.handleForks)
+	api.mux.HandleFunc("/api/nodes", api.handleNodes)
+	api.mux.HandleFunc("/api/nodes/", api.handleNode)
+	return api
+}
+
+func (api *crawlAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mux.ServeHTTP(w, r)
+}
+
+// crawlStats is the response of /api/stats.
+type crawlStats struct {
+	Nodes    int            `json:"nodes"`
+	Live     int            `json:"live"`
+	Clients  map[string]int `json:"clients"`
+	Versions map[string]int `json:"versions"`
+	Networks map[uint64]int `json:"networks"`
+	Forks    map[string]int `json:"forks"`
+}
+
+func (api *crawlAPI) handleStats(w http.ResponseWriter, r *http.Request) {
+	nodes, err := api.db.nodes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	stats := crawlStats{
+		Nodes:    len(nodes),
+		Clients:  make(map[string]int),
+		Versions: make(map[string]int),
+		Networks: make(map[uint64]int),
+		Forks:    make(map[string]int),
+	}
+	for _, n := range nodes {
+		if !api.isLive(n) {
+			continue
+		}
+		stats.Live++
+		client, version := parseClientName(n.Latest.Name)
+		stats.Clients[client]++
+		if version != "" {
+			stats.Versions[client+"/"+version]++
+		}
+		if n.Latest.ForkHash != "" {
+			stats.Networks[n.Latest.NetworkID]++
+			stats.Forks[fmt.Sprintf("%s/%d", n.Latest.ForkHash, n.Latest.ForkNext)]++
+		}
+	}
+	writeJSON(w, stats)
+}
+
+// forkReadiness is the response of /api/forks.
+type forkReadiness struct {
+	Hash    string                `json:"hash"`
+	Next    uint64                `json:"next"`
+	Total   int                   `json:"total"`
+	Ready   int                   `json:"ready"`
+	Clients map[string]*forkCount `json:"clients"`
+}
+
+type forkCount struct {
+	Total int `json:"total"`
+	Ready int `json:"ready"`
+}
+
+// handleForks counts the live nodes announcing the fork ID given by the hash and
+// next parameters. Nodes on another chain or fork don't match the hash and aren't
+// ready. The network parameter restricts the count to a single network.
+func (api *crawlAPI) handleForks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	hash, err := parseForkHash(query.Get("hash"))
+	if err != nil {
+		http.Error(w, "invalid fork hash", http.StatusBadRequest)
+		return
+	}
+	next, err := strconv.ParseUint(query.Get("next"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid next fork block", http.StatusBadRequest)
+		return
+	}
+	want := forkid.ID{Hash: hash, Next: next}
+	var network *uint64
+	if query.Get("network") != "" {
+		id, err := strconv.ParseUint(query.Get("network"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid network ID", http.StatusBadRequest)
+			return
+		}
+		network = &id
+	}
+	nodes, err := api.db.nodes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := forkReadiness{Hash: hexutil.Encode(hash[:]), Next: next, Clients: make(map[string]*forkCount)}
+	for _, n := range nodes {
+		if !api.isLive(n) || n.Latest.ForkHash == "" {
+			continue
+		}
+		if network != nil && n.Latest.NetworkID != *network {
+			continue
+		}
+		client, _ := parseClientName(n.Latest.Name)
+		count := resp.Clients[client]
+		if count == nil {
+			count = new(forkCount)
+			resp.Clients[client] = count
+		}
+		resp.Total++
+		count.Total++
+		if id, err := observedForkID(n.Latest); err == nil && id == want {
+			resp.Ready++
+			count.Ready++
+		}
+	}
+	writeJSON(w, resp)
+}
+
+// parseForkHash decodes a hex encoded fork ID hash.
+func parseForkHash(s string) (hash [4]byte, err error) {
+	b, err := hexutil.Decode(s)
+	if err != nil {
+		return hash, err
+	}
+	if len(b) != len(hash) {
+		return hash, fmt.Errorf("fork hash has %d bytes, want %d", len(b), len(hash))
+	}
+	copy(hash[:], b)
+	return hash, nil
+}
+
+// observedForkID returns the fork ID announced in an observation.
+func observedForkID(obs *observation) (forkid.ID, error) {
+	hash, err := parseForkHash(obs.ForkHash)
+	if err != nil {
+		return forkid.ID{}, err
+	}
+	return forkid.ID{Hash: hash, Next: obs.ForkNext}, nil
+}
+
+// crawlNodeFilter selects nodes by the query parameters of /api/nodes:
+//
+//	live=true          only nodes which responded recently
+//	client=<name>      client name, case-insensitive
+//	network=<id>       network ID
+//	fork=<hash>        fork ID hash
+//	next=<block>       next fork of the fork ID
+//	cap=<name/version> supported capability
+//	format=nodeset     output in nodes.json format
+type crawlNodeFilter struct {
+	live    bool
+	client  string
+	network *uint64
+	fork    string
+	next    *uint64
+	cap     string
+}
+
+func parseCrawlNodeFilter(r *http.Request) (*crawlNodeFilter, error) {
+	var (
+		query = r.URL.Query()
+		f     = &crawlNodeFilter{
+			client: strings.ToLower(query.Get("client")),
+			fork:   strings.ToLower(query.Get("fork")),
+			cap:    query.Get("cap"),
+		}
+		err error
+	)
+	if s := query.Get("live"); s != "" {
+		if f.live, err = strconv.ParseBool(s); err != nil {
+			return nil, fmt.Errorf("invalid live parameter %q", s)
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  **uint64
+	}{{"network", &f.network}, {"next", &f.next}} {
+		if s := query.Get(p.name); s != "" {
+			v, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s parameter %q", p.name, s)
+			}
+			*p.dst = &v
+		}
+	}
+	return f, nil
+}
+
+func (f *crawlNodeFilter) match(api *crawlAPI, n *crawlNode) bool {
+	if f.live && !api.isLive(n) {
+		return false
+	}
+	if f.client == "" && f.network == nil && f.fork == "" && f.next == nil && f.cap == "" {
+		return true
+	}
+	obs := n.Latest
+	if obs == nil {
+		return false
+	}
+	if f.client != "" {
+		if client, _ := parseClientName(obs.Name); strings.ToLower(client) != f.client {
+			return false
+		}
+	}
+	if f.network != nil && (obs.ForkHash == "" || obs.NetworkID != *f.network) {
+		return false
+	}
+	if f.fork != "" && obs.ForkHash != f.fork {
+		return false
+	}
+	if f.next != nil && (obs.ForkHash == "" || obs.ForkNext != *f.next) {
+		return false
+	}
+	if f.cap != "" {
+		found := false
+		for _, c := range obs.Caps {
+			if c.String() == f.cap {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (api *crawlAPI) handleNodes(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseCrawlNodeFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	nodes, err := api.db.nodes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	matches := make([]*crawlNode, 0)
+	for _, n := range nodes {
+		if filter.match(api, n) {
+			matches = append(matches, n)
+		}
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "":
+		writeJSON(w, matches)
+	case "nodeset":
+		ns := make(nodeSet, len(matches))
+		for _, n := range matches {
+			ns[n.N.ID()] = nodeJSON{
+				Seq:           n.N.Seq(),
+				N:             n.N,
+				FirstResponse: n.FirstSeen,
+				LastResponse:  n.LastResponse,
+				LastCheck:     n.LastCheck,
+			}
+		}
+		writeJSON(w, ns)
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+	}
+}
+
+// crawlNodeHistory is the response of /api/nodes/<id>.
+type crawlNodeHistory struct {
+	crawlNode
+	History []*observation `json:"history"`
+}
+
+func (api *crawlAPI) handleNode(w http.ResponseWriter, r *http.Request) {
+	id, err := enode.ParseID(strings.TrimPrefix(r.URL.Path, "/api/nodes/"))
+	if err != nil {
+		http.Error(w, "invalid node ID", http.StatusBadRequest)
+		return
+	}
+	node, err := api.db.node(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if node == nil {
+		http.NotFound(w, r)
+		return
+	}
+	history, err := api.db.history(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, crawlNodeHistory{*node, history})
+}
+
+// isLive reports whether the node responded within the live window.
+func (api *crawlAPI) isLive(n *crawlNode) bool {
+	return n.Latest != nil && api.now().Sub(n.LastResponse) <= api.live
+}
+
+// parseClientName splits a client name like "Gombl/v1.10.17-stable/linux-amd64/go1.18"
+// into the client and its version.
+func parseClientName(name string) (client, version string) {
+	parts := strings.Split(name, "/")
+	client = parts[0]
+	if client == "" {
+		client = "unknown"
+	}
+	if len(parts) > 1 {
+		version = parts[1]
+	}
+	return client, version
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", jsonIndent)
+	enc.Encode(v)
+}
@@ -0,0 +1,223 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of go-mbali.
+//
+// go-mbali is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-mbali is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-mbali. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/p2p"
+	"github.com/mbali/go-mbali/p2p/enode"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Keys of the crawler database.
+const (
+	crawlDBNodePrefix        = "n:" // n:<ID> -> crawlNode
+	crawlDBObservationPrefix = "o:" // o:<ID><time> -> observation
+)
+
+// crawlNode is a node found by the crawler.
+type crawlNode struct {
+	N            *enode.Node  `json:"record"`
+	FirstSeen    time.Time    `json:"firstSeen"`
+	LastSeen     time.Time    `json:"lastSeen"`
+	LastCheck    time.Time    `json:"lastCheck,omitempty"`
+	LastResponse time.Time    `json:"lastResponse,omitempty"`
+	Latest       *observation `json:"latest,omitempty"` // Last successful RLPx observation
+}
+
+// observation is the result of an RLPx connection attempt to a node.
+type observation struct {
+	Time  time.Time `json:"time"`
+	Seq   uint64    `json:"seq"`
+	IP    net.IP    `json:"ip,omitempty"`
+	TCP   int       `json:"tcp,omitempty"`
+	Error string    `json:"error,omitempty"`
+
+	// Protocol handshake
+	Name string    `json:"name,omitempty"`
+	Caps []p2p.Cap `json:"caps,omitempty"`
+
+	// mbl status, if the node supports the protocol
+	NetworkID uint64      `json:"networkID,omitempty"`
+	Genesis   common.Hash `json:"genesis,omitempty"`
+	Head      common.Hash `json:"head,omitempty"`
+	ForkHash  string      `json:"forkHash,omitempty"`
+	ForkNext  uint64      `json:"forkNext,omitempty"`
+}
+
+// crawlDB stores the nodes found by the crawler along with their observations
+// over time.
+type crawlDB struct {
+	lvl *leveldb.DB
+}
+
+// openCrawlDB opens the database at the given path, or an in-memory database
+// if the path is empty.
+func openCrawlDB(path string) (*crawlDB, error) {
+	var (
+		db  *leveldb.DB
+		err error
+	)
+	if path == "" {
+		db, err = leveldb.Open(storage.NewMemStorage(), nil)
+	} else {
+		db, err = leveldb.OpenFile(path, &opt.Options{OpenFilesCacheCapacity: 5})
+		if _, iscorrupted := err.(*errors.ErrCorrupted); iscorrupted {
+			db, err = leveldb.RecoverFile(path, nil)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &crawlDB{lvl: db}, nil
+}
+
+func crawlDBNodeKey(id enode.ID) []byte {
+	return append([]byte(crawlDBNodePrefix), id[:]...)
+}
+
+func crawlDBObservationKey(id enode.ID, t time.Time) []byte {
+	var enc [8]byte
+	binary.BigEndian.PutUint64(enc[:], uint64(t.UnixNano()))
+	key := append([]byte(crawlDBObservationPrefix), id[:]...)
+	return append(key, enc[:]...)
+}
+
+// node returns the node with the given ID, or nil if it's unknown.
+func (db *crawlDB) node(id enode.ID) (*crawlNode, error) {
+	blob, err := db.lvl.Get(crawlDBNodeKey(id), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	n := new(crawlNode)
+	if err := json.Unmarshal(blob, n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// putNode stores a node.
+func (db *crawlDB) putNode(n *crawlNode) error {
+	blob, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return db.lvl.Put(crawlDBNodeKey(n.N.ID()), blob, nil)
+}
+
+// nodes returns all nodes of the database.
+func (db *crawlDB) nodes() ([]*crawlNode, error) {
+	it := db.lvl.NewIterator(util.BytesPrefix([]byte(crawlDBNodePrefix)), nil)
+	defer it.Release()
+
+	var nodes []*crawlNode
+	for it.Next() {
+		n := new(crawlNode)
+		if err := json.Unmarshal(it.Value(), n); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, it.Error()
+}
+
+// addObservation stores an observation of a node.
+func (db *crawlDB) addObservation(id enode.ID, obs *observation) error {
+	blob, err := json.Marshal(obs)
+	if err != nil {
+		return err
+	}
+	return db.lvl.Put(crawlDBObservationKey(id, obs.Time), blob, nil)
+}
+
+// history returns the observations of a node, oldest first.
+func (db *crawlDB) history(id enode.ID) ([]*observation, error) {
+	prefix := append([]byte(crawlDBObservationPrefix), id[:]...)
+	it := db.lvl.NewIterator(util.BytesPrefix(prefix), nil)
+	defer it.Release()
+
+	var history []*observation
+	for it.Next() {
+		obs := new(observation)
+		if err := json.Unmarshal(it.Value(), obs); err != nil {
+			return nil, err
+		}
+		history = append(history, obs)
+	}
+	return history, it.Error()
+}
+
+// prune deletes the nodes last seen and the observations made before the given
+// time, along with all observations of the deleted nodes. It returns the number
+// of deleted nodes and observations.
+func (db *crawlDB) prune(before time.Time) (nodes, observations int, err error) {
+	var (
+		batch   = new(leveldb.Batch)
+		deleted = make(map[enode.ID]bool)
+	)
+	it := db.lvl.NewIterator(util.BytesPrefix([]byte(crawlDBNodePrefix)), nil)
+	for it.Next() {
+		n := new(crawlNode)
+		if err := json.Unmarshal(it.Value(), n); err != nil {
+			it.Release()
+			return 0, 0, err
+		}
+		if n.LastSeen.Before(before) {
+			batch.Delete(common.CopyBytes(it.Key()))
+			deleted[n.N.ID()] = true
+		}
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return 0, 0, err
+	}
+
+	it = db.lvl.NewIterator(util.BytesPrefix([]byte(crawlDBObservationPrefix)), nil)
+	defer it.Release()
+	cutoff := uint64(before.UnixNano())
+	for it.Next() {
+		var (
+			key = it.Key()
+			id  enode.ID
+		)
+		copy(id[:], key[len(crawlDBObservationPrefix):])
+		if binary.BigEndian.Uint64(key[len(key)-8:]) < cutoff || deleted[id] {
+			batch.Delete(common.CopyBytes(key))
+			observations++
+		}
+	}
+	if err := it.Error(); err != nil {
+		return 0, 0, err
+	}
+	return len(deleted), observations, db.lvl.Write(batch, nil)
+}
+
+func (db *crawlDB) close() error {
+	return db.lvl.Close()
+}
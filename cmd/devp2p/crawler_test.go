@@ -0,0 +1,249 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of go-mbali.
+//
+// go-mbali is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-mbali is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-mbali. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mbali/go-mbali/crypto"
+	"github.com/mbali/go-mbali/p2p"
+	"github.com/mbali/go-mbali/p2p/enode"
+	"github.com/mbali/go-mbali/p2p/enr"
+)
+
+func newTestCrawlNode(t *testing.T, seq uint64) *enode.Node {
+	key, _ := crypto.GenerateKey()
+	return signTestCrawlNode(t, key, seq)
+}
+
+func signTestCrawlNode(t *testing.T, key *ecdsa.PrivateKey, seq uint64) *enode.Node {
+	var r enr.Record
+	r.SetSeq(seq)
+	r.Set(enr.IP(net.IP{127, 0, 0, 1}))
+	r.Set(enr.TCP(30303))
+	if err := enode.SignV4(&r, key); err != nil {
+		t.Fatal(err)
+	}
+	n, err := enode.New(enode.ValidSchemes, &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func newTestCrawlDB(t *testing.T) *crawlDB {
+	db, err := openCrawlDB("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// This test checks that nodes found by discovery are stored and probed, but
+// not probed again before the revalidation interval.
+func TestCrawlDaemon(t *testing.T) {
+	db := newTestCrawlDB(t)
+	defer db.close()
+
+	var (
+		mu     sync.Mutex
+		probes = make(map[enode.ID]int)
+	)
+	probe := func(n *enode.Node) *observation {
+		mu.Lock()
+		defer mu.Unlock()
+		probes[n.ID()]++
+		return &observation{Time: time.Now(), Seq: n.Seq(), Name: "Gombl/v1.0.0", Caps: []p2p.Cap{{Name: "mbl", Version: 66}}}
+	}
+	d := newCrawlDaemon(db, probe)
+	d.workers = 2
+	d.revalidate = time.Hour
+
+	key1, _ := crypto.GenerateKey()
+	n1, n2 := signTestCrawlNode(t, key1, 1), newTestCrawlNode(t, 1)
+	d.run(enode.IterNodes([]*enode.Node{n1, n2, n1, n2}))
+
+	if len(probes) != 2 || probes[n1.ID()] != 1 || probes[n2.ID()] != 1 {
+		t.Fatalf("wrong probes: %v", probes)
+	}
+	for _, n := range []*enode.Node{n1, n2} {
+		node, err := db.node(n.ID())
+		if err != nil || node == nil {
+			t.Fatalf("node %v not stored: %v", n.ID(), err)
+		}
+		if node.Latest == nil || node.Latest.Name != "Gombl/v1.0.0" || node.LastResponse.IsZero() {
+			t.Errorf("node %v: observation not recorded: %+v", n.ID(), node)
+		}
+		history, err := db.history(n.ID())
+		if err != nil || len(history) != 1 {
+			t.Errorf("node %v: wrong history length %d (err %v)", n.ID(), len(history), err)
+		}
+	}
+
+	// Newer records replace older ones.
+	d.run(enode.IterNodes([]*enode.Node{signTestCrawlNode(t, key1, 5)}))
+	if node, _ := db.node(n1.ID()); node.N.Seq() != 5 {
+		t.Errorf("record not updated: seq %d", node.N.Seq())
+	}
+}
+
+func TestCrawlDBPrune(t *testing.T) {
+	db := newTestCrawlDB(t)
+	defer db.close()
+
+	var (
+		n     = newTestCrawlNode(t, 1)
+		gone  = newTestCrawlNode(t, 1)
+		start = time.Unix(1000000, 0)
+	)
+	db.putNode(&crawlNode{N: n, LastSeen: start.Add(4 * time.Hour)})
+	db.putNode(&crawlNode{N: gone, LastSeen: start.Add(time.Hour)})
+	for i := 0; i < 5; i++ {
+		obs := &observation{Time: start.Add(time.Duration(i) * time.Hour)}
+		if err := db.addObservation(n.ID(), obs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.addObservation(gone.ID(), &observation{Time: start.Add(3 * time.Hour)})
+
+	nodes, observations, err := db.prune(start.Add(2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes != 1 || observations != 3 {
+		t.Errorf("wrong prune count: %d nodes, %d observations, want 1 and 3", nodes, observations)
+	}
+	history, _ := db.history(n.ID())
+	if len(history) != 3 || !history[0].Time.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("wrong history after pruning: %v", history)
+	}
+	if node, _ := db.node(gone.ID()); node != nil {
+		t.Error("node not seen within retention period not pruned")
+	}
+	if history, _ := db.history(gone.ID()); len(history) != 0 {
+		t.Errorf("history of pruned node not deleted: %v", history)
+	}
+	if node, _ := db.node(n.ID()); node == nil {
+		t.Error("recently seen node pruned")
+	}
+}
+
+func TestCrawlAPI(t *testing.T) {
+	db := newTestCrawlDB(t)
+	defer db.close()
+
+	now := time.Unix(2000000, 0)
+	addNode := func(name string, network uint64, hash string, next uint64, lastResponse time.Time) *enode.Node {
+		n := newTestCrawlNode(t, 1)
+		obs := &observation{
+			Time:      lastResponse,
+			Name:      name,
+			Caps:      []p2p.Cap{{Name: "mbl", Version: 66}},
+			NetworkID: network,
+			ForkHash:  hash,
+			ForkNext:  next,
+		}
+		node := &crawlNode{N: n, FirstSeen: lastResponse, LastSeen: now, LastResponse: lastResponse, Latest: obs}
+		if err := db.putNode(node); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.addObservation(n.ID(), obs); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	gombl := addNode("Gombl/v1.10.17-stable/linux-amd64/go1.18", 1, "0x11223344", 100, now.Add(-time.Minute))
+	addNode("Gombl/v1.10.16-stable/linux-amd64/go1.18", 1, "0x11223344", 0, now.Add(-time.Minute))
+	addNode("Nethermind/v1.12.0/linux-x64/dotnet6", 1, "0x11223344", 100, now.Add(-time.Minute))
+	addNode("Gombl/v1.10.17-stable/linux-amd64/go1.18", 1, "0x55667788", 100, now.Add(-time.Minute)) // other chain
+	addNode("Gombl/v1.10.17-stable/linux-amd64/go1.18", 5, "0x11223344", 100, now.Add(-time.Minute))
+	addNode("Gombl/v1.9.0-stable/linux-amd64/go1.15", 1, "0x11223344", 0, now.Add(-48*time.Hour)) // not live
+
+	api := newCrawlAPI(db, 24*time.Hour)
+	api.now = func() time.Time { return now }
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	get := func(path string, result interface{}) {
+		t.Helper()
+		resp, err := server.Client().Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("%s: status %s", path, resp.Status)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+
+	var stats crawlStats
+	get("/api/stats", &stats)
+	wantStats := crawlStats{
+		Nodes:    6,
+		Live:     5,
+		Clients:  map[string]int{"Gombl": 4, "Nethermind": 1},
+		Versions: map[string]int{"Gombl/v1.10.17-stable": 3, "Gombl/v1.10.16-stable": 1, "Nethermind/v1.12.0": 1},
+		Networks: map[uint64]int{1: 4, 5: 1},
+		Forks:    map[string]int{"0x11223344/100": 3, "0x11223344/0": 1, "0x55667788/100": 1},
+	}
+	if !reflect.DeepEqual(stats, wantStats) {
+		t.Errorf("wrong stats:\nhave %+v\nwant %+v", stats, wantStats)
+	}
+
+	var forks forkReadiness
+	get("/api/forks?hash=0x11223344&next=100&network=1", &forks)
+	wantForks := forkReadiness{
+		Hash:  "0x11223344",
+		Next:  100,
+		Total: 4,
+		Ready: 2,
+		Clients: map[string]*forkCount{
+			"Gombl":      {Total: 3, Ready: 1},
+			"Nethermind": {Total: 1, Ready: 1},
+		},
+	}
+	if !reflect.DeepEqual(forks, wantForks) {
+		t.Errorf("wrong fork readiness:\nhave %+v\nwant %+v", forks, wantForks)
+	}
+
+	var nodes []*crawlNode
+	get("/api/nodes?live=true&client=gombl&network=1", &nodes)
+	if len(nodes) != 3 {
+		t.Errorf("wrong number of filtered nodes: %d", len(nodes))
+	}
+	var ns nodeSet
+	get("/api/nodes?fork=0x11223344&next=100&cap=mbl/66&format=nodeset", &ns)
+	if len(ns) != 3 {
+		t.Errorf("wrong number of nodes in node set: %d", len(ns))
+	}
+
+	var node crawlNodeHistory
+	get("/api/nodes/"+gombl.ID().String(), &node)
+	if node.N.ID() != gombl.ID() || len(node.History) != 1 {
+		t.Errorf("wrong node response: %+v", node)
+	}
+}
@@ -0,0 +1,415 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of go-mbali.
+//
+// go-mbali is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-mbali is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-mbali. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mbali/go-mbali/cmd/devp2p/internal/mbltest"
+	"github.com/mbali/go-mbali/common/hexutil"
+	"github.com/mbali/go-mbali/crypto"
+	"github.com/mbali/go-mbali/log"
+	"github.com/mbali/go-mbali/mbl/protocols/mbl"
+	"github.com/mbali/go-mbali/p2p"
+	"github.com/mbali/go-mbali/p2p/discover"
+	"github.com/mbali/go-mbali/p2p/enode"
+	"github.com/mbali/go-mbali/p2p/rlpx"
+	"github.com/mbali/go-mbali/rlp"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	crawlerProbeTimeout  = 10 * time.Second
+	crawlerPruneInterval = time.Hour
+	crawlerClientName    = "devp2p-crawler"
+)
+
+var (
+	crawlerCommand = cli.Command{
+		Name:      "crawler",
+		Usage:     "Crawls the discv4 and discv5 networks continuously and serves the results over HTTP",
+		ArgsUsage: "<database-directory>",
+		Action:    crawlerRun,
+		Flags: []cli.Flag{
+			bootnodesFlag,
+			nodekeyFlag,
+			listenAddrFlag,
+			crawlerHTTPFlag,
+			crawlerWorkersFlag,
+			crawlerRevalidateFlag,
+			crawlerLiveFlag,
+			crawlerRetentionFlag,
+		},
+	}
+)
+
+var (
+	crawlerHTTPFlag = cli.StringFlag{
+		Name:  "http",
+		Usage: "Listening address of the HTTP API",
+		Value: "127.0.0.1:8080",
+	}
+	crawlerWorkersFlag = cli.IntFlag{
+		Name:  "workers",
+		Usage: "Number of concurrent RLPx probes",
+		Value: 16,
+	}
+	crawlerRevalidateFlag = cli.DurationFlag{
+		Name:  "revalidate",
+		Usage: "Minimum time between RLPx probes of a node",
+		Value: 30 * time.Minute,
+	}
+	crawlerLiveFlag = cli.DurationFlag{
+		Name:  "live",
+		Usage: "Nodes responding within this time are considered live by the API",
+		Value: 24 * time.Hour,
+	}
+	crawlerRetentionFlag = cli.DurationFlag{
+		Name:  "retention",
+		Usage: "Time to keep nodes not seen anymore and the observation history of nodes",
+		Value: 30 * 24 * time.Hour,
+	}
+)
+
+func crawlerRun(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return fmt.Errorf("need database directory as argument")
+	}
+	db, err := openCrawlDB(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+	defer db.close()
+
+	// Run discv4 and discv5 on the same socket, like the p2p server does.
+	ln, cfg := makeDiscoveryConfig(ctx)
+	socket := listen(ln, ctx.String(listenAddrFlag.Name))
+	unhandled := make(chan discover.ReadPacket, 100)
+	cfgv4 := cfg
+	cfgv4.Unhandled = unhandled
+	v4, err := discover.ListenV4(socket, ln, cfgv4)
+	if err != nil {
+		return err
+	}
+	defer v4.Close()
+	v5, err := discover.ListenV5(&sharedUDPConn{socket, unhandled}, ln, cfg)
+	if err != nil {
+		return err
+	}
+	defer v5.Close()
+
+	mix := enode.NewFairMix(0)
+	mix.AddSource(v4.RandomNodes())
+	mix.AddSource(v5.RandomNodes())
+
+	// Serve the API.
+	api := newCrawlAPI(db, ctx.Duration(crawlerLiveFlag.Name))
+	server := &http.Server{Addr: ctx.String(crawlerHTTPFlag.Name), Handler: api}
+	go func() {
+		log.Info("Starting crawler API", "addr", server.Addr)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Error("Crawler API failed", "err", err)
+		}
+	}()
+	defer server.Close()
+
+	// Stop on interrupt.
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		log.Info("Stopping crawler")
+		mix.Close()
+	}()
+
+	key := cfg.PrivateKey
+	d := newCrawlDaemon(db, func(n *enode.Node) *observation { return probeNode(key, n) })
+	d.workers = ctx.Int(crawlerWorkersFlag.Name)
+	d.revalidate = ctx.Duration(crawlerRevalidateFlag.Name)
+	d.retention = ctx.Duration(crawlerRetentionFlag.Name)
+	d.run(mix)
+	return nil
+}
+
+// crawlDaemon feeds the nodes found by discovery into the database, and probes
+// them over RLPx.
+type crawlDaemon struct {
+	db    *crawlDB
+	probe func(*enode.Node) *observation
+
+	// settings
+	workers    int
+	revalidate time.Duration
+	retention  time.Duration
+
+	sem     chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex // protects node updates and pending
+	pending map[enode.ID]struct{}
+}
+
+func newCrawlDaemon(db *crawlDB, probe func(*enode.Node) *observation) *crawlDaemon {
+	return &crawlDaemon{
+		db:         db,
+		probe:      probe,
+		workers:    crawlerWorkersFlag.Value,
+		revalidate: crawlerRevalidateFlag.Value,
+		retention:  crawlerRetentionFlag.Value,
+		pending:    make(map[enode.ID]struct{}),
+	}
+}
+
+// run processes the nodes of the iterator until it's closed, then waits for
+// all pending probes.
+func (d *crawlDaemon) run(it enode.Iterator) {
+	d.sem = make(chan struct{}, d.workers)
+	quit := make(chan struct{})
+	pruneDone := make(chan struct{})
+	go d.pruneLoop(quit, pruneDone)
+
+	for it.Next() {
+		d.handle(it.Node())
+	}
+	d.wg.Wait()
+	close(quit)
+	<-pruneDone
+}
+
+// handle records a node found by discovery and starts probing it if it wasn't
+// checked recently. It blocks while all workers are busy.
+func (d *crawlDaemon) handle(n *enode.Node) {
+	now := time.Now()
+	d.mu.Lock()
+	node, err := d.db.node(n.ID())
+	if err != nil {
+		d.mu.Unlock()
+		log.Warn("Crawler database error", "err", err)
+		return
+	}
+	if node == nil {
+		log.Debug("Found new node", "id", n.ID(), "ip", n.IP())
+		node = &crawlNode{N: n, FirstSeen: now}
+	} else if n.Seq() >= node.N.Seq() {
+		node.N = n
+	}
+	node.LastSeen = now
+	_, pending := d.pending[n.ID()]
+	probe := !pending && now.Sub(node.LastCheck) >= d.revalidate
+	if probe {
+		d.pending[n.ID()] = struct{}{}
+		node.LastCheck = now
+	}
+	err = d.db.putNode(node)
+	d.mu.Unlock()
+	if err != nil {
+		log.Warn("Crawler database error", "err", err)
+		return
+	}
+	if !probe {
+		return
+	}
+
+	d.sem <- struct{}{}
+	d.wg.Add(1)
+	go func() {
+		defer func() {
+			<-d.sem
+			d.wg.Done()
+		}()
+		d.record(n.ID(), d.probe(n))
+	}()
+}
+
+// record stores the result of a probe.
+func (d *crawlDaemon) record(id enode.ID, obs *observation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pending, id)
+
+	if obs.Error != "" {
+		log.Debug("Node probe failed", "id", id, "err", obs.Error)
+	}
+	if err := d.db.addObservation(id, obs); err != nil {
+		log.Warn("Crawler database error", "err", err)
+		return
+	}
+	if obs.Error != "" {
+		return
+	}
+	node, err := d.db.node(id)
+	if err != nil || node == nil {
+		log.Warn("Crawler database error", "err", err)
+		return
+	}
+	node.LastResponse = obs.Time
+	node.Latest = obs
+	if err := d.db.putNode(node); err != nil {
+		log.Warn("Crawler database error", "err", err)
+	}
+}
+
+// pruneLoop removes old nodes and observations from the database.
+func (d *crawlDaemon) pruneLoop(quit, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(crawlerPruneInterval)
+	defer ticker.Stop()
+	for {
+		nodes, observations, err := d.db.prune(time.Now().Add(-d.retention))
+		if err != nil {
+			log.Warn("Crawler database error", "err", err)
+		} else if nodes > 0 || observations > 0 {
+			log.Info("Pruned crawler database", "nodes", nodes, "observations", observations)
+		}
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// probeNode connects to a node and records its protocol handshake and, if
+// it supports the mbl protocol, its status.
+func probeNode(key *ecdsa.PrivateKey, n *enode.Node) *observation {
+	obs := &observation{Time: time.Now(), Seq: n.Seq(), IP: n.IP(), TCP: n.TCP()}
+	if err := probeRLPx(key, n, obs); err != nil {
+		obs.Error = err.Error()
+	}
+	return obs
+}
+
+func probeRLPx(key *ecdsa.PrivateKey, n *enode.Node, obs *observation) error {
+	if n.IP() == nil || n.TCP() == 0 {
+		return errors.New("no TCP endpoint")
+	}
+	addr := &net.TCPAddr{IP: n.IP(), Port: n.TCP()}
+	fd, err := net.DialTimeout("tcp", addr.String(), crawlerProbeTimeout)
+	if err != nil {
+		return err
+	}
+	conn := rlpx.NewConn(fd, n.Pubkey())
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(crawlerProbeTimeout))
+	if _, err := conn.Handshake(key); err != nil {
+		return err
+	}
+
+	hello := &mbltest.Hello{
+		Version: 5,
+		Name:    crawlerClientName,
+		Caps:    []p2p.Cap{{Name: "mbl", Version: 66}},
+		ID:      crypto.FromECDSAPub(&key.PublicKey)[1:],
+	}
+	if err := probeWrite(conn, 0x00, hello); err != nil {
+		return err
+	}
+	for {
+		code, data, _, err := conn.Read()
+		if err != nil {
+			if obs.Name != "" {
+				// The handshake is all we got, but it's still useful.
+				return nil
+			}
+			return err
+		}
+		switch code {
+		case 0x00:
+			var h mbltest.Hello
+			if err := rlp.DecodeBytes(data, &h); err != nil {
+				return fmt.Errorf("invalid handshake: %v", err)
+			}
+			obs.Name, obs.Caps = h.Name, h.Caps
+			if h.Version >= 5 {
+				conn.SetSnappy(true)
+			}
+			if !hasCap(h.Caps, "mbl", 66) {
+				probeWrite(conn, 0x01, []p2p.DiscReason{p2p.DiscUselessPeer})
+				return nil
+			}
+		case 0x01:
+			var reason []p2p.DiscReason
+			rlp.DecodeBytes(data, &reason)
+			if obs.Name != "" {
+				return nil
+			}
+			if len(reason) == 0 {
+				return errors.New("disconnected")
+			}
+			return fmt.Errorf("disconnected: %v", reason[0])
+		case 0x02:
+			probeWrite(conn, 0x03, []interface{}{})
+		case 16:
+			var status mbl.StatusPacket
+			if err := rlp.DecodeBytes(data, &status); err != nil {
+				return fmt.Errorf("invalid status: %v", err)
+			}
+			obs.NetworkID = status.NetworkID
+			obs.Genesis = status.Genesis
+			obs.Head = status.Head
+			obs.ForkHash = hexutil.Encode(status.ForkID.Hash[:])
+			obs.ForkNext = status.ForkID.Next
+			probeWrite(conn, 0x01, []p2p.DiscReason{p2p.DiscQuitting})
+			return nil
+		}
+	}
+}
+
+func probeWrite(conn *rlpx.Conn, code uint64, msg interface{}) error {
+	payload, err := rlp.EncodeToBytes(msg)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(code, payload)
+	return err
+}
+
+func hasCap(caps []p2p.Cap, name string, version uint) bool {
+	for _, c := range caps {
+		if c.Name == name && c.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// sharedUDPConn lets discv5 read the packets which discv4 couldn't handle.
+type sharedUDPConn struct {
+	*net.UDPConn
+	unhandled chan discover.ReadPacket
+}
+
+func (s *sharedUDPConn) ReadFromUDP(b []byte) (n int, addr *net.UDPAddr, err error) {
+	packet, ok := <-s.unhandled
+	if !ok {
+		return 0, nil, errors.New("connection was closed")
+	}
+	l := copy(b, packet.Data)
+	return l, packet.Addr, nil
+}
+
+func (s *sharedUDPConn) Close() error {
+	return nil
+}
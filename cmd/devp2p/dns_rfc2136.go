@@ -0,0 +1,292 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of go-mbali.
+//
+// go-mbali is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-mbali is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-mbali. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mbali/go-mbali/log"
+	"github.com/mbali/go-mbali/p2p/dnsdisc"
+	"github.com/miekg/dns"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	// rfc2136UpdateLimit is the maximum number of records changed by a
+	// single update message, which keeps messages well below the 64KB limit
+	// of DNS over TCP.
+	rfc2136UpdateLimit = 100
+
+	rfc2136Timeout   = 30 * time.Second
+	rfc2136TSIGFudge = 300
+)
+
+var (
+	rfc2136ServerFlag = cli.StringFlag{
+		Name:  "server",
+		Usage: "Primary DNS server (host:port) accepting dynamic updates",
+	}
+	rfc2136ZoneFlag = cli.StringFlag{
+		Name:  "zone",
+		Usage: "DNS zone containing the domain (optional, found with a SOA query by default)",
+	}
+	rfc2136TSIGKeyFlag = cli.StringFlag{
+		Name:  "tsig-key",
+		Usage: "Name of the TSIG key authenticating zone transfers and updates",
+	}
+	rfc2136TSIGSecretFlag = cli.StringFlag{
+		Name:   "tsig-secret",
+		Usage:  "Base64-encoded TSIG secret",
+		EnvVar: "RFC2136_TSIG_SECRET",
+	}
+	rfc2136TSIGAlgorithmFlag = cli.StringFlag{
+		Name:  "tsig-algorithm",
+		Usage: "TSIG algorithm (hmac-sha1, hmac-sha256, hmac-sha512)",
+		Value: "hmac-sha256",
+	}
+)
+
+type rfc2136Client struct {
+	server  string
+	zone    string
+	tsigKey string
+	tsigAlg string
+	secrets map[string]string
+}
+
+// newRFC2136Client sets up a dynamic update client from command line flags.
+func newRFC2136Client(ctx *cli.Context) *rfc2136Client {
+	server := ctx.String(rfc2136ServerFlag.Name)
+	if server == "" {
+		exit(fmt.Errorf("need DNS server address to proceed"))
+	}
+	c := &rfc2136Client{server: server}
+	if zone := ctx.String(rfc2136ZoneFlag.Name); zone != "" {
+		c.zone = dns.Fqdn(zone)
+	}
+	if key := ctx.String(rfc2136TSIGKeyFlag.Name); key != "" {
+		secret := ctx.String(rfc2136TSIGSecretFlag.Name)
+		if secret == "" {
+			exit(fmt.Errorf("need TSIG secret for key %s", key))
+		}
+		c.tsigKey = dns.Fqdn(key)
+		c.tsigAlg = dns.Fqdn(ctx.String(rfc2136TSIGAlgorithmFlag.Name))
+		c.secrets = map[string]string{c.tsigKey: secret}
+	}
+	return c
+}
+
+// deploy applies the changes between the zone and the given tree as dynamic
+// updates.
+func (c *rfc2136Client) deploy(name string, t *dnsdisc.Tree) error {
+	if err := c.checkZone(name); err != nil {
+		return err
+	}
+	existing, err := c.collectRecords(name)
+	if err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("Found %d TXT records", len(existing)))
+	records := t.ToTXT(name)
+	return c.submitUpdates(c.computeUpdates(name, records, existing))
+}
+
+// checkZone finds the zone containing the given domain if it isn't set.
+func (c *rfc2136Client) checkZone(name string) error {
+	if c.zone != "" {
+		if !isSubdomain(name, c.zone) {
+			return fmt.Errorf("%s is not in zone %s", name, c.zone)
+		}
+		return nil
+	}
+	log.Info(fmt.Sprintf("Finding zone of %s", name))
+	labels := dns.SplitDomainName(name)
+	for i := range labels {
+		candidate := dns.Fqdn(strings.Join(labels[i:], "."))
+		msg := new(dns.Msg)
+		msg.SetQuestion(candidate, dns.TypeSOA)
+		resp, err := c.exchange(msg)
+		if err != nil {
+			return err
+		}
+		for _, rr := range resp.Answer {
+			if soa, ok := rr.(*dns.SOA); ok && strings.EqualFold(soa.Hdr.Name, candidate) {
+				c.zone = candidate
+				log.Info(fmt.Sprintf("Found zone %s", c.zone))
+				return nil
+			}
+		}
+	}
+	return errors.New("can't find zone of " + name)
+}
+
+// collectRecords loads all TXT records below the given name with a zone
+// transfer.
+func (c *rfc2136Client) collectRecords(name string) (map[string]recordSet, error) {
+	log.Info("Loading existing TXT records", "name", name, "zone", c.zone)
+	msg := new(dns.Msg)
+	msg.SetAxfr(c.zone)
+	c.sign(msg)
+
+	tr := &dns.Transfer{TsigSecret: c.secrets, ReadTimeout: rfc2136Timeout}
+	envelopes, err := tr.In(msg, c.server)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]recordSet)
+	for env := range envelopes {
+		if env.Error != nil {
+			return nil, fmt.Errorf("zone transfer failed: %v", env.Error)
+		}
+		for _, rr := range env.RR {
+			txt, ok := rr.(*dns.TXT)
+			if !ok || !isSubdomain(txt.Hdr.Name, name) {
+				continue
+			}
+			name := strings.ToLower(strings.TrimSuffix(txt.Hdr.Name, "."))
+			set := existing[name]
+			set.ttl = int64(txt.Hdr.Ttl)
+			set.values = append(set.values, strings.Join(txt.Txt, ""))
+			existing[name] = set
+		}
+	}
+	return existing, nil
+}
+
+// computeUpdates creates the update messages turning the existing records into
+// the given DNS discovery records. The messages are in leaf-added ->
+// root-changed -> leaf-deleted order.
+func (c *rfc2136Client) computeUpdates(name string, records map[string]string, existing map[string]recordSet) []*dns.Msg {
+	// Convert all names to lowercase.
+	lrecords := make(map[string]string, len(records))
+	for name, r := range records {
+		lrecords[strings.ToLower(name)] = r
+	}
+	records = lrecords
+
+	var creates, updates, deletes []string
+	for path, newValue := range records {
+		prev, exists := existing[path]
+		ttl := int64(rootTTL)
+		if path != name {
+			ttl = int64(treeNodeTTL)
+		}
+		switch {
+		case !exists:
+			log.Info(fmt.Sprintf("Creating %s = %q", path, newValue))
+			creates = append(creates, path)
+		case len(prev.values) != 1 || prev.values[0] != newValue || prev.ttl != ttl:
+			log.Info(fmt.Sprintf("Updating %s from %q to %q", path, strings.Join(prev.values, ""), newValue))
+			updates = append(updates, path)
+		default:
+			log.Debug(fmt.Sprintf("Skipping %s = %q", path, newValue))
+		}
+	}
+	for path, prev := range existing {
+		if _, ok := records[path]; !ok {
+			log.Info(fmt.Sprintf("Deleting %s = %q", path, strings.Join(prev.values, "")))
+			deletes = append(deletes, path)
+		}
+	}
+	sort.Strings(creates)
+	sort.Strings(updates)
+	sort.Strings(deletes)
+
+	var msgs []*dns.Msg
+	batch := func(paths []string, remove, insert bool) {
+		for len(paths) > 0 {
+			n := len(paths)
+			if n > rfc2136UpdateLimit {
+				n = rfc2136UpdateLimit
+			}
+			msg := new(dns.Msg)
+			msg.SetUpdate(c.zone)
+			for _, path := range paths[:n] {
+				if remove {
+					msg.RemoveRRset([]dns.RR{newTXTRecord(path, 0, "")})
+				}
+				if insert {
+					ttl := uint32(rootTTL)
+					if path != name {
+						ttl = treeNodeTTL
+					}
+					msg.Insert([]dns.RR{newTXTRecord(path, ttl, records[path])})
+				}
+			}
+			msgs = append(msgs, msg)
+			paths = paths[n:]
+		}
+	}
+	batch(creates, false, true)
+	batch(updates, true, true)
+	batch(deletes, true, false)
+	return msgs
+}
+
+// submitUpdates sends the given update messages to the server.
+func (c *rfc2136Client) submitUpdates(msgs []*dns.Msg) error {
+	if len(msgs) == 0 {
+		log.Info("No DNS changes needed")
+		return nil
+	}
+	for i, msg := range msgs {
+		log.Info(fmt.Sprintf("Submitting update %d/%d with %d changes", i+1, len(msgs), len(msg.Ns)))
+		c.sign(msg)
+		resp, err := c.exchange(msg)
+		if err != nil {
+			return err
+		}
+		if resp.Rcode != dns.RcodeSuccess {
+			return fmt.Errorf("update rejected: %s", dns.RcodeToString[resp.Rcode])
+		}
+	}
+	return nil
+}
+
+// exchange sends a message over TCP and returns the response.
+func (c *rfc2136Client) exchange(msg *dns.Msg) (*dns.Msg, error) {
+	client := &dns.Client{Net: "tcp", Timeout: rfc2136Timeout, TsigSecret: c.secrets}
+	resp, _, err := client.Exchange(msg, c.server)
+	return resp, err
+}
+
+// sign adds a TSIG record to the message if a key is configured.
+func (c *rfc2136Client) sign(msg *dns.Msg) {
+	if c.tsigKey != "" {
+		msg.SetTsig(c.tsigKey, c.tsigAlg, rfc2136TSIGFudge, time.Now().Unix())
+	}
+}
+
+// newTXTRecord creates a TXT record, splitting the value into 255-character
+// strings.
+func newTXTRecord(name string, ttl uint32, value string) *dns.TXT {
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{Name: dns.Fqdn(name), Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl},
+	}
+	for len(value) > 255 {
+		rr.Txt = append(rr.Txt, value[:255])
+		value = value[255:]
+	}
+	if value != "" {
+		rr.Txt = append(rr.Txt, value)
+	}
+	return rr
+}
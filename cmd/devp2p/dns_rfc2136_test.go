@@ -0,0 +1,220 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of go-mbali.
+//
+// go-mbali is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-mbali is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-mbali. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mbali/go-mbali/crypto"
+	"github.com/mbali/go-mbali/p2p/dnsdisc"
+	"github.com/miekg/dns"
+)
+
+const (
+	testTSIGKey    = "deploy."
+	testTSIGSecret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"
+)
+
+// testDNSServer is a primary DNS server stand-in, serving a single zone and
+// accepting zone transfers and dynamic updates authenticated with TSIG.
+type testDNSServer struct {
+	*dns.Server
+	zone string
+
+	mu      sync.Mutex
+	records map[string][]dns.RR // by lowercase name
+	updates int
+}
+
+func startTestDNSServer(t *testing.T, zone string) *testDNSServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testDNSServer{zone: zone, records: make(map[string][]dns.RR)}
+	soa, _ := dns.NewRR(zone + " 3600 IN SOA ns." + zone + " admin." + zone + " 1 3600 600 86400 60")
+	s.records[zone] = []dns.RR{soa}
+	s.Server = &dns.Server{
+		Listener:   l,
+		Net:        "tcp",
+		Handler:    s,
+		TsigSecret: map[string]string{testTSIGKey: testTSIGSecret},
+		// The default function rejects updates.
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+	}
+	started := make(chan struct{})
+	s.NotifyStartedFunc = func() { close(started) }
+	go s.ActivateAndServe()
+	<-started
+	return s
+}
+
+func (s *testDNSServer) addr() string {
+	return s.Listener.Addr().String()
+}
+
+func (s *testDNSServer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	authenticated := req.IsTsig() != nil && w.TsigStatus() == nil
+	if authenticated {
+		resp.SetTsig(testTSIGKey, dns.HmacSHA256, 300, int64(req.IsTsig().TimeSigned))
+	}
+	switch {
+	case req.Opcode == dns.OpcodeQuery && req.Question[0].Qtype == dns.TypeSOA:
+		resp.Answer = s.records[strings.ToLower(req.Question[0].Name)]
+	case req.Opcode == dns.OpcodeQuery && req.Question[0].Qtype == dns.TypeAXFR:
+		if !authenticated {
+			resp.Rcode = dns.RcodeRefused
+			break
+		}
+		soa := s.records[s.zone][0]
+		rrs := []dns.RR{soa}
+		for _, set := range s.records {
+			for _, rr := range set {
+				if rr.Header().Rrtype != dns.TypeSOA {
+					rrs = append(rrs, rr)
+				}
+			}
+		}
+		resp.Answer = append(rrs, soa)
+	case req.Opcode == dns.OpcodeUpdate:
+		if !authenticated {
+			resp.Rcode = dns.RcodeRefused
+			break
+		}
+		s.updates++
+		for _, rr := range req.Ns {
+			name := strings.ToLower(rr.Header().Name)
+			switch rr.Header().Class {
+			case dns.ClassANY: // Delete RRset
+				delete(s.records, name)
+			case dns.ClassINET: // Add to RRset
+				s.records[name] = append(s.records[name], rr)
+			}
+		}
+	default:
+		resp.Rcode = dns.RcodeNotImplemented
+	}
+	w.WriteMsg(resp)
+}
+
+// txtRecords returns the TXT records of the zone, in the format of
+// dnsdisc.Tree.ToTXT.
+func (s *testDNSServer) txtRecords() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make(map[string]string)
+	for name, set := range s.records {
+		for _, rr := range set {
+			if txt, ok := rr.(*dns.TXT); ok {
+				records[strings.TrimSuffix(name, ".")] += strings.Join(txt.Txt, "")
+			}
+		}
+	}
+	return records
+}
+
+func testTree(t *testing.T, domain string, seq uint, links []string) *dnsdisc.Tree {
+	tree, err := dnsdisc.MakeTree(seq, nil, links)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := crypto.GenerateKey()
+	if _, err := tree.Sign(key, domain); err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func lowercaseRecords(records map[string]string) map[string]string {
+	lrecords := make(map[string]string, len(records))
+	for name, r := range records {
+		lrecords[strings.ToLower(name)] = r
+	}
+	return lrecords
+}
+
+// This test checks that the tree is deployed with TSIG-authenticated zone
+// transfers and dynamic updates, leaving unrelated records untouched.
+func TestRFC2136Deploy(t *testing.T) {
+	const domain = "nodes.example.org"
+	server := startTestDNSServer(t, "example.org.")
+	defer server.Shutdown()
+
+	other, _ := dns.NewRR("other.example.org. 60 IN TXT \"unrelated\"")
+	server.records["other.example.org."] = []dns.RR{other}
+
+	client := &rfc2136Client{
+		server:  server.addr(),
+		tsigKey: testTSIGKey,
+		tsigAlg: dns.HmacSHA256,
+		secrets: map[string]string{testTSIGKey: testTSIGSecret},
+	}
+	tree1 := testTree(t, domain, 1, []string{"enrtree://AM5FCQLWIZX2QFPNJAP7VUERCCRNGRHWZG3YYHIUV7BVDQ5FDPRT2@morenodes.example.org"})
+	if err := client.deploy(domain, tree1); err != nil {
+		t.Fatal(err)
+	}
+	if client.zone != "example.org." {
+		t.Fatalf("wrong zone %q", client.zone)
+	}
+	want := lowercaseRecords(tree1.ToTXT(domain))
+	want["other.example.org"] = "unrelated"
+	if have := server.txtRecords(); !reflect.DeepEqual(have, want) {
+		t.Fatalf("wrong records after first deployment:\nhave %v\nwant %v", have, want)
+	}
+
+	// Deploying a new tree replaces the root and removes stale entries
+	tree2 := testTree(t, domain, 2, []string{"enrtree://AM5FCQLWIZX2QFPNJAP7VUERCCRNGRHWZG3YYHIUV7BVDQ5FDPRT2@othernodes.example.org"})
+	if err := client.deploy(domain, tree2); err != nil {
+		t.Fatal(err)
+	}
+	want = lowercaseRecords(tree2.ToTXT(domain))
+	want["other.example.org"] = "unrelated"
+	if have := server.txtRecords(); !reflect.DeepEqual(have, want) {
+		t.Fatalf("wrong records after second deployment:\nhave %v\nwant %v", have, want)
+	}
+
+	// Deploying the same tree again changes nothing
+	updates := server.updates
+	if err := client.deploy(domain, tree2); err != nil {
+		t.Fatal(err)
+	}
+	if server.updates != updates {
+		t.Errorf("unchanged tree caused %d updates", server.updates-updates)
+	}
+}
+
+// This test checks that updates are rejected without a valid TSIG key.
+func TestRFC2136Unauthenticated(t *testing.T) {
+	server := startTestDNSServer(t, "example.org.")
+	defer server.Shutdown()
+
+	client := &rfc2136Client{server: server.addr(), zone: "example.org."}
+	tree := testTree(t, "nodes.example.org", 1, nil)
+	if err := client.deploy("nodes.example.org", tree); err == nil {
+		t.Fatal("deployment succeeded without TSIG key")
+	}
+}
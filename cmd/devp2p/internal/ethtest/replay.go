@@ -0,0 +1,84 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of go-mbali.
+//
+// go-mbali is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-mbali is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-mbali. If not, see <http://www.gnu.org/licenses/>.
+
+package mbltest
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/mbali/go-mbali/crypto"
+	"github.com/mbali/go-mbali/mbl/protocols/mbl"
+	"github.com/mbali/go-mbali/p2p"
+	"github.com/mbali/go-mbali/p2p/enode"
+	"github.com/mbali/go-mbali/p2p/rlpx"
+)
+
+// baseProtocolLength is the number of message codes reserved for the devp2p
+// base protocol.
+const baseProtocolLength = 16
+
+// Dial connects to the given node and performs the devp2p handshake,
+// advertising the given mbl and snap capabilities.
+func Dial(dest *enode.Node, caps []p2p.Cap) (*Conn, error) {
+	fd, err := net.Dial("tcp", fmt.Sprintf("%v:%d", dest.IP(), dest.TCP()))
+	if err != nil {
+		return nil, err
+	}
+	conn := &Conn{Conn: rlpx.NewConn(fd, dest.Pubkey()), caps: caps}
+	conn.ourKey, _ = crypto.GenerateKey()
+	if _, err := conn.Handshake(conn.ourKey); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for _, cap := range caps {
+		switch {
+		case cap.Name == "mbl" && cap.Version > conn.ourHighestProtoVersion:
+			conn.ourHighestProtoVersion = cap.Version
+		case cap.Name == "snap" && cap.Version > conn.ourHighestSnapProtoVersion:
+			conn.ourHighestSnapProtoVersion = cap.Version
+		}
+	}
+	if err := conn.handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Negotiated returns the negotiated mbl and snap capabilities. The snap
+// capability has a zero version if it wasn't negotiated.
+func (c *Conn) Negotiated() (mbl, snap p2p.Cap) {
+	return p2p.Cap{Name: "mbl", Version: c.negotiatedProtoVersion},
+		p2p.Cap{Name: "snap", Version: c.negotiatedSnapProtoVersion}
+}
+
+// Offset returns the offset of the message codes of a negotiated protocol,
+// given as name/version (e.g. "mbl/66").
+func (c *Conn) Offset(proto string) (uint64, bool) {
+	mblCap, snapCap := c.Negotiated()
+	switch proto {
+	case mblCap.String():
+		return baseProtocolLength, true
+	case snapCap.String():
+		if snapCap.Version == 0 {
+			return 0, false
+		}
+		// snap is ordered after mbl
+		return baseProtocolLength + mbl.ProtocolLengths[mblCap.Version], true
+	}
+	return 0, false
+}
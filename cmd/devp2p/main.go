@@ -64,6 +64,8 @@ func init() {
 		dnsCommand,
 		nodesetCommand,
 		rlpxCommand,
+		captureCommand,
+		crawlerCommand,
 	}
 }
 
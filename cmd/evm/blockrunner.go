@@ -0,0 +1,139 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of go-mbali.
+//
+// go-mbali is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-mbali is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-mbali. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/mbali/go-mbali/core"
+	"github.com/mbali/go-mbali/core/state"
+	"github.com/mbali/go-mbali/core/vm"
+	"github.com/mbali/go-mbali/mbl/tracers/logger"
+	"github.com/mbali/go-mbali/log"
+	"github.com/mbali/go-mbali/tests"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+var BlockTestTraceFlag = cli.BoolFlag{
+	Name:  "trace",
+	Usage: "output full trace logs of the imported blocks to stderr",
+}
+
+var blockTestCommand = cli.Command{
+	Action:    blockTestCmd,
+	Name:      "blocktest",
+	Usage:     "executes the given blockchain tests",
+	ArgsUsage: "<file>",
+	Flags: []cli.Flag{
+		BlockTestTraceFlag,
+	},
+}
+
+// BlocktestResult contains the execution status after running a blockchain
+// test, any error that might have occurred and a dump of the final state if
+// requested.
+type BlocktestResult struct {
+	Name  string      `json:"name"`
+	Pass  bool        `json:"pass"`
+	Fork  string      `json:"fork"`
+	Error string      `json:"error,omitempty"`
+	State *state.Dump `json:"state,omitempty"`
+}
+
+func blockTestCmd(ctx *cli.Context) error {
+	if len(ctx.Args().First()) == 0 {
+		return errors.New("path-to-test argument required")
+	}
+	// Configure the go-mbali logger
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(ctx.GlobalInt(VerbosityFlag.Name)))
+	log.Root().Smblandler(glogger)
+
+	// Configure the EVM logger
+	var (
+		trace    = ctx.Bool(BlockTestTraceFlag.Name) || ctx.GlobalBool(DebugFlag.Name)
+		debugger *logger.StructLogger
+		cfg      vm.Config
+	)
+	if trace {
+		debugger = logger.NewStructLogger(&logger.Config{
+			EnableMemory:     !ctx.GlobalBool(DisableMemoryFlag.Name),
+			DisableStack:     ctx.GlobalBool(DisableStackFlag.Name),
+			DisableStorage:   ctx.GlobalBool(DisableStorageFlag.Name),
+			EnableReturnData: !ctx.GlobalBool(DisableReturnDataFlag.Name),
+		})
+		cfg = vm.Config{Debug: true, Tracer: debugger}
+	}
+	// Load the test content from the input file
+	src, err := os.ReadFile(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+	var tests map[string]*tests.BlockTest
+	if err = json.Unmarshal(src, &tests); err != nil {
+		return err
+	}
+	// Run the tests in a deterministic order and aggregate the results
+	names := make([]string, 0, len(tests))
+	for name := range tests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var (
+		results = make([]BlocktestResult, 0, len(tests))
+		failed  int
+	)
+	for _, name := range names {
+		test := tests[name]
+		result := &BlocktestResult{Name: name, Fork: test.Network(), Pass: true}
+		if debugger != nil {
+			debugger.Reset()
+		}
+		err := test.Run(false, cfg, func(_ error, chain *core.BlockChain) {
+			if !ctx.GlobalBool(DumpFlag.Name) {
+				return
+			}
+			if statedb, err := chain.State(); err == nil {
+				dump := statedb.RawDump(nil)
+				result.State = &dump
+			}
+		})
+		if err != nil {
+			result.Pass, result.Error = false, err.Error()
+			failed++
+		}
+		results = append(results, *result)
+
+		// Print any structured logs collected
+		if debugger != nil {
+			fmt.Fprintf(os.Stderr, "#### TRACE %s ####\n", name)
+			logger.WriteTrace(os.Stderr, debugger.StructLogs())
+		}
+	}
+	out, _ := json.MarshalIndent(results, "", "  ")
+	fmt.Println(string(out))
+	if failed > 0 {
+		return fmt.Errorf("%d tests failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of go-mbali.
+//
+// go-mbali is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-mbali is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-mbali. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mbali/go-mbali/core/vm"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	HexFlag = cli.StringFlag{
+		Name:  "hex",
+		Usage: "single container data parse and validation",
+	}
+	EOFVerboseFlag = cli.BoolFlag{
+		Name:  "verbose",
+		Usage: "print the decoded containers",
+	}
+)
+
+var eofParseCommand = cli.Command{
+	Action: eofParseCmd,
+	Name:   "eofparse",
+	Usage:  "parses and validates EOF containers, read as hex from --hex or line by line from stdin",
+	Flags: []cli.Flag{
+		HexFlag,
+		EOFVerboseFlag,
+	},
+}
+
+func eofParseCmd(ctx *cli.Context) error {
+	var (
+		jt      = vm.NewEOFInstructionSet()
+		verbose = ctx.Bool(EOFVerboseFlag.Name)
+	)
+	if ctx.IsSet(HexFlag.Name) {
+		c, err := parseEOF(ctx.String(HexFlag.Name), &jt)
+		if err != nil {
+			return err
+		}
+		printEOF(c, verbose)
+		return nil
+	}
+	// Process the containers on stdin, one hex encoded container per line
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 1024*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		c, err := parseEOF(line, &jt)
+		if err != nil {
+			fmt.Printf("err: %v\n", err)
+			continue
+		}
+		printEOF(c, verbose)
+	}
+	return scanner.Err()
+}
+
+// parseEOF decodes a hex encoded EOF container and validates its code.
+func parseEOF(input string, jt *vm.JumpTable) (*vm.Container, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(input, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode data: %w", err)
+	}
+	var c vm.Container
+	if err := c.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	if err := c.ValidateCode(jt); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// printEOF prints the outcome of a successful validation, along with the
+// decoded container if requested.
+func printEOF(c *vm.Container, verbose bool) {
+	sections := make([]string, len(c.Code))
+	for i, code := range c.Code {
+		sections[i] = hex.EncodeToString(code)
+	}
+	fmt.Printf("OK %s\n", strings.Join(sections, ","))
+	if verbose {
+		fmt.Print(c)
+	}
+}
@@ -46,6 +46,10 @@ var (
 		Name:  "cpuprofile",
 		Usage: "creates a CPU profile at the given path",
 	}
+	EVMProfileFlag = cli.StringFlag{
+		Name:  "evmprofile",
+		Usage: "creates a pprof profile of the gas and time spent per contract and opcode at the given path",
+	}
 	StatDumpFlag = cli.BoolFlag{
 		Name:  "statdump",
 		Usage: "displays stack and heap memory information",
@@ -129,6 +133,10 @@ var (
 		Name:  "noreturndata",
 		Usage: "enable return data output",
 	}
+	ExtraEipsFlag = cli.StringFlag{
+		Name:  "extraeips",
+		Usage: "comma separated list of additional EIPs to activate (e.g. 1153,5656)",
+	}
 )
 
 var stateTransitionCommand = cli.Command{
@@ -204,6 +212,7 @@ func init() {
 		InputFileFlag,
 		MemProfileFlag,
 		CPUProfileFlag,
+		EVMProfileFlag,
 		StatDumpFlag,
 		GenesisFlag,
 		MachineFlag,
@@ -213,12 +222,15 @@ func init() {
 		DisableStackFlag,
 		DisableStorageFlag,
 		DisableReturnDataFlag,
+		ExtraEipsFlag,
 	}
 	app.Commands = []cli.Command{
 		compileCommand,
 		disasmCommand,
 		runCommand,
 		stateTestCommand,
+		blockTestCommand,
+		eofParseCommand,
 		stateTransitionCommand,
 		transactionCommand,
 		blockBuilderCommand,
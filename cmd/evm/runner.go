@@ -25,6 +25,8 @@ import (
 	"os"
 	goruntime "runtime"
 	"runtime/pprof"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -36,7 +38,9 @@ import (
 	"github.com/mbali/go-mbali/core/state"
 	"github.com/mbali/go-mbali/core/vm"
 	"github.com/mbali/go-mbali/core/vm/runtime"
+	"github.com/mbali/go-mbali/mbl/tracers"
 	"github.com/mbali/go-mbali/mbl/tracers/logger"
+	_ "github.com/mbali/go-mbali/mbl/tracers/native"
 	"github.com/mbali/go-mbali/log"
 	"github.com/mbali/go-mbali/params"
 	"gopkg.in/urfave/cli.v1"
@@ -104,6 +108,26 @@ func timedExec(bench bool, execFunc func() ([]byte, uint64, error)) (output []by
 	return output, gasLeft, stats, err
 }
 
+// parseExtraEips parses a comma separated list of EIP numbers and checks that
+// each of them can be activated on the EVM.
+func parseExtraEips(list string) ([]int, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var eips []int
+	for _, field := range strings.Split(list, ",") {
+		eip, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid eip %q: %v", field, err)
+		}
+		if !vm.ValidEip(eip) {
+			return nil, fmt.Errorf("eip %d not activatable, valid ones: %v", eip, strings.Join(vm.ActivateableEips(), ","))
+		}
+		eips = append(eips, eip)
+	}
+	return eips, nil
+}
+
 func runCmd(ctx *cli.Context) error {
 	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
 	glogger.Verbosity(log.Lvl(ctx.GlobalInt(VerbosityFlag.Name)))
@@ -133,6 +157,17 @@ func runCmd(ctx *cli.Context) error {
 	} else {
 		debugLogger = logger.NewStructLogger(logconfig)
 	}
+	var profiler tracers.Tracer
+	if ctx.GlobalString(EVMProfileFlag.Name) != "" {
+		if tracer != nil {
+			return fmt.Errorf("--%s cannot be combined with --%s or --%s", EVMProfileFlag.Name, DebugFlag.Name, MachineFlag.Name)
+		}
+		var err error
+		if profiler, err = tracers.New("pprofTracer", nil); err != nil {
+			return err
+		}
+		tracer = profiler
+	}
 	if ctx.GlobalString(GenesisFlag.Name) != "" {
 		gen := readGenesis(ctx.GlobalString(GenesisFlag.Name))
 		genesisConfig = gen
@@ -197,6 +232,10 @@ func runCmd(ctx *cli.Context) error {
 		}
 		code = common.Hex2Bytes(bin)
 	}
+	extraEips, err := parseExtraEips(ctx.GlobalString(ExtraEipsFlag.Name))
+	if err != nil {
+		return err
+	}
 	initialGas := ctx.GlobalUint64(GasFlag.Name)
 	if genesisConfig.GasLimit != 0 {
 		initialGas = genesisConfig.GasLimit
@@ -212,8 +251,9 @@ func runCmd(ctx *cli.Context) error {
 		Coinbase:    genesisConfig.Coinbase,
 		BlockNumber: new(big.Int).SetUint64(genesisConfig.Number),
 		EVMConfig: vm.Config{
-			Tracer: tracer,
-			Debug:  ctx.GlobalBool(DebugFlag.Name) || ctx.GlobalBool(MachineFlag.Name),
+			Tracer:    tracer,
+			Debug:     tracer != nil,
+			ExtraEips: extraEips,
 		},
 	}
 
@@ -291,6 +331,13 @@ func runCmd(ctx *cli.Context) error {
 		f.Close()
 	}
 
+	if profiler != nil {
+		if err := writeEVMProfile(profiler, ctx.GlobalString(EVMProfileFlag.Name)); err != nil {
+			fmt.Println("could not write EVM profile: ", err)
+			os.Exit(1)
+		}
+	}
+
 	if ctx.GlobalBool(DebugFlag.Name) {
 		if debugLogger != nil {
 			fmt.Fprintln(os.Stderr, "#### TRACE ####")
@@ -307,7 +354,7 @@ allocations:     %d
 allocated bytes: %d
 `, initialGas-leftOverGas, stats.time, stats.allocs, stats.bytesAllocated)
 	}
-	if tracer == nil {
+	if tracer == nil || profiler != nil {
 		fmt.Printf("0x%x\n", output)
 		if err != nil {
 			fmt.Printf(" error: %v\n", err)
@@ -316,3 +363,19 @@ allocated bytes: %d
 
 	return nil
 }
+
+// writeEVMProfile writes the pprof profile collected by the profiler tracer to
+// the given path.
+func writeEVMProfile(profiler tracers.Tracer, path string) error {
+	res, err := profiler.GetResult()
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Pprof []byte `json:"pprof"`
+	}
+	if err := json.Unmarshal(res, &result); err != nil {
+		return err
+	}
+	return os.WriteFile(path, result.Pprof, 0644)
+}
@@ -18,6 +18,7 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
@@ -32,7 +33,9 @@ import (
 	"github.com/mbali/go-mbali/common"
 	"github.com/mbali/go-mbali/common/hexutil"
 	"github.com/mbali/go-mbali/console/prompt"
+	"github.com/mbali/go-mbali/core"
 	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/core/state"
 	"github.com/mbali/go-mbali/core/state/snapshot"
 	"github.com/mbali/go-mbali/core/types"
 	"github.com/mbali/go-mbali/crypto"
@@ -73,6 +76,8 @@ Remove blockchain and state databases`,
 			dbMetadataCmd,
 			dbMigrateFreezerCmd,
 			dbCheckStateContentCmd,
+			dbIndexArchiveCmd,
+			dbVerifyCmd,
 		},
 	}
 	dbInspectCmd = cli.Command{
@@ -208,6 +213,43 @@ WARNING: This is a low-level operation which may cause database corruption!`,
 		Description: `The freezer-migrate command checks your database for receipts in a legacy format and updates those.
 WARNING: please back-up the receipt files in your ancients before running this command.`,
 	}
+	dbVerifyRepairFlag = cli.BoolFlag{
+		Name:  "repair",
+		Usage: "Fix recoverable inconsistencies in place",
+	}
+	dbVerifyCmd = cli.Command{
+		Action:    utils.MigrateFlags(verifyChainData),
+		Name:      "verify",
+		Usage:     "Verify the consistency of the chain data in the freezer and key-value store",
+		ArgsUsage: "",
+		Flags: utils.GroupFlags([]cli.Flag{
+			utils.SyncModeFlag,
+			dbVerifyRepairFlag,
+		}, utils.NetworkFlags, utils.DatabasePathFlags),
+		Description: `This command walks the canonical chain from genesis to the head header, cross
+checking the canonical hashes, headers, bodies, receipts, total difficulties and
+transaction lookup entries stored in the freezer and the key-value store against
+each other and the head markers. Inconsistencies are reported with the range of
+affected blocks.
+
+With --repair, the issues which can be recomputed from other chain data (hash to
+number mappings, total difficulties in the key-value store, transaction lookup
+entries and stale canonical hashes above the head) are fixed in place. The node
+must not be running.`,
+	}
+	dbIndexArchiveCmd = cli.Command{
+		Action:    utils.MigrateFlags(indexArchive),
+		Name:      "index-archive",
+		Usage:     "Build the archive index of historical state up to the current head",
+		ArgsUsage: "",
+		Flags: utils.GroupFlags([]cli.Flag{
+			utils.SyncModeFlag,
+		}, utils.NetworkFlags, utils.DatabasePathFlags),
+		Description: `The index-archive command builds the flat archive index used by --cache.archiveindex
+for an existing archive node. It walks the canonical chain from the last indexed block
+(or genesis) up to the current head, diffing the state of consecutive blocks, and can
+be interrupted and resumed at any time. The node must not be running.`,
+	}
 )
 
 func removeDB(ctx *cli.Context) error {
@@ -795,6 +837,85 @@ func freezerMigrate(ctx *cli.Context) error {
 	return nil
 }
 
+func verifyChainData(ctx *cli.Context) error {
+	if ctx.NArg() != 0 {
+		return fmt.Errorf("no arguments required: %v", ctx.Command.ArgsUsage)
+	}
+	var (
+		repair    = ctx.Bool(dbVerifyRepairFlag.Name)
+		stack, _  = makeConfigNode(ctx)
+		interrupt = make(chan os.Signal, 1)
+		stop      = make(chan struct{})
+	)
+	defer stack.Close()
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	defer close(interrupt)
+	go func() {
+		if _, ok := <-interrupt; ok {
+			log.Info("Interrupted during chain verification, stopping")
+		}
+		close(stop)
+	}()
+	db := utils.MakeChainDatabase(ctx, stack, !repair)
+	defer db.Close()
+
+	start := time.Now()
+	issues, err := core.VerifyChainData(db, repair, stop)
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+	if err != nil {
+		return err
+	}
+	var unrepaired int
+	for _, issue := range issues {
+		if !issue.Repaired {
+			unrepaired++
+		}
+	}
+	log.Info("Verified chain data", "issues", len(issues), "unrepaired", unrepaired, "elapsed", common.PrettyDuration(time.Since(start)))
+	if unrepaired > 0 {
+		return fmt.Errorf("found %d unrepaired inconsistencies", unrepaired)
+	}
+	return nil
+}
+
+func indexArchive(ctx *cli.Context) error {
+	if ctx.NArg() != 0 {
+		return fmt.Errorf("no arguments required: %v", ctx.Command.ArgsUsage)
+	}
+	var (
+		stack, _  = makeConfigNode(ctx)
+		interrupt = make(chan os.Signal, 1)
+		stop      = make(chan struct{})
+	)
+	defer stack.Close()
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	defer close(interrupt)
+	go func() {
+		if _, ok := <-interrupt; ok {
+			log.Info("Interrupted during archive indexing, stopping at next block")
+		}
+		close(stop)
+	}()
+	db := utils.MakeChainDatabase(ctx, stack, false)
+	defer db.Close()
+
+	head := rawdb.ReadHeadBlock(db)
+	if head == nil {
+		return errors.New("no head block found")
+	}
+	log.Info("Indexing archive state", "head", head.NumberU64(), "hash", head.Hash())
+	start := time.Now()
+	if err := core.IndexArchive(db, state.NewDatabase(db), head.Header(), stop); err != nil {
+		return err
+	}
+	log.Info("Archive state indexed", "head", head.NumberU64(), "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
 // dbHasLegacyReceipts checks freezer entries for legacy receipts. It stops at the first
 // non-empty receipt and checks its format. The index of this first non-empty element is
 // the second return parameter.
@@ -17,6 +17,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
@@ -166,6 +167,39 @@ as the backend data source, making this command a lot faster.
 
 The argument is interpreted as block number or hash. If none is provided, the latest
 block is used.
+`,
+			},
+			{
+				Name:      "export",
+				Usage:     "Export the snapshot of a state into a compressed dump file",
+				ArgsUsage: "<root> <dumpfile>",
+				Action:    utils.MigrateFlags(exportSnapshot),
+				Category:  "MISCELLANEOUS COMMANDS",
+				Flags:     utils.GroupFlags(utils.NetworkFlags, utils.DatabasePathFlags),
+				Description: `
+gombl snapshot export <state-root> <dumpfile>
+will write the flat account and storage snapshot of the specified state, along
+with all referenced contract codes, into a gzip compressed dump file. The data
+is split into chunks, each carrying a verification hash. If the state root is
+empty or "latest", the HEAD state is exported.
+`,
+			},
+			{
+				Name:      "import",
+				Usage:     "Import a state snapshot dump and regenerate the state trie",
+				ArgsUsage: "<dumpfile> [<root>]",
+				Action:    utils.MigrateFlags(importSnapshot),
+				Category:  "MISCELLANEOUS COMMANDS",
+				Flags:     utils.GroupFlags(utils.NetworkFlags, utils.DatabasePathFlags),
+				Description: `
+gombl snapshot import <dumpfile> [<state-root>]
+will read a dump created by 'gombl snapshot export', verify the checksum of every
+chunk, write the flat snapshot and contract codes into the database and regenerate
+the state trie from it. The resulting root is checked against the one recorded in
+the dump and, if given, against the specified state root.
+
+This can be used to bootstrap the state of fresh nodes without snap syncing it
+from the network.
 `,
 			},
 		},
@@ -579,3 +613,82 @@ func checkAccount(ctx *cli.Context) error {
 	log.Info("Checked the snapshot journalled storage", "time", common.PrettyDuration(time.Since(start)))
 	return nil
 }
+
+// exportSnapshot writes the flat snapshot of the given state into a dump file.
+func exportSnapshot(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		return errors.New("need <root> <dumpfile> args")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chaindb := utils.MakeChainDatabase(ctx, stack, true)
+	defer chaindb.Close()
+
+	headBlock := rawdb.ReadHeadBlock(chaindb)
+	if headBlock == nil {
+		log.Error("Failed to load head block")
+		return errors.New("no head block")
+	}
+	root := headBlock.Root()
+	if arg := ctx.Args()[0]; arg != "" && arg != "latest" {
+		var err error
+		if root, err = parseRoot(arg); err != nil {
+			log.Error("Failed to resolve state root", "err", err)
+			return err
+		}
+	}
+	snaptree, err := snapshot.New(chaindb, trie.NewDatabase(chaindb), 256, headBlock.Root(), false, false, false)
+	if err != nil {
+		log.Error("Failed to open snapshot tree", "err", err)
+		return err
+	}
+	fh, err := os.OpenFile(ctx.Args()[1], os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	writer := bufio.NewWriter(fh)
+	if err := snapshot.Export(snaptree, root, chaindb, writer); err != nil {
+		log.Error("Failed to export snapshot", "root", root, "err", err)
+		return err
+	}
+	return writer.Flush()
+}
+
+// importSnapshot loads a snapshot dump into the database and regenerates the
+// state trie from it.
+func importSnapshot(ctx *cli.Context) error {
+	if ctx.NArg() < 1 || ctx.NArg() > 2 {
+		return errors.New("need <dumpfile> [<root>] args")
+	}
+	var (
+		root common.Hash
+		err  error
+	)
+	if ctx.NArg() == 2 {
+		if root, err = parseRoot(ctx.Args()[1]); err != nil {
+			log.Error("Failed to resolve state root", "err", err)
+			return err
+		}
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chaindb := utils.MakeChainDatabase(ctx, stack, false)
+	defer chaindb.Close()
+
+	fh, err := os.Open(ctx.Args()[0])
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	if root, err = snapshot.Import(chaindb, bufio.NewReader(fh), root); err != nil {
+		log.Error("Failed to import snapshot", "err", err)
+		return err
+	}
+	log.Info("Imported state snapshot", "root", root)
+	return nil
+}
@@ -112,6 +112,7 @@ var AppHelpFlagGroups = []flags.FlagGroup{
 			utils.CacheSnapshotFlag,
 			utils.CacheNoPrefetchFlag,
 			utils.CachePreimagesFlag,
+			utils.CacheArchiveIndexFlag,
 			utils.FDLimitFlag,
 		},
 	},
@@ -166,10 +167,16 @@ var AppHelpFlagGroups = []flags.FlagGroup{
 			utils.ListenPortFlag,
 			utils.MaxPeersFlag,
 			utils.MaxPendingPeersFlag,
+			utils.EgressRateFlag,
+			utils.PeerEgressRateFlag,
 			utils.NATFlag,
 			utils.NoDiscoverFlag,
 			utils.DiscoveryV5Flag,
 			utils.NetrestrictFlag,
+			utils.NetdenyFlag,
+			utils.NetlistsFileFlag,
+			utils.NoDiversityFlag,
+			utils.NetCaptureFlag,
 			utils.NodeKeyFileFlag,
 			utils.NodeKeyHexFlag,
 		},
@@ -202,6 +209,8 @@ var AppHelpFlagGroups = []flags.FlagGroup{
 		Name: "VIRTUAL MACHINE",
 		Flags: []cli.Flag{
 			utils.VMEnableDebugFlag,
+			utils.VMTraceFlag,
+			utils.VMTraceOutputFlag,
 		},
 	},
 	{
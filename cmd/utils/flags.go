@@ -444,6 +444,10 @@ var (
 		Name:  "cache.preimages",
 		Usage: "Enable recording the SHA3/keccak preimages of trie keys",
 	}
+	CacheArchiveIndexFlag = cli.BoolFlag{
+		Name:  "cache.archiveindex",
+		Usage: "Maintain a flat index of historical account and storage values for fast state queries (archive mode only)",
+	}
 	FDLimitFlag = cli.IntFlag{
 		Name:  "fdlimit",
 		Usage: "Raise the open file descriptor resource limit (default = system fd limit)",
@@ -514,6 +518,14 @@ var (
 		Name:  "vmdebug",
 		Usage: "Record information useful for VM and contract debugging",
 	}
+	VMTraceFlag = cli.StringFlag{
+		Name:  "vmtrace",
+		Usage: "Name of the tracer to run against every imported block (e.g. callTracer)",
+	}
+	VMTraceOutputFlag = cli.StringFlag{
+		Name:  "vmtrace.output",
+		Usage: "File the traces of the imported blocks are appended to, as JSON lines",
+	}
 	InsecureUnlockAllowedFlag = cli.BoolFlag{
 		Name:  "allow-insecure-unlock",
 		Usage: "Allow insecure account unlocking when account-related RPCs are exposed by http",
@@ -680,6 +692,14 @@ var (
 		Usage: "Maximum number of pending connection attempts (defaults used if set to 0)",
 		Value: node.DefaultConfig.P2P.MaxPendingPeers,
 	}
+	EgressRateFlag = cli.IntFlag{
+		Name:  "bandwidth.egress",
+		Usage: "Maximum bandwidth used to send messages to all peers in KiB/s (0 = unlimited)",
+	}
+	PeerEgressRateFlag = cli.IntFlag{
+		Name:  "bandwidth.peeregress",
+		Usage: "Maximum bandwidth used to send messages to each peer in KiB/s (0 = unlimited)",
+	}
 	ListenPortFlag = cli.IntFlag{
 		Name:  "port",
 		Usage: "Network listening port",
@@ -700,7 +720,7 @@ var (
 	}
 	NATFlag = cli.StringFlag{
 		Name:  "nat",
-		Usage: "NAT port mapping mechanism (any|none|upnp|pmp|extip:<IP>)",
+		Usage: "NAT port mapping mechanism (any|none|upnp|pmp|extip:<IP>|stun[:<host:port>,...])",
 		Value: "any",
 	}
 	NoDiscoverFlag = cli.BoolFlag{
@@ -715,6 +735,22 @@ var (
 		Name:  "netrestrict",
 		Usage: "Restricts network communication to the given IP networks (CIDR masks)",
 	}
+	NetdenyFlag = cli.StringFlag{
+		Name:  "netdeny",
+		Usage: "Forbids network communication with the given IP networks (CIDR masks)",
+	}
+	NetlistsFileFlag = cli.StringFlag{
+		Name:  "netlists",
+		Usage: "File of IP allow and deny lists, reloaded on change (overrides --netrestrict and --netdeny)",
+	}
+	NoDiversityFlag = cli.BoolFlag{
+		Name:  "nodiversity",
+		Usage: "Disables the limits on peers from the same IP subnet",
+	}
+	NetCaptureFlag = DirectoryFlag{
+		Name:  "netcapture",
+		Usage: "Directory in which the messages exchanged with peers are recorded (for debugging)",
+	}
 	DNSDiscoveryFlag = cli.StringFlag{
 		Name:  "discovery.dns",
 		Usage: "Sets DNS discovery entry points (use \"\" to disable DNS)",
@@ -1275,6 +1311,12 @@ func SetP2PConfig(ctx *cli.Context, cfg *p2p.Config) {
 	if ctx.GlobalIsSet(MaxPendingPeersFlag.Name) {
 		cfg.MaxPendingPeers = ctx.GlobalInt(MaxPendingPeersFlag.Name)
 	}
+	if ctx.GlobalIsSet(EgressRateFlag.Name) {
+		cfg.MaxEgressRate = ctx.GlobalInt(EgressRateFlag.Name) * 1024
+	}
+	if ctx.GlobalIsSet(PeerEgressRateFlag.Name) {
+		cfg.MaxPeerEgressRate = ctx.GlobalInt(PeerEgressRateFlag.Name) * 1024
+	}
 	if ctx.GlobalIsSet(NoDiscoverFlag.Name) || lightClient {
 		cfg.NoDiscovery = true
 	}
@@ -1296,6 +1338,22 @@ func SetP2PConfig(ctx *cli.Context, cfg *p2p.Config) {
 		}
 		cfg.NetRestrict = list
 	}
+	if netdeny := ctx.GlobalString(NetdenyFlag.Name); netdeny != "" {
+		list, err := netutil.ParseNetlist(netdeny)
+		if err != nil {
+			Fatalf("Option %q: %v", NetdenyFlag.Name, err)
+		}
+		cfg.NetDeny = list
+	}
+	if ctx.GlobalIsSet(NetlistsFileFlag.Name) {
+		cfg.NetListsFile = ctx.GlobalString(NetlistsFileFlag.Name)
+	}
+	if ctx.GlobalIsSet(NoDiversityFlag.Name) {
+		cfg.Diversity.Disabled = ctx.GlobalBool(NoDiversityFlag.Name)
+	}
+	if ctx.GlobalIsSet(NetCaptureFlag.Name) {
+		cfg.Capture.Dir = ctx.GlobalString(NetCaptureFlag.Name)
+	}
 
 	if ctx.GlobalBool(DeveloperFlag.Name) {
 		// --dev mode can't use p2p networking.
@@ -1653,6 +1711,12 @@ func SetmblConfig(ctx *cli.Context, stack *node.Node, cfg *mblconfig.Config) {
 		cfg.Preimages = true
 		log.Info("Enabling recording of key preimages since archive mode is used")
 	}
+	if ctx.GlobalBool(CacheArchiveIndexFlag.Name) {
+		if !cfg.NoPruning {
+			Fatalf("--%s requires --%s=archive", CacheArchiveIndexFlag.Name, GCModeFlag.Name)
+		}
+		cfg.ArchiveIndex = true
+	}
 	if ctx.GlobalIsSet(TxLookupLimitFlag.Name) {
 		cfg.TxLookupLimit = ctx.GlobalUint64(TxLookupLimitFlag.Name)
 	}
@@ -1687,6 +1751,10 @@ func SetmblConfig(ctx *cli.Context, stack *node.Node, cfg *mblconfig.Config) {
 		// TODO(fjl): force-enable this in --dev mode
 		cfg.EnablePreimageRecording = ctx.GlobalBool(VMEnableDebugFlag.Name)
 	}
+	if ctx.GlobalIsSet(VMTraceFlag.Name) {
+		cfg.VMTrace = ctx.GlobalString(VMTraceFlag.Name)
+		cfg.VMTraceOutput = ctx.GlobalString(VMTraceOutputFlag.Name)
+	}
 
 	if ctx.GlobalIsSet(RPCGlobalGasCapFlag.Name) {
 		cfg.RPCGasCap = ctx.GlobalUint64(RPCGlobalGasCapFlag.Name)
@@ -2034,6 +2102,10 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 		TrieTimeLimit:       mblconfig.Defaults.TrieTimeout,
 		SnapshotLimit:       mblconfig.Defaults.SnapshotCache,
 		Preimages:           ctx.GlobalBool(CachePreimagesFlag.Name),
+		ArchiveIndex:        ctx.GlobalBool(CacheArchiveIndexFlag.Name),
+	}
+	if cache.ArchiveIndex && !cache.TrieDirtyDisabled {
+		Fatalf("--%s requires --%s=archive", CacheArchiveIndexFlag.Name, GCModeFlag.Name)
 	}
 	if cache.TrieDirtyDisabled && !cache.Preimages {
 		cache.Preimages = true
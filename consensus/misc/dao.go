@@ -22,6 +22,7 @@ import (
 	"math/big"
 
 	"github.com/mbali/go-mbali/core/state"
+	"github.com/mbali/go-mbali/core/tracing"
 	"github.com/mbali/go-mbali/core/types"
 	"github.com/mbali/go-mbali/params"
 )
@@ -79,7 +80,8 @@ func ApplyDAOHardFork(statedb *state.StateDB) {
 
 	// Move every DAO account and extra-balance account funds into the refund contract
 	for _, addr := range params.DAODrainList() {
-		statedb.AddBalance(params.DAORefundContract, statedb.GetBalance(addr))
-		statedb.SetBalance(addr, new(big.Int))
+		balance := statedb.GetBalance(addr)
+		statedb.AddBalance(params.DAORefundContract, balance, tracing.BalanceIncreaseDaoContract)
+		statedb.SubBalance(addr, balance, tracing.BalanceDecreaseDaoAccount)
 	}
 }
@@ -0,0 +1,213 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/core/state"
+	"github.com/mbali/go-mbali/core/types"
+	"github.com/mbali/go-mbali/log"
+	"github.com/mbali/go-mbali/mbldb"
+)
+
+var (
+	// errArchiveIndexInterrupted is returned if indexing was aborted.
+	errArchiveIndexInterrupted = errors.New("archive indexing interrupted")
+
+	// errArchiveIndexReorged is returned if the canonical chain changed while
+	// indexing it. The index is left consistent up to the last indexed block.
+	errArchiveIndexReorged = errors.New("canonical chain changed during archive indexing")
+)
+
+// ArchiveIndexHead returns the number of the latest block in the archive index
+// if the index is built along the current canonical chain.
+func ArchiveIndexHead(db mbldb.Reader) (uint64, bool) {
+	hash := rawdb.ReadArchiveIndexHead(db)
+	if hash == (common.Hash{}) {
+		return 0, false
+	}
+	number := rawdb.ReadHeaderNumber(db, hash)
+	if number == nil || rawdb.ReadCanonicalHash(db, *number) != hash {
+		return 0, false
+	}
+	return *number, true
+}
+
+// IndexArchive moves the archive index to the given head block. Indexed blocks
+// which are not ancestors of head are removed first, after which the missing
+// blocks are indexed along the canonical chain. All canonical hashes below head
+// must already be written and the state of all affected blocks must be present.
+//
+// Every block is indexed or unindexed in its own batch, so the process can be
+// interrupted at any point and resumed later.
+func IndexArchive(db mbldb.Database, statedb state.Database, head *types.Header, interrupt chan struct{}) error {
+	var (
+		number   = head.Number.Uint64()
+		next     uint64
+		prevHash common.Hash
+		prevRoot = types.EmptyRootHash
+	)
+	// Unwind any indexed blocks not on the chain of the new head
+	for {
+		select {
+		case <-interrupt:
+			return errArchiveIndexInterrupted
+		default:
+		}
+		hash := rawdb.ReadArchiveIndexHead(db)
+		if hash == (common.Hash{}) {
+			break
+		}
+		if hash == head.Hash() {
+			return nil
+		}
+		indexed := rawdb.ReadHeaderNumber(db, hash)
+		if indexed == nil {
+			return fmt.Errorf("archive index head %x unknown", hash)
+		}
+		header := rawdb.ReadHeader(db, hash, *indexed)
+		if header == nil {
+			return fmt.Errorf("archive index head #%d [%x] missing", *indexed, hash)
+		}
+		if *indexed < number && rawdb.ReadCanonicalHash(db, *indexed) == hash {
+			next, prevHash, prevRoot = *indexed+1, hash, header.Root
+			break
+		}
+		parentRoot := types.EmptyRootHash
+		if *indexed > 0 {
+			parent := rawdb.ReadHeader(db, header.ParentHash, *indexed-1)
+			if parent == nil {
+				return fmt.Errorf("archive index block #%d [%x] parent missing", *indexed, hash)
+			}
+			parentRoot = parent.Root
+		}
+		batch := db.NewBatch()
+		if err := state.UnindexArchiveBlock(statedb, batch, *indexed, parentRoot, header.Root); err != nil {
+			return err
+		}
+		if *indexed == 0 {
+			rawdb.DeleteArchiveIndexHead(batch)
+		} else {
+			rawdb.WriteArchiveIndexHead(batch, header.ParentHash)
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		log.Debug("Unindexed archive block", "number", *indexed, "hash", hash)
+	}
+	// Index all the blocks missing from the new head's chain
+	var (
+		start  = time.Now()
+		logged = time.Now()
+	)
+	for n := next; n <= number; n++ {
+		header := head
+		if n < number {
+			if header = rawdb.ReadHeader(db, rawdb.ReadCanonicalHash(db, n), n); header == nil {
+				return fmt.Errorf("canonical header #%d missing", n)
+			}
+		}
+		if header.ParentHash != prevHash {
+			return errArchiveIndexReorged
+		}
+		batch := db.NewBatch()
+		if err := state.IndexArchiveBlock(statedb, batch, n, prevRoot, header.Root); err != nil {
+			return err
+		}
+		rawdb.WriteArchiveIndexHead(batch, header.Hash())
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		prevHash, prevRoot = header.Hash(), header.Root
+
+		select {
+		case <-interrupt:
+			return errArchiveIndexInterrupted
+		default:
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Indexing archive state", "number", n, "head", number, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	return nil
+}
+
+// maintainArchiveIndex keeps the archive index in sync with the canonical chain,
+// moving it to every new chain head in the background. Heads announced while a
+// previous run is still active are coalesced, only the latest one is indexed.
+func (bc *BlockChain) maintainArchiveIndex() {
+	defer bc.wg.Done()
+
+	// indexHead moves the archive index to the given head, reporting any failure
+	indexHead := func(head *types.Header, done chan struct{}) {
+		defer func() { done <- struct{}{} }()
+
+		err := IndexArchive(bc.db, bc.stateCache, head, bc.quit)
+		switch {
+		case err == nil:
+		case errors.Is(err, errArchiveIndexInterrupted), errors.Is(err, errArchiveIndexReorged):
+			log.Debug("Archive indexing aborted", "number", head.Number, "hash", head.Hash(), "err", err)
+		default:
+			log.Error("Failed to update archive index", "number", head.Number, "hash", head.Hash(), "err", err)
+		}
+	}
+	// Catch up with the current head, then follow the chain head events
+	var (
+		done    = make(chan struct{})          // Non-nil if background indexing routine is active.
+		pending *types.Header                  // Latest head announced while indexing was active
+		headCh  = make(chan ChainHeadEvent, 1) // Buffered to avoid locking up the event feed
+	)
+	go indexHead(bc.CurrentBlock().Header(), done)
+
+	sub := bc.SubscribeChainHeadEvent(headCh)
+	if sub == nil {
+		<-done
+		return
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case head := <-headCh:
+			if done == nil {
+				done = make(chan struct{})
+				go indexHead(head.Block.Header(), done)
+			} else {
+				pending = head.Block.Header()
+			}
+		case <-done:
+			done = nil
+			if pending != nil {
+				done = make(chan struct{})
+				go indexHead(pending, done)
+				pending = nil
+			}
+		case <-bc.quit:
+			if done != nil {
+				log.Info("Waiting background archive indexer to exit")
+				<-done
+			}
+			return
+		}
+	}
+}
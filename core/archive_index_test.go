@@ -0,0 +1,137 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/consensus/mblash"
+	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/core/types"
+	"github.com/mbali/go-mbali/core/vm"
+	"github.com/mbali/go-mbali/crypto"
+	"github.com/mbali/go-mbali/params"
+)
+
+// Tests that the archive index follows the canonical chain across imports and
+// reorgs, serving the same historical state as the tries.
+func TestArchiveIndexReorg(t *testing.T) {
+	var (
+		gendb   = rawdb.NewMemoryDatabase()
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		funds   = big.NewInt(1000000000000000)
+		gspec   = &Genesis{
+			Config:  params.TestChainConfig,
+			Alloc:   GenesisAlloc{address: {Balance: funds}},
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
+		genesis = gspec.MustCommit(gendb)
+		signer  = types.LatestSigner(gspec.Config)
+	)
+	makeChain := func(parent *types.Block, n int, recipient common.Address) []*types.Block {
+		blocks, _ := GenerateChain(gspec.Config, parent, mblash.NewFaker(), gendb, n, func(i int, block *BlockGen) {
+			block.SetCoinbase(common.Address{0x00})
+			tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), recipient, big.NewInt(int64(1000*(i+1))), params.TxGas, block.header.BaseFee, nil), signer, key)
+			if err != nil {
+				panic(err)
+			}
+			block.AddTx(tx)
+		})
+		return blocks
+	}
+	var (
+		first  = makeChain(genesis, 6, common.Address{0x01})
+		second = makeChain(first[1], 8, common.Address{0x02})
+	)
+	db := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(db)
+
+	cacheConfig := *defaultCacheConfig
+	cacheConfig.TrieDirtyDisabled = true
+	cacheConfig.SnapshotLimit = 0
+	cacheConfig.ArchiveIndex = true
+
+	chain, err := NewBlockChain(db, &cacheConfig, gspec.Config, mblash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	check := func(blocks []*types.Block) {
+		t.Helper()
+
+		// Wait for the background indexer to reach the chain head
+		head := chain.CurrentBlock()
+		for i := 0; ; i++ {
+			number, ok := ArchiveIndexHead(db)
+			if ok && number == head.NumberU64() {
+				break
+			}
+			if i == 100 {
+				t.Fatalf("archive index head mismatch: have #%d (%v), want #%d", number, ok, head.NumberU64())
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		for _, block := range append([]*types.Block{genesis}, blocks...) {
+			header := chain.GetHeaderByHash(block.Hash())
+			have, err := chain.HistoricStateAt(header)
+			if err != nil {
+				t.Fatalf("block #%d: failed to open historic state: %v", header.Number, err)
+			}
+			want, err := chain.StateAt(header.Root)
+			if err != nil {
+				t.Fatalf("block #%d: failed to open state: %v", header.Number, err)
+			}
+			for _, addr := range []common.Address{address, {0x00}, {0x01}, {0x02}} {
+				if have.GetBalance(addr).Cmp(want.GetBalance(addr)) != 0 {
+					t.Errorf("block #%d, account %x: balance mismatch: have %v, want %v", header.Number, addr, have.GetBalance(addr), want.GetBalance(addr))
+				}
+				if have.GetNonce(addr) != want.GetNonce(addr) {
+					t.Errorf("block #%d, account %x: nonce mismatch: have %v, want %v", header.Number, addr, have.GetNonce(addr), want.GetNonce(addr))
+				}
+			}
+		}
+	}
+	if n, err := chain.InsertChain(first); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	check(first)
+
+	if n, err := chain.InsertChain(second); err != nil {
+		t.Fatalf("failed to insert fork block %d: %v", n, err)
+	}
+	if chain.CurrentBlock().Hash() != second[len(second)-1].Hash() {
+		t.Fatalf("chain did not reorg to the fork")
+	}
+	check(append(first[:2:2], second...))
+
+	// Blocks of the dropped chain must not be served from the index any more
+	it := db.NewIterator(append(common.CopyBytes(rawdb.ArchiveAccountPrefix), crypto.Keccak256(common.Address{0x01}.Bytes())...), nil)
+	defer it.Release()
+
+	var entries int
+	for it.Next() {
+		entries++
+	}
+	if entries != 2 {
+		t.Errorf("dropped account history length mismatch: have %d, want 2", entries)
+	}
+}
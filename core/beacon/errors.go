@@ -76,6 +76,7 @@ var (
 
 	INVALIDBLOCKHASH = "INVALID_BLOCK_HASH"
 
+	InvalidParams            = &EngineAPIError{code: -32602, msg: "Invalid parameters"}
 	GenericServerError       = &EngineAPIError{code: -32000, msg: "Server error"}
 	UnknownPayload           = &EngineAPIError{code: -38001, msg: "Unknown payload"}
 	InvalidForkChoiceState   = &EngineAPIError{code: -38002, msg: "Invalid forkchoice state"}
@@ -19,12 +19,14 @@ package core
 import (
 	"crypto/ecdsa"
 	"math/big"
+	"runtime"
 	"testing"
 
 	"github.com/mbali/go-mbali/common"
 	"github.com/mbali/go-mbali/common/math"
 	"github.com/mbali/go-mbali/consensus/mblash"
 	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/core/state"
 	"github.com/mbali/go-mbali/core/types"
 	"github.com/mbali/go-mbali/core/vm"
 	"github.com/mbali/go-mbali/crypto"
@@ -205,6 +207,60 @@ func benchInsertChain(b *testing.B, disk bool, gen func(int, *BlockGen)) {
 	}
 }
 
+func BenchmarkStateCommit_storage_sequential(b *testing.B) {
+	benchStateCommit(b, 1)
+}
+func BenchmarkStateCommit_storage_parallel(b *testing.B) {
+	benchStateCommit(b, runtime.NumCPU())
+}
+
+// benchStateCommit measures hashing and committing a block's worth of storage
+// updates spread across many contracts, using the given number of concurrent
+// storage trie workers.
+func benchStateCommit(b *testing.B, workers int) {
+	const (
+		contracts = 64
+		slots     = 1000
+	)
+	var (
+		db        = state.NewDatabase(rawdb.NewMemoryDatabase())
+		addresses = make([]common.Address, contracts)
+	)
+	for i := range addresses {
+		addresses[i] = common.BigToAddress(big.NewInt(int64(i + 1)))
+	}
+	// Create the contracts with a pre-populated storage
+	sdb, _ := state.New(common.Hash{}, db, nil)
+	for _, addr := range addresses {
+		sdb.SetNonce(addr, 1)
+		for j := 0; j < slots; j++ {
+			sdb.SetState(addr, common.BigToHash(big.NewInt(int64(j))), common.BigToHash(big.NewInt(int64(j+1))))
+		}
+	}
+	root, err := sdb.Commit(false)
+	if err != nil {
+		b.Fatalf("failed to commit state: %v", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Overwrite all the slots of every contract and commit the changes
+		b.StopTimer()
+		sdb, _ := state.New(root, db, nil)
+		sdb.SetStorageConcurrency(workers)
+		for _, addr := range addresses {
+			for j := 0; j < slots; j++ {
+				sdb.SetState(addr, common.BigToHash(big.NewInt(int64(j))), common.BigToHash(big.NewInt(int64(i+j+2))))
+			}
+		}
+		b.StartTimer()
+
+		if _, err := sdb.Commit(false); err != nil {
+			b.Fatalf("failed to commit state: %v", err)
+		}
+	}
+}
+
 func BenchmarkChainRead_header_10k(b *testing.B) {
 	benchReadChain(b, false, 10000)
 }
@@ -34,6 +34,7 @@ import (
 	"github.com/mbali/go-mbali/core/rawdb"
 	"github.com/mbali/go-mbali/core/state"
 	"github.com/mbali/go-mbali/core/state/snapshot"
+	"github.com/mbali/go-mbali/core/tracing"
 	"github.com/mbali/go-mbali/core/types"
 	"github.com/mbali/go-mbali/core/vm"
 	"github.com/mbali/go-mbali/mbldb"
@@ -130,6 +131,7 @@ type CacheConfig struct {
 	TrieTimeLimit       time.Duration // Time limit after which to flush the current in-memory trie to disk
 	SnapshotLimit       int           // Memory allowance (MB) to use for caching snapshot entries in memory
 	Preimages           bool          // Whmbler to store preimage of trie key to the disk
+	ArchiveIndex        bool          // Whmbler to maintain the flat archive index of historical state (archive node)
 
 	SnapshotWait bool // Wait for snapshot construction on startup. TODO(karalabe): This is a dirty hack for testing, nuke it
 }
@@ -393,6 +395,12 @@ func NewBlockChain(db mbldb.Database, cacheConfig *CacheConfig, chainConfig *par
 		go bc.maintainTxIndex(txIndexBlock)
 	}
 
+	// Start the archive indexer.
+	if bc.cacheConfig.ArchiveIndex {
+		bc.wg.Add(1)
+		go bc.maintainArchiveIndex()
+	}
+
 	// If periodic cache journal is required, spin it up.
 	if bc.cacheConfig.TrieCleanRejournal > 0 {
 		if bc.cacheConfig.TrieCleanRejournal < time.Minute {
@@ -1617,7 +1625,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals, smblead bool)
 				throwaway, _ := state.New(parent.Root, bc.stateCache, bc.snaps)
 
 				go func(start time.Time, followup *types.Block, throwaway *state.StateDB, interrupt *uint32) {
-					bc.prefetcher.Prefetch(followup, throwaway, bc.vmConfig, &followupInterrupt)
+					bc.prefetcher.Prefetch(followup, throwaway, *bc.untracedVMConfig(), &followupInterrupt)
 
 					blockPrefetchExecuteTimer.Update(time.Since(start))
 					if atomic.LoadUint32(interrupt) == 1 {
@@ -2112,6 +2120,10 @@ func (bc *BlockChain) reorg(oldBlock, newBlock *types.Block) error {
 		for i := len(oldChain) - 1; i >= 0; i-- {
 			bc.chainSideFeed.Send(ChainSideEvent{Block: oldChain[i]})
 		}
+		// Let a live tracer know the traces of the dropped blocks are stale
+		if hooks, ok := bc.vmConfig.Tracer.(tracing.ChainHooks); ok && bc.vmConfig.Debug {
+			hooks.OnReorg(oldChain)
+		}
 	}
 	return nil
 }
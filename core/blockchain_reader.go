@@ -314,6 +314,19 @@ func (bc *BlockChain) StateAt(root common.Hash) (*state.StateDB, error) {
 	return state.New(root, bc.stateCache, bc.snaps)
 }
 
+// HistoricStateAt returns a new mutable state based on the given block. If the
+// archive index covers the block, state reads are served from the index instead
+// of the tries.
+func (bc *BlockChain) HistoricStateAt(header *types.Header) (*state.StateDB, error) {
+	if bc.cacheConfig.ArchiveIndex {
+		number := header.Number.Uint64()
+		if indexed, ok := ArchiveIndexHead(bc.db); ok && number <= indexed && bc.GetCanonicalHash(number) == header.Hash() {
+			return state.NewArchive(header.Root, number, bc.stateCache)
+		}
+	}
+	return bc.StateAt(header.Root)
+}
+
 // Config retrieves the chain's fork configuration.
 func (bc *BlockChain) Config() *params.ChainConfig { return bc.chainConfig }
 
@@ -350,9 +363,19 @@ func (bc *BlockChain) Genesis() *types.Block {
 	return bc.genesisBlock
 }
 
-// GetVMConfig returns the block chain VM config.
+// GetVMConfig returns the block chain VM config. The tracer attached to the
+// chain is only meant to observe block imports, so it is stripped from the
+// returned config.
 func (bc *BlockChain) GetVMConfig() *vm.Config {
-	return &bc.vmConfig
+	return bc.untracedVMConfig()
+}
+
+// untracedVMConfig returns a copy of the block chain VM config without the
+// tracer, suitable for executing anything other than the imported blocks.
+func (bc *BlockChain) untracedVMConfig() *vm.Config {
+	config := bc.vmConfig
+	config.Debug, config.Tracer = false, nil
+	return &config
 }
 
 // SetTxLookupLimit is responsible for updating the txlookup limit to the
@@ -3867,3 +3867,44 @@ func TestCanonicalHashMarker(t *testing.T) {
 		}
 	}
 }
+
+// Tests that withdrawals are credited to their recipients when importing
+// post-Shanghai blocks, and that the withdrawals survive a database roundtrip.
+func TestWithdrawalsProcessing(t *testing.T) {
+	var (
+		db     = rawdb.NewMemoryDatabase()
+		engine = beacon.New(mblash.NewFaker())
+		addr   = common.HexToAddress("0xdeadbeef")
+
+		config = *params.TestChainConfig
+	)
+	config.TerminalTotalDifficulty = common.Big0
+	config.ShanghaiTime = new(uint64)
+
+	gspec := &Genesis{Config: &config, Difficulty: common.Big0}
+	genesis := gspec.MustCommit(db)
+	if genesis.Header().WithdrawalsHash == nil || *genesis.Header().WithdrawalsHash != types.EmptyRootHash {
+		t.Fatalf("genesis withdrawals root mismatch: have %v, want %x", genesis.Header().WithdrawalsHash, types.EmptyRootHash)
+	}
+	blocks, _ := GenerateChain(&config, genesis, engine, db, 2, func(i int, gen *BlockGen) {
+		gen.SetDifficulty(common.Big0)
+		gen.AddWithdrawal(&types.Withdrawal{Index: uint64(i), Validator: 42, Address: addr, Amount: 1})
+	})
+	chain, err := NewBlockChain(db, nil, &config, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	state, _ := chain.State()
+	if have, want := state.GetBalance(addr), new(big.Int).Mul(big.NewInt(2), big.NewInt(params.GWei)); have.Cmp(want) != 0 {
+		t.Fatalf("withdrawal recipient balance mismatch: have %v, want %v", have, want)
+	}
+	block := chain.GetBlockByNumber(2)
+	if len(block.Withdrawals()) != 1 || block.Withdrawals()[0].Index != 1 {
+		t.Fatalf("stored withdrawals mismatch: have %v", block.Withdrawals())
+	}
+}
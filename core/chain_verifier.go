@@ -0,0 +1,295 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/core/types"
+	"github.com/mbali/go-mbali/log"
+	"github.com/mbali/go-mbali/mbldb"
+	"github.com/mbali/go-mbali/trie"
+)
+
+// errVerifyInterrupted is returned if chain data verification was aborted.
+var errVerifyInterrupted = errors.New("chain verification interrupted")
+
+// ChainIssue describes an inconsistency in the stored chain data, affecting a
+// contiguous range of blocks.
+type ChainIssue struct {
+	Kind     string // Description of the inconsistency
+	From, To uint64 // Range of affected blocks (inclusive)
+	Repaired bool   // Whmbler the issue was fixed in the database
+}
+
+// String implements fmt.Stringer.
+func (issue *ChainIssue) String() string {
+	var blocks string
+	if issue.From == issue.To {
+		blocks = fmt.Sprintf("#%d", issue.From)
+	} else {
+		blocks = fmt.Sprintf("#%d-#%d", issue.From, issue.To)
+	}
+	if issue.Repaired {
+		return fmt.Sprintf("%s: %s (repaired)", blocks, issue.Kind)
+	}
+	return fmt.Sprintf("%s: %s", blocks, issue.Kind)
+}
+
+// chainVerifier accumulates the issues found while walking the chain, merging
+// consecutive blocks with the same issue into ranges.
+type chainVerifier struct {
+	db     mbldb.Database
+	batch  mbldb.Batch
+	repair bool
+
+	issues []*ChainIssue
+	open   map[string]*ChainIssue // Last issue reported for each kind
+}
+
+// report records an issue for the given block.
+func (v *chainVerifier) report(kind string, number uint64, repaired bool) {
+	if issue := v.open[kind]; issue != nil && issue.To+1 == number && issue.Repaired == repaired {
+		issue.To = number
+		return
+	}
+	issue := &ChainIssue{Kind: kind, From: number, To: number, Repaired: repaired}
+	v.issues = append(v.issues, issue)
+	v.open[kind] = issue
+}
+
+// flush writes out the accumulated repairs if they exceed the ideal batch size
+// or if forced to.
+func (v *chainVerifier) flush(force bool) error {
+	if v.batch.ValueSize() > mbldb.IdealBatchSize || (force && v.batch.ValueSize() > 0) {
+		if err := v.batch.Write(); err != nil {
+			return err
+		}
+		v.batch.Reset()
+	}
+	return nil
+}
+
+// headNumber resolves the block number of a head marker, reporting an issue if
+// the marker is missing or does not point into the canonical chain.
+func (v *chainVerifier) headNumber(name string, hash common.Hash) (uint64, bool) {
+	if hash == (common.Hash{}) {
+		v.report(fmt.Sprintf("missing %s marker", name), 0, false)
+		return 0, false
+	}
+	number := rawdb.ReadHeaderNumber(v.db, hash)
+	if number == nil {
+		v.report(fmt.Sprintf("unknown %s %x", name, hash), 0, false)
+		return 0, false
+	}
+	if rawdb.ReadCanonicalHash(v.db, *number) != hash {
+		v.report(fmt.Sprintf("non-canonical %s %x", name, hash), *number, false)
+		return *number, false
+	}
+	return *number, true
+}
+
+// VerifyChainData walks the canonical chain in the key-value store and the
+// freezer, cross checking canonical hashes, headers, bodies, receipts, total
+// difficulties, transaction lookup entries and the head markers against each
+// other. The found inconsistencies are returned ordered by kind of first
+// occurrence.
+//
+// If repair is set, inconsistencies that can be recomputed from other chain
+// data (hash to number mappings, key-value store total difficulties, tx lookup
+// entries and stale canonical hashes above the head) are fixed in place. Missing
+// or corrupted headers, bodies and receipts can only be fixed by resyncing.
+func VerifyChainData(db mbldb.Database, repair bool, interrupt chan struct{}) ([]*ChainIssue, error) {
+	v := &chainVerifier{
+		db:     db,
+		batch:  db.NewBatch(),
+		repair: repair,
+		open:   make(map[string]*ChainIssue),
+	}
+	// Resolve the range of blocks to verify from the head markers
+	headHeader, ok := v.headNumber("head header", rawdb.ReadHeadHeaderHash(db))
+	if !ok {
+		return v.issues, errors.New("head header unavailable")
+	}
+	headBlock, _ := v.headNumber("head block", rawdb.ReadHeadBlockHash(db))
+	headFast, _ := v.headNumber("head fast block", rawdb.ReadHeadFastBlockHash(db))
+	if headBlock > headHeader || headFast > headHeader {
+		v.report("head block above head header", headHeader, false)
+	}
+	bodyHead := headBlock
+	if headFast > bodyHead {
+		bodyHead = headFast
+	}
+	frozen, err := db.Ancients()
+	if err != nil {
+		frozen = 0 // no freezer attached
+	}
+	if frozen > bodyHead+1 {
+		v.report("freezer ahead of head block", bodyHead+1, false)
+	}
+	tables := make([]string, 0, len(rawdb.FreezerNoSnappy))
+	for table := range rawdb.FreezerNoSnappy {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	txTail := rawdb.ReadTxIndexTail(db)
+
+	var (
+		prevHash common.Hash
+		prevTd   *big.Int
+		start    = time.Now()
+		logged   = time.Now()
+	)
+	for number := uint64(0); number <= headHeader; number++ {
+		select {
+		case <-interrupt:
+			return v.issues, errVerifyInterrupted
+		default:
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Verifying chain data", "number", number, "head", headHeader, "issues", len(v.issues), "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+		if err := v.flush(false); err != nil {
+			return v.issues, err
+		}
+		// Ensure the freezer tables are aligned for ancient blocks
+		if number < frozen {
+			for _, table := range tables {
+				if _, err := db.Ancient(table, number); err != nil {
+					v.report(fmt.Sprintf("missing freezer %s item", table), number, false)
+				}
+			}
+		}
+		// Verify the canonical header and its links to the rest of the chain
+		hash := rawdb.ReadCanonicalHash(db, number)
+		if hash == (common.Hash{}) {
+			v.report("missing canonical hash", number, false)
+			prevHash, prevTd = common.Hash{}, nil
+			continue
+		}
+		header := rawdb.ReadHeader(db, hash, number)
+		if header == nil {
+			v.report("missing header", number, false)
+			prevHash, prevTd = common.Hash{}, nil
+			continue
+		}
+		if header.Hash() != hash || header.Number.Uint64() != number {
+			v.report("header mismatches canonical hash", number, false)
+			prevHash, prevTd = common.Hash{}, nil
+			continue
+		}
+		if n := rawdb.ReadHeaderNumber(db, hash); n == nil || *n != number {
+			if repair {
+				rawdb.WriteHeaderNumber(v.batch, hash, number)
+			}
+			v.report("missing hash to number mapping", number, repair)
+		}
+		if number > 0 && prevHash != (common.Hash{}) && header.ParentHash != prevHash {
+			v.report("header not linked to parent", number, false)
+		}
+		// Verify the total difficulty, recomputing it from the parent if possible
+		var want *big.Int
+		switch {
+		case number == 0:
+			want = new(big.Int).Set(header.Difficulty)
+		case prevTd != nil:
+			want = new(big.Int).Add(prevTd, header.Difficulty)
+		}
+		td := rawdb.ReadTd(db, hash, number)
+		if td == nil || (want != nil && td.Cmp(want) != 0) {
+			fixable := repair && want != nil && number >= frozen
+			if fixable {
+				rawdb.WriteTd(v.batch, hash, number, want)
+			}
+			if td == nil {
+				v.report("missing total difficulty", number, fixable)
+			} else {
+				v.report("total difficulty mismatch", number, fixable)
+			}
+			td = want
+		}
+		prevHash, prevTd = hash, td
+
+		// Headers above the head block are not expected to have any block data
+		if number > bodyHead {
+			continue
+		}
+		body := rawdb.ReadBody(db, hash, number)
+		if body == nil {
+			v.report("missing body", number, false)
+		} else if types.DeriveSha(types.Transactions(body.Transactions), trie.NewStackTrie(nil)) != header.TxHash || types.CalcUncleHash(body.Uncles) != header.UncleHash {
+			v.report("body mismatches header", number, false)
+			body = nil
+		}
+		if !rawdb.HasReceipts(db, hash, number) {
+			v.report("missing receipts", number, false)
+		} else if body != nil {
+			receipts := rawdb.ReadRawReceipts(db, hash, number)
+			if len(receipts) != len(body.Transactions) {
+				v.report("receipts mismatch transactions", number, false)
+			} else {
+				for i, tx := range body.Transactions {
+					receipts[i].Type = tx.Type()
+				}
+				if types.DeriveSha(receipts, trie.NewStackTrie(nil)) != header.ReceiptHash {
+					v.report("receipts mismatch header", number, false)
+				}
+			}
+		}
+		// Verify the transaction lookup entries within the indexed range
+		if body == nil || txTail == nil || number < *txTail {
+			continue
+		}
+		var (
+			hashes = make([]common.Hash, 0, len(body.Transactions))
+			broken bool
+		)
+		for _, tx := range body.Transactions {
+			hashes = append(hashes, tx.Hash())
+			if entry := rawdb.ReadTxLookupEntry(db, tx.Hash()); entry == nil || *entry != number {
+				broken = true
+			}
+		}
+		if broken {
+			if repair {
+				rawdb.WriteTxLookupEntries(v.batch, number, hashes)
+			}
+			v.report("missing or stale tx lookup entries", number, repair)
+		}
+	}
+	// Ensure no canonical hashes are left dangling above the head header
+	numbers, _ := rawdb.ReadAllCanonicalHashes(db, headHeader+1, math.MaxUint64, math.MaxInt32)
+	for _, number := range numbers {
+		if repair {
+			rawdb.DeleteCanonicalHash(v.batch, number)
+		}
+		v.report("stale canonical hash above head header", number, repair)
+	}
+	if err := v.flush(true); err != nil {
+		return v.issues, err
+	}
+	return v.issues, nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/consensus/mblash"
+	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/core/types"
+	"github.com/mbali/go-mbali/core/vm"
+	"github.com/mbali/go-mbali/crypto"
+	"github.com/mbali/go-mbali/mbldb"
+	"github.com/mbali/go-mbali/params"
+)
+
+// makeVerifierChain generates a short chain with a transaction in every block.
+func makeVerifierChain() (*Genesis, []*types.Block, []types.Receipts) {
+	var (
+		gendb   = rawdb.NewMemoryDatabase()
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &Genesis{
+			Config:  params.TestChainConfig,
+			Alloc:   GenesisAlloc{address: {Balance: big.NewInt(1000000000000000)}},
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
+		genesis = gspec.MustCommit(gendb)
+		signer  = types.LatestSigner(gspec.Config)
+	)
+	blocks, receipts := GenerateChain(gspec.Config, genesis, mblash.NewFaker(), gendb, 16, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{0x01}, big.NewInt(1000), params.TxGas, block.header.BaseFee, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	return gspec, blocks, receipts
+}
+
+// checkChainIssues verifies the chain data and checks the reported issues.
+func checkChainIssues(t *testing.T, db mbldb.Database, repair bool, want []ChainIssue) {
+	t.Helper()
+
+	issues, err := VerifyChainData(db, repair, nil)
+	if err != nil {
+		t.Fatalf("failed to verify chain data: %v", err)
+	}
+	if len(issues) != len(want) {
+		t.Fatalf("issue count mismatch: have %v, want %d", issues, len(want))
+	}
+	for i, issue := range issues {
+		if *issue != want[i] {
+			t.Errorf("issue %d mismatch: have %v, want %v", i, issue, &want[i])
+		}
+	}
+}
+
+// Tests that the chain verifier detects inconsistencies in the key-value store
+// and repairs the recoverable ones.
+func TestVerifyChainData(t *testing.T) {
+	gspec, blocks, _ := makeVerifierChain()
+
+	db := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(db)
+	chain, _ := NewBlockChain(db, nil, gspec.Config, mblash.NewFaker(), vm.Config{}, nil, nil)
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	chain.Stop()
+	rawdb.WriteTxIndexTail(db, 0)
+
+	checkChainIssues(t, db, false, nil)
+
+	// Corrupt various parts of the chain data
+	rawdb.DeleteTxLookupEntry(db, blocks[2].Transactions()[0].Hash())
+	rawdb.DeleteTxLookupEntry(db, blocks[3].Transactions()[0].Hash())
+	rawdb.DeleteTd(db, blocks[5].Hash(), blocks[5].NumberU64())
+	rawdb.DeleteHeaderNumber(db, blocks[7].Hash())
+	rawdb.DeleteBody(db, blocks[9].Hash(), blocks[9].NumberU64())
+	rawdb.WriteReceipts(db, blocks[11].Hash(), blocks[11].NumberU64(), nil)
+	rawdb.WriteCanonicalHash(db, common.Hash{0x01}, 100)
+
+	corrupted := []ChainIssue{
+		{Kind: "missing or stale tx lookup entries", From: 3, To: 4},
+		{Kind: "missing total difficulty", From: 6, To: 6},
+		{Kind: "missing hash to number mapping", From: 8, To: 8},
+		{Kind: "missing body", From: 10, To: 10},
+		{Kind: "receipts mismatch transactions", From: 12, To: 12},
+		{Kind: "stale canonical hash above head header", From: 100, To: 100},
+	}
+	checkChainIssues(t, db, false, corrupted)
+
+	// Repair the database and ensure only the unrecoverable issues remain
+	for i := range corrupted {
+		switch corrupted[i].Kind {
+		case "missing body", "receipts mismatch transactions":
+		default:
+			corrupted[i].Repaired = true
+		}
+	}
+	checkChainIssues(t, db, true, corrupted)
+	checkChainIssues(t, db, false, []ChainIssue{
+		{Kind: "missing body", From: 10, To: 10},
+		{Kind: "receipts mismatch transactions", From: 12, To: 12},
+	})
+}
+
+// Tests that a chain partially moved into the freezer verifies cleanly.
+func TestVerifyChainDataFreezer(t *testing.T) {
+	gspec, blocks, receipts := makeVerifierChain()
+
+	db, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false)
+	if err != nil {
+		t.Fatalf("failed to create temp freezer db: %v", err)
+	}
+	defer db.Close()
+	gspec.MustCommit(db)
+
+	chain, _ := NewBlockChain(db, nil, gspec.Config, mblash.NewFaker(), vm.Config{}, nil, nil)
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	if n, err := chain.InsertHeaderChain(headers, 1); err != nil {
+		t.Fatalf("failed to insert header %d: %v", n, err)
+	}
+	if n, err := chain.InsertReceiptChain(blocks, receipts, uint64(len(blocks)/2)); err != nil {
+		t.Fatalf("failed to insert receipt %d: %v", n, err)
+	}
+	chain.Stop()
+
+	if frozen, _ := db.Ancients(); frozen == 0 {
+		t.Fatalf("no blocks moved into the freezer")
+	}
+	checkChainIssues(t, db, false, nil)
+}
@@ -171,7 +171,7 @@ func TestCreation(t *testing.T) {
 	}
 	for i, tt := range tests {
 		for j, ttt := range tt.cases {
-			if have := NewID(tt.config, tt.genesis, ttt.head); have != ttt.want {
+			if have := NewID(tt.config, tt.genesis, ttt.head, 0); have != ttt.want {
 				t.Errorf("test %d, case %d: fork ID mismatch: have %x, want %x", i, j, have, ttt.want)
 			}
 		}
@@ -253,13 +253,74 @@ func TestValidation(t *testing.T) {
 		{7279999, ID{Hash: checksumToBytes(0xa00bc324), Next: 7279999}, ErrLocalIncompatibleOrStale},
 	}
 	for i, tt := range tests {
-		filter := newFilter(params.MainnetChainConfig, params.MainnetGenesisHash, func() uint64 { return tt.head })
+		filter := newFilter(params.MainnetChainConfig, params.MainnetGenesisHash, func() (uint64, uint64) { return tt.head, 0 })
 		if err := filter(tt.id); err != tt.err {
 			t.Errorf("test %d: validation error mismatch: have %v, want %v", i, err, tt.err)
 		}
 	}
 }
 
+// TestTimestampForks tests that fork IDs and validation correctly handle forks
+// scheduled by timestamp after the block number based ones.
+func TestTimestampForks(t *testing.T) {
+	var (
+		shanghai = uint64(1681338455)
+		config   = *params.MainnetChainConfig
+	)
+	config.MergeNetsplitBlock = big.NewInt(15050000)
+	config.ShanghaiTime = &shanghai
+
+	creations := []struct {
+		head, time uint64
+		want       ID
+	}{
+		{13773000, 0, ID{Hash: checksumToBytes(0x20c327fc), Next: 15050000}},            // First Arrow Glacier block
+		{15049999, 0, ID{Hash: checksumToBytes(0x20c327fc), Next: 15050000}},            // Last Arrow Glacier block
+		{15050000, 0, ID{Hash: checksumToBytes(0xf0afd0e3), Next: shanghai}},            // First Merge Netsplit block
+		{20000000, shanghai - 1, ID{Hash: checksumToBytes(0xf0afd0e3), Next: shanghai}}, // Last Merge Netsplit block
+		{20000000, shanghai, ID{Hash: checksumToBytes(0xdce96c2d), Next: 0}},            // First Shanghai block
+		{30000000, 2000000000, ID{Hash: checksumToBytes(0xdce96c2d), Next: 0}},          // Future Shanghai block
+	}
+	for i, tt := range creations {
+		if have := NewID(&config, params.MainnetGenesisHash, tt.head, tt.time); have != tt.want {
+			t.Errorf("creation %d: fork ID mismatch: have %x, want %x", i, have, tt.want)
+		}
+	}
+	validations := []struct {
+		head, time uint64
+		id         ID
+		err        error
+	}{
+		// Local is pre-Shanghai, remote announces the same with knowledge of Shanghai.
+		{15050000, shanghai - 1, ID{Hash: checksumToBytes(0xf0afd0e3), Next: shanghai}, nil},
+
+		// Local is pre-Shanghai, remote already passed Shanghai. Local is out of sync, accept.
+		{15050000, shanghai - 1, ID{Hash: checksumToBytes(0xdce96c2d), Next: 0}, nil},
+
+		// Local is Shanghai, remote announces the Merge netsplit with knowledge about Shanghai.
+		// Remote is simply out of sync, accept.
+		{15050000, shanghai, ID{Hash: checksumToBytes(0xf0afd0e3), Next: shanghai}, nil},
+
+		// Local is Shanghai, remote announces the Merge netsplit without knowledge about
+		// Shanghai. Remote needs software update.
+		{15050000, shanghai, ID{Hash: checksumToBytes(0xf0afd0e3), Next: 0}, ErrRemoteStale},
+
+		// Local is pre-Shanghai, remote announces a non existing fork at a timestamp
+		// already passed locally. Local is incompatible.
+		{15050000, shanghai - 1, ID{Hash: checksumToBytes(0xf0afd0e3), Next: shanghai - 2}, ErrLocalIncompatibleOrStale},
+
+		// Local is Shanghai, far in the future. Remote announces a non existing fork
+		// at some future timestamp, but past for local. Local is incompatible.
+		{88888888, 8888888888, ID{Hash: checksumToBytes(0xdce96c2d), Next: 8888888888}, ErrLocalIncompatibleOrStale},
+	}
+	for i, tt := range validations {
+		filter := newFilter(&config, params.MainnetGenesisHash, func() (uint64, uint64) { return tt.head, tt.time })
+		if err := filter(tt.id); err != tt.err {
+			t.Errorf("validation %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
 // Tests that IDs are properly RLP encoded (specifically important because we
 // use uint32 to store the hash, but we need to encode it as [4]byte).
 func TestEncoding(t *testing.T) {
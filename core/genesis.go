@@ -30,7 +30,9 @@ import (
 	"github.com/mbali/go-mbali/common/math"
 	"github.com/mbali/go-mbali/core/rawdb"
 	"github.com/mbali/go-mbali/core/state"
+	"github.com/mbali/go-mbali/core/tracing"
 	"github.com/mbali/go-mbali/core/types"
+	"github.com/mbali/go-mbali/core/vm"
 	"github.com/mbali/go-mbali/crypto"
 	"github.com/mbali/go-mbali/mbldb"
 	"github.com/mbali/go-mbali/log"
@@ -88,7 +90,7 @@ func (ga *GenesisAlloc) flush(db mbldb.Database) (common.Hash, error) {
 		return common.Hash{}, err
 	}
 	for addr, account := range *ga {
-		statedb.AddBalance(addr, account.Balance)
+		statedb.AddBalance(addr, account.Balance, tracing.BalanceIncreaseGenesisBalance)
 		statedb.SetCode(addr, account.Code)
 		statedb.SetNonce(addr, account.Nonce)
 		for key, value := range account.Storage {
@@ -246,6 +248,9 @@ func SetupGenesisBlockWithOverride(db mbldb.Database, genesis *Genesis, override
 		} else {
 			log.Info("Writing custom genesis block")
 		}
+		if err := vm.ValidatePrecompiles(genesis.Config); err != nil {
+			return genesis.Config, common.Hash{}, err
+		}
 		block, err := genesis.Commit(db)
 		if err != nil {
 			return genesis.Config, common.Hash{}, err
@@ -264,6 +269,9 @@ func SetupGenesisBlockWithOverride(db mbldb.Database, genesis *Genesis, override
 		if hash != stored {
 			return genesis.Config, hash, &GenesisMismatchError{stored, hash}
 		}
+		if err := vm.ValidatePrecompiles(genesis.Config); err != nil {
+			return genesis.Config, hash, err
+		}
 		block, err := genesis.Commit(db)
 		if err != nil {
 			return genesis.Config, hash, err
@@ -290,6 +298,9 @@ func SetupGenesisBlockWithOverride(db mbldb.Database, genesis *Genesis, override
 	}
 	storedcfg := rawdb.ReadChainConfig(db, stored)
 	if storedcfg == nil {
+		if err := vm.ValidatePrecompiles(newcfg); err != nil {
+			return newcfg, stored, err
+		}
 		log.Warn("Found genesis block without chain config")
 		rawdb.WriteChainConfig(db, stored, newcfg)
 		return newcfg, stored, nil
@@ -308,20 +319,46 @@ func SetupGenesisBlockWithOverride(db mbldb.Database, genesis *Genesis, override
 			newcfg.TerminalTotalDifficulty = overrideTerminalTotalDifficulty
 		}
 	}
+	// Refuse to run with a different set of application defined precompiles
+	// than the one the chain was configured with.
+	if err := vm.ValidatePrecompiles(newcfg); err != nil {
+		return newcfg, stored, err
+	}
 	// Check config compatibility and write the config. Compatibility errors
 	// are returned to the caller unless we're already at block zero.
-	height := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadHeaderHash(db))
+	headHash := rawdb.ReadHeadHeaderHash(db)
+	height := rawdb.ReadHeaderNumber(db, headHash)
 	if height == nil {
 		return newcfg, stored, fmt.Errorf("missing block number for head header hash")
 	}
-	compatErr := storedcfg.CheckCompatible(newcfg, *height)
-	if compatErr != nil && *height != 0 && compatErr.RewindTo != 0 {
+	head := rawdb.ReadHeader(db, headHash, *height)
+	if head == nil {
+		return newcfg, stored, fmt.Errorf("missing head header")
+	}
+	compatErr := storedcfg.CheckCompatible(newcfg, *height, head.Time)
+	if compatErr != nil && ((*height != 0 && compatErr.RewindTo != 0) || (head.Time != 0 && compatErr.RewindToTime != 0)) {
+		if compatErr.RewindToTime != 0 {
+			compatErr.RewindTo = rewindNumberForTime(db, head, compatErr.RewindToTime)
+		}
 		return newcfg, stored, compatErr
 	}
 	rawdb.WriteChainConfig(db, stored, newcfg)
 	return newcfg, stored, nil
 }
 
+// rewindNumberForTime maps a timestamp based rewind target onto the local chain,
+// returning the number of the last canonical block not newer than time.
+func rewindNumberForTime(db mbldb.Reader, head *types.Header, time uint64) uint64 {
+	for head.Time > time && head.Number.Uint64() > 0 {
+		parent := rawdb.ReadHeader(db, head.ParentHash, head.Number.Uint64()-1)
+		if parent == nil {
+			break
+		}
+		head = parent
+	}
+	return head.Number.Uint64()
+}
+
 func (g *Genesis) configOrDefault(ghash common.Hash) *params.ChainConfig {
 	switch {
 	case g != nil:
@@ -380,7 +417,11 @@ func (g *Genesis) ToBlock(db mbldb.Database) *types.Block {
 			head.BaseFee = new(big.Int).SetUint64(params.InitialBaseFee)
 		}
 	}
-	return types.NewBlock(head, nil, nil, nil, trie.NewStackTrie(nil))
+	var withdrawals []*types.Withdrawal
+	if g.Config != nil && g.Config.IsShanghai(common.Big0, g.Timestamp) {
+		withdrawals = make([]*types.Withdrawal, 0)
+	}
+	return types.NewBlockWithWithdrawals(head, nil, nil, nil, withdrawals, trie.NewStackTrie(nil))
 }
 
 // Commit writes the block and state of a genesis specification to the database.
@@ -160,6 +160,43 @@ func TestSetupGenesis(t *testing.T) {
 	}
 }
 
+// Tests that rescheduling an already passed timestamp based fork is reported as
+// a compatibility error, with the rewind target mapped onto the local chain.
+func TestSetupGenesisTimestampFork(t *testing.T) {
+	var (
+		oldtime = uint64(25)
+		newtime = uint64(35)
+		oldcfg  = *params.TestChainConfig
+		newcfg  = *params.TestChainConfig
+	)
+	oldcfg.ShanghaiTime = &oldtime
+	newcfg.ShanghaiTime = &newtime
+
+	db := rawdb.NewMemoryDatabase()
+	oldg := &Genesis{Config: &oldcfg, BaseFee: big.NewInt(params.InitialBaseFee)}
+	genesis := oldg.MustCommit(db)
+
+	// Advance to block #4 with a timestamp of 40, past the Shanghai transition
+	bc, _ := NewBlockChain(db, nil, oldg.Config, mblash.NewFaker(), vm.Config{}, nil, nil)
+	defer bc.Stop()
+
+	blocks, _ := GenerateChain(oldg.Config, genesis, mblash.NewFaker(), db, 4, nil)
+	if n, err := bc.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	_, _, err := SetupGenesisBlock(db, &Genesis{Config: &newcfg, BaseFee: big.NewInt(params.InitialBaseFee)})
+	want := &params.ConfigCompatError{
+		What:         "Shanghai fork timestamp",
+		StoredTime:   &oldtime,
+		NewTime:      &newtime,
+		RewindTo:     2,
+		RewindToTime: 24,
+	}
+	if !reflect.DeepEqual(err, want) {
+		t.Fatalf("compatibility error mismatch: have %v, want %v", err, want)
+	}
+}
+
 // TestGenesisHashes checks the congruity of default genesis data to
 // corresponding hardcoded genesis hash values.
 func TestGenesisHashes(t *testing.T) {
@@ -243,3 +280,19 @@ func TestReadWriteGenesisAlloc(t *testing.T) {
 		}
 	}
 }
+
+// Tests that a node refuses to set up a chain whose recorded custom precompiles
+// are not registered in the running binary.
+func TestSetupGenesisCustomPrecompileMismatch(t *testing.T) {
+	config := *params.TestChainConfig
+	config.CustomPrecompiles = map[common.Address]*params.CustomPrecompile{
+		common.HexToAddress("0x0100"): {Name: "unregistered", Block: big.NewInt(0)},
+	}
+	db := rawdb.NewMemoryDatabase()
+	if _, _, err := SetupGenesisBlock(db, &Genesis{Config: &config}); err == nil {
+		t.Fatal("genesis with unregistered custom precompile accepted")
+	}
+	if stored := rawdb.ReadCanonicalHash(db, 0); stored != (common.Hash{}) {
+		t.Fatalf("genesis written despite precompile mismatch: %x", stored)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/log"
+	"github.com/mbali/go-mbali/mbldb"
+)
+
+// ReadArchiveIndexHead retrieves the hash of the latest block indexed into the
+// archive index.
+func ReadArchiveIndexHead(db mbldb.KeyValueReader) common.Hash {
+	data, _ := db.Get(archiveIndexHeadKey)
+	if len(data) != common.HashLength {
+		return common.Hash{}
+	}
+	return common.BytesToHash(data)
+}
+
+// WriteArchiveIndexHead stores the hash of the latest block indexed into the
+// archive index.
+func WriteArchiveIndexHead(db mbldb.KeyValueWriter, hash common.Hash) {
+	if err := db.Put(archiveIndexHeadKey, hash.Bytes()); err != nil {
+		log.Crit("Failed to store archive index head", "err", err)
+	}
+}
+
+// DeleteArchiveIndexHead removes the archive index head marker.
+func DeleteArchiveIndexHead(db mbldb.KeyValueWriter) {
+	if err := db.Delete(archiveIndexHeadKey); err != nil {
+		log.Crit("Failed to delete archive index head", "err", err)
+	}
+}
+
+// readArchiveValue retrieves the latest history entry of an item set at or
+// before the given block. Entries are keyed by the inverted block number, so the
+// first entry found iterating from the requested block is the one in effect.
+func readArchiveValue(db mbldb.Iteratee, prefix []byte, number uint64) []byte {
+	it := db.NewIterator(prefix, encodeBlockNumber(^number))
+	defer it.Release()
+
+	for it.Next() {
+		if len(it.Key()) == len(prefix)+8 {
+			return common.CopyBytes(it.Value())
+		}
+	}
+	return nil
+}
+
+// ReadArchiveAccount retrieves the slim account RLP an account had at the end of
+// the given block, or nil if it did not exist.
+func ReadArchiveAccount(db mbldb.Iteratee, hash common.Hash, number uint64) []byte {
+	return readArchiveValue(db, archiveAccountKey(hash), number)
+}
+
+// WriteArchiveAccount stores the slim account RLP an account was set to in the
+// given block. An empty value marks the account as deleted.
+func WriteArchiveAccount(db mbldb.KeyValueWriter, hash common.Hash, number uint64, value []byte) {
+	if err := db.Put(archiveAccountEntryKey(hash, number), value); err != nil {
+		log.Crit("Failed to store archive account", "err", err)
+	}
+}
+
+// DeleteArchiveAccount removes the change an account had in the given block.
+func DeleteArchiveAccount(db mbldb.KeyValueWriter, hash common.Hash, number uint64) {
+	if err := db.Delete(archiveAccountEntryKey(hash, number)); err != nil {
+		log.Crit("Failed to delete archive account", "err", err)
+	}
+}
+
+// ReadArchiveStorage retrieves the value a storage slot had at the end of the
+// given block, or nil if it did not exist.
+func ReadArchiveStorage(db mbldb.Iteratee, accountHash, storageHash common.Hash, number uint64) []byte {
+	return readArchiveValue(db, archiveStorageKey(accountHash, storageHash), number)
+}
+
+// WriteArchiveStorage stores the value a storage slot was set to in the given
+// block. An empty value marks the slot as deleted.
+func WriteArchiveStorage(db mbldb.KeyValueWriter, accountHash, storageHash common.Hash, number uint64, value []byte) {
+	if err := db.Put(archiveStorageEntryKey(accountHash, storageHash, number), value); err != nil {
+		log.Crit("Failed to store archive storage", "err", err)
+	}
+}
+
+// DeleteArchiveStorage removes the change a storage slot had in the given block.
+func DeleteArchiveStorage(db mbldb.KeyValueWriter, accountHash, storageHash common.Hash, number uint64) {
+	if err := db.Delete(archiveStorageEntryKey(accountHash, storageHash, number)); err != nil {
+		log.Crit("Failed to delete archive storage", "err", err)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mbali/go-mbali/common"
+)
+
+// Tests that archive history lookups return the value in effect at the end of
+// the requested block, without leaking into neighbouring items.
+func TestArchiveHistoryLookup(t *testing.T) {
+	db := NewMemoryDatabase()
+
+	var (
+		acct  = common.Hash{0x01}
+		other = common.Hash{0x02}
+		slot  = common.Hash{0x03}
+	)
+	WriteArchiveAccount(db, acct, 2, []byte{0x02})
+	WriteArchiveAccount(db, acct, 5, []byte{0x05})
+	WriteArchiveAccount(db, acct, 7, nil)
+	WriteArchiveAccount(db, other, 1, []byte{0x11})
+	WriteArchiveStorage(db, acct, slot, 3, []byte{0x33})
+
+	tests := []struct {
+		number uint64
+		want   []byte
+	}{
+		{0, nil}, {1, nil}, {2, []byte{0x02}}, {4, []byte{0x02}},
+		{5, []byte{0x05}}, {6, []byte{0x05}}, {7, nil}, {100, nil},
+	}
+	for _, tt := range tests {
+		if have := ReadArchiveAccount(db, acct, tt.number); !bytes.Equal(have, tt.want) {
+			t.Errorf("block %d: account value mismatch: have %x, want %x", tt.number, have, tt.want)
+		}
+	}
+	if have := ReadArchiveStorage(db, acct, slot, 2); have != nil {
+		t.Errorf("storage value before first change: have %x, want nil", have)
+	}
+	if have := ReadArchiveStorage(db, acct, slot, 9); !bytes.Equal(have, []byte{0x33}) {
+		t.Errorf("storage value mismatch: have %x, want 33", have)
+	}
+	// Dropping the latest changes should expose the older ones again
+	DeleteArchiveAccount(db, acct, 7)
+	DeleteArchiveAccount(db, acct, 5)
+	if have := ReadArchiveAccount(db, acct, 100); !bytes.Equal(have, []byte{0x02}) {
+		t.Errorf("account value after unwind mismatch: have %x, want 02", have)
+	}
+	DeleteArchiveStorage(db, acct, slot, 3)
+	if have := ReadArchiveStorage(db, acct, slot, 9); have != nil {
+		t.Errorf("storage value after unwind: have %x, want nil", have)
+	}
+}
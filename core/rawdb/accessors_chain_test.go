@@ -155,6 +155,44 @@ func TestBlockStorage(t *testing.T) {
 	}
 }
 
+// Tests that block withdrawals survive storage in both the key-value store and
+// the freezer.
+func TestWithdrawalBlockStorage(t *testing.T) {
+	db, err := NewDatabaseWithFreezer(NewMemoryDatabase(), t.TempDir(), "", false)
+	if err != nil {
+		t.Fatalf("failed to create database with ancient backend")
+	}
+	defer db.Close()
+
+	withdrawals := []*types.Withdrawal{
+		{Index: 0, Validator: 1, Address: common.Address{0x01}, Amount: 32000000000},
+		{Index: 1, Validator: 2, Address: common.Address{0x02}, Amount: 1},
+	}
+	header := &types.Header{Number: big.NewInt(0), Extra: []byte("test block")}
+	block := types.NewBlockWithWithdrawals(header, nil, nil, nil, withdrawals, newHasher())
+
+	check := func(location string) {
+		t.Helper()
+
+		entry := ReadBlock(db, block.Hash(), block.NumberU64())
+		if entry == nil {
+			t.Fatalf("%s: stored block not found", location)
+		}
+		if entry.Hash() != block.Hash() {
+			t.Fatalf("%s: retrieved block mismatch: have %v, want %v", location, entry, block)
+		}
+		if !reflect.DeepEqual(entry.Withdrawals(), block.Withdrawals()) {
+			t.Fatalf("%s: retrieved withdrawals mismatch: have %v, want %v", location, entry.Withdrawals(), block.Withdrawals())
+		}
+	}
+	WriteBlock(db, block)
+	check("key-value store")
+
+	DeleteBlock(db, block.Hash(), block.NumberU64())
+	WriteAncientBlocks(db, []*types.Block{block}, []types.Receipts{nil}, big.NewInt(100))
+	check("freezer")
+}
+
 // Tests that partial block contents don't get reassembled into full blocks.
 func TestPartialBlockStorage(t *testing.T) {
 	db := NewMemoryDatabase()
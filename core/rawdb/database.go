@@ -340,6 +340,8 @@ func InspectDatabase(db mbldb.Database, keyPrefix, keyStart []byte) error {
 		txLookups       stat
 		accountSnaps    stat
 		storageSnaps    stat
+		accountArchive  stat
+		storageArchive  stat
 		preimages       stat
 		bloomBits       stat
 		beaconHeaders   stat
@@ -393,6 +395,10 @@ func InspectDatabase(db mbldb.Database, keyPrefix, keyStart []byte) error {
 			accountSnaps.Add(size)
 		case bytes.HasPrefix(key, SnapshotStoragePrefix) && len(key) == (len(SnapshotStoragePrefix)+2*common.HashLength):
 			storageSnaps.Add(size)
+		case bytes.HasPrefix(key, ArchiveAccountPrefix) && len(key) == (len(ArchiveAccountPrefix)+common.HashLength+8):
+			accountArchive.Add(size)
+		case bytes.HasPrefix(key, ArchiveStoragePrefix) && len(key) == (len(ArchiveStoragePrefix)+2*common.HashLength+8):
+			storageArchive.Add(size)
 		case bytes.HasPrefix(key, PreimagePrefix) && len(key) == (len(PreimagePrefix)+common.HashLength):
 			preimages.Add(size)
 		case bytes.HasPrefix(key, configPrefix) && len(key) == (len(configPrefix)+common.HashLength):
@@ -422,6 +428,7 @@ func InspectDatabase(db mbldb.Database, keyPrefix, keyStart []byte) error {
 				lastPivotKey, fastTrieProgressKey, snapshotDisabledKey, SnapshotRootKey, snapshotJournalKey,
 				snapshotGeneratorKey, snapshotRecoveryKey, txIndexTailKey, fastTxLookupLimitKey,
 				uncleanShutdownKey, badBlockKey, transitionStatusKey, skeletonSyncStatusKey,
+				archiveIndexHeadKey,
 			} {
 				if bytes.Equal(key, meta) {
 					metadata.Add(size)
@@ -467,6 +474,8 @@ func InspectDatabase(db mbldb.Database, keyPrefix, keyStart []byte) error {
 		{"Key-Value store", "Trie preimages", preimages.Size(), preimages.Count()},
 		{"Key-Value store", "Account snapshot", accountSnaps.Size(), accountSnaps.Count()},
 		{"Key-Value store", "Storage snapshot", storageSnaps.Size(), storageSnaps.Count()},
+		{"Key-Value store", "Account archive index", accountArchive.Size(), accountArchive.Count()},
+		{"Key-Value store", "Storage archive index", storageArchive.Size(), storageArchive.Count()},
 		{"Key-Value store", "Beacon sync headers", beaconHeaders.Size(), beaconHeaders.Count()},
 		{"Key-Value store", "Clique snapshots", cliqueSnaps.Size(), cliqueSnaps.Count()},
 		{"Key-Value store", "Singleton metadata", metadata.Size(), metadata.Count()},
@@ -84,6 +84,9 @@ var (
 	// transitionStatusKey tracks the mbl2 transition status.
 	transitionStatusKey = []byte("mbl2-transition")
 
+	// archiveIndexHeadKey tracks the latest block indexed into the archive index.
+	archiveIndexHeadKey = []byte("LastArchiveIndexed")
+
 	// Data item prefixes (use single byte to avoid mixing data types, avoid `i`, used for indexes).
 	headerPrefix       = []byte("h") // headerPrefix + num (uint64 big endian) + hash -> header
 	headerTDSuffix     = []byte("t") // headerPrefix + num (uint64 big endian) + hash + headerTDSuffix -> td
@@ -99,6 +102,8 @@ var (
 	SnapshotStoragePrefix = []byte("o") // SnapshotStoragePrefix + account hash + storage hash -> storage trie value
 	CodePrefix            = []byte("c") // CodePrefix + code hash -> account code
 	skeletonHeaderPrefix  = []byte("S") // skeletonHeaderPrefix + num (uint64 big endian) -> header
+	ArchiveAccountPrefix  = []byte("A") // ArchiveAccountPrefix + account hash + ^num (uint64 big endian) -> account
+	ArchiveStoragePrefix  = []byte("O") // ArchiveStoragePrefix + account hash + storage hash + ^num (uint64 big endian) -> storage
 
 	PreimagePrefix = []byte("secure-key-")       // PreimagePrefix + hash -> preimage
 	configPrefix   = []byte("mbali-config-")  // config prefix for the db
@@ -208,6 +213,26 @@ func storageSnapshotsKey(accountHash common.Hash) []byte {
 	return append(SnapshotStoragePrefix, accountHash.Bytes()...)
 }
 
+// archiveAccountKey = ArchiveAccountPrefix + hash
+func archiveAccountKey(hash common.Hash) []byte {
+	return append(ArchiveAccountPrefix, hash.Bytes()...)
+}
+
+// archiveStorageKey = ArchiveStoragePrefix + account hash + storage hash
+func archiveStorageKey(accountHash, storageHash common.Hash) []byte {
+	return append(append(ArchiveStoragePrefix, accountHash.Bytes()...), storageHash.Bytes()...)
+}
+
+// archiveAccountEntryKey = ArchiveAccountPrefix + hash + ^num (uint64 big endian)
+func archiveAccountEntryKey(hash common.Hash, number uint64) []byte {
+	return append(archiveAccountKey(hash), encodeBlockNumber(^number)...)
+}
+
+// archiveStorageEntryKey = ArchiveStoragePrefix + account hash + storage hash + ^num (uint64 big endian)
+func archiveStorageEntryKey(accountHash, storageHash common.Hash, number uint64) []byte {
+	return append(archiveStorageKey(accountHash, storageHash), encodeBlockNumber(^number)...)
+}
+
 // bloomBitsKey = bloomBitsPrefix + bit (uint16 big endian) + section (uint64 big endian) + hash
 func bloomBitsKey(bit uint, section uint64, hash common.Hash) []byte {
 	key := append(append(bloomBitsPrefix, make([]byte, 10)...), hash.Bytes()...)
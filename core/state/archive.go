@@ -0,0 +1,225 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/core/state/snapshot"
+	"github.com/mbali/go-mbali/core/types"
+	"github.com/mbali/go-mbali/mbldb"
+	"github.com/mbali/go-mbali/rlp"
+	"github.com/mbali/go-mbali/trie"
+)
+
+// The archive index is a flat, per-item history of the state. For every account
+// and storage slot ever touched, it stores a separate entry for every block the
+// item changed in, allowing historical state to be read with a single database
+// seek instead of a trie traversal.
+//
+// The index is maintained block by block from genesis onward, so an item with
+// no history entry at or before a block is known to not have existed in it.
+
+// archiveReader is a read-only snapshot.Snapshot serving the state of a single
+// historical block from the archive index.
+type archiveReader struct {
+	db     mbldb.Iteratee
+	root   common.Hash
+	number uint64
+}
+
+// Root returns the root hash of the state the reader was opened for.
+func (r *archiveReader) Root() common.Hash {
+	return r.root
+}
+
+// Account directly retrieves the account associated with a particular hash in
+// the slim data format.
+func (r *archiveReader) Account(hash common.Hash) (*snapshot.Account, error) {
+	data, err := r.AccountRLP(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 { // can be both nil and []byte{}
+		return nil, nil
+	}
+	account := new(snapshot.Account)
+	if err := rlp.DecodeBytes(data, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// AccountRLP directly retrieves the account RLP associated with a particular
+// hash in the slim data format.
+func (r *archiveReader) AccountRLP(hash common.Hash) ([]byte, error) {
+	return rawdb.ReadArchiveAccount(r.db, hash, r.number), nil
+}
+
+// Storage directly retrieves the storage data associated with a particular hash,
+// within a particular account.
+func (r *archiveReader) Storage(accountHash, storageHash common.Hash) ([]byte, error) {
+	return rawdb.ReadArchiveStorage(r.db, accountHash, storageHash, r.number), nil
+}
+
+// NewArchive creates a new state from a given trie root of a historical block,
+// serving account and storage reads from the archive index. The caller must
+// ensure the index covers the block and was built along the chain containing it.
+func NewArchive(root common.Hash, number uint64, db Database) (*StateDB, error) {
+	sdb, err := New(root, db, nil)
+	if err != nil {
+		return nil, err
+	}
+	sdb.snap = &archiveReader{
+		db:     db.TrieDB().DiskDB(),
+		root:   root,
+		number: number,
+	}
+	sdb.snapDestructs = make(map[common.Hash]struct{})
+	sdb.snapAccounts = make(map[common.Hash][]byte)
+	sdb.snapStorage = make(map[common.Hash]map[common.Hash][]byte)
+	return sdb, nil
+}
+
+// IndexArchiveBlock adds the account and storage changes made by the given block,
+// transitioning the state from the parent root to root, into the archive index.
+// Only the changed items are written, existing history is never rewritten.
+func IndexArchiveBlock(db Database, batch mbldb.KeyValueWriter, number uint64, parent, root common.Hash) error {
+	return archiveDiff(db.TrieDB(), parent, root,
+		func(hash common.Hash, blob []byte) {
+			rawdb.WriteArchiveAccount(batch, hash, number, blob)
+		},
+		func(accountHash, storageHash common.Hash, blob []byte) {
+			rawdb.WriteArchiveStorage(batch, accountHash, storageHash, number, blob)
+		},
+	)
+}
+
+// UnindexArchiveBlock removes the account and storage changes made by the given
+// block from the archive index. It is the inverse of IndexArchiveBlock and must
+// be called from the head of the index backwards.
+func UnindexArchiveBlock(db Database, batch mbldb.KeyValueWriter, number uint64, parent, root common.Hash) error {
+	return archiveDiff(db.TrieDB(), parent, root,
+		func(hash common.Hash, blob []byte) {
+			rawdb.DeleteArchiveAccount(batch, hash, number)
+		},
+		func(accountHash, storageHash common.Hash, blob []byte) {
+			rawdb.DeleteArchiveStorage(batch, accountHash, storageHash, number)
+		},
+	)
+}
+
+// archiveDiff invokes the callbacks for every account and storage slot whose
+// value differs between the parent and the child state. Accounts are reported
+// in the slim snapshot format, deleted items with a nil value.
+func archiveDiff(triedb *trie.Database, parent, root common.Hash, onAccount func(hash common.Hash, blob []byte), onStorage func(accountHash, storageHash common.Hash, blob []byte)) error {
+	oldTrie, err := trie.New(common.Hash{}, parent, triedb)
+	if err != nil {
+		return err
+	}
+	newTrie, err := trie.New(common.Hash{}, root, triedb)
+	if err != nil {
+		return err
+	}
+	// Report all accounts that were created or modified
+	diff, _ := trie.NewDifferenceIterator(oldTrie.NodeIterator(nil), newTrie.NodeIterator(nil))
+	it := trie.NewIterator(diff)
+	for it.Next() {
+		var account types.StateAccount
+		if err := rlp.DecodeBytes(it.Value, &account); err != nil {
+			return err
+		}
+		hash := common.BytesToHash(it.Key)
+		onAccount(hash, snapshot.SlimAccountRLP(account.Nonce, account.Balance, account.Root, account.CodeHash))
+
+		prevRoot := emptyRoot
+		blob, err := oldTrie.TryGet(it.Key)
+		if err != nil {
+			return err
+		}
+		if len(blob) > 0 {
+			var prev types.StateAccount
+			if err := rlp.DecodeBytes(blob, &prev); err != nil {
+				return err
+			}
+			prevRoot = prev.Root
+		}
+		if prevRoot != account.Root {
+			if err := archiveStorageDiff(triedb, hash, prevRoot, account.Root, onStorage); err != nil {
+				return err
+			}
+		}
+	}
+	if it.Err != nil {
+		return it.Err
+	}
+	// Report all accounts that were deleted, along with their storage
+	diff, _ = trie.NewDifferenceIterator(newTrie.NodeIterator(nil), oldTrie.NodeIterator(nil))
+	it = trie.NewIterator(diff)
+	for it.Next() {
+		blob, err := newTrie.TryGet(it.Key)
+		if err != nil {
+			return err
+		}
+		if len(blob) > 0 {
+			continue // modified, already reported
+		}
+		var prev types.StateAccount
+		if err := rlp.DecodeBytes(it.Value, &prev); err != nil {
+			return err
+		}
+		hash := common.BytesToHash(it.Key)
+		onAccount(hash, nil)
+		if err := archiveStorageDiff(triedb, hash, prev.Root, emptyRoot, onStorage); err != nil {
+			return err
+		}
+	}
+	return it.Err
+}
+
+// archiveStorageDiff invokes the callback for every storage slot of an account
+// whose value differs between the two storage tries.
+func archiveStorageDiff(triedb *trie.Database, hash common.Hash, parent, root common.Hash, onStorage func(accountHash, storageHash common.Hash, blob []byte)) error {
+	oldTrie, err := trie.New(hash, parent, triedb)
+	if err != nil {
+		return err
+	}
+	newTrie, err := trie.New(hash, root, triedb)
+	if err != nil {
+		return err
+	}
+	diff, _ := trie.NewDifferenceIterator(oldTrie.NodeIterator(nil), newTrie.NodeIterator(nil))
+	it := trie.NewIterator(diff)
+	for it.Next() {
+		onStorage(hash, common.BytesToHash(it.Key), it.Value)
+	}
+	if it.Err != nil {
+		return it.Err
+	}
+	diff, _ = trie.NewDifferenceIterator(newTrie.NodeIterator(nil), oldTrie.NodeIterator(nil))
+	it = trie.NewIterator(diff)
+	for it.Next() {
+		blob, err := newTrie.TryGet(it.Key)
+		if err != nil {
+			return err
+		}
+		if len(blob) == 0 {
+			onStorage(hash, common.BytesToHash(it.Key), nil)
+		}
+	}
+	return it.Err
+}
@@ -0,0 +1,173 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/crypto"
+)
+
+// makeArchiveChain creates a short sequence of committed states, returning the
+// state roots in block order.
+func makeArchiveChain(t *testing.T, db Database) []common.Hash {
+	var (
+		a, b, c = common.Address{0xa}, common.Address{0xb}, common.Address{0xc}
+		k1, k2  = common.Hash{0x01}, common.Hash{0x02}
+		roots   []common.Hash
+	)
+	mutations := []func(*StateDB){
+		func(s *StateDB) {
+			s.SetBalance(a, big.NewInt(1))
+			s.SetState(a, k1, common.Hash{0x11})
+			s.SetBalance(b, big.NewInt(2))
+			s.SetState(b, k1, common.Hash{0x21})
+		},
+		func(s *StateDB) {
+			s.SetState(a, k1, common.Hash{0x12})
+			s.SetState(a, k2, common.Hash{0x13})
+			s.SetBalance(c, big.NewInt(3))
+		},
+		func(s *StateDB) {
+			s.Suicide(b)
+			s.SetState(a, k1, common.Hash{})
+		},
+		func(s *StateDB) {},
+	}
+	parent := common.Hash{}
+	for _, mutate := range mutations {
+		state, err := New(parent, db, nil)
+		if err != nil {
+			t.Fatalf("failed to create state: %v", err)
+		}
+		mutate(state)
+		root, err := state.Commit(true)
+		if err != nil {
+			t.Fatalf("failed to commit state: %v", err)
+		}
+		if err := db.TrieDB().Commit(root, false, nil); err != nil {
+			t.Fatalf("failed to commit trie: %v", err)
+		}
+		roots = append(roots, root)
+		parent = root
+	}
+	return roots
+}
+
+// indexArchive indexes the given state roots as consecutive blocks.
+func indexArchive(t *testing.T, db Database, roots []common.Hash) {
+	parent := emptyRoot
+	for number, root := range roots {
+		batch := db.TrieDB().DiskDB().NewBatch()
+		if err := IndexArchiveBlock(db, batch, uint64(number), parent, root); err != nil {
+			t.Fatalf("block %d: failed to index: %v", number, err)
+		}
+		if err := batch.Write(); err != nil {
+			t.Fatalf("block %d: failed to write index: %v", number, err)
+		}
+		parent = root
+	}
+}
+
+// countArchiveEntries returns the number of archive index entries stored under
+// the given item prefix.
+func countArchiveEntries(db Database, prefix []byte) int {
+	it := db.TrieDB().DiskDB().NewIterator(prefix, nil)
+	defer it.Release()
+
+	var entries int
+	for it.Next() {
+		entries++
+	}
+	return entries
+}
+
+// checkArchive verifies that the archive backed states of all blocks match the
+// trie backed ones.
+func checkArchive(t *testing.T, db Database, roots []common.Hash) {
+	addrs := []common.Address{{0xa}, {0xb}, {0xc}, {0xd}}
+	slots := []common.Hash{{0x01}, {0x02}, {0x03}}
+
+	for number, root := range roots {
+		want, err := New(root, db, nil)
+		if err != nil {
+			t.Fatalf("block %d: failed to open trie state: %v", number, err)
+		}
+		have, err := NewArchive(root, uint64(number), db)
+		if err != nil {
+			t.Fatalf("block %d: failed to open archive state: %v", number, err)
+		}
+		for _, addr := range addrs {
+			if have.Exist(addr) != want.Exist(addr) {
+				t.Errorf("block %d, account %x: existence mismatch: have %v, want %v", number, addr, have.Exist(addr), want.Exist(addr))
+			}
+			if have.GetBalance(addr).Cmp(want.GetBalance(addr)) != 0 {
+				t.Errorf("block %d, account %x: balance mismatch: have %v, want %v", number, addr, have.GetBalance(addr), want.GetBalance(addr))
+			}
+			for _, slot := range slots {
+				if have.GetState(addr, slot) != want.GetState(addr, slot) {
+					t.Errorf("block %d, account %x, slot %x: value mismatch: have %x, want %x", number, addr, slot, have.GetState(addr, slot), want.GetState(addr, slot))
+				}
+			}
+		}
+	}
+}
+
+// Tests that historical state served from the archive index matches the state
+// stored in the tries.
+func TestArchiveIndex(t *testing.T) {
+	db := NewDatabase(rawdb.NewMemoryDatabase())
+	roots := makeArchiveChain(t, db)
+
+	indexArchive(t, db, roots)
+	checkArchive(t, db, roots)
+
+	// Accounts and slots should only store actual changes
+	prefix := append(common.CopyBytes(rawdb.ArchiveAccountPrefix), crypto.Keccak256(common.Address{0xb}.Bytes())...)
+	if entries := countArchiveEntries(db, prefix); entries != 2 {
+		t.Errorf("account history length mismatch: have %d, want 2", entries)
+	}
+}
+
+// Tests that unindexing blocks restores the archive index to its state before
+// the blocks were added, allowing them to be reindexed.
+func TestArchiveUnindex(t *testing.T) {
+	db := NewDatabase(rawdb.NewMemoryDatabase())
+	roots := makeArchiveChain(t, db)
+	indexArchive(t, db, roots)
+
+	for number := len(roots) - 1; number > 0; number-- {
+		batch := db.TrieDB().DiskDB().NewBatch()
+		if err := UnindexArchiveBlock(db, batch, uint64(number), roots[number-1], roots[number]); err != nil {
+			t.Fatalf("block %d: failed to unindex: %v", number, err)
+		}
+		if err := batch.Write(); err != nil {
+			t.Fatalf("block %d: failed to write index: %v", number, err)
+		}
+	}
+	checkArchive(t, db, roots[:1])
+	prefix := append(common.CopyBytes(rawdb.ArchiveStoragePrefix), crypto.Keccak256(common.Address{0xa}.Bytes())...)
+	prefix = append(prefix, crypto.Keccak256(common.Hash{0x02}.Bytes())...)
+	if entries := countArchiveEntries(db, prefix); entries != 0 {
+		t.Errorf("unindexed slot still has %d history entries", entries)
+	}
+	indexArchive(t, db, roots)
+	checkArchive(t, db, roots)
+}
@@ -0,0 +1,444 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/crypto"
+	"github.com/mbali/go-mbali/log"
+	"github.com/mbali/go-mbali/mbldb"
+	"github.com/mbali/go-mbali/rlp"
+	"github.com/mbali/go-mbali/trie"
+)
+
+const (
+	// exportMagic is the identifier written at the start of every snapshot dump.
+	exportMagic = "gomblsnapdump"
+
+	// exportVersion is the version of the snapshot dump format.
+	exportVersion = 0
+
+	// exportChunkSize is the approximate number of payload bytes gathered
+	// into a single verifiable chunk of the dump.
+	exportChunkSize = 1024 * 1024
+)
+
+// Entry kinds contained in the snapshot dump chunks.
+const (
+	exportKindAccount = iota // Slim account RLP, keyed by account hash
+	exportKindCode           // Contract bytecode, keyed by account hash and code hash
+	exportKindStorage        // Storage slot RLP, keyed by account hash and slot hash
+)
+
+var (
+	// errExportMagic is returned if the dump does not start with the expected magic.
+	errExportMagic = errors.New("incompatible snapshot dump, wrong magic")
+
+	// errExportTruncated is returned if the dump ends before the trailer chunk.
+	errExportTruncated = errors.New("snapshot dump truncated")
+)
+
+// exportHeader is the first item of a snapshot dump, describing its content.
+type exportHeader struct {
+	Magic    string
+	Version  uint64
+	Root     common.Hash
+	UnixTime uint64
+}
+
+// exportEntry is a single flat state item of a snapshot dump.
+type exportEntry struct {
+	Kind    uint8
+	Account common.Hash
+	Key     common.Hash
+	Value   []byte
+}
+
+// exportChunk is a batch of flat state items along with the keccak256 hash of
+// their RLP encoding. The final chunk of a dump carries no entries and its
+// checksum is the cumulative hash of all preceding chunk checksums.
+type exportChunk struct {
+	Entries  []exportEntry
+	Checksum common.Hash
+}
+
+// exporter accumulates dump entries and flushes them out in checksummed chunks.
+type exporter struct {
+	w       io.Writer
+	entries []exportEntry
+	size    int
+	chunks  uint64
+	digest  common.Hash
+}
+
+// add appends a new entry to the pending chunk, flushing it if it grew too large.
+func (e *exporter) add(kind uint8, account common.Hash, key common.Hash, value []byte) error {
+	e.entries = append(e.entries, exportEntry{Kind: kind, Account: account, Key: key, Value: value})
+	e.size += 2*common.HashLength + len(value)
+	if e.size >= exportChunkSize {
+		return e.flush()
+	}
+	return nil
+}
+
+// flush writes out the pending chunk, if any, and folds its checksum into the
+// cumulative digest.
+func (e *exporter) flush() error {
+	if len(e.entries) == 0 {
+		return nil
+	}
+	blob, err := rlp.EncodeToBytes(e.entries)
+	if err != nil {
+		return err
+	}
+	chunk := exportChunk{Entries: e.entries, Checksum: crypto.Keccak256Hash(blob)}
+	if err := rlp.Encode(e.w, &chunk); err != nil {
+		return err
+	}
+	e.digest = crypto.Keccak256Hash(e.digest[:], chunk.Checksum[:])
+	e.chunks++
+
+	e.entries, e.size = e.entries[:0], 0
+	return nil
+}
+
+// finish flushes the last pending chunk and writes out the trailer.
+func (e *exporter) finish() error {
+	if err := e.flush(); err != nil {
+		return err
+	}
+	return rlp.Encode(e.w, &exportChunk{Checksum: e.digest})
+}
+
+// Export dumps the flat account and storage snapshot belonging to the given
+// state root, together with all referenced contract codes, into a gzip
+// compressed stream of checksummed chunks.
+func Export(snaptree *Tree, root common.Hash, codedb mbldb.KeyValueReader, w io.Writer) error {
+	acctIt, err := snaptree.AccountIterator(root, common.Hash{})
+	if err != nil {
+		return err // The required snapshot might not exist.
+	}
+	defer acctIt.Release()
+
+	zw := gzip.NewWriter(w)
+	if err := rlp.Encode(zw, &exportHeader{
+		Magic:    exportMagic,
+		Version:  exportVersion,
+		Root:     root,
+		UnixTime: uint64(time.Now().Unix()),
+	}); err != nil {
+		return err
+	}
+	var (
+		exp    = &exporter{w: zw}
+		codes  = make(map[common.Hash]struct{})
+		start  = time.Now()
+		logged = time.Now()
+
+		accounts, slots uint64
+	)
+	for acctIt.Next() {
+		accountHash, blob := acctIt.Hash(), acctIt.Account()
+		account, err := FullAccount(blob)
+		if err != nil {
+			return err
+		}
+		if err := exp.add(exportKindAccount, accountHash, common.Hash{}, common.CopyBytes(blob)); err != nil {
+			return err
+		}
+		accounts++
+
+		// Export the contract code the first time it's referenced
+		codeHash := common.BytesToHash(account.CodeHash)
+		if _, ok := codes[codeHash]; !ok && codeHash != emptyCode {
+			code := rawdb.ReadCode(codedb, codeHash)
+			if len(code) == 0 {
+				return fmt.Errorf("missing code %x of account %x", codeHash, accountHash)
+			}
+			if err := exp.add(exportKindCode, accountHash, codeHash, code); err != nil {
+				return err
+			}
+			codes[codeHash] = struct{}{}
+		}
+		// Export all the storage slots right after the owning account
+		if common.BytesToHash(account.Root) != emptyRoot {
+			storageIt, err := snaptree.StorageIterator(root, accountHash, common.Hash{})
+			if err != nil {
+				return err
+			}
+			for storageIt.Next() {
+				if err := exp.add(exportKindStorage, accountHash, storageIt.Hash(), common.CopyBytes(storageIt.Slot())); err != nil {
+					storageIt.Release()
+					return err
+				}
+				slots++
+			}
+			err = storageIt.Error()
+			storageIt.Release()
+			if err != nil {
+				return err
+			}
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Exporting snapshot", "at", accountHash, "accounts", accounts, "slots", slots,
+				"codes", len(codes), "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := acctIt.Error(); err != nil {
+		return err
+	}
+	if err := exp.finish(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	log.Info("Exported snapshot", "root", root, "accounts", accounts, "slots", slots,
+		"codes", len(codes), "chunks", exp.chunks, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// importer rebuilds the flat snapshot and the tries from a stream of dump
+// entries, which are expected in the same order they were exported.
+type importer struct {
+	db      mbldb.KeyValueWriter
+	accTrie *trie.StackTrie
+	codes   map[common.Hash]struct{}
+
+	account     common.Hash     // Hash of the account being imported
+	accountBlob []byte          // Slim RLP of the account being imported
+	storageTrie *trie.StackTrie // Storage trie of the account being imported
+	storageLast common.Hash     // Last imported slot hash of the current account
+
+	stats generatorStats
+}
+
+func newImporter(db mbldb.KeyValueWriter) *importer {
+	return &importer{
+		db:      db,
+		accTrie: trie.NewStackTrie(db),
+		codes:   make(map[common.Hash]struct{}),
+	}
+}
+
+// process verifies and imports a single dump entry.
+func (imp *importer) process(entry *exportEntry) error {
+	switch entry.Kind {
+	case exportKindAccount:
+		if imp.accountBlob != nil && bytes.Compare(entry.Account[:], imp.account[:]) <= 0 {
+			return fmt.Errorf("unordered account %x after %x", entry.Account, imp.account)
+		}
+		if err := imp.finishAccount(); err != nil {
+			return err
+		}
+		if _, err := FullAccount(entry.Value); err != nil {
+			return fmt.Errorf("invalid account %x: %v", entry.Account, err)
+		}
+		rawdb.WriteAccountSnapshot(imp.db, entry.Account, entry.Value)
+		imp.account, imp.accountBlob = entry.Account, entry.Value
+		imp.stats.accounts++
+		imp.stats.storage += common.StorageSize(1 + common.HashLength + len(entry.Value))
+
+	case exportKindCode:
+		if imp.accountBlob == nil || entry.Account != imp.account {
+			return fmt.Errorf("orphan code %x of account %x", entry.Key, entry.Account)
+		}
+		if hash := crypto.Keccak256Hash(entry.Value); hash != entry.Key {
+			return fmt.Errorf("code hash mismatch: have %x, want %x", hash, entry.Key)
+		}
+		rawdb.WriteCode(imp.db, entry.Key, entry.Value)
+		imp.codes[entry.Key] = struct{}{}
+
+	case exportKindStorage:
+		if imp.accountBlob == nil || entry.Account != imp.account {
+			return fmt.Errorf("orphan slot %x of account %x", entry.Key, entry.Account)
+		}
+		if imp.storageTrie != nil && bytes.Compare(entry.Key[:], imp.storageLast[:]) <= 0 {
+			return fmt.Errorf("unordered slot %x after %x", entry.Key, imp.storageLast)
+		}
+		if len(entry.Value) == 0 {
+			return fmt.Errorf("empty slot %x of account %x", entry.Key, entry.Account)
+		}
+		if imp.storageTrie == nil {
+			imp.storageTrie = trie.NewStackTrieWithOwner(imp.db, imp.account)
+		}
+		imp.storageTrie.TryUpdate(entry.Key[:], entry.Value)
+		rawdb.WriteStorageSnapshot(imp.db, entry.Account, entry.Key, entry.Value)
+		imp.storageLast = entry.Key
+		imp.stats.slots++
+		imp.stats.storage += common.StorageSize(1 + 2*common.HashLength + len(entry.Value))
+
+	default:
+		return fmt.Errorf("unknown entry kind %d", entry.Kind)
+	}
+	return nil
+}
+
+// finishAccount verifies the storage root and code of the account currently
+// being imported and inserts it into the account trie.
+func (imp *importer) finishAccount() error {
+	if imp.accountBlob == nil {
+		return nil
+	}
+	account, err := FullAccount(imp.accountBlob)
+	if err != nil {
+		return err
+	}
+	storageRoot := emptyRoot
+	if imp.storageTrie != nil {
+		if storageRoot, err = imp.storageTrie.Commit(); err != nil {
+			return err
+		}
+	}
+	if !bytes.Equal(account.Root, storageRoot[:]) {
+		return fmt.Errorf("invalid storage root of account %x, want %x, have %x", imp.account, account.Root, storageRoot)
+	}
+	if codeHash := common.BytesToHash(account.CodeHash); codeHash != emptyCode {
+		if _, ok := imp.codes[codeHash]; !ok {
+			return fmt.Errorf("missing code %x of account %x", codeHash, imp.account)
+		}
+	}
+	full, err := rlp.EncodeToBytes(account)
+	if err != nil {
+		return err
+	}
+	imp.accTrie.TryUpdate(imp.account[:], full)
+
+	imp.accountBlob, imp.storageTrie, imp.storageLast = nil, nil, common.Hash{}
+	return nil
+}
+
+// finish finalises the last account and commits the account trie.
+func (imp *importer) finish() (common.Hash, error) {
+	if err := imp.finishAccount(); err != nil {
+		return common.Hash{}, err
+	}
+	if imp.stats.accounts == 0 {
+		return emptyRoot, nil
+	}
+	return imp.accTrie.Commit()
+}
+
+// Import reads a snapshot dump produced by Export, verifies the checksum of
+// every chunk, writes the flat snapshot and contract codes into the database
+// and regenerates all the tries from the flat state. The regenerated state
+// root is checked against the one in the dump and, if non-empty, against the
+// expected root. The database must not contain a snapshot already.
+func Import(db mbldb.KeyValueStore, r io.Reader, root common.Hash) (common.Hash, error) {
+	if have := rawdb.ReadSnapshotRoot(db); have != (common.Hash{}) {
+		return common.Hash{}, fmt.Errorf("database already contains snapshot %x", have)
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	defer zr.Close()
+
+	stream := rlp.NewStream(zr, 0)
+
+	var header exportHeader
+	if err := stream.Decode(&header); err != nil {
+		return common.Hash{}, fmt.Errorf("could not decode header: %v", err)
+	}
+	if header.Magic != exportMagic {
+		return common.Hash{}, errExportMagic
+	}
+	if header.Version != exportVersion {
+		return common.Hash{}, fmt.Errorf("incompatible version %d, (support only %d)", header.Version, exportVersion)
+	}
+	if root != (common.Hash{}) && root != header.Root {
+		return common.Hash{}, fmt.Errorf("snapshot dump root mismatch: have %x, want %x", header.Root, root)
+	}
+	log.Info("Importing snapshot", "root", header.Root, "data age",
+		common.PrettyDuration(time.Since(time.Unix(int64(header.UnixTime), 0))))
+
+	var (
+		batch  = db.NewBatch()
+		imp    = newImporter(batch)
+		digest common.Hash
+		chunks uint64
+		start  = time.Now()
+		logged = time.Now()
+	)
+	for {
+		var chunk exportChunk
+		if err := stream.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				return common.Hash{}, errExportTruncated
+			}
+			return common.Hash{}, err
+		}
+		// An empty chunk is the trailer, check the cumulative checksum
+		if len(chunk.Entries) == 0 {
+			if chunk.Checksum != digest {
+				return common.Hash{}, fmt.Errorf("snapshot dump checksum mismatch: have %x, want %x", digest, chunk.Checksum)
+			}
+			break
+		}
+		blob, err := rlp.EncodeToBytes(chunk.Entries)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		if hash := crypto.Keccak256Hash(blob); hash != chunk.Checksum {
+			return common.Hash{}, fmt.Errorf("chunk %d checksum mismatch: have %x, want %x", chunks, hash, chunk.Checksum)
+		}
+		for i := range chunk.Entries {
+			if err := imp.process(&chunk.Entries[i]); err != nil {
+				return common.Hash{}, err
+			}
+		}
+		digest = crypto.Keccak256Hash(digest[:], chunk.Checksum[:])
+		chunks++
+
+		if batch.ValueSize() > mbldb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return common.Hash{}, err
+			}
+			batch.Reset()
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Importing snapshot", "at", imp.account, "accounts", imp.stats.accounts, "slots", imp.stats.slots,
+				"chunks", chunks, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	got, err := imp.finish()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if got != header.Root {
+		return common.Hash{}, fmt.Errorf("state root hash mismatch: got %x, want %x", got, header.Root)
+	}
+	// Mark the imported snapshot as a complete disk layer
+	rawdb.WriteSnapshotRoot(batch, got)
+	journalProgress(batch, nil, &imp.stats)
+	if err := batch.Write(); err != nil {
+		return common.Hash{}, err
+	}
+	log.Info("Imported snapshot", "root", got, "accounts", imp.stats.accounts, "slots", imp.stats.slots,
+		"codes", len(imp.codes), "chunks", chunks, "elapsed", common.PrettyDuration(time.Since(start)))
+	return got, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/crypto"
+	"github.com/mbali/go-mbali/trie"
+)
+
+// makeExportSnapshot creates a small generated snapshot with storage and
+// contract code to export.
+func makeExportSnapshot(t *testing.T) (common.Hash, *Tree, *testHelper) {
+	var (
+		helper = newHelper()
+		code   = []byte{0x60, 0x00, 0x60, 0x00, 0xf3}
+	)
+	codeHash := crypto.Keccak256Hash(code)
+	rawdb.WriteCode(helper.diskdb, codeHash, code)
+
+	stRoot := helper.makeStorageTrie(common.Hash{}, hashData([]byte("acc-1")), []string{"key-1", "key-2", "key-3"}, []string{"val-1", "val-2", "val-3"}, true)
+	helper.addTrieAccount("acc-1", &Account{Balance: big.NewInt(1), Root: stRoot, CodeHash: codeHash.Bytes()})
+	helper.addTrieAccount("acc-2", &Account{Balance: big.NewInt(2), Root: emptyRoot.Bytes(), CodeHash: emptyCode.Bytes()})
+	helper.addTrieAccount("acc-3", &Account{Balance: big.NewInt(3), Root: emptyRoot.Bytes(), CodeHash: codeHash.Bytes()})
+
+	root, snap := helper.CommitAndGenerate()
+	select {
+	case <-snap.genPending:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Snapshot generation failed")
+	}
+	return root, &Tree{layers: map[common.Hash]snapshot{root: snap}}, helper
+}
+
+// Tests that a snapshot can be exported and imported into an empty database,
+// regenerating the same state.
+func TestExportImport(t *testing.T) {
+	root, snaps, helper := makeExportSnapshot(t)
+
+	var dump bytes.Buffer
+	if err := Export(snaps, root, helper.diskdb, &dump); err != nil {
+		t.Fatalf("Failed to export snapshot: %v", err)
+	}
+	db := rawdb.NewMemoryDatabase()
+	got, err := Import(db, bytes.NewReader(dump.Bytes()), root)
+	if err != nil {
+		t.Fatalf("Failed to import snapshot: %v", err)
+	}
+	if got != root {
+		t.Fatalf("root mismatch: have %x, want %x", got, root)
+	}
+	if have := rawdb.ReadSnapshotRoot(db); have != root {
+		t.Fatalf("snapshot root mismatch: have %x, want %x", have, root)
+	}
+	// Ensure the regenerated tries are complete
+	tr, err := trie.NewSecure(common.Hash{}, root, trie.NewDatabase(db))
+	if err != nil {
+		t.Fatalf("Failed to open imported trie: %v", err)
+	}
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	accounts := 0
+	for it.Next() {
+		accounts++
+	}
+	if it.Err != nil {
+		t.Fatalf("Failed to iterate imported trie: %v", it.Err)
+	}
+	if accounts != 3 {
+		t.Fatalf("account count mismatch: have %d, want 3", accounts)
+	}
+	// Importing on top of an existing snapshot should be rejected
+	if _, err := Import(db, bytes.NewReader(dump.Bytes()), root); err == nil {
+		t.Fatal("Expected failure importing into populated database")
+	}
+}
+
+// Tests that corrupted or mismatching snapshot dumps are rejected.
+func TestImportCorrupted(t *testing.T) {
+	root, snaps, helper := makeExportSnapshot(t)
+
+	var dump bytes.Buffer
+	if err := Export(snaps, root, helper.diskdb, &dump); err != nil {
+		t.Fatalf("Failed to export snapshot: %v", err)
+	}
+	if _, err := Import(rawdb.NewMemoryDatabase(), bytes.NewReader(dump.Bytes()), common.Hash{0x01}); err == nil {
+		t.Fatal("Expected failure importing with mismatching root")
+	}
+	truncated := dump.Bytes()[:dump.Len()/2]
+	if _, err := Import(rawdb.NewMemoryDatabase(), bytes.NewReader(truncated), root); err == nil {
+		t.Fatal("Expected failure importing truncated dump")
+	}
+}
@@ -62,7 +62,7 @@ func (s Storage) Copy() Storage {
 // The usage pattern is as follows:
 // First you need to obtain a state object.
 // Account values can be accessed and modified through the object.
-// Finally, call CommitTrie to write the modified storage trie into a database.
+// Finally, call commitTrie to write the modified storage trie into a database.
 type stateObject struct {
 	address  common.Address
 	addrHash common.Hash // hash of mbali address of the account
@@ -360,33 +360,19 @@ func (s *stateObject) updateTrie(db Database) Trie {
 	return tr
 }
 
-// UpdateRoot sets the trie root to the current root hash of
-func (s *stateObject) updateRoot(db Database) {
-	// If nothing changed, don't bother with hashing anything
-	if s.updateTrie(db) == nil {
-		return
-	}
-	// Track the amount of time wasted on hashing the storage trie
-	if metrics.EnabledExpensive {
-		defer func(start time.Time) { s.db.StorageHashes += time.Since(start) }(time.Now())
-	}
+// hashRoot sets the trie root to the current root hash of the storage trie.
+// The pending storage changes must already be flushed into the trie by
+// updateTrie. It does not touch any shared state of the parent StateDB, so
+// it's safe to be called concurrently for different objects.
+func (s *stateObject) hashRoot() {
 	s.data.Root = s.trie.Hash()
 }
 
-// CommitTrie the storage trie of the object to db.
-// This updates the trie root.
-func (s *stateObject) CommitTrie(db Database) (int, error) {
-	// If nothing changed, don't bother with hashing anything
-	if s.updateTrie(db) == nil {
-		return 0, nil
-	}
-	if s.dbErr != nil {
-		return 0, s.dbErr
-	}
-	// Track the amount of time wasted on committing the storage trie
-	if metrics.EnabledExpensive {
-		defer func(start time.Time) { s.db.StorageCommits += time.Since(start) }(time.Now())
-	}
+// commitTrie writes the storage trie of the object into the trie database and
+// updates the trie root. The pending storage changes must already be flushed
+// into the trie by updateTrie. Similarly to hashRoot, it's safe to be called
+// concurrently for different objects.
+func (s *stateObject) commitTrie() (int, error) {
 	root, committed, err := s.trie.Commit(nil)
 	if err == nil {
 		s.data.Root = root
@@ -21,12 +21,15 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"runtime"
 	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/mbali/go-mbali/common"
 	"github.com/mbali/go-mbali/core/rawdb"
 	"github.com/mbali/go-mbali/core/state/snapshot"
+	"github.com/mbali/go-mbali/core/tracing"
 	"github.com/mbali/go-mbali/core/types"
 	"github.com/mbali/go-mbali/crypto"
 	"github.com/mbali/go-mbali/log"
@@ -77,6 +80,10 @@ type StateDB struct {
 	snapAccounts  map[common.Hash][]byte
 	snapStorage   map[common.Hash]map[common.Hash][]byte
 
+	// storageWorkers is the maximum number of storage tries hashed or
+	// committed concurrently.
+	storageWorkers int
+
 	// This map holds 'live' objects, which will get modified while processing a state transition.
 	stateObjects        map[common.Address]*stateObject
 	stateObjectsPending map[common.Address]struct{} // State objects finalized but not yet written to the trie
@@ -102,6 +109,12 @@ type StateDB struct {
 	// Per-transaction access list
 	accessList *accessList
 
+	// Transient storage (EIP-1153), cleared at the end of every transaction
+	transientStorage transientStorage
+
+	// Optional tracer hooks notified of every state change
+	logger tracing.StateHooks
+
 	// Journal of state modifications. This is the backbone of
 	// Snapshot and RevertToSnapshot.
 	journal        *journal
@@ -145,7 +158,9 @@ func New(root common.Hash, db Database, snaps *snapshot.Tree) (*StateDB, error)
 		preimages:           make(map[common.Hash][]byte),
 		journal:             newJournal(),
 		accessList:          newAccessList(),
+		transientStorage:    newTransientStorage(),
 		hasher:              crypto.NewKeccakState(),
+		storageWorkers:      runtime.NumCPU(),
 	}
 	if sdb.snaps != nil {
 		if sdb.snap = sdb.snaps.Snapshot(root); sdb.snap != nil {
@@ -157,6 +172,12 @@ func New(root common.Hash, db Database, snaps *snapshot.Tree) (*StateDB, error)
 	return sdb, nil
 }
 
+// SetLogger sets the tracer hooks notified of the state changes made through
+// this StateDB. A nil logger disables the notifications.
+func (s *StateDB) SetLogger(l tracing.StateHooks) {
+	s.logger = l
+}
+
 // StartPrefetcher initializes a new trie prefetcher to pull in nodes from the
 // state trie concurrently while the state is mutated so that when we reach the
 // commit phase, most of the needed data is already hot.
@@ -198,6 +219,10 @@ func (s *StateDB) AddLog(log *types.Log) {
 	log.Index = s.logSize
 	s.logs[s.thash] = append(s.logs[s.thash], log)
 	s.logSize++
+
+	if s.logger != nil {
+		s.logger.OnLog(log)
+	}
 }
 
 func (s *StateDB) GetLogs(hash common.Hash, blockHash common.Hash) []*types.Log {
@@ -378,17 +403,25 @@ func (s *StateDB) HasSuicided(addr common.Address) bool {
  */
 
 // AddBalance adds amount to the account associated with addr.
-func (s *StateDB) AddBalance(addr common.Address, amount *big.Int) {
+func (s *StateDB) AddBalance(addr common.Address, amount *big.Int, reason tracing.BalanceChangeReason) {
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
+		if s.logger != nil && amount.Sign() != 0 {
+			prev := new(big.Int).Set(stateObject.Balance())
+			defer func() { s.logger.OnBalanceChange(addr, prev, stateObject.Balance(), reason) }()
+		}
 		stateObject.AddBalance(amount)
 	}
 }
 
 // SubBalance subtracts amount from the account associated with addr.
-func (s *StateDB) SubBalance(addr common.Address, amount *big.Int) {
+func (s *StateDB) SubBalance(addr common.Address, amount *big.Int, reason tracing.BalanceChangeReason) {
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
+		if s.logger != nil && amount.Sign() != 0 {
+			prev := new(big.Int).Set(stateObject.Balance())
+			defer func() { s.logger.OnBalanceChange(addr, prev, stateObject.Balance(), reason) }()
+		}
 		stateObject.SubBalance(amount)
 	}
 }
@@ -396,6 +429,9 @@ func (s *StateDB) SubBalance(addr common.Address, amount *big.Int) {
 func (s *StateDB) SetBalance(addr common.Address, amount *big.Int) {
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
+		if s.logger != nil {
+			s.logger.OnBalanceChange(addr, new(big.Int).Set(stateObject.Balance()), amount, tracing.BalanceChangeUnspecified)
+		}
 		stateObject.SetBalance(amount)
 	}
 }
@@ -403,6 +439,9 @@ func (s *StateDB) SetBalance(addr common.Address, amount *big.Int) {
 func (s *StateDB) SetNonce(addr common.Address, nonce uint64) {
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
+		if s.logger != nil {
+			s.logger.OnNonceChange(addr, stateObject.Nonce(), nonce)
+		}
 		stateObject.SetNonce(nonce)
 	}
 }
@@ -410,13 +449,22 @@ func (s *StateDB) SetNonce(addr common.Address, nonce uint64) {
 func (s *StateDB) SetCode(addr common.Address, code []byte) {
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
-		stateObject.SetCode(crypto.Keccak256Hash(code), code)
+		codeHash := crypto.Keccak256Hash(code)
+		if s.logger != nil {
+			s.logger.OnCodeChange(addr, common.BytesToHash(stateObject.CodeHash()), stateObject.Code(s.db), codeHash, code)
+		}
+		stateObject.SetCode(codeHash, code)
 	}
 }
 
 func (s *StateDB) SetState(addr common.Address, key, value common.Hash) {
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
+		if s.logger != nil {
+			if prev := stateObject.GetState(s.db, key); prev != value {
+				s.logger.OnStorageChange(addr, key, prev, value)
+			}
+		}
 		stateObject.SetState(s.db, key, value)
 	}
 }
@@ -430,6 +478,33 @@ func (s *StateDB) SetStorage(addr common.Address, storage map[common.Hash]common
 	}
 }
 
+// SetTransientState sets transient storage for a given account. It
+// adds the change to the journal so that it can be rolled back
+// to its previous value if there is a revert.
+func (s *StateDB) SetTransientState(addr common.Address, key, value common.Hash) {
+	prev := s.GetTransientState(addr, key)
+	if prev == value {
+		return
+	}
+	s.journal.append(transientStorageChange{
+		account:  &addr,
+		key:      key,
+		prevalue: prev,
+	})
+	s.setTransientState(addr, key, value)
+}
+
+// setTransientState is a lower level setter for transient storage. It
+// is called during a revert to prevent modifications to the journal.
+func (s *StateDB) setTransientState(addr common.Address, key, value common.Hash) {
+	s.transientStorage.Set(addr, key, value)
+}
+
+// GetTransientState gets transient storage for a given account.
+func (s *StateDB) GetTransientState(addr common.Address, key common.Hash) common.Hash {
+	return s.transientStorage.Get(addr, key)
+}
+
 // Suicide marks the given account as suicided.
 // This clears the account balance.
 //
@@ -446,6 +521,9 @@ func (s *StateDB) Suicide(addr common.Address) bool {
 		prevbalance: new(big.Int).Set(stateObject.Balance()),
 	})
 	stateObject.markSuicided()
+	if s.logger != nil && stateObject.Balance().Sign() != 0 {
+		s.logger.OnBalanceChange(addr, new(big.Int).Set(stateObject.Balance()), new(big.Int), tracing.BalanceDecreaseSelfdestruct)
+	}
 	stateObject.data.Balance = new(big.Int)
 
 	return true
@@ -661,6 +739,7 @@ func (s *StateDB) Copy() *StateDB {
 		preimages:           make(map[common.Hash][]byte, len(s.preimages)),
 		journal:             newJournal(),
 		hasher:              crypto.NewKeccakState(),
+		storageWorkers:      s.storageWorkers,
 	}
 	// Copy the dirty states, logs, and preimages
 	for addr := range s.journal.dirties {
@@ -710,6 +789,7 @@ func (s *StateDB) Copy() *StateDB {
 	// However, it doesn't cost us much to copy an empty list, so we do it anyway
 	// to not blow up if we ever decide copy it in the middle of a transaction
 	state.accessList = s.accessList.Copy()
+	state.transientStorage = s.transientStorage.Copy()
 
 	// If there's a prefetcher running, make an inactive copy of it that can
 	// only access data but does not actively preload (since the user will not
@@ -717,7 +797,7 @@ func (s *StateDB) Copy() *StateDB {
 	if s.prefetcher != nil {
 		state.prefetcher = s.prefetcher.copy()
 	}
-	if s.snaps != nil {
+	if s.snaps != nil || s.snap != nil {
 		// In order for the miner to be able to use and make additions
 		// to the snapshot tree, we need to copy that aswell.
 		// Otherwise, any block mined by ourselves will cause gaps in the tree,
@@ -846,11 +926,18 @@ func (s *StateDB) IntermediateRoot(deleteEmptyObjects bool) common.Hash {
 	// the account prefetcher. Instead, let's process all the storage updates
 	// first, giving the account prefeches just a few more milliseconds of time
 	// to pull useful data from disk.
+	//
+	// Flushing the updates into the storage tries touches shared state (snapshot
+	// maps, prefetcher, metrics), so it's done sequentially. Hashing the storage
+	// tries is independent per contract and is done concurrently.
+	hashing := make([]*stateObject, 0, len(s.stateObjectsPending))
 	for addr := range s.stateObjectsPending {
-		if obj := s.stateObjects[addr]; !obj.deleted {
-			obj.updateRoot(s.db)
+		if obj := s.stateObjects[addr]; !obj.deleted && obj.updateTrie(s.db) != nil {
+			hashing = append(hashing, obj)
 		}
 	}
+	s.hashStorageTries(hashing)
+
 	// Now we're about to start to write changes to the trie. The trie is so far
 	// _untouched_. We can check with the prefetcher, if it can give us a trie
 	// which has the same root, but also has some content loaded into it.
@@ -883,11 +970,100 @@ func (s *StateDB) IntermediateRoot(deleteEmptyObjects bool) common.Hash {
 	return s.trie.Hash()
 }
 
+// SetStorageConcurrency sets the maximum number of storage tries which are
+// hashed or committed concurrently. Values below 2 disable concurrency.
+func (s *StateDB) SetStorageConcurrency(workers int) {
+	s.storageWorkers = workers
+}
+
+// hashStorageTries recalculates the storage roots of the given state objects,
+// using a bounded number of concurrent workers.
+func (s *StateDB) hashStorageTries(objs []*stateObject) {
+	// Track the amount of time wasted on hashing the storage tries
+	if metrics.EnabledExpensive {
+		defer func(start time.Time) { s.StorageHashes += time.Since(start) }(time.Now())
+	}
+	s.forEachStorageTrie(objs, func(obj *stateObject) error {
+		obj.hashRoot()
+		return nil
+	})
+}
+
+// commitStorageTries writes the storage tries of the given state objects into
+// the trie database, using a bounded number of concurrent workers. The number
+// of committed trie nodes is returned.
+func (s *StateDB) commitStorageTries(objs []*stateObject) (int, error) {
+	// Track the amount of time wasted on committing the storage tries
+	if metrics.EnabledExpensive {
+		defer func(start time.Time) { s.StorageCommits += time.Since(start) }(time.Now())
+	}
+	var committed int64
+	err := s.forEachStorageTrie(objs, func(obj *stateObject) error {
+		n, err := obj.commitTrie()
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&committed, int64(n))
+		return nil
+	})
+	return int(committed), err
+}
+
+// forEachStorageTrie runs fn on every given state object, spreading the work
+// across up to storageWorkers goroutines. The first error encountered is
+// returned, though all objects are processed regardless.
+func (s *StateDB) forEachStorageTrie(objs []*stateObject, fn func(obj *stateObject) error) error {
+	workers := s.storageWorkers
+	if workers > len(objs) {
+		workers = len(objs)
+	}
+	// Avoid spinning up goroutines if there's nothing to parallelise
+	if workers < 2 {
+		var fail error
+		for _, obj := range objs {
+			if err := fn(obj); err != nil && fail == nil {
+				fail = err
+			}
+		}
+		return fail
+	}
+	var (
+		tasks   = make(chan *stateObject, len(objs))
+		results = make(chan error, workers)
+	)
+	for _, obj := range objs {
+		tasks <- obj
+	}
+	close(tasks)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			var fail error
+			for obj := range tasks {
+				if err := fn(obj); err != nil && fail == nil {
+					fail = err
+				}
+			}
+			results <- fail
+		}()
+	}
+	var fail error
+	for i := 0; i < workers; i++ {
+		if err := <-results; err != nil && fail == nil {
+			fail = err
+		}
+	}
+	return fail
+}
+
 // Prepare sets the current transaction hash and index which are
 // used when the EVM emits new state logs.
 func (s *StateDB) Prepare(thash common.Hash, ti int) {
 	s.thash = thash
 	s.txIndex = ti
+
+	// Transient storage only lives for the duration of a single transaction.
+	s.transientStorage = newTransientStorage()
 }
 
 func (s *StateDB) clearJournalAndRefund() {
@@ -907,7 +1083,7 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
 	s.IntermediateRoot(deleteEmptyObjects)
 
 	// Commit objects to the trie, measuring the elapsed time
-	var storageCommitted int
+	var committing []*stateObject
 	codeWriter := s.db.TrieDB().DiskDB().NewBatch()
 	for addr := range s.stateObjectsDirty {
 		if obj := s.stateObjects[addr]; !obj.deleted {
@@ -916,14 +1092,21 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
 				rawdb.WriteCode(codeWriter, common.BytesToHash(obj.CodeHash()), obj.code)
 				obj.dirtyCode = false
 			}
-			// Write any storage changes in the state object to its storage trie
-			committed, err := obj.CommitTrie(s.db)
-			if err != nil {
-				return common.Hash{}, err
+			// Write any storage changes in the state object to its storage trie,
+			// the tries themselves are committed concurrently afterwards
+			if obj.updateTrie(s.db) == nil {
+				continue
+			}
+			if obj.dbErr != nil {
+				return common.Hash{}, obj.dbErr
 			}
-			storageCommitted += committed
+			committing = append(committing, obj)
 		}
 	}
+	storageCommitted, err := s.commitStorageTries(committing)
+	if err != nil {
+		return common.Hash{}, err
+	}
 	if len(s.stateObjectsDirty) > 0 {
 		s.stateObjectsDirty = make(map[common.Address]struct{})
 	}
@@ -969,8 +1152,9 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
 		if metrics.EnabledExpensive {
 			defer func(start time.Time) { s.SnapshotCommits += time.Since(start) }(time.Now())
 		}
-		// Only update if there's a state transition (skip empty Clique blocks)
-		if parent := s.snap.Root(); parent != root {
+		// Only update if there's a state transition (skip empty Clique blocks).
+		// States served from the archive index have no snapshot tree to update.
+		if parent := s.snap.Root(); s.snaps != nil && parent != root {
 			if err := s.snaps.Update(root, parent, s.snapDestructs, s.snapAccounts, s.snapStorage); err != nil {
 				log.Warn("Failed to update snapshot tree", "from", parent, "to", root, "err", err)
 			}
@@ -1000,6 +1184,7 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
 func (s *StateDB) PrepareAccessList(sender common.Address, dst *common.Address, precompiles []common.Address, list types.AccessList) {
 	// Clear out any leftover from previous executions
 	s.accessList = newAccessList()
+	s.transientStorage = newTransientStorage()
 
 	s.AddAddressToAccessList(sender)
 	if dst != nil {
@@ -31,6 +31,7 @@ import (
 
 	"github.com/mbali/go-mbali/common"
 	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/core/tracing"
 	"github.com/mbali/go-mbali/core/types"
 )
 
@@ -44,7 +45,7 @@ func TestUpdateLeaks(t *testing.T) {
 	// Update it with some accounts
 	for i := byte(0); i < 255; i++ {
 		addr := common.BytesToAddress([]byte{i})
-		state.AddBalance(addr, big.NewInt(int64(11*i)))
+		state.AddBalance(addr, big.NewInt(int64(11*i)), tracing.BalanceChangeUnspecified)
 		state.SetNonce(addr, uint64(42*i))
 		if i%2 == 0 {
 			state.SetState(addr, common.BytesToHash([]byte{i, i, i}), common.BytesToHash([]byte{i, i, i, i}))
@@ -259,7 +260,7 @@ func newTestAction(addr common.Address, r *rand.Rand) testAction {
 		{
 			name: "AddBalance",
 			fn: func(a testAction, s *StateDB) {
-				s.AddBalance(addr, big.NewInt(a.args[0]))
+				s.AddBalance(addr, big.NewInt(a.args[0]), tracing.BalanceChangeUnspecified)
 			},
 			args: make([]int64, 1),
 		},
@@ -477,7 +478,7 @@ func TestTouchDelete(t *testing.T) {
 	s.state, _ = New(root, s.state.db, s.state.snaps)
 
 	snapshot := s.state.Snapshot()
-	s.state.AddBalance(common.Address{}, new(big.Int))
+	s.state.AddBalance(common.Address{}, new(big.Int), tracing.BalanceChangeUnspecified)
 
 	if len(s.state.journal.dirties) != 1 {
 		t.Fatal("expected one dirty state object")
@@ -915,3 +916,49 @@ func TestStateDBAccessList(t *testing.T) {
 		t.Fatalf("expected empty, got %d", got)
 	}
 }
+
+func TestTransientStorage(t *testing.T) {
+	memDb := rawdb.NewMemoryDatabase()
+	db := NewDatabase(memDb)
+	state, _ := New(common.Hash{}, db, nil)
+
+	key := common.Hash{0x01}
+	value := common.Hash{0x02}
+	addr := common.Address{}
+
+	// A reverted write must restore the previous value
+	rev := state.Snapshot()
+	state.SetTransientState(addr, key, value)
+	if got := state.GetTransientState(addr, key); got != value {
+		t.Fatalf("transient storage mismatch: have %x, want %x", got, value)
+	}
+	state.RevertToSnapshot(rev)
+	if got := state.GetTransientState(addr, key); got != (common.Hash{}) {
+		t.Fatalf("transient storage not reverted: have %x", got)
+	}
+	// Copies must be independent of the original
+	state.SetTransientState(addr, key, value)
+	cpy := state.Copy()
+	if got := cpy.GetTransientState(addr, key); got != value {
+		t.Fatalf("copied transient storage mismatch: have %x, want %x", got, value)
+	}
+	cpy.SetTransientState(addr, key, common.Hash{0x03})
+	if got := state.GetTransientState(addr, key); got != value {
+		t.Fatalf("original transient storage modified through copy: have %x", got)
+	}
+	// Transient storage survives the end of the transaction, but not the
+	// start of the next one
+	state.Finalise(true)
+	if got := state.GetTransientState(addr, key); got != value {
+		t.Fatalf("transient storage cleared by Finalise: have %x", got)
+	}
+	state.Prepare(common.Hash{0x04}, 1)
+	if got := state.GetTransientState(addr, key); got != (common.Hash{}) {
+		t.Fatalf("transient storage not cleared at start of transaction: have %x", got)
+	}
+	state.SetTransientState(addr, key, value)
+	state.PrepareAccessList(addr, nil, nil, nil)
+	if got := state.GetTransientState(addr, key); got != (common.Hash{}) {
+		t.Fatalf("transient storage not cleared at start of call: have %x", got)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/mbali/go-mbali/consensus"
 	"github.com/mbali/go-mbali/consensus/misc"
 	"github.com/mbali/go-mbali/core/state"
+	"github.com/mbali/go-mbali/core/tracing"
 	"github.com/mbali/go-mbali/core/types"
 	"github.com/mbali/go-mbali/core/vm"
 	"github.com/mbali/go-mbali/crypto"
@@ -57,6 +58,24 @@ func NewStateProcessor(config *params.ChainConfig, bc *BlockChain, engine consen
 // returns the amount of gas that was used in the process. If any of the
 // transactions failed to execute due to insufficient gas it will return an error.
 func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg vm.Config) (types.Receipts, []*types.Log, uint64, error) {
+	if cfg.Debug {
+		// Hook up the optional state and block tracing extensions of the tracer
+		if hooks, ok := cfg.Tracer.(tracing.StateHooks); ok {
+			statedb.SetLogger(hooks)
+			defer statedb.SetLogger(nil)
+		}
+		if hooks, ok := cfg.Tracer.(tracing.BlockHooks); ok {
+			hooks.OnBlockStart(block)
+			receipts, logs, usedGas, err := p.process(block, statedb, cfg)
+			hooks.OnBlockEnd(err)
+			return receipts, logs, usedGas, err
+		}
+	}
+	return p.process(block, statedb, cfg)
+}
+
+// process executes the transactions of the block and finalizes it, see Process.
+func (p *StateProcessor) process(block *types.Block, statedb *state.StateDB, cfg vm.Config) (types.Receipts, []*types.Log, uint64, error) {
 	var (
 		receipts    types.Receipts
 		usedGas     = new(uint64)
@@ -87,7 +106,7 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 		allLogs = append(allLogs, receipt.Logs...)
 	}
 	// Finalize the block, applying any consensus engine specific extras (e.g. block rewards)
-	p.engine.Finalize(p.bc, header, statedb, block.Transactions(), block.Uncles())
+	p.engine.Finalize(p.bc, header, statedb, block.Transactions(), block.Uncles(), block.Withdrawals())
 
 	return receipts, allLogs, *usedGas, nil
 }
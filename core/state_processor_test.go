@@ -27,9 +27,11 @@ import (
 	"github.com/mbali/go-mbali/consensus/mblash"
 	"github.com/mbali/go-mbali/consensus/misc"
 	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/core/tracing"
 	"github.com/mbali/go-mbali/core/types"
 	"github.com/mbali/go-mbali/core/vm"
 	"github.com/mbali/go-mbali/crypto"
+	"github.com/mbali/go-mbali/mbl/tracers/logger"
 	"github.com/mbali/go-mbali/params"
 	"github.com/mbali/go-mbali/trie"
 	"golang.org/x/crypto/sha3"
@@ -340,3 +342,119 @@ func GenerateBadBlock(parent *types.Block, engine consensus.Engine, txs types.Tr
 	// Assemble and return the final block for sealing
 	return types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil))
 }
+
+// hookRecorder is an EVM logger recording the state and block hook events.
+type hookRecorder struct {
+	vm.EVMLogger
+
+	blocks   []uint64
+	ends     []error
+	balances map[tracing.BalanceChangeReason]int
+	nonces   map[common.Address]uint64
+	codes    map[common.Address][]byte
+	slots    map[common.Hash]common.Hash
+	logs     int
+}
+
+func newHookRecorder() *hookRecorder {
+	return &hookRecorder{
+		EVMLogger: logger.NewStructLogger(nil),
+		balances:  make(map[tracing.BalanceChangeReason]int),
+		nonces:    make(map[common.Address]uint64),
+		codes:     make(map[common.Address][]byte),
+		slots:     make(map[common.Hash]common.Hash),
+	}
+}
+
+func (r *hookRecorder) OnBalanceChange(addr common.Address, prev, new *big.Int, reason tracing.BalanceChangeReason) {
+	r.balances[reason]++
+}
+
+func (r *hookRecorder) OnNonceChange(addr common.Address, prev, new uint64) {
+	r.nonces[addr] = new
+}
+
+func (r *hookRecorder) OnCodeChange(addr common.Address, prevCodeHash common.Hash, prevCode []byte, codeHash common.Hash, code []byte) {
+	r.codes[addr] = code
+}
+
+func (r *hookRecorder) OnStorageChange(addr common.Address, slot common.Hash, prev, new common.Hash) {
+	r.slots[slot] = new
+}
+
+func (r *hookRecorder) OnLog(log *types.Log) { r.logs++ }
+
+func (r *hookRecorder) OnBlockStart(block *types.Block) {
+	r.blocks = append(r.blocks, block.NumberU64())
+}
+
+func (r *hookRecorder) OnBlockEnd(err error) { r.ends = append(r.ends, err) }
+
+// TestStateProcessorTracingHooks tests that the state processor reports the
+// block boundaries and the state changes to tracers implementing the hooks.
+func TestStateProcessorTracingHooks(t *testing.T) {
+	var (
+		config = params.TestChainConfig
+		signer = types.LatestSigner(config)
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		sender = crypto.PubkeyToAddress(key.PublicKey)
+		to     = common.HexToAddress("0xdeadbeef")
+		db     = rawdb.NewMemoryDatabase()
+		gspec  = &Genesis{
+			Config: config,
+			Alloc:  GenesisAlloc{sender: {Balance: big.NewInt(1000000000000000000)}},
+		}
+		genesis = gspec.MustCommit(db)
+		engine  = mblash.NewFaker()
+	)
+	// Init code storing 1 at slot 0, emitting an empty log and deploying a
+	// single STOP opcode
+	initcode := common.FromHex("600160005560006000a0600060005360016000f3")
+	blocks, _ := GenerateChain(config, genesis, engine, db, 1, func(i int, gen *BlockGen) {
+		price := new(big.Int).Add(gen.header.BaseFee, common.Big1)
+		tx, _ := types.SignTx(types.NewTransaction(0, to, big.NewInt(1000), params.TxGas, price, nil), signer, key)
+		gen.AddTx(tx)
+		tx, _ = types.SignTx(types.NewContractCreation(1, new(big.Int), 200000, price, initcode), signer, key)
+		gen.AddTx(tx)
+	})
+	recorder := newHookRecorder()
+	chain, err := NewBlockChain(db, nil, config, engine, vm.Config{Debug: true, Tracer: recorder}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	if len(recorder.blocks) != 1 || recorder.blocks[0] != 1 {
+		t.Fatalf("block start events mismatch: have %v, want [1]", recorder.blocks)
+	}
+	if len(recorder.ends) != 1 || recorder.ends[0] != nil {
+		t.Fatalf("block end events mismatch: have %v, want [<nil>]", recorder.ends)
+	}
+	for reason, want := range map[tracing.BalanceChangeReason]int{
+		tracing.BalanceDecreaseGasBuy:               2,
+		tracing.BalanceChangeTransfer:               2,
+		tracing.BalanceIncreaseGasReturn:            1,
+		tracing.BalanceIncreaseRewardTransactionFee: 2,
+		tracing.BalanceIncreaseRewardMineBlock:      1,
+	} {
+		if have := recorder.balances[reason]; have != want {
+			t.Errorf("balance change %v count mismatch: have %d, want %d", reason, have, want)
+		}
+	}
+	if have := recorder.nonces[sender]; have != 2 {
+		t.Errorf("sender nonce mismatch: have %d, want 2", have)
+	}
+	contract := crypto.CreateAddress(sender, 1)
+	if have := recorder.codes[contract]; len(have) != 1 || have[0] != 0 {
+		t.Errorf("deployed code mismatch: have %x, want 00", have)
+	}
+	if have := recorder.slots[common.Hash{}]; have != common.BigToHash(common.Big1) {
+		t.Errorf("storage change mismatch: have %x, want 1", have)
+	}
+	if recorder.logs != 1 {
+		t.Errorf("log count mismatch: have %d, want 1", recorder.logs)
+	}
+}
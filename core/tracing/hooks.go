@@ -0,0 +1,150 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package tracing defines the optional hooks through which tracers can observe
+// state changes made outside of the EVM interpreter loop.
+//
+// The hooks are extensions of vm.EVMLogger: a tracer opts into them simply by
+// implementing the interfaces below, existing tracers keep working unchanged.
+// State hooks are invoked at the time a change is made. Changes that are later
+// reverted are not reported a second time, tracers needing the final state of
+// a call frame must combine the hooks with the call frame errors.
+package tracing
+
+import (
+	"math/big"
+
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/core/types"
+)
+
+// BalanceChangeReason is used to indicate the reason for a balance change, useful
+// for tracing and reporting.
+type BalanceChangeReason byte
+
+const (
+	BalanceChangeUnspecified BalanceChangeReason = 0
+
+	// Issuance
+	// BalanceIncreaseRewardMineUncle is a reward for mining an uncle block.
+	BalanceIncreaseRewardMineUncle BalanceChangeReason = 1
+	// BalanceIncreaseRewardMineBlock is a reward for mining a block.
+	BalanceIncreaseRewardMineBlock BalanceChangeReason = 2
+	// BalanceIncreaseWithdrawal is mbler withdrawn from the beacon chain.
+	BalanceIncreaseWithdrawal BalanceChangeReason = 3
+	// BalanceIncreaseGenesisBalance is mbler allocated at the genesis block.
+	BalanceIncreaseGenesisBalance BalanceChangeReason = 4
+
+	// Transaction fees
+	// BalanceIncreaseRewardTransactionFee is the transaction tip increasing block builder's balance.
+	BalanceIncreaseRewardTransactionFee BalanceChangeReason = 5
+	// BalanceDecreaseGasBuy is spent to purchase gas for execution a transaction.
+	// Part of this gas will be burnt as per EIP-1559 rules.
+	BalanceDecreaseGasBuy BalanceChangeReason = 6
+	// BalanceIncreaseGasReturn is mbler returned for unused gas at the end of execution.
+	BalanceIncreaseGasReturn BalanceChangeReason = 7
+
+	// DAO fork
+	// BalanceIncreaseDaoContract is mbler sent to the DAO refund contract.
+	BalanceIncreaseDaoContract BalanceChangeReason = 8
+	// BalanceDecreaseDaoAccount is mbler taken from a DAO account to be moved to the refund contract.
+	BalanceDecreaseDaoAccount BalanceChangeReason = 9
+
+	// BalanceChangeTransfer is mbler transferred via a call.
+	// it is a decrease for the sender and an increase for the recipient.
+	BalanceChangeTransfer BalanceChangeReason = 10
+	// BalanceChangeTouchAccount is a transfer of zero value. It is only there to
+	// touch-create an account.
+	BalanceChangeTouchAccount BalanceChangeReason = 11
+
+	// BalanceIncreaseSelfdestruct is added to the recipient as indicated by a selfdestructing account.
+	BalanceIncreaseSelfdestruct BalanceChangeReason = 12
+	// BalanceDecreaseSelfdestruct is deducted from a contract due to self-destruct.
+	BalanceDecreaseSelfdestruct BalanceChangeReason = 13
+)
+
+// String implements the fmt.Stringer interface.
+func (r BalanceChangeReason) String() string {
+	switch r {
+	case BalanceIncreaseRewardMineUncle:
+		return "RewardMineUncle"
+	case BalanceIncreaseRewardMineBlock:
+		return "RewardMineBlock"
+	case BalanceIncreaseWithdrawal:
+		return "Withdrawal"
+	case BalanceIncreaseGenesisBalance:
+		return "GenesisBalance"
+	case BalanceIncreaseRewardTransactionFee:
+		return "RewardTransactionFee"
+	case BalanceDecreaseGasBuy:
+		return "GasBuy"
+	case BalanceIncreaseGasReturn:
+		return "GasReturn"
+	case BalanceIncreaseDaoContract:
+		return "DaoContract"
+	case BalanceDecreaseDaoAccount:
+		return "DaoAccount"
+	case BalanceChangeTransfer:
+		return "Transfer"
+	case BalanceChangeTouchAccount:
+		return "TouchAccount"
+	case BalanceIncreaseSelfdestruct:
+		return "IncreaseSelfdestruct"
+	case BalanceDecreaseSelfdestruct:
+		return "DecreaseSelfdestruct"
+	default:
+		return "Unspecified"
+	}
+}
+
+// StateHooks is implemented by tracers interested in the state changes made
+// through the StateDB, including those made outside of the interpreter such as
+// gas purchases and refunds, block rewards and withdrawals.
+type StateHooks interface {
+	// OnBalanceChange is called when the balance of an account changes.
+	OnBalanceChange(addr common.Address, prev, new *big.Int, reason BalanceChangeReason)
+
+	// OnNonceChange is called when the nonce of an account changes.
+	OnNonceChange(addr common.Address, prev, new uint64)
+
+	// OnCodeChange is called when the code of an account changes.
+	OnCodeChange(addr common.Address, prevCodeHash common.Hash, prevCode []byte, codeHash common.Hash, code []byte)
+
+	// OnStorageChange is called when a storage slot of an account changes.
+	OnStorageChange(addr common.Address, slot common.Hash, prev, new common.Hash)
+
+	// OnLog is called when a log is emitted.
+	OnLog(log *types.Log)
+}
+
+// BlockHooks is implemented by tracers interested in the boundaries of the
+// blocks processed by the state processor.
+type BlockHooks interface {
+	// OnBlockStart is called before the first transaction of a block is executed.
+	OnBlockStart(block *types.Block)
+
+	// OnBlockEnd is called after a block has been processed, with the error
+	// aborting the processing, if any.
+	OnBlockEnd(err error)
+}
+
+// ChainHooks is implemented by tracers attached to the block chain, interested
+// in the blocks they already traced leaving the canonical chain.
+type ChainHooks interface {
+	// OnReorg is called after a reorg with the blocks dropped from the
+	// canonical chain, ordered from the old head backwards.
+	OnReorg(dropped []*types.Block)
+}
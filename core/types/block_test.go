@@ -194,6 +194,61 @@ func TestEIP2718BlockEncoding(t *testing.T) {
 	}
 }
 
+// Tests that blocks carrying withdrawals are correctly encoded and decoded, and
+// that the withdrawals are committed to in the header.
+func TestWithdrawalBlockEncoding(t *testing.T) {
+	header := &Header{
+		Difficulty: common.Big0,
+		Number:     big.NewInt(1),
+		GasLimit:   30000000,
+		Time:       1681338455,
+		BaseFee:    big.NewInt(params.InitialBaseFee),
+	}
+	withdrawals := []*Withdrawal{
+		{Index: 0, Validator: 1, Address: common.Address{0x01}, Amount: 32000000000},
+		{Index: 1, Validator: 2, Address: common.Address{0x02}, Amount: 1},
+	}
+	block := NewBlockWithWithdrawals(header, nil, nil, nil, withdrawals, newHasher())
+	if block.Header().WithdrawalsHash == nil {
+		t.Fatal("withdrawals hash missing from header")
+	}
+	if have, want := *block.Header().WithdrawalsHash, DeriveSha(Withdrawals(withdrawals), newHasher()); have != want {
+		t.Fatalf("withdrawals hash mismatch: have %x, want %x", have, want)
+	}
+	if block.Header().EmptyBody() {
+		t.Fatal("block with withdrawals reported empty body")
+	}
+	enc, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		t.Fatal("encode error: ", err)
+	}
+	var dec Block
+	if err := rlp.DecodeBytes(enc, &dec); err != nil {
+		t.Fatal("decode error: ", err)
+	}
+	if dec.Hash() != block.Hash() {
+		t.Errorf("block hash mismatch: have %x, want %x", dec.Hash(), block.Hash())
+	}
+	if !reflect.DeepEqual(dec.Withdrawals(), block.Withdrawals()) {
+		t.Errorf("withdrawals mismatch: have %v, want %v", dec.Withdrawals(), block.Withdrawals())
+	}
+	// Ensure an empty withdrawal list is retained through encoding
+	empty := NewBlockWithWithdrawals(header, nil, nil, nil, []*Withdrawal{}, newHasher())
+	if hash := empty.Header().WithdrawalsHash; hash == nil || *hash != EmptyRootHash {
+		t.Fatalf("empty withdrawals hash mismatch: have %v, want %x", hash, EmptyRootHash)
+	}
+	if !empty.Header().EmptyBody() {
+		t.Fatal("block with empty withdrawals reported non-empty body")
+	}
+	enc, _ = rlp.EncodeToBytes(empty)
+	if err := rlp.DecodeBytes(enc, &dec); err != nil {
+		t.Fatal("decode error: ", err)
+	}
+	if dec.Withdrawals() == nil || len(dec.Withdrawals()) != 0 {
+		t.Errorf("empty withdrawals not retained: have %v", dec.Withdrawals())
+	}
+}
+
 func TestUncleHash(t *testing.T) {
 	uncles := make([]*Header, 0)
 	h := CalcUncleHash(uncles)
@@ -58,6 +58,10 @@ type Contract struct {
 	CodeAddr *common.Address
 	Input    []byte
 
+	Container   *Container       // Decoded EOF container, nil for legacy code
+	CodeSection uint64           // EOF code section being executed
+	returnStack []*ReturnContext // EOF function return stack
+
 	Gas   uint64
 	value *big.Int
 }
@@ -141,15 +145,25 @@ func (c *Contract) AsDelegate() *Contract {
 	return c
 }
 
-// GetOp returns the n'th element in the contract's byte array
+// GetOp returns the n'th element in the contract's byte array, or in the code
+// section being executed for EOF contracts.
 func (c *Contract) GetOp(n uint64) OpCode {
-	if n < uint64(len(c.Code)) {
-		return OpCode(c.Code[n])
+	if code := c.sectionCode(); n < uint64(len(code)) {
+		return OpCode(code[n])
 	}
 
 	return STOP
 }
 
+// sectionCode returns the code being executed: the whole code of a legacy
+// contract, or the current code section of an EOF contract.
+func (c *Contract) sectionCode() []byte {
+	if c.Container != nil {
+		return c.Container.Code[c.CodeSection]
+	}
+	return c.Code
+}
+
 // Caller returns the caller of the contract.
 //
 // Caller will recursively call caller when the contract is a delegate
@@ -21,6 +21,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"math/big"
+	"math/bits"
 
 	"github.com/mbali/go-mbali/common"
 	"github.com/mbali/go-mbali/common/math"
@@ -93,7 +94,8 @@ var PrecompiledContractsBerlin = map[common.Address]PrecompiledContract{
 }
 
 // PrecompiledContractsBLS contains the set of pre-compiled mbali
-// contracts specified in EIP-2537. These are exported for testing purposes.
+// contracts specified in EIP-2537. They are activated on top of the fork
+// specific set by the BLS12381Block chain config field.
 var PrecompiledContractsBLS = map[common.Address]PrecompiledContract{
 	common.BytesToAddress([]byte{10}): &bls12381G1Add{},
 	common.BytesToAddress([]byte{11}): &bls12381G1Mul{},
@@ -111,6 +113,7 @@ var (
 	PrecompiledAddressesIstanbul  []common.Address
 	PrecompiledAddressesByzantium []common.Address
 	PrecompiledAddressesHomestead []common.Address
+	PrecompiledAddressesBLS       []common.Address
 )
 
 func init() {
@@ -126,20 +129,34 @@ func init() {
 	for k := range PrecompiledContractsBerlin {
 		PrecompiledAddressesBerlin = append(PrecompiledAddressesBerlin, k)
 	}
+	for k := range PrecompiledContractsBLS {
+		PrecompiledAddressesBLS = append(PrecompiledAddressesBLS, k)
+	}
 }
 
 // ActivePrecompiles returns the precompiles enabled with the current configuration.
 func ActivePrecompiles(rules params.Rules) []common.Address {
+	var addrs []common.Address
 	switch {
 	case rules.IsBerlin:
-		return PrecompiledAddressesBerlin
+		addrs = PrecompiledAddressesBerlin
 	case rules.IsIstanbul:
-		return PrecompiledAddressesIstanbul
+		addrs = PrecompiledAddressesIstanbul
 	case rules.IsByzantium:
-		return PrecompiledAddressesByzantium
+		addrs = PrecompiledAddressesByzantium
 	default:
-		return PrecompiledAddressesHomestead
+		addrs = PrecompiledAddressesHomestead
+	}
+	if rules.IsBLS12381 || rules.CustomPrecompiles != 0 {
+		// Copy the fork set, the shared address lists must not be extended
+		active := make([]common.Address, 0, len(addrs)+len(PrecompiledAddressesBLS)+bits.OnesCount64(rules.CustomPrecompiles))
+		active = append(active, addrs...)
+		if rules.IsBLS12381 {
+			active = append(active, PrecompiledAddressesBLS...)
+		}
+		addrs = appendActiveCustomPrecompiles(active, rules)
 	}
+	return addrs
 }
 
 // RunPrecompiledContract runs and evaluates the output of a precompiled contract.
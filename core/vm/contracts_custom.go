@@ -0,0 +1,181 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/params"
+)
+
+var (
+	errPrecompileNil        = errors.New("nil precompiled contract")
+	errPrecompileNoName     = errors.New("empty precompiled contract name")
+	errPrecompileNoBlock    = errors.New("nil precompiled contract activation block")
+	errPrecompileReserved   = errors.New("address reserved by a built-in precompiled contract")
+	errPrecompileRegistered = errors.New("address already registered")
+	errPrecompileLimit      = fmt.Errorf("too many precompiled contracts, limit is %d", params.MaxCustomPrecompiles)
+)
+
+// customPrecompile is an application defined precompiled contract along with
+// the schedule it was registered with.
+type customPrecompile struct {
+	name     string
+	block    *big.Int
+	contract PrecompiledContract
+	index    uint // position in ascending address order, see params.Rules
+}
+
+var (
+	customPrecompiles     = make(map[common.Address]*customPrecompile)
+	customPrecompileAddrs []common.Address // registered addresses in ascending order
+	customPrecompilesLock sync.RWMutex
+)
+
+// RegisterPrecompile registers an application defined precompiled contract at
+// the given address, active from the given block on. Registration must happen
+// before the chain is opened, and the same set of precompiles must be recorded
+// in the chain config (see RegisteredPrecompiles), otherwise the node refuses
+// to start.
+func RegisterPrecompile(addr common.Address, name string, block *big.Int, p PrecompiledContract) error {
+	switch {
+	case p == nil:
+		return errPrecompileNil
+	case name == "":
+		return errPrecompileNoName
+	case block == nil:
+		return errPrecompileNoBlock
+	}
+	if isBuiltinPrecompile(addr) {
+		return fmt.Errorf("%w: %x", errPrecompileReserved, addr)
+	}
+	customPrecompilesLock.Lock()
+	defer customPrecompilesLock.Unlock()
+
+	if prev, ok := customPrecompiles[addr]; ok {
+		return fmt.Errorf("%w: %x (%s)", errPrecompileRegistered, addr, prev.name)
+	}
+	if len(customPrecompiles) >= params.MaxCustomPrecompiles {
+		return errPrecompileLimit
+	}
+	customPrecompiles[addr] = &customPrecompile{
+		name:     name,
+		block:    new(big.Int).Set(block),
+		contract: p,
+	}
+	customPrecompileAddrs = append(customPrecompileAddrs, addr)
+	sort.Slice(customPrecompileAddrs, func(i, j int) bool {
+		return bytes.Compare(customPrecompileAddrs[i][:], customPrecompileAddrs[j][:]) < 0
+	})
+	for i, a := range customPrecompileAddrs {
+		customPrecompiles[a].index = uint(i)
+	}
+	return nil
+}
+
+// RegisteredPrecompiles returns the chain config records of all application
+// defined precompiles, suitable for embedding into a genesis chain config.
+func RegisteredPrecompiles() map[common.Address]*params.CustomPrecompile {
+	customPrecompilesLock.RLock()
+	defer customPrecompilesLock.RUnlock()
+
+	if len(customPrecompiles) == 0 {
+		return nil
+	}
+	records := make(map[common.Address]*params.CustomPrecompile, len(customPrecompiles))
+	for addr, p := range customPrecompiles {
+		records[addr] = &params.CustomPrecompile{Name: p.name, Block: new(big.Int).Set(p.block)}
+	}
+	return records
+}
+
+// ValidatePrecompiles checks that the application defined precompiles recorded
+// in the chain config match the ones registered in this binary exactly.
+func ValidatePrecompiles(config *params.ChainConfig) error {
+	customPrecompilesLock.RLock()
+	defer customPrecompilesLock.RUnlock()
+
+	for addr, record := range config.CustomPrecompiles {
+		p, ok := customPrecompiles[addr]
+		if !ok {
+			return fmt.Errorf("custom precompile %q at %x not registered", record.Name, addr)
+		}
+		if p.name != record.Name {
+			return fmt.Errorf("custom precompile at %x mismatch: registered %q, chain config %q", addr, p.name, record.Name)
+		}
+		if record.Block == nil || p.block.Cmp(record.Block) != 0 {
+			return fmt.Errorf("custom precompile %q at %x activation mismatch: registered %v, chain config %v", p.name, addr, p.block, record.Block)
+		}
+	}
+	for addr, p := range customPrecompiles {
+		if _, ok := config.CustomPrecompiles[addr]; !ok {
+			return fmt.Errorf("custom precompile %q at %x missing from chain config", p.name, addr)
+		}
+	}
+	return nil
+}
+
+// activeCustomPrecompile returns the application defined precompile at the
+// given address if it is activated by the rules.
+func activeCustomPrecompile(rules params.Rules, addr common.Address) (PrecompiledContract, bool) {
+	if rules.CustomPrecompiles == 0 {
+		return nil, false
+	}
+	customPrecompilesLock.RLock()
+	defer customPrecompilesLock.RUnlock()
+
+	if p, ok := customPrecompiles[addr]; ok && rules.CustomPrecompiles&(1<<p.index) != 0 {
+		return p.contract, true
+	}
+	return nil, false
+}
+
+// appendActiveCustomPrecompiles appends the addresses of the application defined
+// precompiles activated by the rules. The set in the rules refers to the chain
+// config records, which match the registered precompiles (see ValidatePrecompiles).
+func appendActiveCustomPrecompiles(addrs []common.Address, rules params.Rules) []common.Address {
+	customPrecompilesLock.RLock()
+	defer customPrecompilesLock.RUnlock()
+
+	for i, addr := range customPrecompileAddrs {
+		if rules.CustomPrecompiles&(1<<uint(i)) != 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// isBuiltinPrecompile reports whmbler the address is used by a precompile of
+// any fork.
+func isBuiltinPrecompile(addr common.Address) bool {
+	for _, set := range []map[common.Address]PrecompiledContract{
+		PrecompiledContractsHomestead, PrecompiledContractsByzantium,
+		PrecompiledContractsIstanbul, PrecompiledContractsBerlin,
+		PrecompiledContractsBLS,
+	} {
+		if _, ok := set[addr]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/core/state"
+	"github.com/mbali/go-mbali/params"
+)
+
+// reverser is a test precompile returning its input reversed.
+type reverser struct{}
+
+func (reverser) RequiredGas(input []byte) uint64 { return uint64(len(input)) }
+
+func (reverser) Run(input []byte) ([]byte, error) {
+	out := make([]byte, len(input))
+	for i, b := range input {
+		out[len(input)-1-i] = b
+	}
+	return out, nil
+}
+
+// resetCustomPrecompiles drops all registered precompiles after a test.
+func resetCustomPrecompiles(t *testing.T) {
+	t.Cleanup(func() {
+		customPrecompilesLock.Lock()
+		customPrecompiles = make(map[common.Address]*customPrecompile)
+		customPrecompileAddrs = nil
+		customPrecompilesLock.Unlock()
+	})
+}
+
+func TestRegisterPrecompile(t *testing.T) {
+	resetCustomPrecompiles(t)
+
+	addr := common.HexToAddress("0x0100")
+	if err := RegisterPrecompile(common.BytesToAddress([]byte{1}), "ecrecover2", big.NewInt(0), reverser{}); !errors.Is(err, errPrecompileReserved) {
+		t.Fatalf("built-in address registration error mismatch: have %v, want %v", err, errPrecompileReserved)
+	}
+	if err := RegisterPrecompile(common.BytesToAddress([]byte{10}), "bls", big.NewInt(0), reverser{}); !errors.Is(err, errPrecompileReserved) {
+		t.Fatalf("BLS address registration error mismatch: have %v, want %v", err, errPrecompileReserved)
+	}
+	if err := RegisterPrecompile(addr, "reverser", nil, reverser{}); err != errPrecompileNoBlock {
+		t.Fatalf("nil block registration error mismatch: have %v, want %v", err, errPrecompileNoBlock)
+	}
+	if err := RegisterPrecompile(addr, "reverser", big.NewInt(5), reverser{}); err != nil {
+		t.Fatalf("failed to register precompile: %v", err)
+	}
+	if err := RegisterPrecompile(addr, "other", big.NewInt(5), reverser{}); !errors.Is(err, errPrecompileRegistered) {
+		t.Fatalf("duplicate registration error mismatch: have %v, want %v", err, errPrecompileRegistered)
+	}
+	// The chain config must record exactly the registered set
+	config := *params.TestChainConfig
+	if err := ValidatePrecompiles(&config); err == nil {
+		t.Fatal("missing chain config record accepted")
+	}
+	config.CustomPrecompiles = map[common.Address]*params.CustomPrecompile{
+		addr: {Name: "reverser", Block: big.NewInt(6)},
+	}
+	if err := ValidatePrecompiles(&config); err == nil {
+		t.Fatal("mismatching activation block accepted")
+	}
+	config.CustomPrecompiles[addr] = &params.CustomPrecompile{Name: "other", Block: big.NewInt(5)}
+	if err := ValidatePrecompiles(&config); err == nil {
+		t.Fatal("mismatching name accepted")
+	}
+	config.CustomPrecompiles = RegisteredPrecompiles()
+	if err := ValidatePrecompiles(&config); err != nil {
+		t.Fatalf("registered set rejected: %v", err)
+	}
+	config.CustomPrecompiles[common.HexToAddress("0x0101")] = &params.CustomPrecompile{Name: "unknown", Block: big.NewInt(0)}
+	if err := ValidatePrecompiles(&config); err == nil {
+		t.Fatal("unregistered chain config record accepted")
+	}
+}
+
+func TestCustomPrecompileActivation(t *testing.T) {
+	resetCustomPrecompiles(t)
+
+	addr := common.HexToAddress("0x0100")
+	if err := RegisterPrecompile(addr, "reverser", big.NewInt(5), reverser{}); err != nil {
+		t.Fatalf("failed to register precompile: %v", err)
+	}
+	config := *params.TestChainConfig
+	config.CustomPrecompiles = RegisteredPrecompiles()
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	newEVM := func(number int64) *EVM {
+		blockCtx := BlockContext{
+			CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+			Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+			BlockNumber: big.NewInt(number),
+			Time:        new(big.Int),
+		}
+		return NewEVM(blockCtx, TxContext{}, statedb, &config, Config{})
+	}
+	pre := newEVM(4)
+	if _, ok := pre.precompile(addr); ok {
+		t.Fatal("custom precompile active before activation block")
+	}
+	for _, a := range ActivePrecompiles(pre.ChainRules()) {
+		if a == addr {
+			t.Fatal("custom precompile listed before activation block")
+		}
+	}
+	post := newEVM(5)
+	var listed bool
+	for _, a := range ActivePrecompiles(post.ChainRules()) {
+		listed = listed || a == addr
+	}
+	if !listed {
+		t.Fatal("custom precompile not listed after activation block")
+	}
+	if got := len(PrecompiledAddressesBerlin); got != len(PrecompiledContractsBerlin) {
+		t.Fatalf("shared precompile address list modified: have %d entries", got)
+	}
+	ret, leftOver, err := post.Call(AccountRef(common.Address{}), addr, []byte{1, 2, 3}, 10, new(big.Int))
+	if err != nil {
+		t.Fatalf("custom precompile call failed: %v", err)
+	}
+	if !bytes.Equal(ret, []byte{3, 2, 1}) {
+		t.Errorf("custom precompile output mismatch: have %x, want 030201", ret)
+	}
+	if leftOver != 7 {
+		t.Errorf("custom precompile gas mismatch: have %d left, want 7", leftOver)
+	}
+}
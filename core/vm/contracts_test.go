@@ -20,11 +20,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/core/state"
+	"github.com/mbali/go-mbali/params"
 )
 
 // precompiledTest defines the input/output pairs for precompiled contract tests.
@@ -391,3 +395,59 @@ func BenchmarkPrecompiledBLS12381G2MultiExpWorstCase(b *testing.B) {
 	}
 	benchmarkPrecompiled("0f", testcase, b)
 }
+
+// Tests that the BLS12-381 precompiles are only reachable through the EVM once
+// scheduled by the chain config, and that the standard vectors pass through it.
+func TestPrecompiledBLS12381Activation(t *testing.T) {
+	config := *params.TestChainConfig
+	config.BLS12381Block = big.NewInt(1)
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	newEVM := func(number int64) *EVM {
+		blockCtx := BlockContext{
+			CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+			Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+			BlockNumber: big.NewInt(number),
+			Time:        new(big.Int),
+		}
+		return NewEVM(blockCtx, TxContext{}, statedb, &config, Config{})
+	}
+	// Before the fork block, none of the BLS addresses should be precompiles
+	pre := newEVM(0)
+	for addr := range PrecompiledContractsBLS {
+		if _, ok := pre.precompile(addr); ok {
+			t.Errorf("precompile %x active before activation block", addr)
+		}
+	}
+	if have, want := len(ActivePrecompiles(pre.ChainRules())), len(PrecompiledAddressesBerlin); have != want {
+		t.Errorf("active precompile count mismatch before activation: have %d, want %d", have, want)
+	}
+	// From the fork block on, the precompiles must run the standard vectors
+	post := newEVM(1)
+	if have, want := len(ActivePrecompiles(post.ChainRules())), len(PrecompiledAddressesBerlin)+len(PrecompiledAddressesBLS); have != want {
+		t.Errorf("active precompile count mismatch after activation: have %d, want %d", have, want)
+	}
+	for name, addr := range map[string]string{
+		"blsG1Add": "0a", "blsG1Mul": "0b", "blsG1MultiExp": "0c",
+		"blsG2Add": "0d", "blsG2Mul": "0e", "blsG2MultiExp": "0f",
+		"blsPairing": "10", "blsMapG1": "11", "blsMapG2": "12",
+	} {
+		tests, err := loadJson(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range tests {
+			ret, leftOver, err := post.Call(AccountRef(common.Address{}), common.HexToAddress(addr), common.Hex2Bytes(test.Input), test.Gas, new(big.Int))
+			if err != nil {
+				t.Errorf("%s: call failed: %v", test.Name, err)
+				continue
+			}
+			if common.Bytes2Hex(ret) != test.Expected {
+				t.Errorf("%s: output mismatch: have %x, want %v", test.Name, ret, test.Expected)
+			}
+			if leftOver != 0 {
+				t.Errorf("%s: gas mismatch: %d gas left over", test.Name, leftOver)
+			}
+		}
+	}
+}
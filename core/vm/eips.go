@@ -17,20 +17,25 @@
 package vm
 
 import (
+	"encoding/binary"
 	"fmt"
 	"sort"
 
+	"github.com/mbali/go-mbali/common"
 	"github.com/mbali/go-mbali/params"
 	"github.com/holiman/uint256"
 )
 
 var activators = map[int]func(*JumpTable){
+	3540: enableEOF,
+	5656: enable5656,
 	3855: enable3855,
 	3529: enable3529,
 	3198: enable3198,
 	2929: enable2929,
 	2200: enable2200,
 	1884: enable1884,
+	1153: enable1153,
 	1344: enable1344,
 }
 
@@ -192,3 +197,181 @@ func opPush0(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]by
 	scope.Stack.push(new(uint256.Int))
 	return nil, nil
 }
+
+// enable1153 applies EIP-1153 "Transient Storage"
+// - Adds TLOAD that reads from transient storage
+// - Adds TSTORE that writes to transient storage
+func enable1153(jt *JumpTable) {
+	jt[TLOAD] = &operation{
+		execute:     opTload,
+		constantGas: params.WarmStorageReadCostEIP2929,
+		minStack:    minStack(1, 1),
+		maxStack:    maxStack(1, 1),
+	}
+
+	jt[TSTORE] = &operation{
+		execute:     opTstore,
+		constantGas: params.WarmStorageReadCostEIP2929,
+		minStack:    minStack(2, 0),
+		maxStack:    maxStack(2, 0),
+	}
+}
+
+// opTload implements TLOAD opcode
+func opTload(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	loc := scope.Stack.peek()
+	hash := common.Hash(loc.Bytes32())
+	val := interpreter.evm.StateDB.GetTransientState(scope.Contract.Address(), hash)
+	loc.SetBytes(val.Bytes())
+	return nil, nil
+}
+
+// opTstore implements TSTORE opcode
+func opTstore(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	if interpreter.readOnly {
+		return nil, ErrWriteProtection
+	}
+	loc := scope.Stack.pop()
+	val := scope.Stack.pop()
+	interpreter.evm.StateDB.SetTransientState(scope.Contract.Address(), loc.Bytes32(), val.Bytes32())
+	return nil, nil
+}
+
+// enable5656 applies EIP-5656 (MCOPY opcode)
+func enable5656(jt *JumpTable) {
+	jt[MCOPY] = &operation{
+		execute:     opMcopy,
+		constantGas: GasFastestStep,
+		dynamicGas:  gasMcopy,
+		minStack:    minStack(3, 0),
+		maxStack:    maxStack(3, 0),
+		memorySize:  memoryMcopy,
+	}
+}
+
+// opMcopy implements the MCOPY opcode
+func opMcopy(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	var (
+		dst    = scope.Stack.pop()
+		src    = scope.Stack.pop()
+		length = scope.Stack.pop()
+	)
+	// These values are checked for overflow during memory expansion calculation
+	// (the memorySize function on the opcode).
+	scope.Memory.Copy(dst.Uint64(), src.Uint64(), length.Uint64())
+	return nil, nil
+}
+
+// enableEOF applies the EOF v1 suite (experimental):
+// - EIP-3540: EOF container format, validated at contract creation
+// - EIP-3670: EOF code validation
+// - EIP-4200: Static relative jumps (RJUMP, RJUMPI)
+// - EIP-4750: Functions (CALLF, RETF)
+// - EIP-5450: EOF stack validation
+func enableEOF(jt *JumpTable) {
+	jt[RJUMP] = &operation{
+		execute:     opRjump,
+		constantGas: params.RjumpGas,
+		minStack:    minStack(0, 0),
+		maxStack:    maxStack(0, 0),
+	}
+	jt[RJUMPI] = &operation{
+		execute:     opRjumpi,
+		constantGas: params.RjumpiGas,
+		minStack:    minStack(1, 0),
+		maxStack:    maxStack(1, 0),
+	}
+	jt[CALLF] = &operation{
+		execute:     opCallf,
+		constantGas: params.CallfGas,
+		minStack:    minStack(0, 0),
+		maxStack:    maxStack(0, 0),
+	}
+	jt[RETF] = &operation{
+		execute:     opRetf,
+		constantGas: params.RetfGas,
+		minStack:    minStack(0, 0),
+		maxStack:    maxStack(0, 0),
+	}
+}
+
+// opRjump implements the RJUMP opcode
+func opRjump(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	if scope.Contract.Container == nil {
+		return nil, &ErrInvalidOpCode{opcode: RJUMP}
+	}
+	// Code validation guarantees the immediate and the destination are within
+	// the code section, check them anyway to never run off the section.
+	code := scope.Contract.sectionCode()
+	if *pc+3 > uint64(len(code)) {
+		return nil, &ErrInvalidOpCode{opcode: RJUMP}
+	}
+	offset := int16(binary.BigEndian.Uint16(code[*pc+1:]))
+	dest := int64(*pc) + 3 + int64(offset)
+	if dest < 0 || dest >= int64(len(code)) {
+		return nil, &ErrInvalidOpCode{opcode: RJUMP}
+	}
+	*pc = uint64(dest - 1) // pc will be increased by the interpreter loop
+	return nil, nil
+}
+
+// opRjumpi implements the RJUMPI opcode
+func opRjumpi(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	if scope.Contract.Container == nil {
+		return nil, &ErrInvalidOpCode{opcode: RJUMPI}
+	}
+	if cond := scope.Stack.pop(); cond.IsZero() {
+		*pc += 2 // Skip the immediate
+		return nil, nil
+	}
+	return opRjump(pc, interpreter, scope)
+}
+
+// opCallf implements the CALLF opcode
+func opCallf(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	container := scope.Contract.Container
+	if container == nil {
+		return nil, &ErrInvalidOpCode{opcode: CALLF}
+	}
+	code := scope.Contract.sectionCode()
+	if *pc+3 > uint64(len(code)) {
+		return nil, &ErrInvalidOpCode{opcode: CALLF}
+	}
+	section := binary.BigEndian.Uint16(code[*pc+1:])
+	if int(section) >= len(container.Types) || int(section) >= len(container.Code) {
+		return nil, &ErrInvalidOpCode{opcode: CALLF}
+	}
+	typ := container.Types[section]
+	if sLen, limit := scope.Stack.len(), int(params.StackLimit)-int(typ.MaxStackHeight)+int(typ.Input); sLen > limit {
+		return nil, &ErrStackOverflow{stackLen: sLen, limit: limit}
+	}
+	if len(scope.Contract.returnStack) >= maxReturnStackHeight {
+		return nil, ErrReturnStackExceeded
+	}
+	scope.Contract.returnStack = append(scope.Contract.returnStack, &ReturnContext{
+		Section: scope.Contract.CodeSection,
+		Pc:      *pc + 3,
+	})
+	scope.Contract.CodeSection = uint64(section)
+	*pc = ^uint64(0) // pc will be increased by the interpreter loop
+	return nil, nil
+}
+
+// opRetf implements the RETF opcode
+func opRetf(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	if scope.Contract.Container == nil {
+		return nil, &ErrInvalidOpCode{opcode: RETF}
+	}
+	// Code validation rejects RETF in section 0, the return stack is never
+	// empty here. Check it anyway.
+	if len(scope.Contract.returnStack) == 0 {
+		return nil, &ErrInvalidOpCode{opcode: RETF}
+	}
+	last := len(scope.Contract.returnStack) - 1
+	ret := scope.Contract.returnStack[last]
+	scope.Contract.returnStack = scope.Contract.returnStack[:last]
+
+	scope.Contract.CodeSection = ret.Section
+	*pc = ret.Pc - 1 // pc will be increased by the interpreter loop
+	return nil, nil
+}
@@ -0,0 +1,303 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	offsetVersion   = 2
+	offsetTypesKind = 3
+	offsetCodeKind  = 6
+
+	kindTypes = 1
+	kindCode  = 2
+	kindData  = 3
+
+	eofFormatByte = 0xef
+	eof1Version   = 1
+
+	maxInputItems        = 127
+	maxOutputItems       = 127
+	maxStackHeight       = 1023
+	maxCodeSections      = 1024
+	maxReturnStackHeight = 1024
+)
+
+var (
+	ErrInvalidMagic           = errors.New("invalid magic")
+	ErrInvalidVersion         = errors.New("invalid version")
+	ErrMissingTypeHeader      = errors.New("missing type header")
+	ErrInvalidTypeSize        = errors.New("invalid type section size")
+	ErrMissingCodeHeader      = errors.New("missing code header")
+	ErrInvalidCodeHeader      = errors.New("invalid code header")
+	ErrInvalidCodeSize        = errors.New("invalid code size")
+	ErrMissingDataHeader      = errors.New("missing data header")
+	ErrMissingTerminator      = errors.New("missing header terminator")
+	ErrTooManyInputs          = errors.New("invalid type content, too many inputs")
+	ErrTooManyOutputs         = errors.New("invalid type content, too many outputs")
+	ErrInvalidSection0Type    = errors.New("invalid section 0 type, input and output should be zero")
+	ErrTooLargeMaxStackHeight = errors.New("invalid type content, max stack height exceeds limit")
+	ErrInvalidContainerSize   = errors.New("invalid container size")
+)
+
+// hasEOFMagic returns whmbler the code starts with the EOF magic 0xEF00.
+func hasEOFMagic(code []byte) bool {
+	return len(code) >= 2 && code[0] == eofFormatByte && code[1] == 0x00
+}
+
+// isEOFVersion1 returns whmbler the code starts with the EOF version 1 prefix.
+func isEOFVersion1(code []byte) bool {
+	return hasEOFMagic(code) && len(code) > offsetVersion && code[offsetVersion] == eof1Version
+}
+
+// Container is an EOF container object, as defined by EIP-3540.
+type Container struct {
+	Types []*FunctionMetadata
+	Code  [][]byte
+	Data  []byte
+}
+
+// ReturnContext is an entry of the EOF function return stack, the location
+// execution resumes at after a RETF.
+type ReturnContext struct {
+	Section uint64
+	Pc      uint64
+}
+
+// FunctionMetadata is an EOF function signature, as defined by EIP-4750.
+type FunctionMetadata struct {
+	Input          uint8
+	Output         uint8
+	MaxStackHeight uint16
+}
+
+// MarshalBinary encodes an EOF container into binary format.
+func (c *Container) MarshalBinary() []byte {
+	// Build the header
+	b := []byte{eofFormatByte, 0, eof1Version}
+
+	b = append(b, kindTypes)
+	b = appendUint16(b, uint16(len(c.Types)*4))
+
+	b = append(b, kindCode)
+	b = appendUint16(b, uint16(len(c.Code)))
+	for _, code := range c.Code {
+		b = appendUint16(b, uint16(len(code)))
+	}
+	b = append(b, kindData)
+	b = appendUint16(b, uint16(len(c.Data)))
+	b = append(b, 0) // terminator
+
+	// Write the section contents
+	for _, ty := range c.Types {
+		b = append(b, ty.Input, ty.Output)
+		b = appendUint16(b, ty.MaxStackHeight)
+	}
+	for _, code := range c.Code {
+		b = append(b, code...)
+	}
+	return append(b, c.Data...)
+}
+
+// UnmarshalBinary decodes an EOF container, checking the well-formedness of
+// its header and types, but not validating its code.
+func (c *Container) UnmarshalBinary(b []byte) error {
+	if !hasEOFMagic(b) {
+		return fmt.Errorf("%w: want %x", ErrInvalidMagic, []byte{eofFormatByte, 0})
+	}
+	if len(b) < 14 {
+		return ErrInvalidContainerSize
+	}
+	if !isEOFVersion1(b) {
+		return fmt.Errorf("%w: have %d, want %d", ErrInvalidVersion, b[offsetVersion], eof1Version)
+	}
+	// Parse the type section header
+	kind, typesSize, err := parseSection(b, offsetTypesKind)
+	if err != nil {
+		return err
+	}
+	if kind != kindTypes {
+		return fmt.Errorf("%w: found section kind %x instead", ErrMissingTypeHeader, kind)
+	}
+	if typesSize < 4 || typesSize%4 != 0 {
+		return fmt.Errorf("%w: type section size must be divisible by 4, have %d", ErrInvalidTypeSize, typesSize)
+	}
+	if typesSize/4 > maxCodeSections {
+		return fmt.Errorf("%w: type section must not exceed 4*1024, have %d", ErrInvalidTypeSize, typesSize)
+	}
+	// Parse the code section header
+	kind, codeSizes, err := parseSectionList(b, offsetCodeKind)
+	if err != nil {
+		return err
+	}
+	if kind != kindCode {
+		return fmt.Errorf("%w: found section kind %x instead", ErrMissingCodeHeader, kind)
+	}
+	if len(codeSizes) != typesSize/4 {
+		return fmt.Errorf("%w: mismatch of code sections count and type signatures, types %d, code %d", ErrInvalidCodeHeader, typesSize/4, len(codeSizes))
+	}
+	// Parse the data section header
+	offsetDataKind := offsetCodeKind + 2 + 2*len(codeSizes) + 1
+	kind, dataSize, err := parseSection(b, offsetDataKind)
+	if err != nil {
+		return err
+	}
+	if kind != kindData {
+		return fmt.Errorf("%w: found section kind %x instead", ErrMissingDataHeader, kind)
+	}
+	// Check for the terminator and the exact size of the container
+	offsetTerminator := offsetDataKind + 3
+	if len(b) <= offsetTerminator {
+		return fmt.Errorf("%w: invalid offset terminator", ErrInvalidContainerSize)
+	}
+	if b[offsetTerminator] != 0 {
+		return fmt.Errorf("%w: have %x", ErrMissingTerminator, b[offsetTerminator])
+	}
+	expectedSize := offsetTerminator + 1 + typesSize + dataSize
+	for _, size := range codeSizes {
+		expectedSize += size
+	}
+	if len(b) != expectedSize {
+		return fmt.Errorf("%w: have %d, want %d", ErrInvalidContainerSize, len(b), expectedSize)
+	}
+	// Parse the types section
+	idx := offsetTerminator + 1
+	var types []*FunctionMetadata
+	for i := 0; i < typesSize/4; i++ {
+		sig := &FunctionMetadata{
+			Input:          b[idx+i*4],
+			Output:         b[idx+i*4+1],
+			MaxStackHeight: binary.BigEndian.Uint16(b[idx+i*4+2:]),
+		}
+		if sig.Input > maxInputItems {
+			return fmt.Errorf("%w for section %d: have %d", ErrTooManyInputs, i, sig.Input)
+		}
+		if sig.Output > maxOutputItems {
+			return fmt.Errorf("%w for section %d: have %d", ErrTooManyOutputs, i, sig.Output)
+		}
+		if sig.MaxStackHeight > maxStackHeight {
+			return fmt.Errorf("%w for section %d: have %d", ErrTooLargeMaxStackHeight, i, sig.MaxStackHeight)
+		}
+		types = append(types, sig)
+	}
+	if types[0].Input != 0 || types[0].Output != 0 {
+		return fmt.Errorf("%w: have %d, %d", ErrInvalidSection0Type, types[0].Input, types[0].Output)
+	}
+	c.Types = types
+
+	// Parse the code sections
+	idx += typesSize
+	code := make([][]byte, len(codeSizes))
+	for i, size := range codeSizes {
+		if size == 0 {
+			return fmt.Errorf("%w for section %d: size must not be 0", ErrInvalidCodeSize, i)
+		}
+		code[i] = b[idx : idx+size]
+		idx += size
+	}
+	c.Code = code
+
+	// Parse the data section
+	c.Data = b[idx : idx+dataSize]
+
+	return nil
+}
+
+// ValidateCode validates each code section of the container against the EOF
+// v1 code validation rules, using the given jump table to look up the defined
+// instructions and their stack requirements.
+func (c *Container) ValidateCode(jt *JumpTable) error {
+	for i, code := range c.Code {
+		if err := validateCode(code, i, c.Types, jt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String returns a human readable string representation of the container.
+func (c *Container) String() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "Header\n")
+	fmt.Fprintf(&out, "  - EOFMagic: %02x%02x\n", eofFormatByte, 0)
+	fmt.Fprintf(&out, "  - EOFVersion: %02x\n", eof1Version)
+	fmt.Fprintf(&out, "  - KindType: %02x\n", kindTypes)
+	fmt.Fprintf(&out, "  - TypesSize: %04x\n", len(c.Types)*4)
+	fmt.Fprintf(&out, "  - KindCode: %02x\n", kindCode)
+	fmt.Fprintf(&out, "  - KindData: %02x\n", kindData)
+	fmt.Fprintf(&out, "  - DataSize: %04x\n", len(c.Data))
+	fmt.Fprintf(&out, "  - Number of code sections: %d\n", len(c.Code))
+	for i, code := range c.Code {
+		fmt.Fprintf(&out, "    - Code section %d length: %04x\n", i, len(code))
+	}
+	fmt.Fprintf(&out, "Body\n")
+	for i, ty := range c.Types {
+		fmt.Fprintf(&out, "  - Type %d: %02x%02x%04x\n", i, ty.Input, ty.Output, ty.MaxStackHeight)
+	}
+	for i, code := range c.Code {
+		fmt.Fprintf(&out, "  - Code section %d: %#x\n", i, code)
+	}
+	fmt.Fprintf(&out, "  - Data: %#x\n", c.Data)
+	return out.String()
+}
+
+// parseSection decodes a (kind, size) pair from an EOF header.
+func parseSection(b []byte, idx int) (kind, size int, err error) {
+	if idx+3 > len(b) {
+		return 0, 0, fmt.Errorf("%w: section header truncated at offset %d", ErrInvalidContainerSize, idx)
+	}
+	kind = int(b[idx])
+	size = int(binary.BigEndian.Uint16(b[idx+1 : idx+3]))
+	return kind, size, nil
+}
+
+// parseSectionList decodes a (kind, len, []codeSize) section list from an EOF
+// header.
+func parseSectionList(b []byte, idx int) (kind int, list []int, err error) {
+	if idx >= len(b) {
+		return 0, nil, fmt.Errorf("%w: section header truncated at offset %d", ErrInvalidContainerSize, idx)
+	}
+	kind = int(b[idx])
+	if kind != kindCode {
+		return kind, nil, nil
+	}
+	if idx+3 > len(b) {
+		return 0, nil, fmt.Errorf("%w: section list header truncated at offset %d", ErrInvalidContainerSize, idx)
+	}
+	count := int(binary.BigEndian.Uint16(b[idx+1 : idx+3]))
+	if count == 0 || count > maxCodeSections {
+		return 0, nil, fmt.Errorf("%w: have %d code sections, want 1 to %d", ErrInvalidCodeHeader, count, maxCodeSections)
+	}
+	if idx+3+2*count > len(b) {
+		return 0, nil, fmt.Errorf("%w: section list truncated at offset %d", ErrInvalidContainerSize, idx)
+	}
+	list = make([]int, count)
+	for i := 0; i < count; i++ {
+		list[i] = int(binary.BigEndian.Uint16(b[idx+3+2*i:]))
+	}
+	return kind, list, nil
+}
+
+// appendUint16 appends the big endian encoding of v to b.
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
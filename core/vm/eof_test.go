@@ -0,0 +1,216 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/core/state"
+	"github.com/mbali/go-mbali/params"
+)
+
+// eofContainer returns a container with a single code section.
+func eofContainer(code []byte, maxStack uint16) *Container {
+	return &Container{
+		Types: []*FunctionMetadata{{MaxStackHeight: maxStack}},
+		Code:  [][]byte{code},
+	}
+}
+
+func TestEOFMarshaling(t *testing.T) {
+	for i, c := range []*Container{
+		eofContainer(common.Hex2Bytes("00"), 0),
+		{
+			Types: []*FunctionMetadata{{MaxStackHeight: 1}, {Input: 2, Output: 3, MaxStackHeight: 4}},
+			Code:  [][]byte{common.Hex2Bytes("e3000100"), common.Hex2Bytes("e4")},
+			Data:  []byte{0x01, 0x02},
+		},
+	} {
+		var (
+			enc = c.MarshalBinary()
+			got Container
+		)
+		if err := got.UnmarshalBinary(enc); err != nil {
+			t.Fatalf("test %d: failed to unmarshal container: %v", i, err)
+		}
+		if !reflect.DeepEqual(c.Types, got.Types) || !reflect.DeepEqual(c.Code, got.Code) {
+			t.Errorf("test %d: container mismatch: have %v, want %v", i, &got, c)
+		}
+		if reenc := got.MarshalBinary(); !bytes.Equal(reenc, enc) {
+			t.Errorf("test %d: encoding mismatch: have %x, want %x", i, reenc, enc)
+		}
+	}
+}
+
+func TestEOFHeaderValidation(t *testing.T) {
+	valid := eofContainer(common.Hex2Bytes("00"), 0).MarshalBinary()
+	badType := (&Container{
+		Types: []*FunctionMetadata{{Input: 1, MaxStackHeight: 1}},
+		Code:  [][]byte{common.Hex2Bytes("00")},
+	}).MarshalBinary()
+
+	for i, tt := range []struct {
+		code []byte
+		err  error
+	}{
+		{valid, nil},
+		{append([]byte{0xef, 0x01}, valid[2:]...), ErrInvalidMagic},
+		{append([]byte{0xef, 0x00, 0x02}, valid[3:]...), ErrInvalidVersion},
+		{append(common.CopyBytes(valid), 0x00), ErrInvalidContainerSize},
+		{valid[:len(valid)-1], ErrInvalidContainerSize},
+		{badType, ErrInvalidSection0Type},
+	} {
+		var c Container
+		if err := c.UnmarshalBinary(tt.code); !errors.Is(err, tt.err) {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+func TestEOFCodeValidation(t *testing.T) {
+	jt := NewEOFInstructionSet()
+
+	for i, tt := range []struct {
+		code     string
+		maxStack uint16
+		err      error
+	}{
+		{"00", 0, nil},
+		{"6001e1000300600200", 1, ErrUnreachableCode},
+		{"60", 0, ErrTruncatedImmediate},
+		{"0c", 0, ErrUndefinedInstruction},
+		{"600056", 1, ErrUndefinedInstruction},
+		{"6001", 1, ErrInvalidCodeTermination},
+		{"e00001600100", 1, ErrInvalidJumpDest},
+		{"e0000200", 0, ErrInvalidJumpDest},
+		{"0000", 0, ErrUnreachableCode},
+		{"60015000", 0, ErrInvalidMaxStackHeight},
+		{"6000e10002600100", 1, ErrConflictingStack},
+		{"e4", 0, ErrInvalidRetf},
+		{"e3000100", 0, ErrInvalidSectionArgument},
+		{"5000", 0, ErrStackUnderflowValidation},
+	} {
+		c := eofContainer(common.Hex2Bytes(tt.code), tt.maxStack)
+		if err := c.ValidateCode(&jt); !errors.Is(err, tt.err) {
+			t.Errorf("test %d (%s): error mismatch: have %v, want %v", i, tt.code, err, tt.err)
+		}
+	}
+}
+
+// newEOFEVM returns an EVM with EOF v1 enabled, on a fresh state.
+func newEOFEVM() *EVM {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	blockCtx := BlockContext{
+		CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+		Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+		BlockNumber: big.NewInt(0),
+		Time:        new(big.Int),
+	}
+	return NewEVM(blockCtx, TxContext{}, statedb, params.TestChainConfig, Config{ExtraEips: []int{3540}})
+}
+
+func TestEOFExecution(t *testing.T) {
+	// Section 0 calls section 1 with 5, storing and returning its result. Section
+	// 1 doubles its input if it isn't zero.
+	container := &Container{
+		Types: []*FunctionMetadata{{MaxStackHeight: 2}, {Input: 1, Output: 1, MaxStackHeight: 2}},
+		Code: [][]byte{
+			common.Hex2Bytes("6005e3000160005260206000f3"),
+			common.Hex2Bytes("80e10001e4600202e4"),
+		},
+	}
+	evm := newEOFEVM()
+	addr := common.HexToAddress("0xc0de")
+	evm.StateDB.SetCode(addr, container.MarshalBinary())
+
+	ret, _, err := evm.Call(AccountRef(common.Address{}), addr, nil, 100000, new(big.Int))
+	if err != nil {
+		t.Fatalf("failed to execute EOF contract: %v", err)
+	}
+	if want := common.LeftPadBytes([]byte{10}, 32); !bytes.Equal(ret, want) {
+		t.Errorf("return data mismatch: have %x, want %x", ret, want)
+	}
+	// The new opcodes are invalid in legacy code
+	legacy := common.HexToAddress("0x1e9ac7")
+	evm.StateDB.SetCode(legacy, common.Hex2Bytes("e0000000"))
+	if _, _, err := evm.Call(AccountRef(common.Address{}), legacy, nil, 100000, new(big.Int)); err == nil {
+		t.Error("RJUMP executed in legacy code")
+	}
+	// Containers in the state are only decoded before execution, invalid
+	// instructions fail when they are executed.
+	invalid := common.HexToAddress("0xbad")
+	for _, code := range []string{"e0ff00", "e4", "e3000100"} {
+		evm.StateDB.SetCode(invalid, eofContainer(common.Hex2Bytes(code), 1).MarshalBinary())
+		_, _, err := evm.Call(AccountRef(common.Address{}), invalid, nil, 100000, new(big.Int))
+		if opErr := new(ErrInvalidOpCode); !errors.As(err, &opErr) {
+			t.Errorf("code %s: error mismatch: have %v, want invalid opcode", code, err)
+		}
+	}
+	evm.StateDB.SetCode(invalid, common.Hex2Bytes("ef0001ff"))
+	if _, _, err := evm.Call(AccountRef(common.Address{}), invalid, nil, 100000, new(big.Int)); !errors.Is(err, ErrInvalidEOFCode) {
+		t.Errorf("malformed container: error mismatch: have %v, want %v", err, ErrInvalidEOFCode)
+	}
+}
+
+func TestEOFCreation(t *testing.T) {
+	// deployer returns EOF initcode copying its data section as the deployed code
+	deployer := func(deployed []byte) []byte {
+		initcode := &Container{
+			Types: []*FunctionMetadata{{MaxStackHeight: 3}},
+			Code:  [][]byte{common.Hex2Bytes("60ff60ff60003960ff6000f3")},
+			Data:  deployed,
+		}
+		offset := len(initcode.MarshalBinary()) - len(deployed)
+		code := initcode.Code[0]
+		code[1], code[3], code[8] = byte(len(deployed)), byte(offset), byte(len(deployed))
+		return initcode.MarshalBinary()
+	}
+	valid := eofContainer(common.Hex2Bytes("00"), 0).MarshalBinary()
+
+	for i, tt := range []struct {
+		initcode []byte
+		err      error
+	}{
+		{deployer(valid), nil},
+		{eofContainer(common.Hex2Bytes("60"), 0).MarshalBinary(), ErrInvalidEOFInitcode},
+		{deployer(eofContainer(common.Hex2Bytes("0c"), 0).MarshalBinary()), ErrInvalidEOFCode},
+		{deployer(common.Hex2Bytes("ef01")), ErrInvalidEOFCode},
+		{deployer(common.Hex2Bytes("6000")), ErrInvalidEOFCode},
+	} {
+		evm := newEOFEVM()
+		_, addr, leftOver, err := evm.Create(AccountRef(common.Address{}), tt.initcode, 100000, new(big.Int))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+			continue
+		}
+		if err != nil {
+			if leftOver != 0 {
+				t.Errorf("test %d: failed creation left %d gas", i, leftOver)
+			}
+			continue
+		}
+		if code := evm.StateDB.GetCode(addr); !bytes.Equal(code, valid) {
+			t.Errorf("test %d: deployed code mismatch: have %x, want %x", i, code, valid)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/mbali/go-mbali/params"
+)
+
+var (
+	ErrUndefinedInstruction     = errors.New("undefined instruction")
+	ErrTruncatedImmediate       = errors.New("truncated immediate")
+	ErrInvalidSectionArgument   = errors.New("invalid section argument")
+	ErrInvalidJumpDest          = errors.New("invalid jump destination")
+	ErrInvalidRetf              = errors.New("invalid RETF in section 0")
+	ErrConflictingStack         = errors.New("conflicting stack height")
+	ErrInvalidOutputs           = errors.New("invalid number of outputs")
+	ErrInvalidMaxStackHeight    = errors.New("invalid max stack height")
+	ErrInvalidCodeTermination   = errors.New("invalid code termination")
+	ErrUnreachableCode          = errors.New("unreachable code")
+	ErrStackUnderflowValidation = errors.New("stack underflow")
+)
+
+// immediateSize returns the number of immediate bytes following an opcode in
+// EOF code.
+func immediateSize(op OpCode) int {
+	switch {
+	case op >= PUSH1 && op <= PUSH32:
+		return int(op-PUSH1) + 1
+	case op == RJUMP || op == RJUMPI || op == CALLF:
+		return 2
+	}
+	return 0
+}
+
+// isTerminal returns whmbler the opcode ends the execution of a code section.
+func isTerminal(op OpCode) bool {
+	switch op {
+	case STOP, RETURN, REVERT, INVALID, RETF, RJUMP:
+		return true
+	}
+	return false
+}
+
+// validateCode validates the code of an EOF code section:
+//   - all instructions are defined and their immediates are not truncated,
+//   - the legacy dynamic jumps and deprecated instructions are not used,
+//   - relative jumps land on instruction boundaries within the section,
+//   - CALLF targets existing sections and RETF is not used in section 0,
+//   - the stack height is consistent on all code paths, never underflows and
+//     matches the declared maximum, and the code never falls off its end.
+func validateCode(code []byte, section int, metadata []*FunctionMetadata, jt *JumpTable) error {
+	var (
+		i      = 0
+		starts = make([]bool, len(code)) // Instruction boundaries
+		jumps  []int                     // Offsets of the relative jumps
+	)
+	for i < len(code) {
+		op := OpCode(code[i])
+		switch {
+		case jt[op].undefined && op != INVALID:
+			return fmt.Errorf("%w: op %s, pos %d", ErrUndefinedInstruction, op, i)
+		case op == JUMP || op == JUMPI || op == PC || op == CALLCODE || op == SELFDESTRUCT:
+			return fmt.Errorf("%w: op %s, pos %d", ErrUndefinedInstruction, op, i)
+		}
+		size := immediateSize(op)
+		if size > 0 && i+size >= len(code) {
+			return fmt.Errorf("%w: op %s, pos %d", ErrTruncatedImmediate, op, i)
+		}
+		switch op {
+		case RJUMP, RJUMPI:
+			jumps = append(jumps, i)
+		case CALLF:
+			if arg := int(binary.BigEndian.Uint16(code[i+1:])); arg >= len(metadata) {
+				return fmt.Errorf("%w: arg %d, last section %d, pos %d", ErrInvalidSectionArgument, arg, len(metadata)-1, i)
+			}
+		case RETF:
+			if section == 0 {
+				return fmt.Errorf("%w: pos %d", ErrInvalidRetf, i)
+			}
+		}
+		starts[i] = true
+		i += size + 1
+	}
+	for _, pos := range jumps {
+		dest := relativeJumpTarget(code, pos)
+		if dest < 0 || dest >= len(code) || !starts[dest] {
+			return fmt.Errorf("%w: pos %d, dest %d", ErrInvalidJumpDest, pos, dest)
+		}
+	}
+	return validateStack(code, section, metadata, jt, starts)
+}
+
+// relativeJumpTarget returns the destination of the relative jump at pos.
+func relativeJumpTarget(code []byte, pos int) int {
+	offset := int16(binary.BigEndian.Uint16(code[pos+1:]))
+	return pos + 3 + int(offset)
+}
+
+// validateStack walks all code paths of a code section, checking that the
+// stack height at each instruction is the same regardless of the path taken
+// to reach it, and that the maximum height matches the declared one.
+func validateStack(code []byte, section int, metadata []*FunctionMetadata, jt *JumpTable, starts []bool) error {
+	var (
+		heights  = make([]int, len(code))
+		worklist = []int{0}
+		maxH     = int(metadata[section].Input)
+	)
+	for i := range heights {
+		heights[i] = -1
+	}
+	heights[0] = int(metadata[section].Input)
+
+	for len(worklist) > 0 {
+		pos := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+
+		var (
+			op     = OpCode(code[pos])
+			height = heights[pos]
+			next   = height
+		)
+		switch op {
+		case CALLF:
+			callee := metadata[binary.BigEndian.Uint16(code[pos+1:])]
+			if height < int(callee.Input) {
+				return fmt.Errorf("%w: at pos %d", ErrStackUnderflowValidation, pos)
+			}
+			next = height - int(callee.Input) + int(callee.Output)
+		case RETF:
+			if height != int(metadata[section].Output) {
+				return fmt.Errorf("%w: have %d, want %d, at pos %d", ErrInvalidOutputs, height, metadata[section].Output, pos)
+			}
+		case INVALID:
+			// The designated invalid instruction aborts, regardless of the stack
+		default:
+			pops := jt[op].minStack
+			pushes := int(params.StackLimit) + pops - jt[op].maxStack
+			if height < pops {
+				return fmt.Errorf("%w: at pos %d", ErrStackUnderflowValidation, pos)
+			}
+			next = height - pops + pushes
+		}
+		if next > maxH {
+			maxH = next
+		}
+		// Collect the successors of the instruction
+		var successors []int
+		if op == RJUMP || op == RJUMPI {
+			successors = append(successors, relativeJumpTarget(code, pos))
+		}
+		if !isTerminal(op) {
+			succ := pos + immediateSize(op) + 1
+			if succ >= len(code) {
+				return fmt.Errorf("%w: end with %s, pos %d", ErrInvalidCodeTermination, op, pos)
+			}
+			successors = append(successors, succ)
+		}
+		for _, succ := range successors {
+			switch heights[succ] {
+			case -1:
+				heights[succ] = next
+				worklist = append(worklist, succ)
+			case next:
+			default:
+				return fmt.Errorf("%w: have %d, want %d, at pos %d", ErrConflictingStack, next, heights[succ], succ)
+			}
+		}
+	}
+	for pos, start := range starts {
+		if start && heights[pos] == -1 {
+			return fmt.Errorf("%w: at pos %d", ErrUnreachableCode, pos)
+		}
+	}
+	if maxH > maxStackHeight {
+		return fmt.Errorf("%w: computed %d, limit %d", ErrInvalidMaxStackHeight, maxH, maxStackHeight)
+	}
+	if maxH != int(metadata[section].MaxStackHeight) {
+		return fmt.Errorf("%w in code section %d: have %d, want %d", ErrInvalidMaxStackHeight, section, metadata[section].MaxStackHeight, maxH)
+	}
+	return nil
+}
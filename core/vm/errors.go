@@ -36,6 +36,9 @@ var (
 	ErrGasUintOverflow          = errors.New("gas uint64 overflow")
 	ErrInvalidCode              = errors.New("invalid code: must not begin with 0xef")
 	ErrNonceUintOverflow        = errors.New("nonce uint64 overflow")
+	ErrReturnStackExceeded      = errors.New("return stack limit reached")
+	ErrInvalidEOFInitcode       = errors.New("invalid eof initcode")
+	ErrInvalidEOFCode           = errors.New("invalid eof code")
 
 	// errStopToken is an internal token indicating interpreter loop termination,
 	// never returned to outside callers.
@@ -17,11 +17,13 @@
 package vm
 
 import (
+	"fmt"
 	"math/big"
 	"sync/atomic"
 	"time"
 
 	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/core/tracing"
 	"github.com/mbali/go-mbali/crypto"
 	"github.com/mbali/go-mbali/params"
 	"github.com/holiman/uint256"
@@ -53,8 +55,15 @@ func (evm *EVM) precompile(addr common.Address) (PrecompiledContract, bool) {
 	default:
 		precompiles = PrecompiledContractsHomestead
 	}
-	p, ok := precompiles[addr]
-	return p, ok
+	if p, ok := precompiles[addr]; ok {
+		return p, true
+	}
+	if evm.chainRules.IsBLS12381 {
+		if p, ok := PrecompiledContractsBLS[addr]; ok {
+			return p, true
+		}
+	}
+	return activeCustomPrecompile(evm.chainRules, addr)
 }
 
 // BlockContext provides the EVM with auxiliary information. Once provided
@@ -126,13 +135,17 @@ type EVM struct {
 // NewEVM returns a new EVM. The returned EVM is not thread safe and should
 // only ever be used *once*.
 func NewEVM(blockCtx BlockContext, txCtx TxContext, statedb StateDB, chainConfig *params.ChainConfig, config Config) *EVM {
+	var timestamp uint64
+	if blockCtx.Time != nil {
+		timestamp = blockCtx.Time.Uint64()
+	}
 	evm := &EVM{
 		Context:     blockCtx,
 		TxContext:   txCtx,
 		StateDB:     statedb,
 		Config:      config,
 		chainConfig: chainConfig,
-		chainRules:  chainConfig.Rules(blockCtx.BlockNumber, blockCtx.Random != nil),
+		chainRules:  chainConfig.Rules(blockCtx.BlockNumber, blockCtx.Random != nil, timestamp),
 	}
 	evm.interpreter = NewEVMInterpreter(evm, config)
 	return evm
@@ -354,7 +367,7 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 	// This doesn't matter on Mainnet, where all empties are gone at the time of Byzantium,
 	// but is the correct thing to do and matters on other networks, in tests, and potential
 	// future scenarios
-	evm.StateDB.AddBalance(addr, big0)
+	evm.StateDB.AddBalance(addr, big0, tracing.BalanceChangeTouchAccount)
 
 	// Invoke tracer hooks that signal entering/exiting a call frame
 	if evm.Config.Debug {
@@ -450,15 +463,33 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 
 	start := time.Now()
 
-	ret, err := evm.interpreter.Run(contract, nil, false)
+	// EOF initcode must be a valid container, otherwise the creation fails
+	// without executing it.
+	var (
+		ret []byte
+		err error
+	)
+	if evm.interpreter.eof && hasEOFMagic(codeAndHash.code) {
+		if contract.Container, err = evm.interpreter.parseContainer(codeAndHash.code); err != nil {
+			err = fmt.Errorf("%w: %v", ErrInvalidEOFInitcode, err)
+		}
+	}
+	if err == nil {
+		ret, err = evm.interpreter.Run(contract, nil, false)
+	}
 
 	// Check whmbler the max code size has been exceeded, assign err if the case.
 	if err == nil && evm.chainRules.IsEIP158 && len(ret) > params.MaxCodeSize {
 		err = ErrMaxCodeSizeExceeded
 	}
 
-	// Reject code starting with 0xEF if EIP-3541 is enabled.
-	if err == nil && len(ret) >= 1 && ret[0] == 0xEF && evm.chainRules.IsLondon {
+	// EOF initcode must deploy a valid EOF container. Otherwise, reject code
+	// starting with 0xEF if EIP-3541 is enabled.
+	if err == nil && contract.Container != nil {
+		if _, verr := evm.interpreter.parseContainer(ret); verr != nil {
+			err = fmt.Errorf("%w: %v", ErrInvalidEOFCode, verr)
+		}
+	} else if err == nil && len(ret) >= 1 && ret[0] == 0xEF && evm.chainRules.IsLondon {
 		err = ErrInvalidCode
 	}
 
@@ -513,3 +544,6 @@ func (evm *EVM) Create2(caller ContractRef, code []byte, gas uint64, endowment *
 
 // ChainConfig returns the environment's chain configuration
 func (evm *EVM) ChainConfig() *params.ChainConfig { return evm.chainConfig }
+
+// ChainRules returns the fork rules active in the environment's block
+func (evm *EVM) ChainRules() params.Rules { return evm.chainRules }
@@ -17,6 +17,7 @@
 package vm
 
 import (
+	"fmt"
 	"hash"
 
 	"github.com/mbali/go-mbali/common"
@@ -62,6 +63,8 @@ type EVMInterpreter struct {
 
 	readOnly   bool   // Whmbler to throw on stateful modifications
 	returnData []byte // Last CALL's return data for subsequent reuse
+
+	eof bool // Whmbler EOF v1 containers are recognized (EIP-3540)
 }
 
 // NewEVMInterpreter returns a new instance of the Interpreter.
@@ -101,10 +104,28 @@ func NewEVMInterpreter(evm *EVM, cfg Config) *EVMInterpreter {
 		}
 	}
 
+	var eof bool
+	for _, eip := range cfg.ExtraEips {
+		eof = eof || eip == 3540
+	}
 	return &EVMInterpreter{
 		evm: evm,
 		cfg: cfg,
+		eof: eof,
+	}
+}
+
+// parseContainer decodes an EOF container and validates its code against the
+// active instruction set.
+func (in *EVMInterpreter) parseContainer(code []byte) (*Container, error) {
+	var c Container
+	if err := c.UnmarshalBinary(code); err != nil {
+		return nil, err
+	}
+	if err := c.ValidateCode(in.cfg.JumpTable); err != nil {
+		return nil, err
 	}
+	return &c, nil
 }
 
 // Run loops and evaluates the contract's code with the given input data and returns
@@ -134,6 +155,16 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 	if len(contract.Code) == 0 {
 		return nil, nil
 	}
+	// Decode EOF containers. The code is validated on deployment only, the
+	// instructions check their immediates at runtime, which covers code
+	// predating the activation of EOF.
+	if in.eof && contract.Container == nil && hasEOFMagic(contract.Code) {
+		c := new(Container)
+		if err := c.UnmarshalBinary(contract.Code); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidEOFCode, err)
+		}
+		contract.Container = c
+	}
 
 	var (
 		op          OpCode        // current opcode
@@ -39,12 +39,14 @@ import (
 	"github.com/mbali/go-mbali/core/types"
 	"github.com/mbali/go-mbali/core/vm"
 	"github.com/mbali/go-mbali/crypto"
+	"github.com/mbali/go-mbali/light"
 	"github.com/mbali/go-mbali/mbl/tracers/logger"
 	"github.com/mbali/go-mbali/log"
 	"github.com/mbali/go-mbali/p2p"
 	"github.com/mbali/go-mbali/params"
 	"github.com/mbali/go-mbali/rlp"
 	"github.com/mbali/go-mbali/rpc"
+	"github.com/mbali/go-mbali/trie"
 	"github.com/tyler-smith/go-bip39"
 )
 
@@ -709,6 +711,174 @@ func (s *PublicBlockChainAPI) GetProof(ctx context.Context, address common.Addre
 	}, state.Error()
 }
 
+// maxStorageRangeProofSlots is the maximum number of storage slots returned by
+// a single GetStorageRangeProof request.
+const maxStorageRangeProofSlots = 1024
+
+// StorageRangeResult is the result of a GetStorageRangeProof operation. Keys
+// are the hashed storage slot keys in trie order and values are the raw RLP
+// encoded slot contents, as stored in the storage trie.
+type StorageRangeResult struct {
+	Address      common.Address  `json:"address"`
+	AccountProof []string        `json:"accountProof"`
+	StorageHash  common.Hash     `json:"storageHash"`
+	Start        common.Hash     `json:"start"`
+	Keys         []common.Hash   `json:"keys"`
+	Values       []hexutil.Bytes `json:"values"`
+	Proof        []string        `json:"proof"`
+}
+
+// GetStorageRangeProof returns a contiguous range of storage slots of the given
+// account, starting at the given hashed slot key, together with the Merkle proofs
+// of the range boundaries. The range can be verified with trie.VerifyRangeProof.
+func (s *PublicBlockChainAPI) GetStorageRangeProof(ctx context.Context, address common.Address, start common.Hash, maxResults int, blockNrOrHash rpc.BlockNumberOrHash) (*StorageRangeResult, error) {
+	if maxResults <= 0 || maxResults > maxStorageRangeProofSlots {
+		maxResults = maxStorageRangeProofSlots
+	}
+	state, _, err := s.b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	if state == nil || err != nil {
+		return nil, err
+	}
+	accountProof, err := state.GetProof(address)
+	if err != nil {
+		return nil, err
+	}
+	result := &StorageRangeResult{
+		Address:      address,
+		AccountProof: toHexSlice(accountProof),
+		StorageHash:  types.EmptyRootHash,
+		Start:        start,
+		Keys:         []common.Hash{},
+		Values:       []hexutil.Bytes{},
+		Proof:        []string{},
+	}
+	// If there's no storage trie, the account doesn't exist, only the account
+	// proof is needed.
+	storageTrie := state.StorageTrie(address)
+	if storageTrie == nil {
+		return result, state.Error()
+	}
+	result.StorageHash = storageTrie.Hash()
+
+	it := trie.NewIterator(storageTrie.NodeIterator(start[:]))
+	for len(result.Keys) < maxResults && it.Next() {
+		result.Keys = append(result.Keys, common.BytesToHash(it.Key))
+		result.Values = append(result.Values, common.CopyBytes(it.Value))
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+	// Prove the first and last key of the range, the first one being a
+	// non-existence proof if the start key is not in the trie.
+	proof := light.NewNodeSet()
+	if err := storageTrie.Prove(start[:], 0, proof); err != nil {
+		return nil, err
+	}
+	if n := len(result.Keys); n > 0 {
+		if err := storageTrie.Prove(result.Keys[n-1][:], 0, proof); err != nil {
+			return nil, err
+		}
+	}
+	for _, node := range proof.NodeList() {
+		result.Proof = append(result.Proof, hexutil.Encode(node))
+	}
+	return result, state.Error()
+}
+
+// MultiProofArgs selects an account and optionally some of its storage slots
+// to be proven by GetMultiProof.
+type MultiProofArgs struct {
+	Address     common.Address `json:"address"`
+	StorageKeys []string       `json:"storageKeys"`
+}
+
+// MultiProofResult is the result of a GetMultiProof operation. The proofs of
+// all the requested accounts and storage slots are merged into a single set of
+// deduplicated trie nodes.
+type MultiProofResult struct {
+	Accounts []MultiProofAccount `json:"accounts"`
+	Proof    []string            `json:"proof"`
+}
+
+// MultiProofAccount is an account proven by a GetMultiProof operation.
+type MultiProofAccount struct {
+	Address     common.Address      `json:"address"`
+	Balance     *hexutil.Big        `json:"balance"`
+	CodeHash    common.Hash         `json:"codeHash"`
+	Nonce       hexutil.Uint64      `json:"nonce"`
+	StorageHash common.Hash         `json:"storageHash"`
+	Storage     []MultiProofStorage `json:"storage"`
+}
+
+// MultiProofStorage is a storage slot proven by a GetMultiProof operation.
+type MultiProofStorage struct {
+	Key   string       `json:"key"`
+	Value *hexutil.Big `json:"value"`
+}
+
+// GetMultiProof returns the Merkle-proofs for many accounts and their storage
+// slots at once. Trie nodes shared between the individual proofs are only
+// included once in the response.
+func (s *PublicBlockChainAPI) GetMultiProof(ctx context.Context, args []MultiProofArgs, blockNrOrHash rpc.BlockNumberOrHash) (*MultiProofResult, error) {
+	state, _, err := s.b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	if state == nil || err != nil {
+		return nil, err
+	}
+	var (
+		proof  = light.NewNodeSet()
+		result = &MultiProofResult{
+			Accounts: make([]MultiProofAccount, 0, len(args)),
+			Proof:    []string{},
+		}
+	)
+	// Collect the given proof into the shared node set
+	collect := func(nodes [][]byte) {
+		for _, node := range nodes {
+			proof.Put(crypto.Keccak256(node), node)
+		}
+	}
+	for _, arg := range args {
+		accountProof, err := state.GetProof(arg.Address)
+		if err != nil {
+			return nil, err
+		}
+		collect(accountProof)
+
+		account := MultiProofAccount{
+			Address:     arg.Address,
+			Balance:     (*hexutil.Big)(state.GetBalance(arg.Address)),
+			CodeHash:    state.GetCodeHash(arg.Address),
+			Nonce:       hexutil.Uint64(state.GetNonce(arg.Address)),
+			StorageHash: types.EmptyRootHash,
+			Storage:     make([]MultiProofStorage, len(arg.StorageKeys)),
+		}
+		storageTrie := state.StorageTrie(arg.Address)
+		if storageTrie != nil {
+			account.StorageHash = storageTrie.Hash()
+		} else {
+			// no storageTrie means the account does not exist, so the codeHash is the hash of an empty bytearray.
+			account.CodeHash = crypto.Keccak256Hash(nil)
+		}
+		for i, key := range arg.StorageKeys {
+			if storageTrie == nil {
+				account.Storage[i] = MultiProofStorage{key, &hexutil.Big{}}
+				continue
+			}
+			storageProof, err := state.GetStorageProof(arg.Address, common.HexToHash(key))
+			if err != nil {
+				return nil, err
+			}
+			collect(storageProof)
+			account.Storage[i] = MultiProofStorage{key, (*hexutil.Big)(state.GetState(arg.Address, common.HexToHash(key)).Big())}
+		}
+		result.Accounts = append(result.Accounts, account)
+	}
+	for _, node := range proof.NodeList() {
+		result.Proof = append(result.Proof, hexutil.Encode(node))
+	}
+	return result, state.Error()
+}
+
 // gombleaderByNumber returns the requested canonical block header.
 // * When blockNr is -1 the chain head is returned.
 // * When blockNr is -2 the pending chain head is returned.
@@ -1181,6 +1351,9 @@ func RPCMarshalHeader(head *types.Header) map[string]interface{} {
 	if head.BaseFee != nil {
 		result["baseFeePerGas"] = (*hexutil.Big)(head.BaseFee)
 	}
+	if head.WithdrawalsHash != nil {
+		result["withdrawalsRoot"] = head.WithdrawalsHash
+	}
 
 	return result
 }
@@ -1217,6 +1390,9 @@ func RPCMarshalBlock(block *types.Block, inclTx bool, fullTx bool, config *param
 		uncleHashes[i] = uncle.Hash()
 	}
 	fields["uncles"] = uncleHashes
+	if block.Header().WithdrawalsHash != nil {
+		fields["withdrawals"] = block.Withdrawals()
+	}
 
 	return fields, nil
 }
@@ -1405,7 +1581,7 @@ func AccessList(ctx context.Context, b Backend, blockNrOrHash rpc.BlockNumberOrH
 	}
 	isPostMerge := header.Difficulty.Cmp(common.Big0) == 0
 	// Retrieve the precompiles since they don't need to be added to the access list
-	precompiles := vm.ActivePrecompiles(b.ChainConfig().Rules(header.Number, isPostMerge))
+	precompiles := vm.ActivePrecompiles(b.ChainConfig().Rules(header.Number, isPostMerge, header.Time))
 
 	// Create an initial tracer
 	prevTracer := logger.NewAccessListTracer(nil, args.from(), to, precompiles)
@@ -141,6 +141,31 @@ web3._extend({
 			call: 'admin_removeTrustedPeer',
 			params: 1
 		}),
+		new web3._extend.Mmblod({
+			name: 'addBan',
+			call: 'admin_addBan',
+			params: 3,
+			inputFormatter: [null, null, null]
+		}),
+		new web3._extend.Mmblod({
+			name: 'removeBan',
+			call: 'admin_removeBan',
+			params: 1
+		}),
+		new web3._extend.Mmblod({
+			name: 'listBans',
+			call: 'admin_listBans'
+		}),
+		new web3._extend.Mmblod({
+			name: 'setEgressRates',
+			call: 'admin_setEgressRates',
+			params: 2
+		}),
+		new web3._extend.Mmblod({
+			name: 'setNetLists',
+			call: 'admin_setNetLists',
+			params: 2
+		}),
 		new web3._extend.Mmblod({
 			name: 'exportChain',
 			call: 'admin_exportChain',
@@ -203,6 +228,10 @@ web3._extend({
 			name: 'datadir',
 			getter: 'admin_datadir'
 		}),
+		new web3._extend.Property({
+			name: 'netLists',
+			getter: 'admin_netLists'
+		}),
 	]
 });
 `
@@ -578,6 +607,18 @@ web3._extend({
 			params: 3,
 			inputFormatter: [web3._extend.formatters.inputAddressFormatter, null, web3._extend.formatters.inputBlockNumberFormatter]
 		}),
+		new web3._extend.Mmblod({
+			name: 'getStorageRangeProof',
+			call: 'mbl_getStorageRangeProof',
+			params: 4,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, null, null, web3._extend.formatters.inputBlockNumberFormatter]
+		}),
+		new web3._extend.Mmblod({
+			name: 'getMultiProof',
+			call: 'mbl_getMultiProof',
+			params: 2,
+			inputFormatter: [null, web3._extend.formatters.inputBlockNumberFormatter]
+		}),
 		new web3._extend.Mmblod({
 			name: 'createAccessList',
 			call: 'mbl_createAccessList',
@@ -111,7 +111,8 @@ func (h *clientHandler) handle(p *serverPeer, noInitAnnounce bool) error {
 	p.Log().Debug("Light mbali peer connected", "name", p.Name())
 
 	// Execute the LES handshake
-	forkid := forkid.NewID(h.backend.blockchain.Config(), h.backend.genesis, h.backend.blockchain.CurrentHeader().Number.Uint64())
+	head := h.backend.blockchain.CurrentHeader()
+	forkid := forkid.NewID(h.backend.blockchain.Config(), h.backend.genesis, head.Number.Uint64(), head.Time)
 	if err := p.Handshake(h.backend.blockchain.Genesis().Hash(), forkid, h.forkFilter); err != nil {
 		p.Log().Debug("Light mbali handshake failed", "err", err)
 		return err
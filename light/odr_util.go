@@ -144,7 +144,7 @@ func GetBlock(ctx context.Context, odr OdrBackend, hash common.Hash, number uint
 		return nil, err
 	}
 	// Reassemble the block and return
-	return types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles), nil
+	return types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles).WithWithdrawals(body.Withdrawals), nil
 }
 
 // GetBlockReceipts retrieves the receipts generated by the transactions included
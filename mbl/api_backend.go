@@ -159,7 +159,7 @@ func (b *mblAPIBackend) StateAndHeaderByNumber(ctx context.Context, number rpc.B
 	if header == nil {
 		return nil, nil, errors.New("header not found")
 	}
-	stateDb, err := b.mbl.BlockChain().StateAt(header.Root)
+	stateDb, err := b.mbl.BlockChain().HistoricStateAt(header)
 	return stateDb, header, err
 }
 
@@ -178,7 +178,7 @@ func (b *mblAPIBackend) StateAndHeaderByNumberOrHash(ctx context.Context, blockN
 		if blockNrOrHash.RequireCanonical && b.mbl.blockchain.GetCanonicalHash(header.Number.Uint64()) != hash {
 			return nil, nil, errors.New("hash is not currently canonical")
 		}
-		stateDb, err := b.mbl.BlockChain().StateAt(header.Root)
+		stateDb, err := b.mbl.BlockChain().HistoricStateAt(header)
 		return stateDb, header, err
 	}
 	return nil, nil, errors.New("invalid arguments; neither block nor hash specified")
@@ -45,6 +45,7 @@ import (
 	"github.com/mbali/go-mbali/mbl/gasprice"
 	"github.com/mbali/go-mbali/mbl/protocols/mbl"
 	"github.com/mbali/go-mbali/mbl/protocols/snap"
+	"github.com/mbali/go-mbali/mbl/tracers/live"
 	"github.com/mbali/go-mbali/mbldb"
 	"github.com/mbali/go-mbali/event"
 	"github.com/mbali/go-mbali/internal/mblapi"
@@ -101,6 +102,8 @@ type mbali struct {
 	lock sync.RWMutex // Protects the variadic fields (e.g. gas price and mblerbase)
 
 	shutdownTracker *shutdowncheck.ShutdownTracker // Tracks if and when the node has shutdown ungracefully
+
+	liveTracer *live.Tracer // Tracer attached to the block import, nil if disabled
 }
 
 // New creates a new mbali object (including the
@@ -201,8 +204,24 @@ func New(stack *node.Node, config *mblconfig.Config) (*mbali, error) {
 			TrieTimeLimit:       config.TrieTimeout,
 			SnapshotLimit:       config.SnapshotCache,
 			Preimages:           config.Preimages,
+			ArchiveIndex:        config.ArchiveIndex,
 		}
 	)
+	if config.VMTrace != "" {
+		if config.VMTraceOutput == "" {
+			return nil, errors.New("live tracing requires a trace output file")
+		}
+		sink, err := live.NewFileSink(stack.ResolvePath(config.VMTraceOutput))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open live trace output: %v", err)
+		}
+		if mbl.liveTracer, err = live.New(config.VMTrace, sink); err != nil {
+			sink.Close()
+			return nil, fmt.Errorf("failed to create live tracer %q: %v", config.VMTrace, err)
+		}
+		vmConfig.Debug, vmConfig.Tracer = true, mbl.liveTracer
+		log.Info("Enabled live tracing of imported blocks", "tracer", config.VMTrace, "output", config.VMTraceOutput)
+	}
 	mbl.blockchain, err = core.NewBlockChain(chainDb, cacheConfig, chainConfig, mbl.engine, vmConfig, mbl.shouldPreserve, &config.TxLookupLimit)
 	if err != nil {
 		return nil, err
@@ -581,6 +600,9 @@ func (s *mbali) Stop() error {
 	s.miner.Close()
 	s.blockchain.Stop()
 	s.engine.Close()
+	if s.liveTracer != nil {
+		s.liveTracer.Close()
+	}
 
 	// Clean shutdown marker as the last thing before closing db
 	s.shutdownTracker.Stop()
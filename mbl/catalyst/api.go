@@ -22,6 +22,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math/big"
 	"sync"
 	"time"
 
@@ -33,6 +34,7 @@ import (
 	"github.com/mbali/go-mbali/mbl"
 	"github.com/mbali/go-mbali/log"
 	"github.com/mbali/go-mbali/node"
+	"github.com/mbali/go-mbali/rlp"
 	"github.com/mbali/go-mbali/rpc"
 )
 
@@ -90,6 +92,42 @@ func NewConsensusAPI(mbl *mbl.mbali) *ConsensusAPI {
 // If there are payloadAttributes:
 // 		we try to assemble a block with the payloadAttributes and return its payloadID
 func (api *ConsensusAPI) ForkchoiceUpdatedV1(update beacon.ForkchoiceStateV1, payloadAttributes *beacon.PayloadAttributesV1) (beacon.ForkChoiceResponse, error) {
+	if payloadAttributes != nil && payloadAttributes.Withdrawals != nil {
+		return beacon.STATUS_INVALID, beacon.InvalidParams.With(errors.New("withdrawals not supported in V1"))
+	}
+	return api.forkchoiceUpdated(update, payloadAttributes)
+}
+
+// ForkchoiceUpdatedV2 is equivalent to V1 with the addition of withdrawals in
+// the payload attributes. Withdrawals must be set for Shanghai payloads and
+// must be omitted for earlier ones.
+func (api *ConsensusAPI) ForkchoiceUpdatedV2(update beacon.ForkchoiceStateV1, payloadAttributes *beacon.PayloadAttributesV1) (beacon.ForkChoiceResponse, error) {
+	if payloadAttributes != nil {
+		if err := api.verifyPayloadAttributes(payloadAttributes); err != nil {
+			return beacon.STATUS_INVALID, beacon.InvalidParams.With(err)
+		}
+	}
+	return api.forkchoiceUpdated(update, payloadAttributes)
+}
+
+// verifyPayloadAttributes checks that the presence of withdrawals in the
+// attributes matches the fork active at the requested timestamp.
+func (api *ConsensusAPI) verifyPayloadAttributes(attr *beacon.PayloadAttributesV1) error {
+	if !api.mbl.BlockChain().Config().IsShanghai(api.mbl.BlockChain().CurrentBlock().Number(), attr.Timestamp) {
+		// Reject payload attributes with withdrawals before Shanghai
+		if attr.Withdrawals != nil {
+			return errors.New("withdrawals before Shanghai")
+		}
+	} else {
+		// Reject payload attributes without withdrawals after Shanghai
+		if attr.Withdrawals == nil {
+			return errors.New("missing withdrawals list")
+		}
+	}
+	return nil
+}
+
+func (api *ConsensusAPI) forkchoiceUpdated(update beacon.ForkchoiceStateV1, payloadAttributes *beacon.PayloadAttributesV1) (beacon.ForkChoiceResponse, error) {
 	api.forkChoiceLock.Lock()
 	defer api.forkChoiceLock.Unlock()
 
@@ -201,14 +239,14 @@ func (api *ConsensusAPI) ForkchoiceUpdatedV1(update beacon.ForkchoiceStateV1, pa
 	// might replace it arbitrarily many times in between.
 	if payloadAttributes != nil {
 		// Create an empty block first which can be used as a fallback
-		empty, err := api.mbl.Miner().GetSealingBlockSync(update.HeadBlockHash, payloadAttributes.Timestamp, payloadAttributes.SuggestedFeeRecipient, payloadAttributes.Random, true)
+		empty, err := api.mbl.Miner().GetSealingBlockSync(update.HeadBlockHash, payloadAttributes.Timestamp, payloadAttributes.SuggestedFeeRecipient, payloadAttributes.Random, payloadAttributes.Withdrawals, true)
 		if err != nil {
 			log.Error("Failed to create empty sealing payload", "err", err)
 			return valid(nil), beacon.InvalidPayloadAttributes.With(err)
 		}
 		// Send a request to generate a full block in the background.
 		// The result can be obtained via the returned channel.
-		resCh, err := api.mbl.Miner().GetSealingBlockAsync(update.HeadBlockHash, payloadAttributes.Timestamp, payloadAttributes.SuggestedFeeRecipient, payloadAttributes.Random, false)
+		resCh, err := api.mbl.Miner().GetSealingBlockAsync(update.HeadBlockHash, payloadAttributes.Timestamp, payloadAttributes.SuggestedFeeRecipient, payloadAttributes.Random, payloadAttributes.Withdrawals, false)
 		if err != nil {
 			log.Error("Failed to create async sealing payload", "err", err)
 			return valid(nil), beacon.InvalidPayloadAttributes.With(err)
@@ -255,8 +293,35 @@ func (api *ConsensusAPI) GetPayloadV1(payloadID beacon.PayloadID) (*beacon.Execu
 	return data, nil
 }
 
+// GetPayloadV2 returns a cached payload by id. The payload includes the
+// withdrawals list for blocks built after Shanghai.
+func (api *ConsensusAPI) GetPayloadV2(payloadID beacon.PayloadID) (*beacon.ExecutableDataV1, error) {
+	return api.GetPayloadV1(payloadID)
+}
+
 // NewPayloadV1 creates an mbl1 block, inserts it in the chain, and returns the status of the chain.
 func (api *ConsensusAPI) NewPayloadV1(params beacon.ExecutableDataV1) (beacon.PayloadStatusV1, error) {
+	if params.Withdrawals != nil {
+		return beacon.PayloadStatusV1{Status: beacon.INVALID}, beacon.InvalidParams.With(errors.New("withdrawals not supported in V1"))
+	}
+	return api.newPayload(params)
+}
+
+// NewPayloadV2 creates an mbl1 block, inserts it in the chain, and returns the
+// status of the chain. Withdrawals must be set for Shanghai payloads and must
+// be omitted for earlier ones.
+func (api *ConsensusAPI) NewPayloadV2(params beacon.ExecutableDataV1) (beacon.PayloadStatusV1, error) {
+	if api.mbl.BlockChain().Config().IsShanghai(new(big.Int).SetUint64(params.Number), params.Timestamp) {
+		if params.Withdrawals == nil {
+			return beacon.PayloadStatusV1{Status: beacon.INVALID}, beacon.InvalidParams.With(errors.New("nil withdrawals post-shanghai"))
+		}
+	} else if params.Withdrawals != nil {
+		return beacon.PayloadStatusV1{Status: beacon.INVALID}, beacon.InvalidParams.With(errors.New("non-nil withdrawals pre-shanghai"))
+	}
+	return api.newPayload(params)
+}
+
+func (api *ConsensusAPI) newPayload(params beacon.ExecutableDataV1) (beacon.PayloadStatusV1, error) {
 	log.Trace("Engine API request received", "mmblod", "ExecutePayload", "number", params.Number, "hash", params.BlockHash)
 	block, err := beacon.ExecutableDataToBlock(params)
 	if err != nil {
@@ -344,6 +409,9 @@ func computePayloadId(headBlockHash common.Hash, params *beacon.PayloadAttribute
 	binary.Write(hasher, binary.BigEndian, params.Timestamp)
 	hasher.Write(params.Random[:])
 	hasher.Write(params.SuggestedFeeRecipient[:])
+	if params.Withdrawals != nil {
+		rlp.Encode(hasher, params.Withdrawals)
+	}
 	var out beacon.PayloadID
 	copy(out[:], hasher.Sum(nil)[:8])
 	return out
@@ -603,7 +603,7 @@ func TestNewPayloadOnInvalidChain(t *testing.T) {
 }
 
 func assembleBlock(api *ConsensusAPI, parentHash common.Hash, params *beacon.PayloadAttributesV1) (*beacon.ExecutableDataV1, error) {
-	block, err := api.mbl.Miner().GetSealingBlockSync(parentHash, params.Timestamp, params.SuggestedFeeRecipient, params.Random, false)
+	block, err := api.mbl.Miner().GetSealingBlockSync(parentHash, params.Timestamp, params.SuggestedFeeRecipient, params.Random, params.Withdrawals, false)
 	if err != nil {
 		return nil, err
 	}
@@ -846,7 +846,7 @@ func TestNewPayloadOnInvalidTerminalBlock(t *testing.T) {
 		Random:                crypto.Keccak256Hash([]byte{byte(1)}),
 		SuggestedFeeRecipient: parent.Coinbase(),
 	}
-	empty, err := api.mbl.Miner().GetSealingBlockSync(parent.Hash(), params.Timestamp, params.SuggestedFeeRecipient, params.Random, true)
+	empty, err := api.mbl.Miner().GetSealingBlockSync(parent.Hash(), params.Timestamp, params.SuggestedFeeRecipient, params.Random, params.Withdrawals, true)
 	if err != nil {
 		t.Fatalf("error preparing payload, err=%v", err)
 	}
@@ -859,3 +859,37 @@ func TestNewPayloadOnInvalidTerminalBlock(t *testing.T) {
 		t.Fatalf("error sending invalid forkchoice, invalid status: %v", resp.PayloadStatus.Status)
 	}
 }
+
+func TestWithdrawalsBeforeShanghai(t *testing.T) {
+	genesis, preMergeBlocks := generatePreMergeChain(10)
+	n, mblservice := startmblService(t, genesis, preMergeBlocks)
+	defer n.Close()
+
+	api := NewConsensusAPI(mblservice)
+	parent := mblservice.BlockChain().CurrentBlock()
+	fcState := beacon.ForkchoiceStateV1{
+		HeadBlockHash: parent.Hash(),
+	}
+	params := beacon.PayloadAttributesV1{
+		Timestamp:   parent.Time() + 5,
+		Withdrawals: []*types.Withdrawal{{Index: 0, Validator: 1, Address: testAddr, Amount: 1}},
+	}
+	if _, err := api.ForkchoiceUpdatedV1(fcState, &params); err == nil {
+		t.Fatal("expected V1 forkchoice update with withdrawals to fail")
+	}
+	if _, err := api.ForkchoiceUpdatedV2(fcState, &params); err == nil {
+		t.Fatal("expected V2 forkchoice update with withdrawals before Shanghai to fail")
+	}
+	params.Withdrawals = nil
+	if _, err := api.ForkchoiceUpdatedV2(fcState, &params); err != nil {
+		t.Fatalf("expected V2 forkchoice update without withdrawals to succeed: %v", err)
+	}
+	payload := getNewPayload(t, api, parent)
+	payload.Withdrawals = []*types.Withdrawal{}
+	if _, err := api.NewPayloadV2(*payload); err == nil {
+		t.Fatal("expected V2 payload with withdrawals before Shanghai to fail")
+	}
+	if _, err := api.NewPayloadV1(*payload); err == nil {
+		t.Fatal("expected V1 payload with withdrawals to fail")
+	}
+}
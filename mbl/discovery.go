@@ -58,6 +58,7 @@ func (mbl *mbali) startmblEntryUpdate(ln *enode.LocalNode) {
 }
 
 func (mbl *mbali) currentmblEntry() *mblEntry {
+	head := mbl.blockchain.CurrentHeader()
 	return &mblEntry{ForkID: forkid.NewID(mbl.blockchain.Config(), mbl.blockchain.Genesis().Hash(),
-		mbl.blockchain.CurrentHeader().Number.Uint64())}
+		head.Number.Uint64(), head.Time)}
 }
@@ -349,7 +349,7 @@ func XTestDelivery(t *testing.T) {
 					uncleHashes[i] = types.CalcUncleHash(uncles)
 				}
 				time.Sleep(100 * time.Millisecond)
-				_, err := q.DeliverBodies(peer.id, txset, txsHashes, uncleset, uncleHashes)
+				_, err := q.DeliverBodies(peer.id, txset, txsHashes, uncleset, uncleHashes, make([][]*types.Withdrawal, len(txset)), make([]common.Hash, len(txset)))
 				if err != nil {
 					fmt.Printf("delivered %d bodies %v\n", len(txset), err)
 				}
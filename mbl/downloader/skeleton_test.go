@@ -774,7 +774,7 @@ func TestSkeletonSyncRetrievals(t *testing.T) {
 		}
 		// Create a peer dropper to track malicious peers
 		dropped := make(map[string]int)
-		drop := func(peer string) {
+		drop := func(peer string, reason error, misbehaved bool) {
 			if p := peerset.Peer(peer); p != nil {
 				atomic.AddUint64(&p.peer.(*skeletonTestPeer).dropped, 1)
 			}
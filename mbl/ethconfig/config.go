@@ -170,6 +170,7 @@ type Config struct {
 	TrieTimeout             time.Duration
 	SnapshotCache           int
 	Preimages               bool
+	ArchiveIndex            bool `toml:",omitempty"` // Whmbler to maintain the flat archive index of historical state
 
 	// Mining options
 	Miner miner.Config
@@ -186,6 +187,10 @@ type Config struct {
 	// Enables tracking of SHA3 preimages in the VM
 	EnablePreimageRecording bool
 
+	// Live tracing of the imported blocks
+	VMTrace       string `toml:",omitempty"` // Name of the tracer to run against every imported block
+	VMTraceOutput string `toml:",omitempty"` // File the live traces are appended to
+
 	// Miscellaneous options
 	DocRoot string `toml:"-"`
 
@@ -48,11 +48,14 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		TrieTimeout                     time.Duration
 		SnapshotCache                   int
 		Preimages                       bool
+		ArchiveIndex                    bool `toml:",omitempty"`
 		Miner                           miner.Config
 		mblash                          mblash.Config
 		TxPool                          core.TxPoolConfig
 		GPO                             gasprice.Config
 		EnablePreimageRecording         bool
+		VMTrace                         string `toml:",omitempty"`
+		VMTraceOutput                   string `toml:",omitempty"`
 		DocRoot                         string `toml:"-"`
 		RPCGasCap                       uint64
 		RPCEVMTimeout                   time.Duration
@@ -93,11 +96,14 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.TrieTimeout = c.TrieTimeout
 	enc.SnapshotCache = c.SnapshotCache
 	enc.Preimages = c.Preimages
+	enc.ArchiveIndex = c.ArchiveIndex
 	enc.Miner = c.Miner
 	enc.mblash = c.mblash
 	enc.TxPool = c.TxPool
 	enc.GPO = c.GPO
 	enc.EnablePreimageRecording = c.EnablePreimageRecording
+	enc.VMTrace = c.VMTrace
+	enc.VMTraceOutput = c.VMTraceOutput
 	enc.DocRoot = c.DocRoot
 	enc.RPCGasCap = c.RPCGasCap
 	enc.RPCEVMTimeout = c.RPCEVMTimeout
@@ -142,11 +148,14 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		TrieTimeout                     *time.Duration
 		SnapshotCache                   *int
 		Preimages                       *bool
+		ArchiveIndex                    *bool `toml:",omitempty"`
 		Miner                           *miner.Config
 		mblash                          *mblash.Config
 		TxPool                          *core.TxPoolConfig
 		GPO                             *gasprice.Config
 		EnablePreimageRecording         *bool
+		VMTrace                         *string `toml:",omitempty"`
+		VMTraceOutput                   *string `toml:",omitempty"`
 		DocRoot                         *string `toml:"-"`
 		RPCGasCap                       *uint64
 		RPCEVMTimeout                   *time.Duration
@@ -250,6 +259,9 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.Preimages != nil {
 		c.Preimages = *dec.Preimages
 	}
+	if dec.ArchiveIndex != nil {
+		c.ArchiveIndex = *dec.ArchiveIndex
+	}
 	if dec.Miner != nil {
 		c.Miner = *dec.Miner
 	}
@@ -265,6 +277,12 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.EnablePreimageRecording != nil {
 		c.EnablePreimageRecording = *dec.EnablePreimageRecording
 	}
+	if dec.VMTrace != nil {
+		c.VMTrace = *dec.VMTrace
+	}
+	if dec.VMTraceOutput != nil {
+		c.VMTraceOutput = *dec.VMTraceOutput
+	}
 	if dec.DocRoot != nil {
 		c.DocRoot = *dec.DocRoot
 	}
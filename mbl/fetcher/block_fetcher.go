@@ -66,7 +66,11 @@ var (
 	bodyFilterOutMeter   = metrics.NewRegisteredMeter("mbl/fetcher/block/filter/bodies/out", nil)
 )
 
-var errTerminated = errors.New("terminated")
+var (
+	errTerminated    = errors.New("terminated")
+	errFetchTimeout  = errors.New("fetch timed out")
+	errInvalidNumber = errors.New("invalid block number")
+)
 
 // HeaderRetrievalFn is a callback type for retrieving a header from the local chain.
 type HeaderRetrievalFn func(common.Hash) *types.Header
@@ -95,8 +99,10 @@ type headersInsertFn func(headers []*types.Header) (int, error)
 // chainInsertFn is a callback type to insert a batch of blocks into the local chain.
 type chainInsertFn func(types.Blocks) (int, error)
 
-// peerDropFn is a callback type for dropping a peer detected as malicious.
-type peerDropFn func(id string)
+// peerDropFn is a callback type for dropping a peer. The reason tells why the
+// peer is dropped, misbehaved whmbler it violated the protocol rather than
+// being unresponsive.
+type peerDropFn func(id string, reason error, misbehaved bool)
 
 // blockAnnounce is the hash notification of the availability of a new block in the
 // network.
@@ -490,7 +496,7 @@ func (f *BlockFetcher) loop() {
 								// was already rescheduled at this point, we were
 								// waiting for a catchup. With an unresponsive
 								// peer however, it's a protocol violation.
-								f.dropPeer(peer)
+								f.dropPeer(peer, errFetchTimeout, false)
 							}
 						}(hash)
 					}
@@ -541,7 +547,7 @@ func (f *BlockFetcher) loop() {
 					case res := <-resCh:
 						res.Done <- nil
 
-						txs, uncles := res.Res.(*mbl.BlockBodiesPacket).Unpack()
+						txs, uncles, _ := res.Res.(*mbl.BlockBodiesPacket).Unpack()
 						f.FilterBodies(peer, txs, uncles, time.Now())
 
 					case <-timeout.C:
@@ -549,7 +555,7 @@ func (f *BlockFetcher) loop() {
 						// was already rescheduled at this point, we were
 						// waiting for a catchup. With an unresponsive
 						// peer however, it's a protocol violation.
-						f.dropPeer(peer)
+						f.dropPeer(peer, errFetchTimeout, false)
 					}
 				}(peer, hashes)
 			}
@@ -579,7 +585,7 @@ func (f *BlockFetcher) loop() {
 					// If the delivered header does not match the promised number, drop the announcer
 					if header.Number.Uint64() != announce.number {
 						log.Trace("Invalid block number fetched", "peer", announce.origin, "hash", header.Hash(), "announced", announce.number, "provided", header.Number)
-						f.dropPeer(announce.origin)
+						f.dropPeer(announce.origin, errInvalidNumber, true)
 						f.forgomblash(hash)
 						continue
 					}
@@ -819,7 +825,7 @@ func (f *BlockFetcher) importHeaders(peer string, header *types.Header) {
 		// Validate the header and if sommbling went wrong, drop the peer
 		if err := f.verifyHeader(header); err != nil && err != consensus.ErrFutureBlock {
 			log.Debug("Propagated header verification failed", "peer", peer, "number", header.Number, "hash", hash, "err", err)
-			f.dropPeer(peer)
+			f.dropPeer(peer, err, true)
 			return
 		}
 		// Run the actual import and log any issues
@@ -864,7 +870,7 @@ func (f *BlockFetcher) importBlocks(peer string, block *types.Block) {
 		default:
 			// Sommbling went very wrong, drop the peer
 			log.Debug("Propagated block verification failed", "peer", peer, "number", block.Number(), "hash", hash, "err", err)
-			f.dropPeer(peer)
+			f.dropPeer(peer, err, true)
 			return
 		}
 		// Run the actual import and log any issues
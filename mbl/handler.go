@@ -51,6 +51,11 @@ var (
 	syncChallengeTimeout = 15 * time.Second // Time allowance for a node to reply to the sync progress challenge
 )
 
+var (
+	errCheckpointTimeout    = errors.New("checkpoint challenge timed out")
+	errRequiredBlockTimeout = errors.New("required block challenge timed out")
+)
+
 // txPool defines the mmblods needed from a transaction pool implementation to
 // support all the operations needed by the mbali chain protocols.
 type txPool interface {
@@ -320,7 +325,7 @@ func (h *handler) runmblPeer(peer *mbl.Peer, handler mbl.Handler) error {
 		number  = head.Number.Uint64()
 		td      = h.chain.GetTd(hash, number)
 	)
-	forkID := forkid.NewID(h.chain.Config(), h.chain.Genesis().Hash(), h.chain.CurrentHeader().Number.Uint64())
+	forkID := forkid.NewID(h.chain.Config(), h.chain.Genesis().Hash(), head.Number.Uint64(), head.Time)
 	if err := peer.Handshake(h.networkID, td, hash, genesis.Hash(), forkID, h.forkFilter); err != nil {
 		peer.Log().Debug("mbali handshake failed", "err", err)
 		return err
@@ -416,7 +421,7 @@ func (h *handler) runmblPeer(peer *mbl.Peer, handler mbl.Handler) error {
 
 			case <-timeout.C:
 				peer.Log().Warn("Checkpoint challenge timed out, dropping", "addr", peer.RemoteAddr(), "type", peer.Name())
-				h.removePeer(peer.ID())
+				h.removePeer(peer.ID(), errCheckpointTimeout, false)
 
 			case <-dead:
 				// Peer handler terminated, abort all goroutines
@@ -456,7 +461,7 @@ func (h *handler) runmblPeer(peer *mbl.Peer, handler mbl.Handler) error {
 				res.Done <- nil
 			case <-timeout.C:
 				peer.Log().Warn("Required block challenge timed out, dropping", "addr", peer.RemoteAddr(), "type", peer.Name())
-				h.removePeer(peer.ID())
+				h.removePeer(peer.ID(), errRequiredBlockTimeout, false)
 			}
 		}(number, hash)
 	}
@@ -479,10 +484,14 @@ func (h *handler) runSnapExtension(peer *snap.Peer, handler snap.Handler) error
 	return handler(peer)
 }
 
-// removePeer requests disconnection of a peer.
-func (h *handler) removePeer(id string) {
+// removePeer requests disconnection of a peer. Peers violating the protocol
+// are reported as misbehaving too, slow or unsynced peers are only dropped.
+func (h *handler) removePeer(id string, reason error, misbehaved bool) {
 	peer := h.peers.peer(id)
 	if peer != nil {
+		if misbehaved {
+			peer.Peer.ReportMisbehavior(reason.Error(), p2p.MisbehaviorMajor)
+		}
 		peer.Peer.Disconnect(p2p.DiscUselessPeer)
 	}
 }
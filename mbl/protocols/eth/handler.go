@@ -17,6 +17,7 @@
 package mbl
 
 import (
+	"errors"
 	"fmt"
 	"math/big"
 	"time"
@@ -102,7 +103,7 @@ func MakeProtocols(backend Backend, network uint64, dnsdisc enode.Iterator) []p2
 		protocols[i] = p2p.Protocol{
 			Name:    ProtocolName,
 			Version: version,
-			Length:  protocolLengths[version],
+			Length:  ProtocolLengths[version],
 			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
 				peer := NewPeer(version, p, rw, backend.TxPool())
 				defer peer.Close()
@@ -153,11 +154,23 @@ func Handle(backend Backend, peer *Peer) error {
 	for {
 		if err := handleMessage(backend, peer); err != nil {
 			peer.Log().Debug("Message handling failed in `mbl`", "err", err)
+			reportMisbehavior(peer, err)
 			return err
 		}
 	}
 }
 
+// reportMisbehavior reports the protocol violations among the message handling
+// failures to the peer's reputation tracker.
+func reportMisbehavior(peer *Peer, err error) {
+	switch {
+	case errors.Is(err, errDecode), errors.Is(err, errMsgTooLarge), errors.Is(err, errInvalidMsgCode):
+		peer.ReportMisbehavior(err.Error(), p2p.MisbehaviorCritical)
+	case errors.Is(err, errDanglingResponse), errors.Is(err, errMismatchingResponseType):
+		peer.ReportMisbehavior(err.Error(), p2p.MisbehaviorMinor)
+	}
+}
+
 type msgHandler func(backend Backend, msg Decoder, peer *Peer) error
 type Decoder interface {
 	Decode(val interface{}) error
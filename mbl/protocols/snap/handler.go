@@ -18,6 +18,7 @@ package snap
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"time"
 
@@ -122,11 +123,23 @@ func Handle(backend Backend, peer *Peer) error {
 	for {
 		if err := HandleMessage(backend, peer); err != nil {
 			peer.Log().Debug("Message handling failed in `snap`", "err", err)
+			reportMisbehavior(peer, err)
 			return err
 		}
 	}
 }
 
+// reportMisbehavior reports the protocol violations among the message handling
+// failures to the peer's reputation tracker.
+func reportMisbehavior(peer *Peer, err error) {
+	switch {
+	case errors.Is(err, errDecode), errors.Is(err, errMsgTooLarge), errors.Is(err, errInvalidMsgCode):
+		peer.ReportMisbehavior(err.Error(), p2p.MisbehaviorCritical)
+	case errors.Is(err, errBadRequest):
+		peer.ReportMisbehavior(err.Error(), p2p.MisbehaviorMajor)
+	}
+}
+
 // HandleMessage is invoked whenever an inbound message is received from a
 // remote peer on the `snap` protocol. The remote connection is torn down upon
 // returning any error.
@@ -0,0 +1,135 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracetest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/core/state"
+	"github.com/mbali/go-mbali/core/vm"
+	"github.com/mbali/go-mbali/core/vm/runtime"
+	"github.com/mbali/go-mbali/mbl/tracers"
+)
+
+type profileOp struct {
+	Pc    uint64 `json:"pc"`
+	Op    string `json:"op"`
+	Count uint64 `json:"count"`
+	Gas   uint64 `json:"gas"`
+}
+
+type profileContract struct {
+	Count uint64       `json:"count"`
+	Gas   uint64       `json:"gas"`
+	Ops   []*profileOp `json:"ops"`
+}
+
+type profileResult struct {
+	Contracts map[common.Address]*profileContract `json:"contracts"`
+	Pprof     []byte                              `json:"pprof"`
+}
+
+// Tests that the profiler attributes the gas of every executed opcode to the
+// contract executing it, without double counting the gas of the callees.
+func TestProfileTracer(t *testing.T) {
+	var (
+		parent = common.HexToAddress("0xaa")
+		child  = common.HexToAddress("0xbb")
+	)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.SetCode(child, []byte{
+		byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.SSTORE), byte(vm.STOP),
+	})
+	code := []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.DUP1), byte(vm.DUP1), byte(vm.DUP1), // in and outs zero, value zero
+		byte(vm.PUSH1), 0xbb, byte(vm.GAS), byte(vm.CALL), byte(vm.POP),
+		byte(vm.PUSH1), 0x02, byte(vm.PUSH1), 0x03, byte(vm.ADD), byte(vm.POP), byte(vm.STOP),
+	}
+	statedb.SetCode(parent, code)
+
+	for _, name := range []string{"profileTracer", "pprofTracer"} {
+		tracer, err := tracers.New(name, nil)
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+		cfg := &runtime.Config{
+			State:     statedb.Copy(),
+			GasLimit:  100000,
+			EVMConfig: vm.Config{Debug: true, Tracer: tracer},
+		}
+		_, leftOver, err := runtime.Call(parent, common.FromHex("0xdeadbeef"), cfg)
+		if err != nil {
+			t.Fatalf("%s: failed to execute: %v", name, err)
+		}
+		res, err := tracer.GetResult()
+		if err != nil {
+			t.Fatalf("%s: failed to retrieve trace result: %v", name, err)
+		}
+		result := new(profileResult)
+		if err := json.Unmarshal(res, result); err != nil {
+			t.Fatalf("%s: failed to unmarshal trace result: %v", name, err)
+		}
+		if len(result.Contracts) != 2 || result.Contracts[parent] == nil || result.Contracts[child] == nil {
+			t.Fatalf("%s: profiled contracts mismatch: have %v", name, result.Contracts)
+		}
+		if have, want := result.Contracts[parent].Count, uint64(14); have != want {
+			t.Errorf("%s: parent step count mismatch: have %d, want %d", name, have, want)
+		}
+		if have, want := len(result.Contracts[child].Ops), 4; have != want {
+			t.Errorf("%s: child opcode count mismatch: have %d, want %d", name, have, want)
+		}
+		var total uint64
+		for _, contract := range result.Contracts {
+			var sum uint64
+			for _, op := range contract.Ops {
+				sum += op.Gas
+			}
+			if sum != contract.Gas {
+				t.Errorf("%s: contract gas mismatch: have %d, ops sum %d", name, contract.Gas, sum)
+			}
+			total += sum
+		}
+		if used := cfg.GasLimit - leftOver; total != used {
+			t.Errorf("%s: profiled gas mismatch: have %d, want %d", name, total, used)
+		}
+		if name == "profileTracer" {
+			if result.Pprof != nil {
+				t.Errorf("%s: unexpected pprof profile", name)
+			}
+			continue
+		}
+		r, err := gzip.NewReader(bytes.NewReader(result.Pprof))
+		if err != nil {
+			t.Fatalf("%s: invalid pprof profile: %v", name, err)
+		}
+		blob, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: invalid pprof profile: %v", name, err)
+		}
+		for _, frame := range []string{parent.Hex() + ":0xdeadbeef", child.Hex(), "SSTORE"} {
+			if !bytes.Contains(blob, []byte(frame)) {
+				t.Errorf("%s: pprof profile misses frame %q", name, frame)
+			}
+		}
+	}
+}
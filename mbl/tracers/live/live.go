@@ -0,0 +1,217 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package live implements tracing of the blocks imported by the local chain.
+//
+// A live tracer is attached to core.BlockChain through vm.Config. It runs a
+// fresh instance of a named transaction tracer (e.g. callTracer) for every
+// transaction of every processed block and hands the collected results to a
+// Sink once the block is done, avoiding the historical re-execution the
+// debug_trace* RPC mmblods need.
+//
+// Blocks are traced when they are processed, which does not imply they become
+// canonical: processing may still fail validation afterwards and side chain
+// blocks are processed too. Sinks are therefore notified of the blocks dropped
+// from the canonical chain by reorgs, and consumers should key traces by block
+// hash.
+package live
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/core/tracing"
+	"github.com/mbali/go-mbali/core/types"
+	"github.com/mbali/go-mbali/core/vm"
+	"github.com/mbali/go-mbali/log"
+	"github.com/mbali/go-mbali/mbl/tracers"
+)
+
+// TxTrace is the result of tracing a single transaction.
+type TxTrace struct {
+	TxHash common.Hash     `json:"txHash"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// BlockTrace is the result of tracing all transactions of a block.
+type BlockTrace struct {
+	Number     uint64      `json:"number"`
+	Hash       common.Hash `json:"hash"`
+	ParentHash common.Hash `json:"parentHash"`
+	Txs        []*TxTrace  `json:"txs"`
+}
+
+// Tracer is a vm.EVMLogger running a named transaction tracer against every
+// transaction of the blocks processed by the chain it is attached to.
+type Tracer struct {
+	name string
+	sink Sink
+
+	block   *types.Block   // Block being processed, nil outside of block processing
+	trace   *BlockTrace    // Results collected for the block being processed
+	current tracers.Tracer // Tracer of the transaction being executed, if any
+}
+
+// New creates a live tracer running the named transaction tracer, writing the
+// traces into the given sink.
+func New(name string, sink Sink) (*Tracer, error) {
+	if sink == nil {
+		return nil, errors.New("live tracer sink not specified")
+	}
+	// Make sure the tracer exists before attaching it to the chain
+	if _, err := tracers.New(name, new(tracers.Context)); err != nil {
+		return nil, err
+	}
+	return &Tracer{name: name, sink: sink}, nil
+}
+
+// Close closes the underlying sink.
+func (t *Tracer) Close() error {
+	return t.sink.Close()
+}
+
+// OnBlockStart implements tracing.BlockHooks, starting the collection of the
+// traces of a block.
+func (t *Tracer) OnBlockStart(block *types.Block) {
+	t.block = block
+	t.trace = &BlockTrace{
+		Number:     block.NumberU64(),
+		Hash:       block.Hash(),
+		ParentHash: block.ParentHash(),
+		Txs:        make([]*TxTrace, 0, len(block.Transactions())),
+	}
+}
+
+// OnBlockEnd implements tracing.BlockHooks, handing the traces of a successfully
+// processed block to the sink.
+func (t *Tracer) OnBlockEnd(err error) {
+	block, trace := t.block, t.trace
+	t.block, t.trace, t.current = nil, nil, nil
+
+	if err != nil || trace == nil {
+		return
+	}
+	if err := t.sink.WriteBlock(trace); err != nil {
+		log.Warn("Failed to write live block trace", "number", block.Number(), "hash", block.Hash(), "err", err)
+	}
+}
+
+// OnReorg implements tracing.ChainHooks, notifying the sink about the blocks
+// dropped from the canonical chain.
+func (t *Tracer) OnReorg(dropped []*types.Block) {
+	hashes := make([]common.Hash, len(dropped))
+	for i, block := range dropped {
+		hashes[i] = block.Hash()
+	}
+	if err := t.sink.Reorg(hashes); err != nil {
+		log.Warn("Failed to write live trace reorg", "dropped", len(hashes), "err", err)
+	}
+}
+
+// CaptureTxStart implements vm.EVMLogger, creating a new tracer instance for
+// the transaction about to be executed.
+func (t *Tracer) CaptureTxStart(gasLimit uint64) {
+	if t.block == nil {
+		return
+	}
+	index := len(t.trace.Txs)
+	txs := t.block.Transactions()
+	if index >= len(txs) {
+		return
+	}
+	tracer, err := tracers.New(t.name, &tracers.Context{
+		BlockHash: t.trace.Hash,
+		TxIndex:   index,
+		TxHash:    txs[index].Hash(),
+	})
+	if err != nil {
+		log.Warn("Failed to create live transaction tracer", "tracer", t.name, "err", err)
+		t.trace.Txs = append(t.trace.Txs, &TxTrace{TxHash: txs[index].Hash(), Error: err.Error()})
+		return
+	}
+	t.current = tracer
+	t.current.CaptureTxStart(gasLimit)
+}
+
+// CaptureTxEnd implements vm.EVMLogger, collecting the result of the tracer of
+// the executed transaction.
+func (t *Tracer) CaptureTxEnd(restGas uint64) {
+	if t.current == nil {
+		return
+	}
+	t.current.CaptureTxEnd(restGas)
+
+	trace := &TxTrace{TxHash: t.block.Transactions()[len(t.trace.Txs)].Hash()}
+	if res, err := t.current.GetResult(); err != nil {
+		trace.Error = err.Error()
+	} else {
+		trace.Result = res
+	}
+	t.trace.Txs = append(t.trace.Txs, trace)
+	t.current = nil
+}
+
+// CaptureStart implements vm.EVMLogger.
+func (t *Tracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	if t.current != nil {
+		t.current.CaptureStart(env, from, to, create, input, gas, value)
+	}
+}
+
+// CaptureEnd implements vm.EVMLogger.
+func (t *Tracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) {
+	if t.current != nil {
+		t.current.CaptureEnd(output, gasUsed, d, err)
+	}
+}
+
+// CaptureEnter implements vm.EVMLogger.
+func (t *Tracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	if t.current != nil {
+		t.current.CaptureEnter(typ, from, to, input, gas, value)
+	}
+}
+
+// CaptureExit implements vm.EVMLogger.
+func (t *Tracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if t.current != nil {
+		t.current.CaptureExit(output, gasUsed, err)
+	}
+}
+
+// CaptureState implements vm.EVMLogger.
+func (t *Tracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if t.current != nil {
+		t.current.CaptureState(pc, op, gas, cost, scope, rData, depth, err)
+	}
+}
+
+// CaptureFault implements vm.EVMLogger.
+func (t *Tracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+	if t.current != nil {
+		t.current.CaptureFault(pc, op, gas, cost, scope, depth, err)
+	}
+}
+
+var (
+	_ vm.EVMLogger       = (*Tracer)(nil)
+	_ tracing.BlockHooks = (*Tracer)(nil)
+	_ tracing.ChainHooks = (*Tracer)(nil)
+)
@@ -0,0 +1,176 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package live
+
+import (
+	"bufio"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/consensus/mblash"
+	"github.com/mbali/go-mbali/core"
+	"github.com/mbali/go-mbali/core/rawdb"
+	"github.com/mbali/go-mbali/core/types"
+	"github.com/mbali/go-mbali/core/vm"
+	"github.com/mbali/go-mbali/crypto"
+	_ "github.com/mbali/go-mbali/mbl/tracers/native"
+	"github.com/mbali/go-mbali/params"
+)
+
+func TestNewUnknownTracer(t *testing.T) {
+	if _, err := New("noSuchTracer", NewChanSink(make(chan *Event))); err == nil {
+		t.Fatal("unknown tracer accepted")
+	}
+	if _, err := New("callTracer", nil); err == nil {
+		t.Fatal("missing sink accepted")
+	}
+}
+
+// Tests that a live tracer attached to the chain traces every transaction of
+// the imported blocks and reports the blocks dropped by reorgs.
+func TestLiveTracing(t *testing.T) {
+	var (
+		config = params.TestChainConfig
+		signer = types.LatestSigner(config)
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		sender = crypto.PubkeyToAddress(key.PublicKey)
+		db     = rawdb.NewMemoryDatabase()
+		gspec  = &core.Genesis{
+			Config: config,
+			Alloc:  core.GenesisAlloc{sender: {Balance: big.NewInt(1000000000000000000)}},
+		}
+		genesis = gspec.MustCommit(db)
+		engine  = mblash.NewFaker()
+	)
+	makeChain := func(n int, coinbase common.Address) []*types.Block {
+		blocks, _ := core.GenerateChain(config, genesis, engine, db, n, func(i int, gen *core.BlockGen) {
+			gen.SetCoinbase(coinbase)
+			tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(sender), common.Address{0xaa}, big.NewInt(1), params.TxGas, gen.BaseFee(), nil), signer, key)
+			gen.AddTx(tx)
+		})
+		return blocks
+	}
+	events := make(chan *Event, 16)
+	tracer, err := New("callTracer", NewChanSink(events))
+	if err != nil {
+		t.Fatalf("failed to create live tracer: %v", err)
+	}
+	defer tracer.Close()
+
+	chain, err := core.NewBlockChain(db, nil, config, engine, vm.Config{Debug: true, Tracer: tracer}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if cfg := chain.GetVMConfig(); cfg.Debug || cfg.Tracer != nil {
+		t.Fatal("live tracer leaked through the chain VM config")
+	}
+	// Import a short chain and check every block got traced
+	original := makeChain(2, common.Address{0x01})
+	if n, err := chain.InsertChain(original); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	for _, block := range original {
+		event := <-events
+		if event.Block == nil || event.Block.Hash != block.Hash() {
+			t.Fatalf("block event mismatch: have %+v, want block %x", event, block.Hash())
+		}
+		if len(event.Block.Txs) != 1 || event.Block.Txs[0].TxHash != block.Transactions()[0].Hash() {
+			t.Fatalf("block %d transaction traces mismatch: have %+v", block.NumberU64(), event.Block.Txs)
+		}
+		var frame struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(event.Block.Txs[0].Result, &frame); err != nil || frame.Type != "CALL" {
+			t.Fatalf("block %d call trace mismatch: %s (%v)", block.NumberU64(), event.Block.Txs[0].Result, err)
+		}
+	}
+	// Import a heavier fork and check the original blocks get reported dropped
+	fork := makeChain(3, common.Address{0x02})
+	if n, err := chain.InsertChain(fork); err != nil {
+		t.Fatalf("block %d: failed to insert fork into chain: %v", n, err)
+	}
+	var (
+		traced  = make(map[common.Hash]bool)
+		dropped = make(map[common.Hash]bool)
+	)
+	for len(events) > 0 {
+		event := <-events
+		if event.Block != nil {
+			traced[event.Block.Hash] = true
+		}
+		for _, hash := range event.Dropped {
+			dropped[hash] = true
+		}
+	}
+	for _, block := range fork {
+		if !traced[block.Hash()] {
+			t.Errorf("fork block %d not traced", block.NumberU64())
+		}
+	}
+	for _, block := range original {
+		if !dropped[block.Hash()] {
+			t.Errorf("reorged block %d not reported dropped", block.NumberU64())
+		}
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatalf("failed to create file sink: %v", err)
+	}
+	trace := &BlockTrace{Number: 1, Hash: common.Hash{0x01}, Txs: []*TxTrace{{TxHash: common.Hash{0x02}, Result: json.RawMessage(`{}`)}}}
+	if err := sink.WriteBlock(trace); err != nil {
+		t.Fatalf("failed to write block: %v", err)
+	}
+	if err := sink.Reorg([]common.Hash{{0x01}}); err != nil {
+		t.Fatalf("failed to write reorg: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open trace file: %v", err)
+	}
+	defer file.Close()
+
+	var events []*Event
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		event := new(Event)
+		if err := json.Unmarshal(scanner.Bytes(), event); err != nil {
+			t.Fatalf("failed to decode event %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 2 {
+		t.Fatalf("event count mismatch: have %d, want 2", len(events))
+	}
+	if events[0].Block == nil || events[0].Block.Hash != trace.Hash || len(events[0].Block.Txs) != 1 {
+		t.Errorf("block event mismatch: have %+v", events[0].Block)
+	}
+	if len(events[1].Dropped) != 1 || events[1].Dropped[0] != trace.Hash {
+		t.Errorf("reorg event mismatch: have %v", events[1].Dropped)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package live
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/mbali/go-mbali/common"
+)
+
+var errSinkClosed = errors.New("live trace sink closed")
+
+// Sink is the destination of the traces collected by a live tracer. Sinks are
+// called synchronously from the block import, so they should be fast.
+type Sink interface {
+	// WriteBlock is called with the traces of every processed block.
+	WriteBlock(trace *BlockTrace) error
+
+	// Reorg is called with the hashes of the blocks dropped from the canonical
+	// chain, whose traces were previously written.
+	Reorg(dropped []common.Hash) error
+
+	// Close releases any resources held by the sink.
+	Close() error
+}
+
+// Event is a single notification of a live tracer, either the traces of a
+// processed block or the list of blocks dropped by a reorg.
+type Event struct {
+	Block   *BlockTrace   `json:"block,omitempty"`
+	Dropped []common.Hash `json:"dropped,omitempty"`
+}
+
+// FileSink is a Sink appending the events as JSON lines to a file.
+type FileSink struct {
+	lock sync.Mutex
+	file *os.File
+	buf  *bufio.Writer
+	enc  *json.Encoder
+}
+
+// NewFileSink opens the file at the given path for appending traces to it.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewWriter(file)
+	return &FileSink{file: file, buf: buf, enc: json.NewEncoder(buf)}, nil
+}
+
+// WriteBlock implements Sink, appending the block traces to the file.
+func (s *FileSink) WriteBlock(trace *BlockTrace) error {
+	return s.write(&Event{Block: trace})
+}
+
+// Reorg implements Sink, appending the dropped block hashes to the file.
+func (s *FileSink) Reorg(dropped []common.Hash) error {
+	return s.write(&Event{Dropped: dropped})
+}
+
+// write encodes a single event and flushes it to the file, so that readers
+// tailing it always see complete lines.
+func (s *FileSink) write(event *Event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.enc.Encode(event); err != nil {
+		return err
+	}
+	return s.buf.Flush()
+}
+
+// Close implements Sink, closing the file.
+func (s *FileSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.buf.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// ChanSink is a Sink delivering the events to a local consumer over a channel.
+// Delivery blocks the block import until the consumer reads the event.
+type ChanSink struct {
+	events chan<- *Event
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewChanSink creates a sink delivering events into the given channel.
+func NewChanSink(events chan<- *Event) *ChanSink {
+	return &ChanSink{events: events, closed: make(chan struct{})}
+}
+
+// WriteBlock implements Sink, delivering the block traces to the consumer.
+func (s *ChanSink) WriteBlock(trace *BlockTrace) error {
+	return s.send(&Event{Block: trace})
+}
+
+// Reorg implements Sink, delivering the dropped block hashes to the consumer.
+func (s *ChanSink) Reorg(dropped []common.Hash) error {
+	return s.send(&Event{Dropped: dropped})
+}
+
+// send delivers an event unless the sink was closed.
+func (s *ChanSink) send(event *Event) error {
+	select {
+	case s.events <- event:
+		return nil
+	case <-s.closed:
+		return errSinkClosed
+	}
+}
+
+// Close implements Sink, aborting any pending and future deliveries. The
+// events channel itself is owned by the consumer and left open.
+func (s *ChanSink) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package native
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"sort"
+	"time"
+)
+
+// Field numbers of the pprof profile.proto messages used by the encoder, see
+// https://github.com/google/pprof/blob/main/proto/profile.proto
+const (
+	pprofProfileSampleType        = 1
+	pprofProfileSample            = 2
+	pprofProfileLocation          = 4
+	pprofProfileFunction          = 5
+	pprofProfileStringTable       = 6
+	pprofProfileTimeNanos         = 9
+	pprofProfileDurationNanos     = 10
+	pprofProfileDefaultSampleType = 14
+
+	pprofValueTypeType = 1
+	pprofValueTypeUnit = 2
+
+	pprofSampleLocationID = 1
+	pprofSampleValue      = 2
+
+	pprofLocationID   = 1
+	pprofLocationLine = 4
+
+	pprofLineFunctionID = 1
+	pprofLineLine       = 2
+
+	pprofFunctionID   = 1
+	pprofFunctionName = 2
+)
+
+// protoBuffer is a minimal protobuf wire format encoder, sufficient to emit
+// pprof profiles without depending on the protobuf libraries.
+type protoBuffer struct {
+	data []byte
+	tmp  [binary.MaxVarintLen64]byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	n := binary.PutUvarint(b.tmp[:], x)
+	b.data = append(b.data, b.tmp[:n]...)
+}
+
+// uint64 encodes a varint field, omitting zero values as proto3 does.
+func (b *protoBuffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(field)<<3 | 0)
+	b.varint(x)
+}
+
+// bytes encodes a length delimited field.
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+// packed encodes a packed repeated varint field.
+func (b *protoBuffer) packed(field int, xs []uint64) {
+	var inner protoBuffer
+	for _, x := range xs {
+		inner.varint(x)
+	}
+	b.bytes(field, inner.data)
+}
+
+// message encodes an embedded message field.
+func (b *protoBuffer) message(field int, encode func(*protoBuffer)) {
+	var inner protoBuffer
+	encode(&inner)
+	b.bytes(field, inner.data)
+}
+
+// encodePprof encodes the aggregated call stacks as a gzipped pprof profile,
+// with the execution count, gas and time as sample values.
+func (t *profileTracer) encodePprof() ([]byte, error) {
+	var (
+		strs  = map[string]uint64{"": 0}
+		table = []string{""}
+	)
+	str := func(s string) uint64 {
+		if id, ok := strs[s]; ok {
+			return id
+		}
+		strs[s] = uint64(len(table))
+		table = append(table, s)
+		return strs[s]
+	}
+	var b protoBuffer
+	for _, typ := range [][2]string{{"instructions", "count"}, {"gas", "gas"}, {"time", "nanoseconds"}} {
+		typ := typ
+		b.message(pprofProfileSampleType, func(vt *protoBuffer) {
+			vt.uint64(pprofValueTypeType, str(typ[0]))
+			vt.uint64(pprofValueTypeUnit, str(typ[1]))
+		})
+	}
+	// Emit the samples in a deterministic order
+	keys := make([]string, 0, len(t.stacks))
+	for key := range t.stacks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sample := t.stacks[key]
+		b.message(pprofProfileSample, func(s *protoBuffer) {
+			s.packed(pprofSampleLocationID, sample.locs)
+			s.packed(pprofSampleValue, []uint64{sample.count, sample.gas, uint64(sample.time)})
+		})
+	}
+	locs := make([]profileLocation, len(t.locs))
+	for loc, id := range t.locs {
+		locs[id-1] = loc
+	}
+	for i, loc := range locs {
+		loc := loc
+		b.message(pprofProfileLocation, func(l *protoBuffer) {
+			l.uint64(pprofLocationID, uint64(i+1))
+			l.message(pprofLocationLine, func(line *protoBuffer) {
+				line.uint64(pprofLineFunctionID, t.funcs[loc.fn])
+				line.uint64(pprofLineLine, loc.line)
+			})
+		})
+	}
+	funcs := make([]string, len(t.funcs))
+	for fn, id := range t.funcs {
+		funcs[id-1] = fn
+	}
+	for i, fn := range funcs {
+		name := str(fn)
+		b.message(pprofProfileFunction, func(f *protoBuffer) {
+			f.uint64(pprofFunctionID, uint64(i+1))
+			f.uint64(pprofFunctionName, name)
+		})
+	}
+	if !t.start.IsZero() {
+		b.uint64(pprofProfileTimeNanos, uint64(t.start.UnixNano()))
+		b.uint64(pprofProfileDurationNanos, uint64(time.Since(t.start)))
+	}
+	b.uint64(pprofProfileDefaultSampleType, str("gas"))
+
+	// The string table must be emitted last, as it's populated by the above
+	for _, s := range table {
+		b.bytes(pprofProfileStringTable, []byte(s))
+	}
+	var out bytes.Buffer
+	w := gzip.NewWriter(&out)
+	if _, err := w.Write(b.data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
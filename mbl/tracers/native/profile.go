@@ -0,0 +1,347 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package native
+
+import (
+	"encoding/json"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mbali/go-mbali/common"
+	"github.com/mbali/go-mbali/common/hexutil"
+	"github.com/mbali/go-mbali/core/vm"
+	"github.com/mbali/go-mbali/mbl/tracers"
+)
+
+func init() {
+	register("profileTracer", newProfileTracer)
+	register("pprofTracer", newPprofTracer)
+}
+
+// profileTracer aggregates the execution count, the gas used and the wall time
+// spent per contract and per program counter. The gas and time of calls and
+// creations only cover the opcode itself, the execution of the callee is
+// attributed to the callee's own opcodes.
+//
+// The pprofTracer variant additionally returns the aggregates as a gzipped
+// pprof profile (base64 encoded in the "pprof" field of the result), with the
+// contracts, suffixed with the called function selectors, and the opcodes as
+// frames. It can be inspected with `go tool pprof`.
+//
+// Example:
+//   > debug.traceTransaction( "0x214e...", {tracer: "profileTracer"})
+//   {
+//     contracts: {
+//       0x6b175474e89094c44da98b954eedeac495271d0f: {
+//         count: 412, gas: 23615, time: 180264,
+//         ops: [{pc: 0, op: "PUSH1", count: 1, gas: 3, time: 250}, ...]
+//       }
+//     }
+//   }
+type profileTracer struct {
+	pprof bool // Whmbler to encode a pprof profile into the result
+
+	frames []*profileFrame                  // Call frames currently being executed
+	ops    map[profileOpKey]*profileOpStats // Aggregates per contract, pc and opcode
+	stacks map[string]*profileSample        // Aggregates per pprof call stack
+	locs   map[profileLocation]uint64       // Ids of the pprof locations
+	funcs  map[string]uint64                // Ids of the pprof functions
+	start  time.Time                        // Time the tracing started at
+
+	interrupt uint32 // Atomic flag to signal execution interruption
+	reason    error  // Textual reason for the interruption
+}
+
+// profileOpKey identifies an opcode within a contract.
+type profileOpKey struct {
+	addr common.Address
+	pc   uint64
+	op   vm.OpCode
+}
+
+// profileOpStats is the aggregated cost of an opcode.
+type profileOpStats struct {
+	count uint64
+	gas   uint64
+	time  time.Duration
+}
+
+// profileFrame is a call frame being executed, along with the opcode it
+// currently executes.
+type profileFrame struct {
+	addr common.Address
+	name string // Contract address and function selector, used as pprof frame
+
+	entered time.Time // Time the frame was entered at
+	pending bool      // Whmbler an opcode is being executed
+	op      profileOpKey
+	gas     uint64        // Gas available before the pending opcode
+	cost    uint64        // Cost of the pending opcode as reported by the EVM
+	started time.Time     // Time the pending opcode started at
+	subGas  uint64        // Gas used by the callees of the pending opcode
+	subTime time.Duration // Time spent in the callees of the pending opcode
+}
+
+// profileLocation is a pprof location, a line of a function.
+type profileLocation struct {
+	fn   string
+	line uint64
+}
+
+// profileSample is the aggregated cost of a pprof call stack.
+type profileSample struct {
+	locs  []uint64
+	count uint64
+	gas   uint64
+	time  time.Duration
+}
+
+// newProfileTracer returns a native go tracer which aggregates the cost of the
+// executed opcodes, and implements vm.EVMLogger.
+func newProfileTracer(ctx *tracers.Context) tracers.Tracer {
+	return &profileTracer{
+		ops:    make(map[profileOpKey]*profileOpStats),
+		stacks: make(map[string]*profileSample),
+		locs:   make(map[profileLocation]uint64),
+		funcs:  make(map[string]uint64),
+	}
+}
+
+// newPprofTracer returns a profileTracer which also encodes its results as a
+// pprof profile.
+func newPprofTracer(ctx *tracers.Context) tracers.Tracer {
+	t := newProfileTracer(ctx).(*profileTracer)
+	t.pprof = true
+	return t
+}
+
+// CaptureStart implements the EVMLogger interface to initialize the tracing operation.
+func (t *profileTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.start = time.Now()
+	t.enter(to, create, input)
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *profileTracer) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) {
+	t.exit(gasUsed)
+}
+
+// CaptureState implements the EVMLogger interface to trace a single step of VM execution.
+func (t *profileTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if len(t.frames) == 0 || atomic.LoadUint32(&t.interrupt) > 0 {
+		return
+	}
+	now := time.Now()
+	frame := t.frames[len(t.frames)-1]
+	if frame.pending {
+		// The gas consumed since the previous step, less the gas used by
+		// its callees, is the cost of the previous opcode
+		var used uint64
+		if spent := frame.gas - gas; spent > frame.subGas {
+			used = spent - frame.subGas
+		}
+		t.record(used, now)
+	}
+	frame.pending = true
+	frame.op = profileOpKey{addr: frame.addr, pc: pc, op: op}
+	frame.gas, frame.cost = gas, cost
+	frame.started = now
+	frame.subGas, frame.subTime = 0, 0
+}
+
+// CaptureFault implements the EVMLogger interface to trace an execution fault.
+func (t *profileTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, _ *vm.ScopeContext, depth int, err error) {
+}
+
+// CaptureEnter is called when EVM enters a new scope (via call, create or selfdestruct).
+func (t *profileTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	t.enter(to, typ == vm.CREATE || typ == vm.CREATE2, input)
+}
+
+// CaptureExit is called when EVM exits a scope, even if the scope didn't
+// execute any code.
+func (t *profileTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	t.exit(gasUsed)
+}
+
+func (*profileTracer) CaptureTxStart(gasLimit uint64) {}
+
+func (*profileTracer) CaptureTxEnd(restGas uint64) {}
+
+// enter pushes a new call frame.
+func (t *profileTracer) enter(addr common.Address, create bool, input []byte) {
+	name := addr.Hex()
+	switch {
+	case create:
+		name += ":create"
+	case len(input) >= 4:
+		name += ":" + hexutil.Encode(input[:4])
+	}
+	t.frames = append(t.frames, &profileFrame{addr: addr, name: name, entered: time.Now()})
+}
+
+// exit pops the current call frame, recording its last opcode and charging the
+// frame's cost to the opcode of the caller.
+func (t *profileTracer) exit(gasUsed uint64) {
+	if len(t.frames) == 0 {
+		return
+	}
+	now := time.Now()
+	frame := t.frames[len(t.frames)-1]
+	if frame.pending {
+		// There is no subsequent step to measure the gas against, use the
+		// cost reported for the opcode instead
+		t.record(frame.cost, now)
+	}
+	t.frames = t.frames[:len(t.frames)-1]
+
+	if len(t.frames) > 0 {
+		caller := t.frames[len(t.frames)-1]
+		caller.subGas += gasUsed
+		caller.subTime += now.Sub(frame.entered)
+	}
+}
+
+// record charges the pending opcode of the current frame with the given gas
+// and with the time elapsed since it started, less the time of its callees.
+func (t *profileTracer) record(gas uint64, now time.Time) {
+	frame := t.frames[len(t.frames)-1]
+	frame.pending = false
+
+	elapsed := now.Sub(frame.started) - frame.subTime
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	stats := t.ops[frame.op]
+	if stats == nil {
+		stats = new(profileOpStats)
+		t.ops[frame.op] = stats
+	}
+	stats.count++
+	stats.gas += gas
+	stats.time += elapsed
+
+	if !t.pprof {
+		return
+	}
+	// Assemble the call stack of the opcode, leaf first: the opcode itself,
+	// the contract executing it, followed by the call sites of the callers
+	locs := make([]uint64, 0, len(t.frames)+1)
+	locs = append(locs, t.location(frame.op.op.String(), frame.op.pc))
+	for i := len(t.frames) - 1; i >= 0; i-- {
+		locs = append(locs, t.location(t.frames[i].name, t.frames[i].op.pc))
+	}
+	var key strings.Builder
+	for _, loc := range locs {
+		key.WriteString(strconv.FormatUint(loc, 16))
+		key.WriteByte(',')
+	}
+	sample := t.stacks[key.String()]
+	if sample == nil {
+		sample = &profileSample{locs: locs}
+		t.stacks[key.String()] = sample
+	}
+	sample.count++
+	sample.gas += gas
+	sample.time += elapsed
+}
+
+// location returns the pprof location id of a function line, assigning a new
+// one if needed.
+func (t *profileTracer) location(fn string, line uint64) uint64 {
+	loc := profileLocation{fn: fn, line: line}
+	if id, ok := t.locs[loc]; ok {
+		return id
+	}
+	if _, ok := t.funcs[fn]; !ok {
+		t.funcs[fn] = uint64(len(t.funcs) + 1)
+	}
+	id := uint64(len(t.locs) + 1)
+	t.locs[loc] = id
+	return id
+}
+
+// profileOpResult is the JSON encoding of the aggregated cost of an opcode.
+type profileOpResult struct {
+	Pc    uint64 `json:"pc"`
+	Op    string `json:"op"`
+	Count uint64 `json:"count"`
+	Gas   uint64 `json:"gas"`
+	Time  int64  `json:"time"` // Nanoseconds
+}
+
+// profileContractResult is the JSON encoding of the aggregated cost of a contract.
+type profileContractResult struct {
+	Count uint64             `json:"count"`
+	Gas   uint64             `json:"gas"`
+	Time  int64              `json:"time"` // Nanoseconds
+	Ops   []*profileOpResult `json:"ops"`
+}
+
+// profileResult is the JSON encoding of the result of the tracer.
+type profileResult struct {
+	Contracts map[common.Address]*profileContractResult `json:"contracts"`
+	Pprof     []byte                                    `json:"pprof,omitempty"`
+}
+
+// GetResult returns the json-encoded aggregates, and any error arising from
+// the encoding or forceful termination (via `Stop`).
+func (t *profileTracer) GetResult() (json.RawMessage, error) {
+	result := &profileResult{Contracts: make(map[common.Address]*profileContractResult)}
+	for key, stats := range t.ops {
+		contract := result.Contracts[key.addr]
+		if contract == nil {
+			contract = new(profileContractResult)
+			result.Contracts[key.addr] = contract
+		}
+		contract.Count += stats.count
+		contract.Gas += stats.gas
+		contract.Time += int64(stats.time)
+		contract.Ops = append(contract.Ops, &profileOpResult{
+			Pc:    key.pc,
+			Op:    key.op.String(),
+			Count: stats.count,
+			Gas:   stats.gas,
+			Time:  int64(stats.time),
+		})
+	}
+	for _, contract := range result.Contracts {
+		sort.Slice(contract.Ops, func(i, j int) bool { return contract.Ops[i].Pc < contract.Ops[j].Pc })
+	}
+	if t.pprof {
+		profile, err := t.encodePprof()
+		if err != nil {
+			return nil, err
+		}
+		result.Pprof = profile
+	}
+	res, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(res), t.reason
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *profileTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
@@ -19,6 +19,8 @@ package gomblclient
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math/big"
 	"runtime"
 	"runtime/debug"
@@ -27,8 +29,12 @@ import (
 	"github.com/mbali/go-mbali/common"
 	"github.com/mbali/go-mbali/common/hexutil"
 	"github.com/mbali/go-mbali/core/types"
+	"github.com/mbali/go-mbali/crypto"
+	"github.com/mbali/go-mbali/mbldb/memorydb"
 	"github.com/mbali/go-mbali/p2p"
+	"github.com/mbali/go-mbali/rlp"
 	"github.com/mbali/go-mbali/rpc"
+	"github.com/mbali/go-mbali/trie"
 )
 
 // Client is a wrapper around rpc.Client that implements gombl-specific functionality.
@@ -119,6 +125,254 @@ func (ec *Client) GetProof(ctx context.Context, account common.Address, keys []s
 	return &result, err
 }
 
+// StorageRangeResult is the result of a GetStorageRangeProof operation. Keys are
+// the hashed storage slot keys and values the RLP encoded slot contents.
+type StorageRangeResult struct {
+	Address      common.Address `json:"address"`
+	AccountProof []string       `json:"accountProof"`
+	StorageHash  common.Hash    `json:"storageHash"`
+	Start        common.Hash    `json:"start"`
+	Keys         []common.Hash  `json:"keys"`
+	Values       [][]byte       `json:"values"`
+	Proof        []string       `json:"proof"`
+}
+
+// GetStorageRangeProof returns a contiguous range of at most maxResults storage slots of the
+// specified account, starting at the given hashed slot key, including the Merkle-proofs of the
+// range boundaries. The block number can be nil, in which case the range is taken from the
+// latest known block.
+func (ec *Client) GetStorageRangeProof(ctx context.Context, account common.Address, start common.Hash, maxResults int, blockNumber *big.Int) (*StorageRangeResult, error) {
+	type storageRangeResult struct {
+		Address      common.Address  `json:"address"`
+		AccountProof []string        `json:"accountProof"`
+		StorageHash  common.Hash     `json:"storageHash"`
+		Start        common.Hash     `json:"start"`
+		Keys         []common.Hash   `json:"keys"`
+		Values       []hexutil.Bytes `json:"values"`
+		Proof        []string        `json:"proof"`
+	}
+	var res storageRangeResult
+	if err := ec.c.CallContext(ctx, &res, "mbl_getStorageRangeProof", account, start, maxResults, toBlockNumArg(blockNumber)); err != nil {
+		return nil, err
+	}
+	values := make([][]byte, len(res.Values))
+	for i, value := range res.Values {
+		values[i] = value
+	}
+	return &StorageRangeResult{
+		Address:      res.Address,
+		AccountProof: res.AccountProof,
+		StorageHash:  res.StorageHash,
+		Start:        res.Start,
+		Keys:         res.Keys,
+		Values:       values,
+		Proof:        res.Proof,
+	}, nil
+}
+
+// Verify checks the storage range against the given state root. The account proof
+// is used to verify the storage root and the boundary proofs to verify that the
+// range is complete. It returns whether there are more slots after the range.
+func (r *StorageRangeResult) Verify(stateRoot common.Hash) (bool, error) {
+	account, err := verifyAccountProof(stateRoot, r.Address, r.AccountProof)
+	if err != nil {
+		return false, err
+	}
+	storageHash := types.EmptyRootHash
+	if account != nil {
+		storageHash = account.Root
+	}
+	if storageHash != r.StorageHash {
+		return false, fmt.Errorf("storage hash mismatch: have %x, want %x", r.StorageHash, storageHash)
+	}
+	if storageHash == types.EmptyRootHash {
+		if len(r.Keys) > 0 {
+			return false, errors.New("slots returned for empty storage")
+		}
+		return false, nil
+	}
+	proof, err := proofDatabase(r.Proof)
+	if err != nil {
+		return false, err
+	}
+	var (
+		keys    = make([][]byte, len(r.Keys))
+		lastKey []byte
+	)
+	for i := range r.Keys {
+		keys[i] = r.Keys[i][:]
+	}
+	if len(keys) > 0 {
+		lastKey = keys[len(keys)-1]
+	}
+	return trie.VerifyRangeProof(r.StorageHash, r.Start[:], lastKey, keys, r.Values, proof)
+}
+
+// MultiProofRequest selects an account and some of its storage slots to be proven
+// by GetMultiProof.
+type MultiProofRequest struct {
+	Address     common.Address `json:"address"`
+	StorageKeys []string       `json:"storageKeys"`
+}
+
+// MultiProofResult is the result of a GetMultiProof operation. The proofs of all
+// accounts and storage slots are merged into a single deduplicated set of nodes.
+type MultiProofResult struct {
+	Accounts []MultiProofAccount `json:"accounts"`
+	Proof    []string            `json:"proof"`
+}
+
+// MultiProofAccount is an account proven by a GetMultiProof operation.
+type MultiProofAccount struct {
+	Address     common.Address      `json:"address"`
+	Balance     *big.Int            `json:"balance"`
+	CodeHash    common.Hash         `json:"codeHash"`
+	Nonce       uint64              `json:"nonce"`
+	StorageHash common.Hash         `json:"storageHash"`
+	Storage     []MultiProofStorage `json:"storage"`
+}
+
+// MultiProofStorage is a storage slot proven by a GetMultiProof operation.
+type MultiProofStorage struct {
+	Key   string   `json:"key"`
+	Value *big.Int `json:"value"`
+}
+
+// GetMultiProof returns the account and storage values of many accounts at once, with
+// the Merkle-proof nodes shared between them deduplicated. The block number can be nil,
+// in which case the values are taken from the latest known block.
+func (ec *Client) GetMultiProof(ctx context.Context, requests []MultiProofRequest, blockNumber *big.Int) (*MultiProofResult, error) {
+	type multiProofStorage struct {
+		Key   string       `json:"key"`
+		Value *hexutil.Big `json:"value"`
+	}
+	type multiProofAccount struct {
+		Address     common.Address      `json:"address"`
+		Balance     *hexutil.Big        `json:"balance"`
+		CodeHash    common.Hash         `json:"codeHash"`
+		Nonce       hexutil.Uint64      `json:"nonce"`
+		StorageHash common.Hash         `json:"storageHash"`
+		Storage     []multiProofStorage `json:"storage"`
+	}
+	type multiProofResult struct {
+		Accounts []multiProofAccount `json:"accounts"`
+		Proof    []string            `json:"proof"`
+	}
+	var res multiProofResult
+	if err := ec.c.CallContext(ctx, &res, "mbl_getMultiProof", requests, toBlockNumArg(blockNumber)); err != nil {
+		return nil, err
+	}
+	// Turn hexutils back to normal datatypes
+	result := &MultiProofResult{
+		Accounts: make([]MultiProofAccount, 0, len(res.Accounts)),
+		Proof:    res.Proof,
+	}
+	for _, acc := range res.Accounts {
+		storage := make([]MultiProofStorage, 0, len(acc.Storage))
+		for _, st := range acc.Storage {
+			storage = append(storage, MultiProofStorage{
+				Key:   st.Key,
+				Value: st.Value.ToInt(),
+			})
+		}
+		result.Accounts = append(result.Accounts, MultiProofAccount{
+			Address:     acc.Address,
+			Balance:     acc.Balance.ToInt(),
+			CodeHash:    acc.CodeHash,
+			Nonce:       uint64(acc.Nonce),
+			StorageHash: acc.StorageHash,
+			Storage:     storage,
+		})
+	}
+	return result, nil
+}
+
+// Verify checks all the account and storage values against the given state root,
+// using the shared proof nodes.
+func (r *MultiProofResult) Verify(stateRoot common.Hash) error {
+	proof, err := proofDatabase(r.Proof)
+	if err != nil {
+		return err
+	}
+	for _, acc := range r.Accounts {
+		blob, err := trie.VerifyProof(stateRoot, crypto.Keccak256(acc.Address[:]), proof)
+		if err != nil {
+			return fmt.Errorf("invalid proof for account %x: %v", acc.Address, err)
+		}
+		// Non-existent accounts are proven to be empty
+		want := types.StateAccount{
+			Balance:  new(big.Int),
+			Root:     types.EmptyRootHash,
+			CodeHash: crypto.Keccak256(nil),
+		}
+		if blob != nil {
+			if err := rlp.DecodeBytes(blob, &want); err != nil {
+				return fmt.Errorf("invalid account %x: %v", acc.Address, err)
+			}
+		}
+		if acc.Nonce != want.Nonce || acc.Balance == nil || acc.Balance.Cmp(want.Balance) != 0 ||
+			acc.StorageHash != want.Root || acc.CodeHash != common.BytesToHash(want.CodeHash) {
+			return fmt.Errorf("account %x mismatches proof", acc.Address)
+		}
+		for _, st := range acc.Storage {
+			key := common.HexToHash(st.Key)
+			value := new(big.Int)
+			if acc.StorageHash != types.EmptyRootHash {
+				blob, err := trie.VerifyProof(acc.StorageHash, crypto.Keccak256(key[:]), proof)
+				if err != nil {
+					return fmt.Errorf("invalid proof for slot %x of account %x: %v", key, acc.Address, err)
+				}
+				if blob != nil {
+					_, content, _, err := rlp.Split(blob)
+					if err != nil {
+						return fmt.Errorf("invalid slot %x of account %x: %v", key, acc.Address, err)
+					}
+					value.SetBytes(content)
+				}
+			}
+			if st.Value == nil || st.Value.Cmp(value) != 0 {
+				return fmt.Errorf("slot %x of account %x mismatches proof", key, acc.Address)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyAccountProof checks the account proof against the state root, returning
+// the proven account or nil if it doesn't exist.
+func verifyAccountProof(stateRoot common.Hash, address common.Address, nodes []string) (*types.StateAccount, error) {
+	proof, err := proofDatabase(nodes)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := trie.VerifyProof(stateRoot, crypto.Keccak256(address[:]), proof)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proof for account %x: %v", address, err)
+	}
+	if blob == nil {
+		return nil, nil
+	}
+	var account types.StateAccount
+	if err := rlp.DecodeBytes(blob, &account); err != nil {
+		return nil, fmt.Errorf("invalid account %x: %v", address, err)
+	}
+	return &account, nil
+}
+
+// proofDatabase decodes the hex encoded proof nodes into a database keyed by
+// the node hashes.
+func proofDatabase(nodes []string) (*memorydb.Database, error) {
+	db := memorydb.New()
+	for _, node := range nodes {
+		blob, err := hexutil.Decode(node)
+		if err != nil {
+			return nil, err
+		}
+		db.Put(crypto.Keccak256(blob), blob)
+	}
+	return db, nil
+}
+
 // OverrideAccount specifies the state of an account to be overridden.
 type OverrideAccount struct {
 	Nonce     uint64                      `json:"nonce"`
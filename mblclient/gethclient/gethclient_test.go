@@ -110,6 +110,12 @@ func TestgomblClient(t *testing.T) {
 		{
 			"TestGetProof",
 			func(t *testing.T) { testGetProof(t, client) },
+		}, {
+			"TestGetStorageRangeProof",
+			func(t *testing.T) { testGetStorageRangeProof(t, client) },
+		}, {
+			"TestGetMultiProof",
+			func(t *testing.T) { testGetMultiProof(t, client) },
 		}, {
 			"TestGCStats",
 			func(t *testing.T) { testGCStats(t, client) },
@@ -225,6 +231,65 @@ func testGetProof(t *testing.T, client *rpc.Client) {
 
 }
 
+func testGetStorageRangeProof(t *testing.T, client *rpc.Client) {
+	ec := New(client)
+	mblcl := mblclient.NewClient(client)
+	header, err := mblcl.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ec.GetStorageRangeProof(context.Background(), testAddr, common.Hash{}, 10, header.Number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Keys) != 1 || result.Keys[0] != crypto.Keccak256Hash(testSlot[:]) {
+		t.Fatalf("invalid storage range keys: %v", result.Keys)
+	}
+	more, err := result.Verify(header.Root)
+	if err != nil {
+		t.Fatalf("failed to verify storage range: %v", err)
+	}
+	if more {
+		t.Fatal("unexpected storage slots after range")
+	}
+	// Tamper with the slot value and ensure verification fails
+	result.Values[0] = []byte{0x01}
+	if _, err := result.Verify(header.Root); err == nil {
+		t.Fatal("tampered storage range verified")
+	}
+}
+
+func testGetMultiProof(t *testing.T, client *rpc.Client) {
+	ec := New(client)
+	mblcl := mblclient.NewClient(client)
+	header, err := mblcl.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := []MultiProofRequest{
+		{Address: testAddr, StorageKeys: []string{testSlot.String()}},
+		{Address: common.Address{0x01}, StorageKeys: []string{testSlot.String()}},
+	}
+	result, err := ec.GetMultiProof(context.Background(), requests, header.Number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Accounts) != len(requests) {
+		t.Fatalf("invalid number of accounts, want %d, got %d", len(requests), len(result.Accounts))
+	}
+	if value := result.Accounts[0].Storage[0].Value; value.Cmp(testValue.Big()) != 0 {
+		t.Fatalf("invalid storage value, want %v, got %v", testValue.Big(), value)
+	}
+	if err := result.Verify(header.Root); err != nil {
+		t.Fatalf("failed to verify multi proof: %v", err)
+	}
+	// Tamper with the balance and ensure verification fails
+	result.Accounts[0].Balance = new(big.Int).Add(result.Accounts[0].Balance, common.Big1)
+	if err := result.Verify(header.Root); err == nil {
+		t.Fatal("tampered multi proof verified")
+	}
+}
+
 func testGCStats(t *testing.T, client *rpc.Client) {
 	ec := New(client)
 	_, err := ec.GCStats(context.Background())
@@ -962,14 +962,15 @@ func (w *worker) commitTransactions(env *environment, txs *types.TransactionsByP
 
 // generateParams wraps various of settings for generating sealing task.
 type generateParams struct {
-	timestamp  uint64         // The timstamp for sealing task
-	forceTime  bool           // Flag whmbler the given timestamp is immutable or not
-	parentHash common.Hash    // Parent block hash, empty means the latest chain head
-	coinbase   common.Address // The fee recipient address for including transaction
-	random     common.Hash    // The randomness generated by beacon chain, empty before the merge
-	noUncle    bool           // Flag whmbler the uncle block inclusion is allowed
-	noExtra    bool           // Flag whmbler the extra field assignment is allowed
-	noTxs      bool           // Flag whmbler an empty block without any transaction is expected
+	timestamp   uint64              // The timstamp for sealing task
+	forceTime   bool                // Flag whmbler the given timestamp is immutable or not
+	parentHash  common.Hash         // Parent block hash, empty means the latest chain head
+	coinbase    common.Address      // The fee recipient address for including transaction
+	random      common.Hash         // The randomness generated by beacon chain, empty before the merge
+	withdrawals []*types.Withdrawal // List of withdrawals to include in block, nil before Shanghai
+	noUncle     bool                // Flag whmbler the uncle block inclusion is allowed
+	noExtra     bool                // Flag whmbler the extra field assignment is allowed
+	noTxs       bool                // Flag whmbler an empty block without any transaction is expected
 }
 
 // prepareWork constructs the sealing task according to the given parameters,
@@ -1094,7 +1095,7 @@ func (w *worker) generateWork(params *generateParams) (*types.Block, error) {
 	if !params.noTxs {
 		w.fillTransactions(nil, work)
 	}
-	return w.engine.FinalizeAndAssemble(w.chain, work.header, work.state, work.txs, work.unclelist(), work.receipts)
+	return w.engine.FinalizeAndAssemble(w.chain, work.header, work.state, work.txs, work.unclelist(), work.receipts, params.withdrawals)
 }
 
 // commitWork generates several new sealing tasks based on the parent block
@@ -1152,7 +1153,7 @@ func (w *worker) commit(env *environment, interval func(), update bool, start ti
 		// Create a local environment copy, avoid the data race with snapshot state.
 		// https://github.com/mbali/go-mbali/issues/24299
 		env := env.copy()
-		block, err := w.engine.FinalizeAndAssemble(w.chain, env.header, env.state, env.txs, env.unclelist(), env.receipts)
+		block, err := w.engine.FinalizeAndAssemble(w.chain, env.header, env.state, env.txs, env.unclelist(), env.receipts, nil)
 		if err != nil {
 			return err
 		}
@@ -1180,21 +1181,22 @@ func (w *worker) commit(env *environment, interval func(), update bool, start ti
 // getSealingBlock generates the sealing block based on the given parameters.
 // The generation result will be passed back via the given channel no matter
 // the generation itself succeeds or not.
-func (w *worker) getSealingBlock(parent common.Hash, timestamp uint64, coinbase common.Address, random common.Hash, noTxs bool) (chan *types.Block, chan error, error) {
+func (w *worker) getSealingBlock(parent common.Hash, timestamp uint64, coinbase common.Address, random common.Hash, withdrawals []*types.Withdrawal, noTxs bool) (chan *types.Block, chan error, error) {
 	var (
 		resCh = make(chan *types.Block, 1)
 		errCh = make(chan error, 1)
 	)
 	req := &getWorkReq{
 		params: &generateParams{
-			timestamp:  timestamp,
-			forceTime:  true,
-			parentHash: parent,
-			coinbase:   coinbase,
-			random:     random,
-			noUncle:    true,
-			noExtra:    true,
-			noTxs:      noTxs,
+			timestamp:   timestamp,
+			forceTime:   true,
+			parentHash:  parent,
+			coinbase:    coinbase,
+			random:      random,
+			withdrawals: withdrawals,
+			noUncle:     true,
+			noExtra:     true,
+			noTxs:       noTxs,
 		},
 		result: resCh,
 		err:    errCh,
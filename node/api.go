@@ -19,7 +19,9 @@ package node
 import (
 	"context"
 	"fmt"
+	"net"
 	"strings"
+	"time"
 
 	"github.com/mbali/go-mbali/common/hexutil"
 	"github.com/mbali/go-mbali/crypto"
@@ -27,6 +29,7 @@ import (
 	"github.com/mbali/go-mbali/log"
 	"github.com/mbali/go-mbali/p2p"
 	"github.com/mbali/go-mbali/p2p/enode"
+	"github.com/mbali/go-mbali/p2p/netutil"
 	"github.com/mbali/go-mbali/rpc"
 )
 
@@ -125,6 +128,156 @@ func (api *privateAdminAPI) RemoveTrustedPeer(url string) (bool, error) {
 	return true, nil
 }
 
+// AddBan bans a node or an IP network, disconnecting the matching peers. The
+// target is either an enode URL, a node ID, an IP address or a CIDR network.
+// The ban lasts for the given duration (e.g. "24h"), or for a duration escalating
+// with each ban of the target if omitted.
+func (api *privateAdminAPI) AddBan(target string, duration *string, reason *string) (bool, error) {
+	// Make sure the server is running, fail otherwise
+	server := api.node.Server()
+	if server == nil {
+		return false, ErrNodeStopped
+	}
+	var (
+		d   time.Duration
+		why = "banned by admin"
+		err error
+	)
+	if duration != nil {
+		if d, err = time.ParseDuration(*duration); err != nil {
+			return false, fmt.Errorf("invalid duration: %v", err)
+		}
+		if d <= 0 {
+			return false, fmt.Errorf("invalid duration: %v", d)
+		}
+	}
+	if reason != nil {
+		why = *reason
+	}
+	id, network, err := parseBanTarget(target)
+	if err != nil {
+		return false, err
+	}
+	if network != nil {
+		err = server.BanNetwork(network, d, why)
+	} else {
+		err = server.BanNode(id, d, why)
+	}
+	return err == nil, err
+}
+
+// RemoveBan lifts the ban of a node or an IP network, also forgetting its past
+// bans. It returns whmbler the target was banned.
+func (api *privateAdminAPI) RemoveBan(target string) (bool, error) {
+	// Make sure the server is running, fail otherwise
+	server := api.node.Server()
+	if server == nil {
+		return false, ErrNodeStopped
+	}
+	id, network, err := parseBanTarget(target)
+	if err != nil {
+		return false, err
+	}
+	if network != nil {
+		return server.UnbanNetwork(network), nil
+	}
+	return server.UnbanNode(id), nil
+}
+
+// ListBans returns the active bans of nodes and IP networks.
+func (api *privateAdminAPI) ListBans() ([]*p2p.BanInfo, error) {
+	server := api.node.Server()
+	if server == nil {
+		return nil, ErrNodeStopped
+	}
+	return server.Bans(), nil
+}
+
+// SetEgressRates updates the caps of the bandwidth used to send messages to all
+// peers and to each peer, in bytes per second. Zero means unlimited.
+func (api *privateAdminAPI) SetEgressRates(rate, peerRate int) (bool, error) {
+	// Make sure the server is running, fail otherwise
+	server := api.node.Server()
+	if server == nil {
+		return false, ErrNodeStopped
+	}
+	if err := server.SetEgressRates(rate, peerRate); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// NetLists returns the IP allow and deny lists, in CIDR notation. A null allow
+// list allows all addresses which are not denied.
+func (api *privateAdminAPI) NetLists() (map[string][]string, error) {
+	server := api.node.Server()
+	if server == nil {
+		return nil, ErrNodeStopped
+	}
+	allow, deny := server.NetLists()
+	lists := map[string][]string{"allow": nil, "deny": {}}
+	if allow != nil {
+		lists["allow"] = allow.MarshalTOML().([]string)
+	}
+	if deny != nil {
+		lists["deny"] = deny.MarshalTOML().([]string)
+	}
+	return lists, nil
+}
+
+// SetNetLists replaces the IP allow and deny lists with the given CIDR masks,
+// disconnecting the peers which don't match them anymore. A null or empty allow
+// list allows all addresses which are not denied.
+func (api *privateAdminAPI) SetNetLists(allow, deny []string) (bool, error) {
+	// Make sure the server is running, fail otherwise
+	server := api.node.Server()
+	if server == nil {
+		return false, ErrNodeStopped
+	}
+	var allowList *netutil.Netlist
+	if len(allow) > 0 {
+		list, err := netutil.ParseNetlist(strings.Join(allow, ","))
+		if err != nil {
+			return false, fmt.Errorf("invalid allow list: %v", err)
+		}
+		allowList = list
+	}
+	denyList, err := netutil.ParseNetlist(strings.Join(deny, ","))
+	if err != nil {
+		return false, fmt.Errorf("invalid deny list: %v", err)
+	}
+	if err := server.SetNetLists(allowList, denyList); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// parseBanTarget parses the target of a ban, either a node (enode URL or ID)
+// or an IP network (CIDR or single IP address).
+func parseBanTarget(target string) (enode.ID, *net.IPNet, error) {
+	if strings.Contains(target, "/") && !strings.Contains(target, "://") {
+		_, network, err := net.ParseCIDR(target)
+		if err != nil {
+			return enode.ID{}, nil, fmt.Errorf("invalid network: %v", err)
+		}
+		return enode.ID{}, network, nil
+	}
+	if ip := net.ParseIP(target); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return enode.ID{}, &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return enode.ID{}, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	if id, err := enode.ParseID(target); err == nil {
+		return id, nil, nil
+	}
+	node, err := enode.Parse(enode.ValidSchemes, target)
+	if err != nil {
+		return enode.ID{}, nil, fmt.Errorf("invalid ban target: %v", err)
+	}
+	return node.ID(), nil, nil
+}
+
 // PeerEvents creates an RPC subscription which receives peer events from the
 // node's p2p.Server
 func (api *privateAdminAPI) PeerEvents(ctx context.Context) (*rpc.Subscription, error) {
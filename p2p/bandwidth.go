@@ -0,0 +1,182 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mbali/go-mbali/common/mclock"
+)
+
+// maxEgressWait is the longest a single message is held back by the egress
+// bandwidth caps. Debt beyond this is forgiven, so a burst of writes across many
+// peers can't stall a peer until the remote end times out.
+const maxEgressWait = 5 * time.Second
+
+// TrafficInfo contains the number of bytes and messages exchanged with a peer
+// for a single message type. Sizes are those of the uncompressed payloads.
+type TrafficInfo struct {
+	IngressBytes    uint64 `json:"ingressBytes"`
+	IngressMessages uint64 `json:"ingressMessages"`
+	EgressBytes     uint64 `json:"egressBytes"`
+	EgressMessages  uint64 `json:"egressMessages"`
+}
+
+// trafficKey identifies a message type of a subprotocol.
+type trafficKey struct {
+	cap  Cap
+	code uint64
+}
+
+// peerTraffic counts the subprotocol messages exchanged with a peer.
+type peerTraffic struct {
+	mu       sync.Mutex
+	counters map[trafficKey]*TrafficInfo
+}
+
+func newPeerTraffic() *peerTraffic {
+	return &peerTraffic{counters: make(map[trafficKey]*TrafficInfo)}
+}
+
+// add counts a message of the given subprotocol, code being relative to the
+// protocol offset.
+func (t *peerTraffic) add(cap Cap, code uint64, size uint32, ingress bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := trafficKey{cap, code}
+	counter := t.counters[key]
+	if counter == nil {
+		counter = new(TrafficInfo)
+		t.counters[key] = counter
+	}
+	if ingress {
+		counter.IngressBytes += uint64(size)
+		counter.IngressMessages++
+	} else {
+		counter.EgressBytes += uint64(size)
+		counter.EgressMessages++
+	}
+}
+
+// info returns a copy of the counters, indexed by protocol (e.g. "mbl/66") and
+// message code.
+func (t *peerTraffic) info() map[string]map[string]*TrafficInfo {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info := make(map[string]map[string]*TrafficInfo)
+	for key, counter := range t.counters {
+		proto := key.cap.String()
+		if info[proto] == nil {
+			info[proto] = make(map[string]*TrafficInfo)
+		}
+		c := *counter
+		info[proto][fmt.Sprintf("%#02x", key.code)] = &c
+	}
+	return info
+}
+
+// egressLimiter enforces the egress bandwidth caps, both across all peers and
+// for each peer. It's a set of token buckets holding up to one second worth of
+// traffic. Writes exceeding the available tokens put the buckets in debt, and
+// have to wait until it's repaid, but no longer than maxEgressWait.
+type egressLimiter struct {
+	clock mclock.Clock
+
+	mu       sync.Mutex
+	rate     float64 // Bytes per second across all peers, zero if unlimited
+	peerRate float64 // Bytes per second for each peer, zero if unlimited
+	total    tokenBucket
+}
+
+// tokenBucket is the state of a token bucket, whose rate is held by the
+// egressLimiter.
+type tokenBucket struct {
+	tokens  float64
+	updated mclock.AbsTime
+}
+
+func newEgressLimiter(clock mclock.Clock, rate, peerRate int) *egressLimiter {
+	l := &egressLimiter{clock: clock}
+	l.setRates(rate, peerRate)
+	return l
+}
+
+// setRates updates the bandwidth caps, in bytes per second. Zero disables a cap.
+func (l *egressLimiter) setRates(rate, peerRate int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate, l.peerRate = float64(rate), float64(peerRate)
+}
+
+// rates returns the bandwidth caps, in bytes per second.
+func (l *egressLimiter) rates() (rate, peerRate int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.rate), int(l.peerRate)
+}
+
+// reserve takes the tokens for a write of the given size, returning the time
+// the writer has to wait for the buckets to be out of debt.
+func (l *egressLimiter) reserve(peer *tokenBucket, size int) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	wait := l.total.take(now, l.rate, size)
+	if peerWait := peer.take(now, l.peerRate, size); peerWait > wait {
+		wait = peerWait
+	}
+	return wait
+}
+
+// take refills the bucket at the given rate and takes size tokens, returning
+// the time it takes to repay the debt. The debt is capped at maxEgressWait.
+func (b *tokenBucket) take(now mclock.AbsTime, rate float64, size int) time.Duration {
+	elapsed := now.Sub(b.updated)
+	b.updated = now
+	if rate == 0 {
+		b.tokens = 0
+		return 0
+	}
+	b.tokens += rate * elapsed.Seconds()
+	if b.tokens > rate {
+		b.tokens = rate
+	}
+	b.tokens -= float64(size)
+	if b.tokens >= 0 {
+		return 0
+	}
+	if maxDebt := rate * maxEgressWait.Seconds(); b.tokens < -maxDebt {
+		b.tokens = -maxDebt
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
@@ -0,0 +1,98 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mbali/go-mbali/common/mclock"
+)
+
+func TestPeerTraffic(t *testing.T) {
+	proto := Protocol{
+		Name:   "a",
+		Length: 5,
+		Run: func(peer *Peer, rw MsgReadWriter) error {
+			if err := ExpectMsg(rw, 2, []uint{1}); err != nil {
+				t.Error(err)
+			}
+			if err := ExpectMsg(rw, 2, []uint{2}); err != nil {
+				t.Error(err)
+			}
+			return SendItems(rw, 1, uint(3), uint(4))
+		},
+	}
+	closer, rw, peer, errc := testPeer([]Protocol{proto})
+	defer closer()
+
+	Send(rw, baseProtocolLength+2, []uint{1})
+	Send(rw, baseProtocolLength+2, []uint{2})
+	if err := ExpectMsg(rw, baseProtocolLength+1, []uint{3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err != errProtocolReturned {
+			t.Fatalf("peer returned error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("receive timeout")
+	}
+	want := map[string]map[string]*TrafficInfo{
+		"a/0": {
+			"0x02": {IngressBytes: 4, IngressMessages: 2},
+			"0x01": {EgressBytes: 3, EgressMessages: 1},
+		},
+	}
+	if have := peer.Info().Traffic; !reflect.DeepEqual(have, want) {
+		t.Errorf("traffic mismatch: have %v, want %v", have, want)
+	}
+}
+
+func TestEgressLimiter(t *testing.T) {
+	var (
+		clock mclock.Simulated
+		l     = newEgressLimiter(&clock, 1000, 400)
+		a, b  tokenBucket
+	)
+	clock.Run(time.Second) // Fill the buckets
+
+	for i, tt := range []struct {
+		peer *tokenBucket
+		size int
+		wait time.Duration
+	}{
+		{&a, 400, 0},                      // Within both caps
+		{&a, 200, 500 * time.Millisecond}, // Exceeds the peer cap
+		{&b, 400, 0},                      // Other peers are unaffected
+		{&b, 100, 250 * time.Millisecond}, // Exceeds both caps
+		{&a, 1000, 3 * time.Second},       // Debt accumulates
+		{&b, 0, 1100 * time.Millisecond},  // Total debt
+		{&a, 10000, maxEgressWait},        // Debt is capped
+	} {
+		if wait := l.reserve(tt.peer, tt.size); wait != tt.wait {
+			t.Errorf("test %d: wait mismatch: have %v, want %v", i, wait, tt.wait)
+		}
+	}
+	// Lifting the caps stops the throttling
+	l.setRates(0, 0)
+	if wait := l.reserve(&a, 1000000); wait != 0 {
+		t.Errorf("throttled without caps: %v", wait)
+	}
+}
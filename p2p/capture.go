@@ -0,0 +1,336 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mbali/go-mbali/rlp"
+)
+
+const (
+	defaultCaptureFileSize  = 16 * 1024 * 1024
+	defaultCaptureFiles     = 4
+	defaultCaptureTotalSize = 1024 * 1024 * 1024
+
+	// CaptureFileExt is the extension of the files holding the messages
+	// captured for a peer.
+	CaptureFileExt = ".capture"
+)
+
+// CaptureConfig configures the recording of the messages exchanged with peers.
+type CaptureConfig struct {
+	// Dir is the directory in which the messages are recorded, one file per
+	// peer named after its node ID. Capturing is disabled if empty.
+	Dir string `toml:",omitempty"`
+
+	// MaxFileSize is the size in bytes at which a capture file is rotated.
+	// MaxFiles is the number of files kept for each peer, including the
+	// current one. Zero defaults to preset values.
+	MaxFileSize int64 `toml:",omitempty"`
+	MaxFiles    int   `toml:",omitempty"`
+
+	// MaxTotalSize is the total size in bytes of the capture files in Dir.
+	// Whenever a file is started, the files modified least recently are
+	// deleted to leave room for it. Zero defaults to a preset value.
+	MaxTotalSize int64 `toml:",omitempty"`
+}
+
+func (cfg CaptureConfig) withDefaults() CaptureConfig {
+	if cfg.MaxFileSize == 0 {
+		cfg.MaxFileSize = defaultCaptureFileSize
+	}
+	if cfg.MaxFiles == 0 {
+		cfg.MaxFiles = defaultCaptureFiles
+	}
+	if cfg.MaxTotalSize == 0 {
+		cfg.MaxTotalSize = defaultCaptureTotalSize
+	}
+	return cfg
+}
+
+// CaptureRecord is a message captured from the connection to a peer. Capture
+// files hold a sequence of RLP-encoded records.
+type CaptureRecord struct {
+	Time     uint64 // Unix time in nanoseconds
+	Ingress  bool   // Whmbler the message was received from the peer
+	Protocol string // Subprotocol (e.g. "mbl/66"), empty for base protocol messages
+	Code     uint64 // Message code, relative to the subprotocol offset
+	Payload  []byte // Uncompressed RLP payload
+}
+
+// Timestamp returns the time at which the message was captured.
+func (r *CaptureRecord) Timestamp() time.Time {
+	return time.Unix(0, int64(r.Time))
+}
+
+// CaptureReader reads the records of a capture file.
+type CaptureReader struct {
+	s *rlp.Stream
+}
+
+// NewCaptureReader creates a reader of the records held by r.
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	return &CaptureReader{s: rlp.NewStream(r, 0)}
+}
+
+// Next returns the next record, or io.EOF at the end of the capture.
+func (r *CaptureReader) Next() (*CaptureRecord, error) {
+	rec := new(CaptureRecord)
+	if err := r.s.Decode(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// captureWriter appends records to the capture file of a peer, rotating it
+// when it grows too large.
+type captureWriter struct {
+	cfg  CaptureConfig
+	path string
+
+	mu   sync.Mutex
+	fd   *os.File
+	size int64
+}
+
+func newCaptureWriter(cfg CaptureConfig, name string) (*captureWriter, error) {
+	cfg = cfg.withDefaults()
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, err
+	}
+	w := &captureWriter{cfg: cfg, path: filepath.Join(cfg.Dir, name+CaptureFileExt)}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	pruneCaptureDir(cfg.Dir, cfg.MaxTotalSize-cfg.MaxFileSize, w.path)
+	return w, nil
+}
+
+// open opens the current capture file for appending.
+func (w *captureWriter) open() error {
+	fd, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	w.fd, w.size = fd, stat.Size()
+	return nil
+}
+
+// rotate shifts the previous capture files, dropping the oldest one, and
+// starts a new current file.
+func (w *captureWriter) rotate() error {
+	w.fd.Close()
+	w.fd = nil
+
+	name := func(i int) string {
+		if i == 0 {
+			return w.path
+		}
+		return fmt.Sprintf("%s.%d", w.path, i)
+	}
+	os.Remove(name(w.cfg.MaxFiles - 1))
+	for i := w.cfg.MaxFiles - 1; i > 0; i-- {
+		os.Rename(name(i-1), name(i))
+	}
+	pruneCaptureDir(w.cfg.Dir, w.cfg.MaxTotalSize-w.cfg.MaxFileSize, w.path)
+	return w.open()
+}
+
+// pruneCaptureDir deletes the capture files modified least recently until the
+// total size of the capture files in dir is within limit. The current file of
+// the calling writer is kept.
+func pruneCaptureDir(dir string, limit int64, keep string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var (
+		files []os.FileInfo
+		total int64
+	)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.Contains(entry.Name(), CaptureFileExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, info := range files {
+		if total <= limit {
+			break
+		}
+		path := filepath.Join(dir, info.Name())
+		if path == keep {
+			continue
+		}
+		if os.Remove(path) == nil {
+			total -= info.Size()
+		}
+	}
+}
+
+// write appends a record to the capture file.
+func (w *captureWriter) write(rec *CaptureRecord) error {
+	enc, err := rlp.EncodeToBytes(rec)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.fd == nil {
+		return os.ErrClosed
+	}
+	if w.size > 0 && w.size+int64(len(enc)) > w.cfg.MaxFileSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.fd.Write(enc)
+	w.size += int64(n)
+	return err
+}
+
+func (w *captureWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.fd != nil {
+		w.fd.Close()
+		w.fd = nil
+	}
+}
+
+// captureTransport wraps the transport of a peer connection, recording all
+// messages read and written.
+type captureTransport struct {
+	transport
+	w       *captureWriter
+	resolve func(code uint64) (proto string, relCode uint64)
+	log     func(err error)
+}
+
+// startCapture starts recording the messages exchanged with the peer. It must
+// be called before the peer is run.
+func (p *Peer) startCapture(cfg CaptureConfig) error {
+	w, err := newCaptureWriter(cfg, p.ID().String())
+	if err != nil {
+		return err
+	}
+	var logOnce sync.Once
+	p.rw.transport = &captureTransport{
+		transport: p.rw.transport,
+		w:         w,
+		resolve:   p.resolveCode,
+		log: func(err error) {
+			logOnce.Do(func() { p.log.Warn("Failed to capture message", "err", err) })
+		},
+	}
+	return nil
+}
+
+// resolveCode maps a message code to its subprotocol.
+func (p *Peer) resolveCode(code uint64) (string, uint64) {
+	if code < baseProtocolLength {
+		return "", code
+	}
+	proto, err := p.getProto(code)
+	if err != nil {
+		return "", code
+	}
+	return proto.cap().String(), code - proto.offset
+}
+
+func (t *captureTransport) ReadMsg() (Msg, error) {
+	msg, err := t.transport.ReadMsg()
+	if err != nil {
+		return msg, err
+	}
+	payload, err := capturePayload(&msg)
+	if err != nil {
+		return msg, err
+	}
+	proto, code := t.resolve(msg.Code)
+	t.record(true, proto, code, payload)
+	return msg, nil
+}
+
+func (t *captureTransport) WriteMsg(msg Msg) error {
+	payload, err := capturePayload(&msg)
+	if err != nil {
+		return err
+	}
+	if err := t.transport.WriteMsg(msg); err != nil {
+		return err
+	}
+	// Messages written by subprotocols carry their protocol, the others
+	// belong to the base protocol.
+	proto, code := "", msg.Code
+	if msg.meterCap.Name != "" {
+		proto, code = msg.meterCap.String(), msg.meterCode
+	}
+	t.record(false, proto, code, payload)
+	return nil
+}
+
+func (t *captureTransport) record(ingress bool, proto string, code uint64, payload []byte) {
+	rec := &CaptureRecord{
+		Time:     uint64(time.Now().UnixNano()),
+		Ingress:  ingress,
+		Protocol: proto,
+		Code:     code,
+		Payload:  payload,
+	}
+	if err := t.w.write(rec); err != nil {
+		t.log(err)
+	}
+}
+
+func (t *captureTransport) close(err error) {
+	t.transport.close(err)
+	t.w.close()
+}
+
+// capturePayload reads the payload of a message, replacing it with a reader of
+// the returned copy.
+func capturePayload(msg *Msg) ([]byte, error) {
+	payload, err := io.ReadAll(msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+	msg.Payload = bytes.NewReader(payload)
+	return payload, nil
+}
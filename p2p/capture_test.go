@@ -0,0 +1,188 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mbali/go-mbali/log"
+	"github.com/mbali/go-mbali/rlp"
+)
+
+func readCapture(t *testing.T, file string) []*CaptureRecord {
+	fd, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	var (
+		r       = NewCaptureReader(fd)
+		records []*CaptureRecord
+	)
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+}
+
+func TestPeerCapture(t *testing.T) {
+	proto := Protocol{
+		Name:    "a",
+		Version: 1,
+		Length:  5,
+		Run: func(peer *Peer, rw MsgReadWriter) error {
+			if err := ExpectMsg(rw, 2, []uint{1}); err != nil {
+				t.Error(err)
+			}
+			return SendItems(rw, 3, uint(2))
+		},
+	}
+	var (
+		dir        = t.TempDir()
+		fd1, fd2   = net.Pipe()
+		key1, key2 = newkey(), newkey()
+		c1         = &conn{fd: fd1, node: newNode(uintID(1), ""), transport: newTestTransport(&key2.PublicKey, fd1, nil), caps: []Cap{proto.cap()}}
+		c2         = &conn{fd: fd2, node: newNode(uintID(2), ""), transport: newTestTransport(&key1.PublicKey, fd2, &key1.PublicKey)}
+		peer       = newPeer(log.Root(), c1, []Protocol{proto})
+		errc       = make(chan error, 1)
+	)
+	defer c2.close(errors.New("test done"))
+
+	if err := peer.startCapture(CaptureConfig{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, err := peer.run()
+		errc <- err
+	}()
+	Send(c2, baseProtocolLength+2, []uint{1})
+	if err := ExpectMsg(c2, baseProtocolLength+3, []uint{2}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err != errProtocolReturned {
+			t.Fatalf("peer returned error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("receive timeout")
+	}
+	records := readCapture(t, filepath.Join(dir, peer.ID().String()+CaptureFileExt))
+	if len(records) != 2 {
+		t.Fatalf("wrong number of records: have %d, want 2", len(records))
+	}
+	for i, want := range []struct {
+		ingress bool
+		code    uint64
+		payload []uint
+	}{
+		{true, 2, []uint{1}},
+		{false, 3, []uint{2}},
+	} {
+		rec := records[i]
+		if rec.Ingress != want.ingress || rec.Protocol != "a/1" || rec.Code != want.code {
+			t.Errorf("record %d: wrong header: %+v", i, rec)
+		}
+		var payload []uint
+		if err := rlp.DecodeBytes(rec.Payload, &payload); err != nil || !reflect.DeepEqual(payload, want.payload) {
+			t.Errorf("record %d: wrong payload %v (%v), want %v", i, payload, err, want.payload)
+		}
+	}
+}
+
+func TestCaptureRotation(t *testing.T) {
+	dir := t.TempDir()
+	w, err := newCaptureWriter(CaptureConfig{Dir: dir, MaxFileSize: 100, MaxFiles: 3}, "peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := w.write(&CaptureRecord{Code: uint64(i), Payload: make([]byte, 40)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.close()
+
+	// Each file holds two records, the oldest ones are dropped
+	path := filepath.Join(dir, "peer"+CaptureFileExt)
+	for i, file := range []string{path + ".2", path + ".1", path} {
+		records := readCapture(t, file)
+		if len(records) != 2 {
+			t.Fatalf("%s: wrong number of records: have %d, want 2", file, len(records))
+		}
+		if want := uint64(4 + 2*i); records[0].Code != want {
+			t.Errorf("%s: wrong first record: have %d, want %d", file, records[0].Code, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("too many capture files kept: %v", err)
+	}
+}
+
+func TestCaptureDirLimit(t *testing.T) {
+	dir := t.TempDir()
+	cfg := CaptureConfig{Dir: dir, MaxFileSize: 100, MaxFiles: 2, MaxTotalSize: 250}
+
+	// Fill the files of two peers, the first one written to long ago
+	old, _ := newCaptureWriter(cfg, "old")
+	for i := 0; i < 4; i++ {
+		old.write(&CaptureRecord{Payload: make([]byte, 40)})
+	}
+	old.close()
+	past := time.Now().Add(-time.Hour)
+	oldPath := filepath.Join(dir, "old"+CaptureFileExt)
+	os.Chtimes(oldPath, past, past)
+	os.Chtimes(oldPath+".1", past.Add(-time.Minute), past.Add(-time.Minute))
+
+	// A new peer's capture gets the oldest files deleted
+	w, err := newCaptureWriter(cfg, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+	for i := 0; i < 4; i++ {
+		if err := w.write(&CaptureRecord{Payload: make([]byte, 40)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(oldPath + ".1"); !os.IsNotExist(err) {
+		t.Errorf("oldest capture file not deleted: %v", err)
+	}
+	var total int64
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		info, _ := entry.Info()
+		total += info.Size()
+	}
+	if total > cfg.MaxTotalSize {
+		t.Errorf("capture files exceed the limit: %d bytes", total)
+	}
+}
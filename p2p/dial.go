@@ -77,7 +77,6 @@ var (
 	errAlreadyDialing   = errors.New("already dialing")
 	errAlreadyConnected = errors.New("already connected")
 	errRecentlyDialed   = errors.New("recently dialed")
-	errNetRestrict      = errors.New("not contained in netrestrict list")
 	errNoPort           = errors.New("node does not provide TCP port")
 )
 
@@ -109,6 +108,8 @@ type dialScheduler struct {
 	dialing   map[enode.ID]*dialTask // active tasks
 	peers     map[enode.ID]struct{}  // all connected peers
 	dialPeers int                    // current number of dialed peers
+	subnets   *subnetSet             // IPs of dynamic dials and dynamically dialed peers
+	subnetIDs map[enode.ID]subnetRef // nodes counted in subnets
 
 	// The static map tracks all static dial tasks. The subset of usable static dial tasks
 	// (i.e. those passing checkDial) is kept in staticPool. The scheduler prefers
@@ -129,11 +130,20 @@ type dialScheduler struct {
 
 type dialSetupFunc func(net.Conn, connFlag, *enode.Node) error
 
+// subnetRef tracks the uses of a node's IP in the dialer subnet set. A dynamic dial
+// and the peer it creates overlap in time, but the node is counted only once.
+type subnetRef struct {
+	ip   net.IP
+	refs int
+}
+
 type dialConfig struct {
-	self           enode.ID         // our own ID
-	maxDialPeers   int              // maximum number of dialed peers
-	maxActiveDials int              // maximum number of active dials
-	netRestrict    *netutil.Netlist // IP netrestrict list, disabled if nil
+	self           enode.ID                // our own ID
+	maxDialPeers   int                     // maximum number of dialed peers
+	maxActiveDials int                     // maximum number of active dials
+	netFilter      *netutil.NetFilter      // IP allow and deny lists, disabled if nil
+	banned         func(*enode.Node) error // check for banned nodes, disabled if nil
+	subnetLimits   *SubnetLimits           // limits of dynamic dials per subnet, disabled if nil
 	resolver       nodeResolver
 	dialer         NodeDialer
 	log            log.Logger
@@ -174,6 +184,10 @@ func newDialScheduler(config dialConfig, it enode.Iterator, setupFunc dialSetupF
 		addPeerCh:   make(chan *conn),
 		remPeerCh:   make(chan *conn),
 	}
+	if d.subnetLimits != nil {
+		d.subnets = newSubnetSet(*d.subnetLimits)
+		d.subnetIDs = make(map[enode.ID]subnetRef)
+	}
 	d.lastStatsLog = d.clock.Now()
 	d.ctx, d.cancel = context.WithCancel(context.Background())
 	d.wg.Add(2)
@@ -242,15 +256,27 @@ loop:
 
 		select {
 		case node := <-nodesCh:
-			if err := d.checkDial(node); err != nil {
+			err := d.checkDial(node)
+			if err == nil && d.banned != nil {
+				err = d.banned(node)
+			}
+			if err == nil && !d.subnets.allowed(node.IP()) {
+				diversityDialRejectMeter.Mark(1)
+				err = errSubnetLimit
+			}
+			if err != nil {
 				d.log.Trace("Discarding dial candidate", "id", node.ID(), "ip", node.IP(), "reason", err)
 			} else {
+				d.holdSubnet(node.ID(), node.IP())
 				d.startDial(newDialTask(node, dynDialedConn))
 			}
 
 		case task := <-d.doneCh:
 			id := task.dest.ID()
 			delete(d.dialing, id)
+			if task.flags&dynDialedConn != 0 {
+				d.releaseSubnet(id)
+			}
 			d.updateStaticPool(id)
 			d.doneSinceLastLog++
 
@@ -258,6 +284,9 @@ loop:
 			if c.is(dynDialedConn) || c.is(staticDialedConn) {
 				d.dialPeers++
 			}
+			if c.is(dynDialedConn) {
+				d.holdSubnet(c.node.ID(), c.node.IP())
+			}
 			id := c.node.ID()
 			d.peers[id] = struct{}{}
 			// Remove from static pool because the node is now connected.
@@ -271,6 +300,9 @@ loop:
 			if c.is(dynDialedConn) || c.is(staticDialedConn) {
 				d.dialPeers--
 			}
+			if c.is(dynDialedConn) {
+				d.releaseSubnet(c.node.ID())
+			}
 			delete(d.peers, c.node.ID())
 			d.updateStaticPool(c.node.ID())
 
@@ -401,8 +433,8 @@ func (d *dialScheduler) checkDial(n *enode.Node) error {
 	if _, ok := d.peers[n.ID()]; ok {
 		return errAlreadyConnected
 	}
-	if d.netRestrict != nil && !d.netRestrict.Contains(n.IP()) {
-		return errNetRestrict
+	if err := d.netFilter.Check(n.IP()); err != nil {
+		return err
 	}
 	if d.history.contains(string(n.ID().Bytes())) {
 		return errRecentlyDialed
@@ -449,6 +481,36 @@ func (d *dialScheduler) removeFromStaticPool(idx int) {
 	task.staticPoolIndex = -1
 }
 
+// holdSubnet counts the IP of a dynamically dialed node in the subnet set. The IP is
+// added once per node, no matter how many times the node is held.
+func (d *dialScheduler) holdSubnet(id enode.ID, ip net.IP) {
+	if d.subnets == nil {
+		return
+	}
+	ref, ok := d.subnetIDs[id]
+	if !ok {
+		ref.ip = ip
+		d.subnets.add(ip)
+	}
+	ref.refs++
+	d.subnetIDs[id] = ref
+}
+
+// releaseSubnet undoes holdSubnet. The IP leaves the subnet set when the last hold
+// on the node is released.
+func (d *dialScheduler) releaseSubnet(id enode.ID) {
+	ref, ok := d.subnetIDs[id]
+	if !ok {
+		return
+	}
+	if ref.refs--; ref.refs > 0 {
+		d.subnetIDs[id] = ref
+		return
+	}
+	delete(d.subnetIDs, id)
+	d.subnets.remove(ref.ip)
+}
+
 // startDial runs the given dial task in a separate goroutine.
 func (d *dialScheduler) startDial(task *dialTask) {
 	d.log.Trace("Starting p2p dial", "id", task.dest.ID(), "ip", task.dest.IP(), "flag", task.flags)
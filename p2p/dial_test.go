@@ -130,12 +130,13 @@ func TestDialSchedNetRestrict(t *testing.T) {
 		newNode(uintID(0x07), "127.0.2.7:30303"),
 		newNode(uintID(0x08), "127.0.2.8:30303"),
 	}
+	restrict := new(netutil.Netlist)
+	restrict.Add("127.0.2.0/24")
 	config := dialConfig{
-		netRestrict:    new(netutil.Netlist),
+		netFilter:      netutil.NewNetFilter(restrict, nil),
 		maxActiveDials: 10,
 		maxDialPeers:   10,
 	}
-	config.netRestrict.Add("127.0.2.0/24")
 	runDialTest(t, config, []dialTestRound{
 		{
 			discovered:   nodes,
@@ -152,6 +153,105 @@ func TestDialSchedNetRestrict(t *testing.T) {
 	})
 }
 
+// This test checks that dynamic dials respect the subnet limits.
+func TestDialSchedSubnetLimits(t *testing.T) {
+	t.Parallel()
+
+	config := dialConfig{
+		maxActiveDials: 10,
+		maxDialPeers:   10,
+		subnetLimits:   &SubnetLimits{IPv4Subnet24: 2, IPv4Subnet16: 3},
+	}
+	runDialTest(t, config, []dialTestRound{
+		// The static peer doesn't count against the limits.
+		{
+			peersAdded: []*conn{
+				{flags: staticDialedConn, node: newNode(uintID(0x00), "1.2.3.1:30303")},
+				{flags: dynDialedConn, node: newNode(uintID(0x01), "1.2.3.2:30303")},
+			},
+			discovered: []*enode.Node{
+				newNode(uintID(0x02), "1.2.3.3:30303"),
+				newNode(uintID(0x03), "1.2.3.4:30303"), // not dialed because the /24 is full
+				newNode(uintID(0x04), "1.2.4.1:30303"),
+				newNode(uintID(0x05), "1.2.5.1:30303"), // not dialed because the /16 is full
+				newNode(uintID(0x06), "10.0.0.1:30303"),
+				newNode(uintID(0x07), "5.6.7.8:30303"),
+			},
+			wantNewDials: []*enode.Node{
+				newNode(uintID(0x02), "1.2.3.3:30303"),
+				newNode(uintID(0x04), "1.2.4.1:30303"),
+				newNode(uintID(0x06), "10.0.0.1:30303"),
+				newNode(uintID(0x07), "5.6.7.8:30303"),
+			},
+		},
+		// The dynamic peer drops off, making room for one more dial.
+		{
+			peersRemoved: []enode.ID{
+				uintID(0x01),
+			},
+			discovered: []*enode.Node{
+				newNode(uintID(0x03), "1.2.3.4:30303"),
+				newNode(uintID(0x05), "1.2.5.1:30303"), // not dialed because the /16 is full again
+			},
+			wantNewDials: []*enode.Node{
+				newNode(uintID(0x03), "1.2.3.4:30303"),
+			},
+		},
+	})
+}
+
+// This test checks that a dynamically dialed node is counted once against the subnet
+// limits while its dial task and its peer connection overlap.
+func TestDialSchedSubnetLimitsDialedPeer(t *testing.T) {
+	t.Parallel()
+
+	config := dialConfig{
+		maxActiveDials: 10,
+		maxDialPeers:   10,
+		subnetLimits:   &SubnetLimits{IPv4Subnet24: 2, IPv4Subnet16: 10},
+	}
+	runDialTest(t, config, []dialTestRound{
+		{
+			discovered: []*enode.Node{
+				newNode(uintID(0x01), "1.2.3.1:30303"),
+			},
+			wantNewDials: []*enode.Node{
+				newNode(uintID(0x01), "1.2.3.1:30303"),
+			},
+		},
+		// The peer of the dialed node connects before the dial task is done.
+		{
+			peersAdded: []*conn{
+				{flags: dynDialedConn, node: newNode(uintID(0x01), "1.2.3.1:30303")},
+			},
+			discovered: []*enode.Node{
+				newNode(uintID(0x02), "1.2.3.2:30303"),
+				newNode(uintID(0x03), "1.2.3.3:30303"), // not dialed because the /24 is full
+			},
+			wantNewDials: []*enode.Node{
+				newNode(uintID(0x02), "1.2.3.2:30303"),
+			},
+		},
+		// The node is released once both the peer and the dial task are gone.
+		{
+			peersRemoved: []enode.ID{
+				uintID(0x01),
+			},
+			failed: []enode.ID{
+				uintID(0x01),
+			},
+		},
+		{
+			discovered: []*enode.Node{
+				newNode(uintID(0x03), "1.2.3.3:30303"),
+			},
+			wantNewDials: []*enode.Node{
+				newNode(uintID(0x03), "1.2.3.3:30303"),
+			},
+		},
+	})
+}
+
 // This test checks that static dials work and obey the limits.
 func TestDialSchedStaticDial(t *testing.T) {
 	t.Parallel()
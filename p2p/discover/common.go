@@ -42,6 +42,7 @@ type Config struct {
 
 	// These settings are optional:
 	NetRestrict  *netutil.Netlist   // list of allowed IP networks
+	NetFilter    *netutil.NetFilter // runtime-editable IP allow and deny lists, overrides NetRestrict
 	Bootnodes    []*enode.Node      // list of bootstrap nodes
 	Unhandled    chan<- ReadPacket  // unhandled packets are sent on this channel
 	Log          log.Logger         // if set, log messages go here
@@ -59,6 +60,9 @@ func (cfg Config) withDefaults() Config {
 	if cfg.Clock == nil {
 		cfg.Clock = mclock.System{}
 	}
+	if cfg.NetFilter == nil && cfg.NetRestrict != nil {
+		cfg.NetFilter = netutil.NewNetFilter(cfg.NetRestrict, nil)
+	}
 	return cfg
 }
 
@@ -70,6 +70,7 @@ type Table struct {
 	nursery []*node           // bootstrap nodes
 	rand    *mrand.Rand       // source of randomness, periodically reseeded
 	ips     netutil.DistinctNetSet
+	filter  *netutil.NetFilter // IP allow and deny lists, nil if unrestricted
 
 	log        log.Logger
 	db         *enode.DB // database of known nodes
@@ -99,10 +100,11 @@ type bucket struct {
 	ips          netutil.DistinctNetSet
 }
 
-func newTable(t transport, db *enode.DB, bootnodes []*enode.Node, log log.Logger) (*Table, error) {
+func newTable(t transport, db *enode.DB, bootnodes []*enode.Node, filter *netutil.NetFilter, log log.Logger) (*Table, error) {
 	tab := &Table{
 		net:        t,
 		db:         db,
+		filter:     filter,
 		refreshReq: make(chan chan struct{}),
 		initDone:   make(chan struct{}),
 		closeReq:   make(chan struct{}),
@@ -464,6 +466,9 @@ func (tab *Table) addSeenNode(n *node) {
 	if n.ID() == tab.self().ID() {
 		return
 	}
+	if !tab.filter.Allowed(n.IP()) {
+		return
+	}
 
 	tab.mutex.Lock()
 	defer tab.mutex.Unlock()
@@ -506,6 +511,9 @@ func (tab *Table) addVerifiedNode(n *node) {
 	if n.ID() == tab.self().ID() {
 		return
 	}
+	if !tab.filter.Allowed(n.IP()) {
+		return
+	}
 
 	tab.mutex.Lock()
 	defer tab.mutex.Unlock()
@@ -0,0 +1,500 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mbali/go-mbali/common/mclock"
+	"github.com/mbali/go-mbali/p2p/discover/v5wire"
+	"github.com/mbali/go-mbali/p2p/enode"
+	"github.com/mbali/go-mbali/rlp"
+)
+
+const (
+	topicAdLifetime       = 15 * time.Minute // time an ad stays in a topic queue
+	topicQueueCapacity    = 50               // max ads per topic
+	topicTableLimit       = 500              // max number of topics
+	topicRegWindow        = 10 * time.Second // time to use a ticket after the wait time
+	topicQueryResultLimit = 16               // applies in TOPICQUERY handler
+	topicRegistrarCount   = 8                // registrars per topic used by RegisterTopic
+	topicRegLookupDelay   = time.Minute      // min time between registrar lookups
+	topicSearchDelay      = 10 * time.Second // min time between topic search rounds
+	topicTicketMACSize    = 16
+)
+
+var (
+	errInvalidTicket = errors.New("invalid ticket")
+	errTicketMAC     = errors.New("ticket MAC mismatch")
+	errTicketWait    = errors.New("ticket wait time exceeds ad lifetime")
+)
+
+// topicTable is the registrar side of topic advertisement. It keeps the ads
+// of other nodes in topic queues of limited capacity.
+//
+// A node can place an ad immediately while the queue has free slots. When the
+// queue is full, the node receives a ticket instead, reserving the slot of the
+// next ad to expire. The ticket has to be presented in another registration
+// attempt within topicRegWindow after the slot becomes free, or the
+// reservation is lost. Each ad slot backs at most one reservation. Once all
+// slots are reserved, further tickets carry no reservation and only tell the
+// node when to try again.
+//
+// The table is accessed by the dispatch loop only.
+type topicTable struct {
+	key    []byte // ticket MAC key
+	queues map[v5wire.TopicID]*topicQueue
+}
+
+type topicQueue struct {
+	ads          []topicAd                   // ordered by expiry
+	reservations map[enode.ID]mclock.AbsTime // ticket ready times
+}
+
+type topicAd struct {
+	node   *enode.Node
+	expiry mclock.AbsTime
+}
+
+// topicTicket is the content of a ticket. Tickets are only interpreted by the
+// registrar which issued them, so the times can use the local clock.
+type topicTicket struct {
+	Topic v5wire.TopicID
+	Node  enode.ID
+	IP    net.IP
+	Ready uint64 // local time when the ticket can be used
+}
+
+func newTopicTable() *topicTable {
+	key := make([]byte, 32)
+	crand.Read(key)
+	return &topicTable{key: key, queues: make(map[v5wire.TopicID]*topicQueue)}
+}
+
+// register handles a registration attempt of node n from the given IP. It
+// returns whether the ad was placed, and the ticket and wait time otherwise.
+func (tab *topicTable) register(topic v5wire.TopicID, n *enode.Node, ip net.IP, ticket []byte, now mclock.AbsTime) (placed bool, newTicket []byte, wait time.Duration) {
+	tab.expire(now)
+	q := tab.queues[topic]
+	if q == nil {
+		if len(tab.queues) >= topicTableLimit {
+			return false, tab.issue(topic, n.ID(), ip, now.Add(topicAdLifetime)), topicAdLifetime
+		}
+		q = &topicQueue{reservations: make(map[enode.ID]mclock.AbsTime)}
+		tab.queues[topic] = q
+	}
+
+	// Only one ad per node, it can re-register once the ad expires.
+	for _, ad := range q.ads {
+		if ad.node.ID() == n.ID() {
+			return false, tab.issue(topic, n.ID(), ip, ad.expiry), time.Duration(ad.expiry - now)
+		}
+	}
+	// Redeem the ticket.
+	if tk, err := tab.decodeTicket(ticket); err == nil && tk.Topic == topic && tk.Node == n.ID() && tk.IP.Equal(ip) {
+		ready, reserved := q.reservations[n.ID()]
+		switch {
+		case reserved && uint64(ready) == tk.Ready && now < ready:
+			// Too early, keep waiting.
+			return false, ticket, time.Duration(ready - now)
+		case reserved && uint64(ready) == tk.Ready && len(q.ads) < topicQueueCapacity:
+			delete(q.reservations, n.ID())
+			q.place(n, now)
+			return true, nil, 0
+		}
+	}
+	delete(q.reservations, n.ID())
+	if len(q.ads)+len(q.reservations) < topicQueueCapacity {
+		q.place(n, now)
+		return true, nil, 0
+	}
+	ready, ok := q.nextSlot(now)
+	if !ok {
+		// Every slot is spoken for. The ticket carries no reservation, the
+		// registrant has to start over when it is ready.
+		return false, tab.issue(topic, n.ID(), ip, ready), time.Duration(ready - now)
+	}
+	q.reservations[n.ID()] = ready
+	return false, tab.issue(topic, n.ID(), ip, ready), time.Duration(ready - now)
+}
+
+// search returns up to limit random nodes advertising the topic.
+func (tab *topicTable) search(topic v5wire.TopicID, limit int, now mclock.AbsTime) []*enode.Node {
+	tab.expire(now)
+	q := tab.queues[topic]
+	if q == nil {
+		return nil
+	}
+	nodes := make([]*enode.Node, len(q.ads))
+	for i, ad := range q.ads {
+		nodes[i] = ad.node
+	}
+	rand.Shuffle(len(nodes), func(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] })
+	if len(nodes) > limit {
+		nodes = nodes[:limit]
+	}
+	return nodes
+}
+
+// expire removes expired ads, unused reservations and empty queues.
+func (tab *topicTable) expire(now mclock.AbsTime) {
+	for topic, q := range tab.queues {
+		i := 0
+		for i < len(q.ads) && q.ads[i].expiry <= now {
+			i++
+		}
+		q.ads = q.ads[i:]
+		for id, ready := range q.reservations {
+			if ready.Add(topicRegWindow) < now {
+				delete(q.reservations, id)
+			}
+		}
+		if len(q.ads) == 0 && len(q.reservations) == 0 {
+			delete(tab.queues, topic)
+		}
+	}
+}
+
+func (q *topicQueue) place(n *enode.Node, now mclock.AbsTime) {
+	q.ads = append(q.ads, topicAd{node: n, expiry: now.Add(topicAdLifetime)})
+}
+
+// nextSlot returns the time when the next unreserved slot becomes free. It
+// returns false if every slot is already reserved.
+func (q *topicQueue) nextSlot(now mclock.AbsTime) (mclock.AbsTime, bool) {
+	if len(q.reservations) >= topicQueueCapacity {
+		return q.retryTime(now), false
+	}
+	pending := make(map[mclock.AbsTime]int)
+	for _, ready := range q.reservations {
+		if ready > now {
+			pending[ready]++
+		}
+	}
+	for _, ad := range q.ads {
+		if pending[ad.expiry] > 0 {
+			pending[ad.expiry]--
+			continue
+		}
+		return ad.expiry, true
+	}
+	return q.retryTime(now), false
+}
+
+// retryTime returns the time at which a registrant that could not get a
+// reservation should try again.
+func (q *topicQueue) retryTime(now mclock.AbsTime) mclock.AbsTime {
+	if len(q.ads) > 0 {
+		if last := q.ads[len(q.ads)-1].expiry; last > now {
+			return last
+		}
+	}
+	return now.Add(topicRegWindow)
+}
+
+// issue creates a ticket.
+func (tab *topicTable) issue(topic v5wire.TopicID, id enode.ID, ip net.IP, ready mclock.AbsTime) []byte {
+	enc, _ := rlp.EncodeToBytes(&topicTicket{Topic: topic, Node: id, IP: ip, Ready: uint64(ready)})
+	return append(enc, tab.mac(enc)...)
+}
+
+// decodeTicket verifies and decodes a ticket.
+func (tab *topicTable) decodeTicket(ticket []byte) (*topicTicket, error) {
+	if len(ticket) <= topicTicketMACSize {
+		return nil, errInvalidTicket
+	}
+	enc, mac := ticket[:len(ticket)-topicTicketMACSize], ticket[len(ticket)-topicTicketMACSize:]
+	if !hmac.Equal(mac, tab.mac(enc)) {
+		return nil, errTicketMAC
+	}
+	tk := new(topicTicket)
+	if err := rlp.DecodeBytes(enc, tk); err != nil {
+		return nil, errInvalidTicket
+	}
+	return tk, nil
+}
+
+func (tab *topicTable) mac(data []byte) []byte {
+	h := hmac.New(sha256.New, tab.key)
+	h.Write(data)
+	return h.Sum(nil)[:topicTicketMACSize]
+}
+
+// topicReg is a running topic registration.
+type topicReg struct {
+	cancel context.CancelFunc
+	done   chan struct{} // closed when all registrars have stopped
+}
+
+// RegisterTopic starts advertising the local node in the given topic. The
+// node registers with the nodes closest to the topic ID, renewing its ads
+// until UnregisterTopic is called or the transport is closed.
+func (t *UDPv5) RegisterTopic(topic v5wire.TopicID) {
+	t.topicRegLock.Lock()
+	defer t.topicRegLock.Unlock()
+	if _, ok := t.topicRegs[topic]; ok || t.closeCtx.Err() != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(t.closeCtx)
+	reg := &topicReg{cancel: cancel, done: make(chan struct{})}
+	t.topicRegs[topic] = reg
+	t.wg.Add(1)
+	go t.topicRegLoop(ctx, topic, reg.done)
+}
+
+// UnregisterTopic stops advertising the local node in the given topic. The
+// ads which were already placed stay until they expire. It returns after all
+// registration requests of the topic have ended, so a later RegisterTopic
+// doesn't overlap with them.
+func (t *UDPv5) UnregisterTopic(topic v5wire.TopicID) {
+	t.topicRegLock.Lock()
+	defer t.topicRegLock.Unlock()
+	if reg, ok := t.topicRegs[topic]; ok {
+		reg.cancel()
+		delete(t.topicRegs, topic)
+		<-reg.done
+	}
+}
+
+// topicRegLoop keeps the registrations of a topic at topicRegistrarCount
+// registrars. When ctx is canceled, it waits for the registrars to stop
+// before closing stopped.
+func (t *UDPv5) topicRegLoop(ctx context.Context, topic v5wire.TopicID, stopped chan struct{}) {
+	defer t.wg.Done()
+	defer close(stopped)
+
+	var (
+		active     = make(map[enode.ID]struct{})
+		done       = make(chan enode.ID)
+		timer      = t.clock.NewTimer(0)
+		registrars sync.WaitGroup
+	)
+	defer timer.Stop()
+	defer registrars.Wait()
+	for {
+		select {
+		case <-timer.C():
+			for _, n := range t.newLookup(ctx, enode.ID(topic)).run() {
+				if len(active) >= topicRegistrarCount {
+					break
+				}
+				if _, ok := active[n.ID()]; ok {
+					continue
+				}
+				active[n.ID()] = struct{}{}
+				registrars.Add(1)
+				go func(n *enode.Node) {
+					defer registrars.Done()
+					t.registerAt(ctx, n, topic)
+					select {
+					case done <- n.ID():
+					case <-ctx.Done():
+					}
+				}(n)
+			}
+			timer.Reset(topicRegLookupDelay)
+		case id := <-done:
+			delete(active, id)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// registerAt keeps an ad for the topic at registrar n until ctx is canceled or
+// the registrar stops responding.
+func (t *UDPv5) registerAt(ctx context.Context, n *enode.Node, topic v5wire.TopicID) {
+	var ticket []byte
+	for {
+		placed, newTicket, wait, err := t.regtopic(n, topic, ticket)
+		if err != nil {
+			t.log.Debug("Topic registration failed", "topic", topic, "id", n.ID(), "err", err)
+			return
+		}
+		if placed {
+			t.log.Trace("Topic ad placed", "topic", topic, "id", n.ID())
+			wait = topicAdLifetime
+		}
+		ticket = newTicket
+
+		timer := t.clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// regtopic calls REGTOPIC on a node and waits for the TICKET or REGCONFIRMATION
+// response.
+func (t *UDPv5) regtopic(n *enode.Node, topic v5wire.TopicID, ticket []byte) (placed bool, newTicket []byte, wait time.Duration, err error) {
+	req := &v5wire.Regtopic{Topic: topic, ENR: t.Self().Record(), Ticket: ticket}
+	resp := t.call(n, v5wire.TicketMsg, req)
+	defer t.callDone(resp)
+
+	select {
+	case p := <-resp.ch:
+		switch p := p.(type) {
+		case *v5wire.Ticket:
+			// A registrar asking for more than one ad lifetime isn't worth
+			// waiting for. Giving up frees the registrar slot for another node.
+			if p.WaitTime > uint(topicAdLifetime/time.Second) {
+				return false, nil, 0, errTicketWait
+			}
+			return false, p.Ticket, time.Duration(p.WaitTime) * time.Second, nil
+		case *v5wire.Regconfirmation:
+			return true, nil, 0, nil
+		}
+		return false, nil, 0, errors.New("unexpected response")
+	case err := <-resp.err:
+		return false, nil, 0, err
+	}
+}
+
+// topicQuery calls TOPICQUERY on a node and waits for the NODES responses.
+func (t *UDPv5) topicQuery(n *enode.Node, topic v5wire.TopicID) ([]*enode.Node, error) {
+	resp := t.call(n, v5wire.NodesMsg, &v5wire.TopicQuery{Topic: topic})
+	return t.waitForNodes(resp, nil)
+}
+
+// handleRegtopic places an ad or issues a ticket.
+func (t *UDPv5) handleRegtopic(p *v5wire.Regtopic, fromID enode.ID, fromAddr *net.UDPAddr) {
+	if p.ENR == nil {
+		t.log.Debug("REGTOPIC without record", "id", fromID, "addr", fromAddr)
+		return
+	}
+	n, err := enode.New(t.validSchemes, p.ENR)
+	if err != nil {
+		t.log.Debug("Invalid record in REGTOPIC", "id", fromID, "addr", fromAddr, "err", err)
+		return
+	}
+	if n.ID() != fromID || !n.IP().Equal(fromAddr.IP) || n.UDP() != fromAddr.Port {
+		t.log.Debug("REGTOPIC record doesn't match sender", "id", fromID, "addr", fromAddr)
+		return
+	}
+	placed, ticket, wait := t.topics.register(p.Topic, n, fromAddr.IP, p.Ticket, t.clock.Now())
+	if placed {
+		t.sendResponse(fromID, fromAddr, &v5wire.Regconfirmation{ReqID: p.ReqID, Topic: p.Topic})
+		return
+	}
+	// Round the wait time up to whole seconds.
+	secs := uint((wait + time.Second - 1) / time.Second)
+	t.sendResponse(fromID, fromAddr, &v5wire.Ticket{ReqID: p.ReqID, Ticket: ticket, WaitTime: secs})
+}
+
+// handleTopicQuery returns the nodes advertising the topic.
+func (t *UDPv5) handleTopicQuery(p *v5wire.TopicQuery, fromID enode.ID, fromAddr *net.UDPAddr) {
+	nodes := t.topics.search(p.Topic, topicQueryResultLimit, t.clock.Now())
+	for _, resp := range packNodes(p.ReqID, nodes) {
+		t.sendResponse(fromID, fromAddr, resp)
+	}
+}
+
+// TopicSearch returns an iterator which finds nodes advertising the given
+// topic. It queries the nodes closest to the topic ID in rounds, starting a new
+// round at most every topicSearchDelay. The iterator can be used as a dial
+// source, e.g. in Protocol.DialCandidates.
+func (t *UDPv5) TopicSearch(topic v5wire.TopicID) enode.Iterator {
+	ctx, cancel := context.WithCancel(t.closeCtx)
+	return &topicIterator{t: t, topic: topic, ctx: ctx, cancel: cancel}
+}
+
+// topicIterator implements TopicSearch.
+type topicIterator struct {
+	t          *UDPv5
+	topic      v5wire.TopicID
+	ctx        context.Context
+	cancel     func()
+	registrars []*enode.Node
+	seen       map[enode.ID]struct{} // results of the current round
+	buffer     []*enode.Node
+	lastRound  mclock.AbsTime
+	rounds     int
+}
+
+// Node returns the current node.
+func (it *topicIterator) Node() *enode.Node {
+	if len(it.buffer) == 0 {
+		return nil
+	}
+	return it.buffer[0]
+}
+
+// Next moves to the next node.
+func (it *topicIterator) Next() bool {
+	// Consume next node in buffer.
+	if len(it.buffer) > 0 {
+		it.buffer = it.buffer[1:]
+	}
+	for len(it.buffer) == 0 {
+		if it.ctx.Err() != nil {
+			it.buffer = nil
+			return false
+		}
+		if len(it.registrars) == 0 {
+			it.startRound()
+			continue
+		}
+		n := it.registrars[0]
+		it.registrars = it.registrars[1:]
+		nodes, err := it.t.topicQuery(n, it.topic)
+		if err != nil {
+			it.t.log.Debug("Topic query failed", "topic", it.topic, "id", n.ID(), "err", err)
+		}
+		for _, n := range nodes {
+			if _, ok := it.seen[n.ID()]; !ok && n.ID() != it.t.Self().ID() {
+				it.seen[n.ID()] = struct{}{}
+				it.buffer = append(it.buffer, n)
+			}
+		}
+	}
+	return true
+}
+
+// startRound finds the registrars to query next.
+func (it *topicIterator) startRound() {
+	if it.rounds > 0 {
+		if wait := it.lastRound.Add(topicSearchDelay) - it.t.clock.Now(); wait > 0 {
+			timer := it.t.clock.NewTimer(time.Duration(wait))
+			select {
+			case <-timer.C():
+			case <-it.ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}
+	it.rounds++
+	it.lastRound = it.t.clock.Now()
+	it.seen = make(map[enode.ID]struct{})
+	it.registrars = it.t.newLookup(it.ctx, enode.ID(it.topic)).run()
+}
+
+// Close ends the iterator.
+func (it *topicIterator) Close() {
+	it.cancel()
+}
@@ -0,0 +1,310 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mbali/go-mbali/common/mclock"
+	"github.com/mbali/go-mbali/p2p/discover/v5wire"
+	"github.com/mbali/go-mbali/p2p/enode"
+)
+
+// This test checks that the topic table issues tickets for full queues and
+// honors them when the reserved slot becomes free.
+func TestTopicTable(t *testing.T) {
+	var (
+		tab   = newTopicTable()
+		topic = v5wire.NewTopicID("test")
+		ip    = net.IP{10, 0, 0, 1}
+		now   = mclock.AbsTime(0)
+		nodes = nodesAtDistance(enode.ID{}, 256, topicQueueCapacity+2)
+	)
+	// Fill the queue, one ad per second.
+	for i, n := range nodes[:topicQueueCapacity] {
+		if placed, _, _ := tab.register(topic, n, ip, nil, now); !placed {
+			t.Fatalf("ad %d not placed in non-full queue", i)
+		}
+		now = now.Add(time.Second)
+	}
+	if placed, _, wait := tab.register(topic, nodes[0], ip, nil, now); placed || wait != topicAdLifetime-topicQueueCapacity*time.Second {
+		t.Fatalf("second ad of node placed or wrong wait time %v", wait)
+	}
+
+	// The next two nodes get tickets for the slots of the oldest ads.
+	_, ticket1, wait1 := tab.register(topic, nodes[topicQueueCapacity], ip, nil, now)
+	_, ticket2, wait2 := tab.register(topic, nodes[topicQueueCapacity+1], ip, nil, now)
+	if ticket1 == nil || ticket2 == nil {
+		t.Fatal("no ticket issued for full queue")
+	}
+	if want := topicAdLifetime - topicQueueCapacity*time.Second; wait1 != want || wait2 != want+time.Second {
+		t.Fatalf("wrong wait times %v, %v", wait1, wait2)
+	}
+
+	// Using the ticket too early doesn't work.
+	now = now.Add(wait1 / 2)
+	placed, ticket, wait := tab.register(topic, nodes[topicQueueCapacity], ip, ticket1, now)
+	if placed || !bytes.Equal(ticket, ticket1) || wait != wait1/2 {
+		t.Fatalf("early ticket use: placed %t, wait %v", placed, wait)
+	}
+	// Tickets can't be used by other nodes.
+	if placed, _, _ := tab.register(topic, nodes[topicQueueCapacity+1], ip, ticket1, now); placed {
+		t.Fatal("ad placed with ticket of other node")
+	}
+	// The ticket works after the wait time.
+	now = now.Add(wait1 / 2)
+	if placed, _, _ := tab.register(topic, nodes[topicQueueCapacity], ip, ticket1, now); !placed {
+		t.Fatal("ad not placed with valid ticket")
+	}
+	// The second reservation is dropped after the registration window.
+	if _, err := tab.decodeTicket(ticket2); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Second + topicRegWindow + time.Millisecond)
+	tab.expire(now)
+	if n := len(tab.queues[topic].reservations); n != 0 {
+		t.Fatalf("%d reservations left after registration window", n)
+	}
+
+	// Invalid tickets are rejected.
+	forged := append([]byte{}, ticket1...)
+	forged[0]++
+	if _, err := tab.decodeTicket(forged); err != errTicketMAC {
+		t.Fatalf("wrong error for forged ticket: %v", err)
+	}
+
+	// Everything expires.
+	now = now.Add(topicAdLifetime + topicRegWindow)
+	if nodes := tab.search(topic, 10, now); len(nodes) != 0 || len(tab.queues) != 0 {
+		t.Fatalf("ads not expired")
+	}
+}
+
+// This test checks that a crowd of registrants can't reserve more slots than
+// the queue holds.
+func TestTopicTableReservationLimit(t *testing.T) {
+	var (
+		tab   = newTopicTable()
+		topic = v5wire.NewTopicID("test")
+		ip    = net.IP{10, 0, 0, 1}
+		now   = mclock.AbsTime(0)
+		nodes = nodesAtDistance(enode.ID{}, 256, 3*topicQueueCapacity)
+	)
+	tickets := make([][]byte, len(nodes))
+	for i, n := range nodes {
+		placed, ticket, wait := tab.register(topic, n, ip, nil, now)
+		switch {
+		case i < topicQueueCapacity && !placed:
+			t.Fatalf("ad %d not placed in non-full queue", i)
+		case i >= topicQueueCapacity && (placed || ticket == nil):
+			t.Fatalf("registrant %d: placed %t, ticket %x", i, placed, ticket)
+		case wait > topicAdLifetime:
+			t.Fatalf("registrant %d: wait time %v exceeds ad lifetime", i, wait)
+		}
+		tickets[i] = ticket
+	}
+	if n := len(tab.queues[topic].reservations); n != topicQueueCapacity {
+		t.Fatalf("%d reservations, want %d", n, topicQueueCapacity)
+	}
+
+	// Once the ads expire, only the reserved tickets can be redeemed.
+	now = now.Add(topicAdLifetime)
+	for i, n := range nodes[topicQueueCapacity:] {
+		placed, _, _ := tab.register(topic, n, ip, tickets[topicQueueCapacity+i], now)
+		if reserved := i < topicQueueCapacity; placed != reserved {
+			t.Fatalf("registrant %d: placed %t, want %t", topicQueueCapacity+i, placed, reserved)
+		}
+	}
+	if n := len(tab.queues[topic].ads); n != topicQueueCapacity {
+		t.Fatalf("%d ads in queue, want %d", n, topicQueueCapacity)
+	}
+}
+
+// This test checks that REGTOPIC and TOPICQUERY are handled.
+func TestUDPv5_regtopicHandling(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	topic := v5wire.NewTopicID("test")
+	remote := test.getNode(test.remotekey, test.remoteaddr).Node()
+
+	// Registration with a record which doesn't match the sender is ignored.
+	other := test.getNode(newkey(), &net.UDPAddr{IP: net.IP{10, 0, 1, 100}, Port: 30303}).Node()
+	test.packetIn(&v5wire.Regtopic{ReqID: []byte{0}, Topic: topic, ENR: other.Record()})
+
+	test.packetIn(&v5wire.Regtopic{ReqID: []byte{1}, Topic: topic, ENR: remote.Record()})
+	test.waitPacketOut(func(p *v5wire.Regconfirmation, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !bytes.Equal(p.ReqID, []byte{1}) || p.Topic != topic {
+			t.Errorf("wrong REGCONFIRMATION %v", p)
+		}
+	})
+	// The second registration has to wait until the ad expires.
+	test.packetIn(&v5wire.Regtopic{ReqID: []byte{2}, Topic: topic, ENR: remote.Record()})
+	test.waitPacketOut(func(p *v5wire.Ticket, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if len(p.Ticket) == 0 || p.WaitTime != uint(topicAdLifetime/time.Second) {
+			t.Errorf("wrong TICKET %v", p)
+		}
+	})
+
+	test.packetIn(&v5wire.TopicQuery{ReqID: []byte{3}, Topic: topic})
+	test.expectNodes([]byte{3}, 1, []*enode.Node{remote})
+	test.packetIn(&v5wire.TopicQuery{ReqID: []byte{4}, Topic: v5wire.NewTopicID("other")})
+	test.expectNodes([]byte{4}, 1, nil)
+}
+
+// This test checks that outgoing REGTOPIC calls handle both responses.
+func TestUDPv5_regtopicCall(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	var (
+		topic  = v5wire.NewTopicID("test")
+		remote = test.getNode(test.remotekey, test.remoteaddr).Node()
+		done   = make(chan error, 1)
+		placed bool
+		ticket []byte
+		wait   time.Duration
+	)
+	go func() {
+		var err error
+		placed, ticket, wait, err = test.udp.regtopic(remote, topic, nil)
+		done <- err
+	}()
+	test.waitPacketOut(func(p *v5wire.Regtopic, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if p.Topic != topic || p.ENR == nil || len(p.Ticket) != 0 {
+			t.Errorf("wrong REGTOPIC %v", p)
+		}
+		test.packetIn(&v5wire.Ticket{ReqID: p.ReqID, Ticket: []byte("ticket"), WaitTime: 5})
+	})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if placed || string(ticket) != "ticket" || wait != 5*time.Second {
+		t.Fatalf("wrong result: placed %t, ticket %q, wait %v", placed, ticket, wait)
+	}
+
+	go func() {
+		var err error
+		placed, _, _, err = test.udp.regtopic(remote, topic, ticket)
+		done <- err
+	}()
+	test.waitPacketOut(func(p *v5wire.Regtopic, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if string(p.Ticket) != "ticket" {
+			t.Errorf("wrong ticket in REGTOPIC: %q", p.Ticket)
+		}
+		test.packetIn(&v5wire.Regconfirmation{ReqID: p.ReqID, Topic: topic})
+	})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !placed {
+		t.Fatal("registration not confirmed")
+	}
+
+	// Tickets with a wait time beyond the ad lifetime are refused.
+	go func() {
+		var err error
+		_, _, _, err = test.udp.regtopic(remote, topic, nil)
+		done <- err
+	}()
+	test.waitPacketOut(func(p *v5wire.Regtopic, addr *net.UDPAddr, _ v5wire.Nonce) {
+		test.packetIn(&v5wire.Ticket{ReqID: p.ReqID, Ticket: []byte("ticket"), WaitTime: uint(topicAdLifetime/time.Second) + 1})
+	})
+	if err := <-done; err != errTicketWait {
+		t.Fatalf("wrong error for long wait time: %v", err)
+	}
+}
+
+// This test checks that UnregisterTopic waits for pending registrations.
+func TestUDPv5_unregisterTopic(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	topic := v5wire.NewTopicID("test")
+	remote := test.getNode(test.remotekey, test.remoteaddr).Node()
+	fillTable(test.table, []*node{wrapNode(remote)})
+
+	test.udp.RegisterTopic(topic)
+	test.waitPacketOut(func(p *v5wire.Findnode, addr *net.UDPAddr, _ v5wire.Nonce) {
+		test.packetIn(&v5wire.Nodes{ReqID: p.ReqID, Total: 1})
+	})
+	var reqID []byte
+	test.waitPacketOut(func(p *v5wire.Regtopic, addr *net.UDPAddr, _ v5wire.Nonce) {
+		reqID = p.ReqID
+	})
+
+	unregistered := make(chan struct{})
+	go func() {
+		test.udp.UnregisterTopic(topic)
+		close(unregistered)
+	}()
+	select {
+	case <-unregistered:
+		t.Fatal("UnregisterTopic returned during pending REGTOPIC call")
+	case <-time.After(50 * time.Millisecond):
+	}
+	test.packetIn(&v5wire.Regconfirmation{ReqID: reqID, Topic: topic})
+	select {
+	case <-unregistered:
+	case <-time.After(time.Second):
+		t.Fatal("UnregisterTopic didn't return after REGTOPIC call ended")
+	}
+}
+
+// Real sockets, real crypto: this test checks that a registered topic can be
+// found by another node.
+func TestUDPv5_topicE2E(t *testing.T) {
+	t.Parallel()
+
+	var nodes []*UDPv5
+	for i := 0; i < 4; i++ {
+		var cfg Config
+		if len(nodes) > 0 {
+			cfg.Bootnodes = []*enode.Node{nodes[0].Self()}
+		}
+		node := startLocalhostV5(t, cfg)
+		nodes = append(nodes, node)
+		defer node.Close()
+	}
+	topic := v5wire.NewTopicID("test")
+	nodes[1].RegisterTopic(topic)
+	time.Sleep(500 * time.Millisecond)
+
+	it := nodes[3].TopicSearch(topic)
+	defer it.Close()
+	found := make(chan *enode.Node, 1)
+	go func() {
+		for it.Next() {
+			found <- it.Node()
+			return
+		}
+	}()
+	select {
+	case n := <-found:
+		if n.ID() != nodes[1].Self().ID() {
+			t.Fatalf("found wrong node %v", n.ID())
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("topic search didn't find registered node")
+	}
+}
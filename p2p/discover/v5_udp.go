@@ -64,7 +64,7 @@ type UDPv5 struct {
 	// static fields
 	conn         UDPConn
 	tab          *Table
-	netrestrict  *netutil.Netlist
+	netfilter    *netutil.NetFilter
 	priv         *ecdsa.PrivateKey
 	localNode    *enode.LocalNode
 	db           *enode.DB
@@ -76,6 +76,11 @@ type UDPv5 struct {
 	trlock     sync.Mutex
 	trhandlers map[string]TalkRequestHandler
 
+	// topic advertisement
+	topics       *topicTable // registrar state, accessed by dispatch
+	topicRegLock sync.Mutex
+	topicRegs    map[v5wire.TopicID]*topicReg
+
 	// channels into dispatch
 	packetInCh    chan ReadPacket
 	readNextCh    chan struct{}
@@ -115,6 +120,12 @@ type callV5 struct {
 	timeout        mclock.Timer
 }
 
+// acceptsResponse reports whether a packet of the given type answers the call.
+func (c *callV5) acceptsResponse(kind byte) bool {
+	// REGTOPIC is answered by either TICKET or REGCONFIRMATION.
+	return kind == c.responseType || (c.responseType == v5wire.TicketMsg && kind == v5wire.RegconfirmationMsg)
+}
+
 // callTimeout is the response timeout event of a call.
 type callTimeout struct {
 	c     *callV5
@@ -143,12 +154,14 @@ func newUDPv5(conn UDPConn, ln *enode.LocalNode, cfg Config) (*UDPv5, error) {
 		conn:         conn,
 		localNode:    ln,
 		db:           ln.Database(),
-		netrestrict:  cfg.NetRestrict,
+		netfilter:    cfg.NetFilter,
 		priv:         cfg.PrivateKey,
 		log:          cfg.Log,
 		validSchemes: cfg.ValidSchemes,
 		clock:        cfg.Clock,
 		trhandlers:   make(map[string]TalkRequestHandler),
+		topics:       newTopicTable(),
+		topicRegs:    make(map[v5wire.TopicID]*topicReg),
 		// channels into dispatch
 		packetInCh:    make(chan ReadPacket, 1),
 		readNextCh:    make(chan struct{}, 1),
@@ -164,7 +177,7 @@ func newUDPv5(conn UDPConn, ln *enode.LocalNode, cfg Config) (*UDPv5, error) {
 		closeCtx:       closeCtx,
 		cancelCloseCtx: cancelCloseCtx,
 	}
-	tab, err := newTable(t, t.db, cfg.Bootnodes, cfg.Log)
+	tab, err := newTable(t, t.db, cfg.Bootnodes, cfg.NetFilter, cfg.Log)
 	if err != nil {
 		return nil, err
 	}
@@ -407,6 +420,9 @@ func (t *UDPv5) verifyResponseNode(c *callV5, r *enr.Record, distances []uint, s
 	if err := netutil.CheckRelayIP(c.node.IP(), node.IP()); err != nil {
 		return nil, err
 	}
+	if err := t.netfilter.Check(node.IP()); err != nil {
+		return nil, err
+	}
 	if c.node.UDP() <= 1024 {
 		return nil, errLowPort
 	}
@@ -673,7 +689,7 @@ func (t *UDPv5) handleCallResponse(fromID enode.ID, fromAddr *net.UDPAddr, p v5w
 		t.log.Debug(fmt.Sprintf("%s from wrong endpoint", p.Name()), "id", fromID, "addr", fromAddr)
 		return false
 	}
-	if p.Kind() != ac.responseType {
+	if !ac.acceptsResponse(p.Kind()) {
 		t.log.Debug(fmt.Sprintf("Wrong discv5 response type %s", p.Name()), "id", fromID, "addr", fromAddr)
 		return false
 	}
@@ -714,6 +730,14 @@ func (t *UDPv5) handle(p v5wire.Packet, fromID enode.ID, fromAddr *net.UDPAddr)
 		t.handleTalkRequest(p, fromID, fromAddr)
 	case *v5wire.TalkResponse:
 		t.handleCallResponse(fromID, fromAddr, p)
+	case *v5wire.Regtopic:
+		t.handleRegtopic(p, fromID, fromAddr)
+	case *v5wire.Ticket:
+		t.handleCallResponse(fromID, fromAddr, p)
+	case *v5wire.Regconfirmation:
+		t.handleCallResponse(fromID, fromAddr, p)
+	case *v5wire.TopicQuery:
+		t.handleTopicQuery(p, fromID, fromAddr)
 	}
 }
 
@@ -21,6 +21,7 @@ import (
 	"net"
 
 	"github.com/mbali/go-mbali/common/mclock"
+	"github.com/mbali/go-mbali/crypto"
 	"github.com/mbali/go-mbali/p2p/enode"
 	"github.com/mbali/go-mbali/p2p/enr"
 	"github.com/mbali/go-mbali/rlp"
@@ -42,9 +43,8 @@ const (
 	NodesMsg
 	TalkRequestMsg
 	TalkResponseMsg
-	RequestTicketMsg
-	TicketMsg
 	RegtopicMsg
+	TicketMsg
 	RegconfirmationMsg
 	TopicQueryMsg
 
@@ -113,38 +113,49 @@ type (
 		Message []byte
 	}
 
-	// REQUESTTICKET requests a ticket for a topic queue.
-	RequestTicket struct {
-		ReqID []byte
-		Topic []byte
-	}
-
-	// TICKET is the response to REQUESTTICKET.
-	Ticket struct {
+	// REGTOPIC registers the sender in a topic queue. The ticket is empty on the
+	// first attempt.
+	Regtopic struct {
 		ReqID  []byte
+		Topic  TopicID
+		ENR    *enr.Record
 		Ticket []byte
 	}
 
-	// REGTOPIC registers the sender in a topic queue using a ticket.
-	Regtopic struct {
-		ReqID  []byte
-		Ticket []byte
-		ENR    *enr.Record
+	// TICKET is the reply to REGTOPIC when the registration has to wait. The
+	// ticket can be used in another REGTOPIC after the wait time, in seconds.
+	Ticket struct {
+		ReqID    []byte
+		Ticket   []byte
+		WaitTime uint
 	}
 
-	// REGCONFIRMATION is the reply to REGTOPIC.
+	// REGCONFIRMATION is the reply to REGTOPIC when the ad was placed.
 	Regconfirmation struct {
-		ReqID      []byte
-		Registered bool
+		ReqID []byte
+		Topic TopicID
 	}
 
 	// TOPICQUERY asks for nodes with the given topic.
 	TopicQuery struct {
 		ReqID []byte
-		Topic []byte
+		Topic TopicID
 	}
 )
 
+// TopicID identifies a topic. It is the Keccak256 hash of the topic name.
+type TopicID [32]byte
+
+// NewTopicID creates the ID of a topic name.
+func NewTopicID(name string) TopicID {
+	return TopicID(crypto.Keccak256Hash([]byte(name)))
+}
+
+// String returns the topic ID in hex.
+func (t TopicID) String() string {
+	return fmt.Sprintf("%x", t[:])
+}
+
 // DecodeMessage decodes the message body of a packet.
 func DecodeMessage(ptype byte, body []byte) (Packet, error) {
 	var dec Packet
@@ -161,8 +172,6 @@ func DecodeMessage(ptype byte, body []byte) (Packet, error) {
 		dec = new(TalkRequest)
 	case TalkResponseMsg:
 		dec = new(TalkResponse)
-	case RequestTicketMsg:
-		dec = new(RequestTicket)
 	case TicketMsg:
 		dec = new(Ticket)
 	case RegtopicMsg:
@@ -223,11 +232,6 @@ func (*TalkResponse) Kind() byte               { return TalkResponseMsg }
 func (p *TalkResponse) RequestID() []byte      { return p.ReqID }
 func (p *TalkResponse) SetRequestID(id []byte) { p.ReqID = id }
 
-func (*RequestTicket) Name() string             { return "REQTICKET/v5" }
-func (*RequestTicket) Kind() byte               { return RequestTicketMsg }
-func (p *RequestTicket) RequestID() []byte      { return p.ReqID }
-func (p *RequestTicket) SetRequestID(id []byte) { p.ReqID = id }
-
 func (*Regtopic) Name() string             { return "REGTOPIC/v5" }
 func (*Regtopic) Kind() byte               { return RegtopicMsg }
 func (p *Regtopic) RequestID() []byte      { return p.ReqID }
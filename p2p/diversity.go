@@ -0,0 +1,179 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"errors"
+	"net"
+
+	"github.com/mbali/go-mbali/p2p/enode"
+	"github.com/mbali/go-mbali/p2p/netutil"
+)
+
+// Default subnet limits. Outbound slots are scarcer than inbound ones and are
+// the main target of eclipse attacks, so they get the tighter limits.
+var (
+	defaultInboundSubnetLimits  = SubnetLimits{IPv4Subnet24: 4, IPv4Subnet16: 8, IPv6Subnet48: 4}
+	defaultOutboundSubnetLimits = SubnetLimits{IPv4Subnet24: 2, IPv4Subnet16: 4, IPv6Subnet48: 2}
+)
+
+var errSubnetLimit = errors.New("too many peers in subnet")
+
+// DiversityConfig limits the number of peers from the same IP subnet, making it
+// harder for an attacker who controls a network range to occupy all peer slots.
+// Trusted peers and peers dialed as static nodes are exempt, as are peers with
+// LAN addresses.
+type DiversityConfig struct {
+	// Disabled turns off the subnet limits.
+	Disabled bool `toml:",omitempty"`
+
+	// Limits of the inbound and the outbound peer slots. Dynamically dialed
+	// peers count against the outbound limits.
+	Inbound  SubnetLimits `toml:",omitempty"`
+	Outbound SubnetLimits `toml:",omitempty"`
+}
+
+// SubnetLimits are the maximum numbers of peers in a single subnet. Zero selects
+// the default limit, a negative value removes the limit.
+type SubnetLimits struct {
+	IPv4Subnet24 int `toml:",omitempty"` // peers per IPv4 /24
+	IPv4Subnet16 int `toml:",omitempty"` // peers per IPv4 /16
+	IPv6Subnet48 int `toml:",omitempty"` // peers per IPv6 /48
+}
+
+func (l SubnetLimits) withDefaults(def SubnetLimits) SubnetLimits {
+	if l.IPv4Subnet24 == 0 {
+		l.IPv4Subnet24 = def.IPv4Subnet24
+	}
+	if l.IPv4Subnet16 == 0 {
+		l.IPv4Subnet16 = def.IPv4Subnet16
+	}
+	if l.IPv6Subnet48 == 0 {
+		l.IPv6Subnet48 = def.IPv6Subnet48
+	}
+	return l
+}
+
+// subnetLimit is a single prefix length and the number of addresses allowed in
+// each subnet of that length.
+type subnetLimit struct {
+	mask  net.IPMask
+	limit int
+}
+
+// subnetSet counts IP addresses by subnet. The nil set allows everything.
+type subnetSet struct {
+	v4, v6 []subnetLimit
+	counts map[string]int // keyed by subnet in CIDR notation
+}
+
+func newSubnetSet(limits SubnetLimits) *subnetSet {
+	return &subnetSet{
+		v4: []subnetLimit{
+			{net.CIDRMask(24, 32), limits.IPv4Subnet24},
+			{net.CIDRMask(16, 32), limits.IPv4Subnet16},
+		},
+		v6: []subnetLimit{
+			{net.CIDRMask(48, 128), limits.IPv6Subnet48},
+		},
+		counts: make(map[string]int),
+	}
+}
+
+// subnets calls fn for each limited subnet containing ip, until fn returns false.
+// Addresses without an IP and LAN addresses are exempt from the limits.
+func (s *subnetSet) subnets(ip net.IP, fn func(key string, limit int) bool) {
+	if s == nil || ip == nil || netutil.IsLAN(ip) {
+		return
+	}
+	limits := s.v6
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, limits = ip4, s.v4
+	}
+	for _, l := range limits {
+		if l.limit < 0 {
+			continue
+		}
+		subnet := net.IPNet{IP: ip.Mask(l.mask), Mask: l.mask}
+		if !fn(subnet.String(), l.limit) {
+			return
+		}
+	}
+}
+
+// allowed reports whether another address can be added without exceeding a limit.
+func (s *subnetSet) allowed(ip net.IP) bool {
+	ok := true
+	s.subnets(ip, func(key string, limit int) bool {
+		ok = s.counts[key] < limit
+		return ok
+	})
+	return ok
+}
+
+// add adds an address to the set, even when this exceeds a limit.
+func (s *subnetSet) add(ip net.IP) {
+	s.subnets(ip, func(key string, limit int) bool {
+		s.counts[key]++
+		return true
+	})
+}
+
+// remove removes an address from the set.
+func (s *subnetSet) remove(ip net.IP) {
+	s.subnets(ip, func(key string, limit int) bool {
+		if s.counts[key] <= 1 {
+			delete(s.counts, key)
+		} else {
+			s.counts[key]--
+		}
+		return true
+	})
+}
+
+// diversityExempt reports whether c is exempt from the subnet limits.
+func diversityExempt(c *conn) bool {
+	return c.is(trustedConn) || c.is(staticDialedConn)
+}
+
+// checkDiversity checks the subnet limits for a new connection against the
+// connected peers of the same direction.
+func (srv *Server) checkDiversity(peers map[enode.ID]*Peer, c *conn) error {
+	if srv.Diversity.Disabled || diversityExempt(c) {
+		return nil
+	}
+	inbound := c.is(inboundConn)
+	limits := srv.Diversity.Outbound.withDefaults(defaultOutboundSubnetLimits)
+	if inbound {
+		limits = srv.Diversity.Inbound.withDefaults(defaultInboundSubnetLimits)
+	}
+	set := newSubnetSet(limits)
+	for _, p := range peers {
+		if p.rw.is(inboundConn) == inbound && !diversityExempt(p.rw) {
+			set.add(netutil.AddrIP(p.RemoteAddr()))
+		}
+	}
+	if set.allowed(netutil.AddrIP(c.fd.RemoteAddr())) {
+		return nil
+	}
+	if inbound {
+		diversityInboundRejectMeter.Mark(1)
+	} else {
+		diversityOutboundRejectMeter.Mark(1)
+	}
+	return DiscTooManyPeers
+}
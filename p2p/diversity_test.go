@@ -0,0 +1,121 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mbali/go-mbali/log"
+	"github.com/mbali/go-mbali/p2p/enode"
+)
+
+func TestSubnetSet(t *testing.T) {
+	set := newSubnetSet(SubnetLimits{IPv4Subnet24: 2, IPv4Subnet16: 3, IPv6Subnet48: 1})
+	add := func(ip string) bool {
+		if !set.allowed(net.ParseIP(ip)) {
+			return false
+		}
+		set.add(net.ParseIP(ip))
+		return true
+	}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"1.2.3.1", true},
+		{"1.2.3.2", true},
+		{"1.2.3.3", false}, // /24 full
+		{"1.2.4.1", true},
+		{"1.2.5.1", false}, // /16 full
+		{"1.3.0.1", true},
+		{"::ffff:1.3.0.2", true}, // IPv4-mapped addresses count as IPv4
+		{"2001:db8:1::1", true},
+		{"2001:db8:1:ffff::1", false}, // /48 full
+		{"2001:db8:2::1", true},
+		{"10.0.0.1", true}, // LAN addresses are exempt
+		{"10.0.0.2", true},
+		{"10.0.0.3", true},
+	}
+	for _, test := range tests {
+		if ok := add(test.ip); ok != test.want {
+			t.Errorf("add %s: got %t, want %t", test.ip, ok, test.want)
+		}
+	}
+
+	set.remove(net.ParseIP("1.2.3.1"))
+	if !add("1.2.5.1") {
+		t.Error("address not allowed after removal")
+	}
+
+	// Negative limits disable the limit.
+	set = newSubnetSet(SubnetLimits{IPv4Subnet24: -1, IPv4Subnet16: -1, IPv6Subnet48: 1})
+	for i := 0; i < 10; i++ {
+		if !add("1.2.3.1") {
+			t.Fatal("address not allowed without limit")
+		}
+	}
+}
+
+func TestServerDiversity(t *testing.T) {
+	srv := &Server{Config: Config{
+		Diversity: DiversityConfig{
+			Inbound:  SubnetLimits{IPv4Subnet24: 2},
+			Outbound: SubnetLimits{IPv4Subnet24: 1},
+		},
+	}}
+	newConn := func(ip string, flags connFlag) *conn {
+		addr := &net.TCPAddr{IP: net.ParseIP(ip), Port: 30303}
+		return &conn{fd: &fakeAddrConn{remoteAddr: addr}, flags: flags, node: enode.NewV4(&newkey().PublicKey, addr.IP, addr.Port, 0)}
+	}
+	peers := make(map[enode.ID]*Peer)
+	for _, c := range []*conn{
+		newConn("1.2.3.1", inboundConn),
+		newConn("1.2.3.2", dynDialedConn),
+		newConn("1.2.3.3", staticDialedConn),
+		newConn("1.2.3.4", inboundConn|trustedConn),
+	} {
+		peers[c.node.ID()] = newPeer(log.Root(), c, nil)
+	}
+
+	tests := []struct {
+		c    *conn
+		want error
+	}{
+		{newConn("1.2.3.5", inboundConn), nil},
+		{newConn("1.2.3.5", dynDialedConn), DiscTooManyPeers},
+		{newConn("1.2.3.5", staticDialedConn), nil},
+		{newConn("1.2.4.1", dynDialedConn), nil},
+		{newConn("1.2.3.5", dynDialedConn|trustedConn), nil},
+	}
+	for i, test := range tests {
+		if err := srv.checkDiversity(peers, test.c); err != test.want {
+			t.Errorf("test %d: got error %v, want %v", i, err, test.want)
+		}
+	}
+
+	// Fill the inbound limit.
+	c := newConn("1.2.3.5", inboundConn)
+	peers[c.node.ID()] = newPeer(log.Root(), c, nil)
+	if err := srv.checkDiversity(peers, newConn("1.2.3.6", inboundConn)); err != DiscTooManyPeers {
+		t.Errorf("inbound limit not enforced, error %v", err)
+	}
+	srv.Diversity.Disabled = true
+	if err := srv.checkDiversity(peers, newConn("1.2.3.6", inboundConn)); err != nil {
+		t.Errorf("disabled limits enforced, error %v", err)
+	}
+}
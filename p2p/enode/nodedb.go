@@ -53,6 +53,12 @@ const (
 	// Local information is keyed by ID only, the full key is "local:<ID>:seq".
 	// Use localItemKey to create those keys.
 	dbLocalSeq = "seq"
+
+	// Bans are kept apart from the node entries so they survive the expiration
+	// of unseen nodes. The full keys are "ban:n:<ID>" and "ban:net:<CIDR>".
+	dbBanPrefix   = "ban:"
+	dbBanNodeRoot = "n:"
+	dbBanNetRoot  = "net:"
 )
 
 const (
@@ -427,6 +433,117 @@ func (db *DB) UpdateFindFailsV5(id ID, ip net.IP, fails int) error {
 	return db.storeInt64(v5Key(id, ip, dbNodeFindFails), int64(fails))
 }
 
+// Ban is the persisted ban state of a node or of an IP network.
+type Ban struct {
+	Until  uint64 // Unix time the ban expires at
+	Count  uint64 // Number of times the ban was imposed
+	Reason string // Reason of the last ban
+	IP     net.IP // IP address of a banned node at the time of the ban
+}
+
+// Active returns whmbler the ban is in effect at the given time.
+func (b *Ban) Active(now time.Time) bool {
+	return int64(b.Until) > now.Unix()
+}
+
+// nodeBanKey returns the database key of a node ban.
+func nodeBanKey(id ID) []byte {
+	return append([]byte(dbBanPrefix+dbBanNodeRoot), id[:]...)
+}
+
+// netBanKey returns the database key of an IP network ban.
+func netBanKey(network *net.IPNet) []byte {
+	return []byte(dbBanPrefix + dbBanNetRoot + network.String())
+}
+
+// fetchBan retrieves the ban stored under the given key.
+func (db *DB) fetchBan(key []byte) *Ban {
+	blob, err := db.lvl.Get(key, nil)
+	if err != nil {
+		return nil
+	}
+	ban := new(Ban)
+	if err := rlp.DecodeBytes(blob, ban); err != nil {
+		return nil
+	}
+	return ban
+}
+
+// storeBan stores a ban under the given key.
+func (db *DB) storeBan(key []byte, ban *Ban) error {
+	blob, err := rlp.EncodeToBytes(ban)
+	if err != nil {
+		return err
+	}
+	return db.lvl.Put(key, blob, nil)
+}
+
+// NodeBan retrieves the ban state of a node, nil if it was never banned.
+func (db *DB) NodeBan(id ID) *Ban {
+	return db.fetchBan(nodeBanKey(id))
+}
+
+// UpdateNodeBan stores the ban state of a node.
+func (db *DB) UpdateNodeBan(id ID, ban *Ban) error {
+	return db.storeBan(nodeBanKey(id), ban)
+}
+
+// DeleteNodeBan removes the ban state of a node.
+func (db *DB) DeleteNodeBan(id ID) error {
+	return db.lvl.Delete(nodeBanKey(id), nil)
+}
+
+// NetBan retrieves the ban state of an IP network, nil if it was never banned.
+func (db *DB) NetBan(network *net.IPNet) *Ban {
+	return db.fetchBan(netBanKey(network))
+}
+
+// UpdateNetBan stores the ban state of an IP network.
+func (db *DB) UpdateNetBan(network *net.IPNet, ban *Ban) error {
+	return db.storeBan(netBanKey(network), ban)
+}
+
+// DeleteNetBan removes the ban state of an IP network.
+func (db *DB) DeleteNetBan(network *net.IPNet) error {
+	return db.lvl.Delete(netBanKey(network), nil)
+}
+
+// NodeBans retrieves the ban states of all nodes, including expired bans.
+func (db *DB) NodeBans() map[ID]*Ban {
+	bans := make(map[ID]*Ban)
+	prefix := []byte(dbBanPrefix + dbBanNodeRoot)
+
+	it := db.lvl.NewIterator(util.BytesPrefix(prefix), nil)
+	defer it.Release()
+	for it.Next() {
+		var id ID
+		if len(it.Key()) != len(prefix)+len(id) {
+			continue
+		}
+		copy(id[:], it.Key()[len(prefix):])
+		if ban := db.fetchBan(it.Key()); ban != nil {
+			bans[id] = ban
+		}
+	}
+	return bans
+}
+
+// NetBans retrieves the ban states of all IP networks, keyed by their CIDR
+// notation, including expired bans.
+func (db *DB) NetBans() map[string]*Ban {
+	bans := make(map[string]*Ban)
+	prefix := []byte(dbBanPrefix + dbBanNetRoot)
+
+	it := db.lvl.NewIterator(util.BytesPrefix(prefix), nil)
+	defer it.Release()
+	for it.Next() {
+		if ban := db.fetchBan(it.Key()); ban != nil {
+			bans[string(it.Key()[len(prefix):])] = ban
+		}
+	}
+	return bans
+}
+
 // localSeq retrieves the local record sequence counter, defaulting to the current
 // timestamp if no previous exists. This ensures that wiping all data associated
 // with a node (apart from its key) will not generate already used sequence nums.
@@ -41,6 +41,11 @@ var (
 	egressConnectMeter  = metrics.NewRegisteredMeter("p2p/dials", nil)
 	egressTrafficMeter  = metrics.NewRegisteredMeter(egressMeterName, nil)
 	activePeerGauge     = metrics.NewRegisteredGauge("p2p/peers", nil)
+
+	// Connections and dial candidates rejected by the subnet diversity limits.
+	diversityInboundRejectMeter  = metrics.NewRegisteredMeter("p2p/diversity/inbound", nil)
+	diversityOutboundRejectMeter = metrics.NewRegisteredMeter("p2p/diversity/outbound", nil)
+	diversityDialRejectMeter     = metrics.NewRegisteredMeter("p2p/diversity/dial", nil)
 )
 
 // meteredConn is a wrapper around a net.Conn that meters both the
@@ -59,12 +59,21 @@ type Interface interface {
 //     "upnp"               uses the Universal Plug and Play protocol
 //     "pmp"                uses NAT-PMP with an auto-detected gateway address
 //     "pmp:192.168.0.1"    uses NAT-PMP with the given gateway address
+//     "stun"               asks the default STUN servers about the external address
+//     "stun:<servers>"     asks the given comma-separated STUN servers (host:port)
 func Parse(spec string) (Interface, error) {
 	var (
 		parts = strings.SplitN(spec, ":", 2)
 		mech  = strings.ToLower(parts[0])
 		ip    net.IP
 	)
+	if mech == "stun" {
+		var servers []string
+		if len(parts) > 1 {
+			servers = strings.Split(parts[1], ",")
+		}
+		return NewSTUN(servers), nil
+	}
 	if len(parts) > 1 {
 		ip = net.ParseIP(parts[1])
 		if ip == nil {
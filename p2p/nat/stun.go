@@ -0,0 +1,334 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package nat
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultSTUNServers are the public STUN servers used if none are configured.
+var DefaultSTUNServers = []string{
+	"stun.l.google.com:19302",
+	"stun1.l.google.com:19302",
+	"stun.cloudflare.com:3478",
+}
+
+const (
+	defaultSTUNTimeout = 2 * time.Second
+
+	// STUN message format, see RFC 5389.
+	stunHeaderSize         = 20
+	stunBindingRequest     = 0x0001
+	stunBindingSuccess     = 0x0101
+	stunMagicCookie        = 0x2112A442
+	stunAttrMappedAddr     = 0x0001
+	stunAttrXorMappedAddr  = 0x0020
+	stunFamilyIPv4         = 0x01
+	stunFamilyIPv6         = 0x02
+	stunMaxResponseSize    = 1280
+	stunTransactionIDSize  = 12
+	stunAttrHeaderSize     = 4
+	stunAttrAddrHeaderSize = 4
+	stunConnQueueSize      = 8
+)
+
+var (
+	errNoSTUNServers     = errors.New("no STUN servers")
+	errInvalidSTUNPacket = errors.New("invalid STUN response")
+	errNoMappedAddress   = errors.New("no mapped address in STUN response")
+)
+
+// STUN discovers the external address of the local machine by asking STUN
+// servers, without mapping any ports. It suits NATs which keep the port of
+// outgoing UDP traffic, the address reported for the discovery socket then
+// being reachable by other nodes.
+type STUN struct {
+	Servers []string      // host:port of the STUN servers, queried in order
+	Timeout time.Duration // time to wait for the response of each server
+}
+
+// NewSTUN creates a STUN client for the given servers. If none are given, the
+// default servers are used.
+func NewSTUN(servers []string) *STUN {
+	if len(servers) == 0 {
+		servers = DefaultSTUNServers
+	}
+	return &STUN{Servers: servers, Timeout: defaultSTUNTimeout}
+}
+
+func (s *STUN) String() string {
+	return fmt.Sprintf("STUN(%s)", strings.Join(s.Servers, ","))
+}
+
+// ExternalIP returns the external IP address reported by the first STUN server
+// which responds.
+func (s *STUN) ExternalIP() (net.IP, error) {
+	addr, err := s.Query(nil)
+	if err != nil {
+		return nil, err
+	}
+	return addr.IP, nil
+}
+
+// Query returns the external address of the given UDP socket, as reported by
+// the first STUN server which responds. If conn is nil, a temporary socket is
+// used. The socket must not be read by anyone else during the query, see
+// QueryShared for sockets which are in use.
+func (s *STUN) Query(conn net.PacketConn) (*net.UDPAddr, error) {
+	if conn == nil {
+		c, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			return nil, err
+		}
+		defer c.Close()
+		conn = c
+	} else {
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = defaultSTUNTimeout
+	}
+	err := errNoSTUNServers
+	for _, server := range s.Servers {
+		var addr *net.UDPAddr
+		if addr, err = stunQuery(conn, server, timeout); err == nil {
+			return addr, nil
+		}
+	}
+	return nil, err
+}
+
+// QueryShared is like Query, but runs on a socket which is read by someone
+// else, such as node discovery. Only one query may run on conn at a time.
+func (s *STUN) QueryShared(conn *STUNConn) (*net.UDPAddr, error) {
+	return s.Query(&stunQueryConn{conn: conn})
+}
+
+// These do nothing.
+
+func (*STUN) AddMapping(string, int, int, string, time.Duration) error { return nil }
+func (*STUN) DeleteMapping(string, int, int) error                     { return nil }
+
+// stunQuery sends a binding request to a STUN server and waits for the
+// response.
+func stunQuery(conn net.PacketConn, server string, timeout time.Duration) (*net.UDPAddr, error) {
+	addr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return nil, err
+	}
+	var txid [stunTransactionIDSize]byte
+	if _, err := rand.Read(txid[:]); err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteTo(stunRequest(txid), addr); err != nil {
+		return nil, err
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, stunMaxResponseSize)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Ignore unrelated packets, the socket may be in use by discovery.
+		if fromUDP, ok := from.(*net.UDPAddr); !ok || !fromUDP.IP.Equal(addr.IP) || fromUDP.Port != addr.Port {
+			continue
+		}
+		mapped, err := parseSTUNResponse(buf[:n], txid)
+		if err == errInvalidSTUNPacket {
+			continue
+		}
+		return mapped, err
+	}
+}
+
+// stunRequest encodes a binding request.
+func stunRequest(txid [stunTransactionIDSize]byte) []byte {
+	req := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint16(req[2:], 0)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	copy(req[8:], txid[:])
+	return req
+}
+
+// parseSTUNResponse decodes the mapped address of a binding response.
+func parseSTUNResponse(packet []byte, txid [stunTransactionIDSize]byte) (*net.UDPAddr, error) {
+	if len(packet) < stunHeaderSize ||
+		binary.BigEndian.Uint16(packet[0:]) != stunBindingSuccess ||
+		binary.BigEndian.Uint32(packet[4:]) != stunMagicCookie ||
+		!bytes.Equal(packet[8:stunHeaderSize], txid[:]) {
+		return nil, errInvalidSTUNPacket
+	}
+	length := int(binary.BigEndian.Uint16(packet[2:]))
+	if stunHeaderSize+length > len(packet) {
+		return nil, errInvalidSTUNPacket
+	}
+	var (
+		attrs  = packet[stunHeaderSize : stunHeaderSize+length]
+		mapped *net.UDPAddr
+	)
+	for len(attrs) >= stunAttrHeaderSize {
+		typ := binary.BigEndian.Uint16(attrs[0:])
+		size := int(binary.BigEndian.Uint16(attrs[2:]))
+		if stunAttrHeaderSize+size > len(attrs) {
+			return nil, errInvalidSTUNPacket
+		}
+		value := attrs[stunAttrHeaderSize : stunAttrHeaderSize+size]
+		switch typ {
+		case stunAttrXorMappedAddr:
+			// The XOR-mapped address takes precedence.
+			addr, err := decodeSTUNAddr(value, packet[4:stunHeaderSize])
+			if err != nil {
+				return nil, err
+			}
+			return addr, nil
+		case stunAttrMappedAddr:
+			addr, err := decodeSTUNAddr(value, nil)
+			if err != nil {
+				return nil, err
+			}
+			mapped = addr
+		}
+		// Attributes are padded to a multiple of four bytes.
+		size = (size + 3) &^ 3
+		if stunAttrHeaderSize+size > len(attrs) {
+			break
+		}
+		attrs = attrs[stunAttrHeaderSize+size:]
+	}
+	if mapped == nil {
+		return nil, errNoMappedAddress
+	}
+	return mapped, nil
+}
+
+// decodeSTUNAddr decodes an address attribute. The address is XOR-ed with the
+// given key (the magic cookie followed by the transaction ID) if non-nil.
+func decodeSTUNAddr(value, key []byte) (*net.UDPAddr, error) {
+	if len(value) < stunAttrAddrHeaderSize {
+		return nil, errInvalidSTUNPacket
+	}
+	var ip net.IP
+	switch value[1] {
+	case stunFamilyIPv4:
+		ip = make(net.IP, net.IPv4len)
+	case stunFamilyIPv6:
+		ip = make(net.IP, net.IPv6len)
+	default:
+		return nil, errInvalidSTUNPacket
+	}
+	if len(value) != stunAttrAddrHeaderSize+len(ip) {
+		return nil, errInvalidSTUNPacket
+	}
+	copy(ip, value[stunAttrAddrHeaderSize:])
+	port := binary.BigEndian.Uint16(value[2:])
+	if key != nil {
+		port ^= binary.BigEndian.Uint16(key)
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// STUNConn wraps a UDP socket so STUN queries can run on it while it is in use.
+// ReadFromUDP hides STUN responses from the reader and passes them on to the
+// running query instead.
+type STUNConn struct {
+	*net.UDPConn
+	responses chan stunPacket
+}
+
+type stunPacket struct {
+	data []byte
+	from net.Addr
+}
+
+// NewSTUNConn wraps the given socket.
+func NewSTUNConn(conn *net.UDPConn) *STUNConn {
+	return &STUNConn{UDPConn: conn, responses: make(chan stunPacket, stunConnQueueSize)}
+}
+
+// ReadFromUDP reads the next packet which isn't a STUN response.
+func (c *STUNConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	for {
+		n, from, err := c.UDPConn.ReadFromUDP(b)
+		if err != nil || !isSTUNResponse(b[:n]) {
+			return n, from, err
+		}
+		select {
+		case c.responses <- stunPacket{data: append([]byte(nil), b[:n]...), from: from}:
+		default:
+			// Nobody is waiting for the response.
+		}
+	}
+}
+
+// isSTUNResponse reports whether the packet looks like a binding response.
+func isSTUNResponse(packet []byte) bool {
+	return len(packet) >= stunHeaderSize &&
+		binary.BigEndian.Uint16(packet[0:]) == stunBindingSuccess &&
+		binary.BigEndian.Uint32(packet[4:]) == stunMagicCookie
+}
+
+// stunQueryConn is the side of a STUNConn used by queries. It reads the STUN
+// responses diverted by STUNConn.ReadFromUDP.
+type stunQueryConn struct {
+	conn     *STUNConn
+	deadline time.Time
+}
+
+func (q *stunQueryConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	var timeout <-chan time.Time
+	if !q.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(q.deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case p := <-q.conn.responses:
+		return copy(b, p.data), p.from, nil
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (q *stunQueryConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return q.conn.WriteTo(b, addr)
+}
+
+func (q *stunQueryConn) SetReadDeadline(t time.Time) error {
+	q.deadline = t
+	return nil
+}
+
+func (q *stunQueryConn) SetDeadline(t time.Time) error      { return q.SetReadDeadline(t) }
+func (q *stunQueryConn) SetWriteDeadline(t time.Time) error { return nil }
+func (q *stunQueryConn) LocalAddr() net.Addr                { return q.conn.LocalAddr() }
+func (q *stunQueryConn) Close() error                       { return nil }
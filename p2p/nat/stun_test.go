@@ -0,0 +1,177 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package nat
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// startSTUNResponder runs a STUN server on localhost, which reports the sender
+// address of binding requests in an attribute of the given type.
+func startSTUNResponder(t *testing.T, attr uint16) *net.UDPConn {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, stunMaxResponseSize)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n != stunHeaderSize || binary.BigEndian.Uint16(buf) != stunBindingRequest {
+				continue
+			}
+			ip, port := append(net.IP{}, from.IP.To4()...), uint16(from.Port)
+			value := make([]byte, stunAttrAddrHeaderSize+len(ip))
+			value[1] = stunFamilyIPv4
+			if attr == stunAttrXorMappedAddr {
+				port ^= stunMagicCookie >> 16
+				for i := range ip {
+					ip[i] ^= buf[4+i]
+				}
+			}
+			binary.BigEndian.PutUint16(value[2:], port)
+			copy(value[stunAttrAddrHeaderSize:], ip)
+
+			resp := make([]byte, stunHeaderSize+stunAttrHeaderSize+len(value))
+			binary.BigEndian.PutUint16(resp[0:], stunBindingSuccess)
+			binary.BigEndian.PutUint16(resp[2:], uint16(stunAttrHeaderSize+len(value)))
+			copy(resp[4:], buf[4:stunHeaderSize])
+			binary.BigEndian.PutUint16(resp[20:], attr)
+			binary.BigEndian.PutUint16(resp[22:], uint16(len(value)))
+			copy(resp[24:], value)
+			conn.WriteToUDP(resp, from)
+		}
+	}()
+	return conn
+}
+
+func TestSTUNQuery(t *testing.T) {
+	for _, attr := range []uint16{stunAttrXorMappedAddr, stunAttrMappedAddr} {
+		server := startSTUNResponder(t, attr)
+		defer server.Close()
+
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		stun := NewSTUN([]string{server.LocalAddr().String()})
+		addr, err := stun.Query(conn)
+		if err != nil {
+			t.Fatalf("attribute %#04x: query failed: %v", attr, err)
+		}
+		if want := conn.LocalAddr().(*net.UDPAddr); !addr.IP.Equal(want.IP) || addr.Port != want.Port {
+			t.Errorf("attribute %#04x: wrong address: have %v, want %v", attr, addr, want)
+		}
+	}
+}
+
+// This test checks that a query can run on a socket which is read concurrently.
+func TestSTUNQueryShared(t *testing.T) {
+	server := startSTUNResponder(t, stunAttrXorMappedAddr)
+	defer server.Close()
+
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	conn := NewSTUNConn(udp)
+
+	// The reader gets other packets, but no STUN responses.
+	received := make(chan []byte, 10)
+	go func() {
+		buf := make([]byte, stunMaxResponseSize)
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				close(received)
+				return
+			}
+			received <- append([]byte(nil), buf[:n]...)
+		}
+	}()
+	stun := NewSTUN([]string{server.LocalAddr().String()})
+	addr, err := stun.QueryShared(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := udp.LocalAddr().(*net.UDPAddr); !addr.IP.Equal(want.IP) || addr.Port != want.Port {
+		t.Errorf("wrong address: have %v, want %v", addr, want)
+	}
+	if _, err := server.WriteToUDP([]byte("ping"), udp.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-received:
+		if string(p) != "ping" {
+			t.Errorf("reader got wrong packet %x", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reader didn't get packet")
+	}
+}
+
+func TestSTUNFailover(t *testing.T) {
+	// The first server doesn't respond
+	silent, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	server := startSTUNResponder(t, stunAttrXorMappedAddr)
+	defer server.Close()
+
+	stun := &STUN{
+		Servers: []string{silent.LocalAddr().String(), server.LocalAddr().String()},
+		Timeout: 200 * time.Millisecond,
+	}
+	ip, err := stun.ExternalIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.IP{127, 0, 0, 1}) {
+		t.Errorf("wrong IP: %v", ip)
+	}
+}
+
+func TestParseSTUN(t *testing.T) {
+	for spec, want := range map[string][]string{
+		"stun":                       DefaultSTUNServers,
+		"STUN:1.2.3.4:3478,host:100": {"1.2.3.4:3478", "host:100"},
+	} {
+		n, err := Parse(spec)
+		if err != nil {
+			t.Fatalf("%q: %v", spec, err)
+		}
+		stun, ok := n.(*STUN)
+		if !ok {
+			t.Fatalf("%q: wrong interface %T", spec, n)
+		}
+		if !reflect.DeepEqual(stun.Servers, want) {
+			t.Errorf("%q: wrong servers: have %v, want %v", spec, stun.Servers, want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mbali/go-mbali/p2p/enode"
+	"github.com/mbali/go-mbali/p2p/netutil"
+)
+
+// netListsReloadInterval is the interval at which the file of IP allow and
+// deny lists is checked for changes.
+const netListsReloadInterval = 5 * time.Second
+
+// NetLists returns the IP allow and deny lists. A nil allow list allows all
+// addresses which are not denied.
+func (srv *Server) NetLists() (allow, deny *netutil.Netlist) {
+	if srv.netFilter == nil {
+		return srv.NetRestrict, srv.NetDeny
+	}
+	return srv.netFilter.Lists()
+}
+
+// SetNetLists replaces the IP allow and deny lists, disconnecting the peers
+// which don't match them anymore. A nil allow list allows all addresses which
+// are not denied. The lists are replaced again if the file of lists changes.
+func (srv *Server) SetNetLists(allow, deny *netutil.Netlist) error {
+	if srv.netFilter == nil {
+		return errServerStopped
+	}
+	srv.netFilter.SetLists(allow, deny)
+	srv.dropFiltered()
+	return nil
+}
+
+// setupNetFilter creates the IP allow and deny lists, loading them from the
+// file of lists if configured.
+func (srv *Server) setupNetFilter() error {
+	srv.netFilter = netutil.NewNetFilter(srv.NetRestrict, srv.NetDeny)
+	if srv.NetListsFile == "" {
+		return nil
+	}
+	allow, deny, err := netutil.LoadNetLists(srv.NetListsFile)
+	if err != nil {
+		return fmt.Errorf("can't load IP lists: %v", err)
+	}
+	srv.netFilter.SetLists(allow, deny)
+	return nil
+}
+
+// watchNetLists reloads the IP allow and deny lists whenever their file
+// changes. Invalid lists are ignored, keeping the current ones.
+func (srv *Server) watchNetLists() {
+	defer srv.loopWG.Done()
+
+	var lastMod time.Time
+	if stat, err := os.Stat(srv.NetListsFile); err == nil {
+		lastMod = stat.ModTime()
+	}
+	ticker := time.NewTicker(netListsReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			stat, err := os.Stat(srv.NetListsFile)
+			if err != nil || stat.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = stat.ModTime()
+
+			allow, deny, err := netutil.LoadNetLists(srv.NetListsFile)
+			if err != nil {
+				srv.log.Warn("Failed to reload IP lists", "file", srv.NetListsFile, "err", err)
+				continue
+			}
+			srv.log.Info("Reloaded IP lists", "file", srv.NetListsFile)
+			srv.netFilter.SetLists(allow, deny)
+			srv.dropFiltered()
+
+		case <-srv.quit:
+			return
+		}
+	}
+}
+
+// dropFiltered disconnects the peers which don't match the IP allow and deny
+// lists.
+func (srv *Server) dropFiltered() {
+	srv.doPeerOp(func(peers map[enode.ID]*Peer) {
+		for _, p := range peers {
+			ip := netutil.AddrIP(p.RemoteAddr())
+			if ip == nil {
+				continue
+			}
+			if err := srv.netFilter.Check(ip); err != nil {
+				p.log.Debug("Dropping filtered peer", "err", err)
+				p.Disconnect(DiscUselessPeer)
+			}
+		}
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mbali/go-mbali/internal/testlog"
+	"github.com/mbali/go-mbali/log"
+	"github.com/mbali/go-mbali/p2p/netutil"
+)
+
+func TestServerNetLists(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "netlists")
+	if err := os.WriteFile(file, []byte("allow 1.0.0.0/8\ndeny 1.2.3.0/24\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{
+		Config: Config{
+			PrivateKey:   newkey(),
+			MaxPeers:     10,
+			NoDial:       true,
+			NoDiscovery:  true,
+			NetRestrict:  new(netutil.Netlist), // Overridden by the file
+			NetListsFile: file,
+			Logger:       testlog.Logger(t, log.LvlTrace),
+		},
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal("can't start: ", err)
+	}
+	defer srv.Stop()
+
+	// The lists of the file apply to inbound connections
+	for _, test := range []struct {
+		ip  net.IP
+		err error
+	}{
+		{net.IP{1, 1, 1, 1}, nil},
+		{net.IP{1, 2, 3, 4}, netutil.ErrNetDeny},
+		{net.IP{2, 2, 2, 2}, netutil.ErrNetRestrict},
+	} {
+		if err := srv.checkInboundConn(test.ip); err != test.err {
+			t.Errorf("%v: error mismatch: have %v, want %v", test.ip, err, test.err)
+		}
+	}
+	// Lifting the restrictions allows the addresses
+	if err := srv.SetNetLists(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []net.IP{{1, 2, 3, 5}, {2, 2, 2, 3}} {
+		if err := srv.checkInboundConn(ip); err != nil {
+			t.Errorf("%v: address rejected: %v", ip, err)
+		}
+	}
+	if allow, deny := srv.NetLists(); allow != nil || deny != nil {
+		t.Errorf("wrong lists: allow %v, deny %v", allow, deny)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package netutil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNetRestrict = errors.New("not contained in netrestrict list")
+	ErrNetDeny     = errors.New("contained in netdeny list")
+)
+
+// NetFilter restricts communication to the IP networks of an allow list, and
+// forbids it with the IP networks of a deny list. The lists can be updated at
+// any time. A nil filter allows all addresses.
+type NetFilter struct {
+	mu    sync.RWMutex
+	allow *Netlist // nil if all addresses are allowed
+	deny  *Netlist
+}
+
+// NewNetFilter creates a filter from an allow list and a deny list, both of
+// which may be nil.
+func NewNetFilter(allow, deny *Netlist) *NetFilter {
+	f := new(NetFilter)
+	f.SetLists(allow, deny)
+	return f
+}
+
+// Check returns an error if communication with the given IP is forbidden.
+func (f *NetFilter) Check(ip net.IP) error {
+	if f == nil {
+		return nil
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.allow != nil && !f.allow.Contains(ip) {
+		return ErrNetRestrict
+	}
+	if f.deny.Contains(ip) {
+		return ErrNetDeny
+	}
+	return nil
+}
+
+// Allowed reports whmbler communication with the given IP is allowed.
+func (f *NetFilter) Allowed(ip net.IP) bool {
+	return f.Check(ip) == nil
+}
+
+// Lists returns copies of the allow list and the deny list.
+func (f *NetFilter) Lists() (allow, deny *Netlist) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.allow.copy(), f.deny.copy()
+}
+
+// SetLists replaces the allow list and the deny list. A nil allow list allows
+// all addresses which are not denied.
+func (f *NetFilter) SetLists(allow, deny *Netlist) {
+	allow, deny = allow.copy(), deny.copy()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.allow, f.deny = allow, deny
+}
+
+// copy returns a copy of the list.
+func (l *Netlist) copy() *Netlist {
+	if l == nil {
+		return nil
+	}
+	cpy := make(Netlist, len(*l))
+	copy(cpy, *l)
+	return &cpy
+}
+
+// LoadNetLists reads an allow list and a deny list from a file. Each line of
+// the file holds either "allow" or "deny" followed by comma-separated CIDR
+// masks. Empty lines and lines starting with '#' are ignored. The returned
+// allow list is nil if the file has no allow entries.
+func LoadNetLists(file string) (allow, deny *Netlist, err error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fd.Close()
+
+	return ReadNetLists(fd)
+}
+
+// ReadNetLists reads an allow list and a deny list in the format of
+// LoadNetLists.
+func ReadNetLists(r io.Reader) (allow, deny *Netlist, err error) {
+	deny = new(Netlist)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		sep := strings.IndexAny(text, " \t")
+		if sep < 0 {
+			return nil, nil, fmt.Errorf("line %d: missing networks", line)
+		}
+		list, err := ParseNetlist(text[sep+1:])
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		switch text[:sep] {
+		case "allow":
+			if allow == nil {
+				allow = new(Netlist)
+			}
+			*allow = append(*allow, *list...)
+		case "deny":
+			*deny = append(*deny, *list...)
+		default:
+			return nil, nil, fmt.Errorf("line %d: unknown list %q", line, text[:sep])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return allow, deny, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package netutil
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNetFilter(t *testing.T) {
+	allow, _ := ParseNetlist("10.0.0.0/8, 192.168.0.0/16")
+	deny, _ := ParseNetlist("10.1.0.0/16")
+	f := NewNetFilter(allow, deny)
+
+	tests := []struct {
+		ip  string
+		err error
+	}{
+		{"10.0.0.1", nil},
+		{"192.168.1.1", nil},
+		{"10.1.2.3", ErrNetDeny},
+		{"127.0.0.1", ErrNetRestrict},
+	}
+	for _, test := range tests {
+		if err := f.Check(net.ParseIP(test.ip)); err != test.err {
+			t.Errorf("%s: error mismatch: have %v, want %v", test.ip, err, test.err)
+		}
+	}
+	// Updating the lists applies immediately
+	f.SetLists(nil, deny)
+	if err := f.Check(net.ParseIP("127.0.0.1")); err != nil {
+		t.Errorf("address not allowed without allow list: %v", err)
+	}
+	if err := f.Check(net.ParseIP("10.1.2.3")); err != ErrNetDeny {
+		t.Errorf("denied address allowed: %v", err)
+	}
+	// The nil filter allows everything
+	var nilFilter *NetFilter
+	if !nilFilter.Allowed(net.ParseIP("10.1.2.3")) {
+		t.Error("nil filter doesn't allow address")
+	}
+}
+
+func TestReadNetLists(t *testing.T) {
+	allow, deny, err := ReadNetLists(strings.NewReader(`
+# Internal networks
+allow 10.0.0.0/8, 192.168.0.0/16
+allow	172.16.0.0/12
+deny 10.1.0.0/16
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := allow.MarshalTOML(), []string{"10.0.0.0/8", "192.168.0.0/16", "172.16.0.0/12"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("allow list mismatch: have %v, want %v", have, want)
+	}
+	if have, want := deny.MarshalTOML(), []string{"10.1.0.0/16"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("deny list mismatch: have %v, want %v", have, want)
+	}
+	// Files without allow entries allow everything
+	if allow, _, _ := ReadNetLists(strings.NewReader("deny 10.0.0.0/8")); allow != nil {
+		t.Errorf("allow list not nil: %v", allow)
+	}
+	for _, input := range []string{"allow", "permit 10.0.0.0/8", "deny 10.0.0.0"} {
+		if _, _, err := ReadNetLists(strings.NewReader(input)); err == nil {
+			t.Errorf("%q: no error for invalid lists", input)
+		}
+	}
+}
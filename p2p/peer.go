@@ -117,6 +117,12 @@ type Peer struct {
 	// events receives message send / receive events if set
 	events   *event.Feed
 	testPipe *MsgPipeRW // for testing
+
+	// reporter scores misbehaviors if set
+	reporter func(p *Peer, reason string, weight int)
+
+	// traffic counts the subprotocol messages exchanged
+	traffic *peerTraffic
 }
 
 // NewPeer returns a peer for testing purposes.
@@ -190,6 +196,16 @@ func (p *Peer) RunningCap(protocol string, versions []uint) bool {
 	return false
 }
 
+// ReportMisbehavior reports a misbehavior of the peer, with a weight according
+// to its severity (see MisbehaviorMinor and friends). Peers accumulating
+// misbehaviors are disconnected and banned for escalating durations.
+func (p *Peer) ReportMisbehavior(reason string, weight int) {
+	if p == nil || p.reporter == nil {
+		return
+	}
+	p.reporter(p, reason, weight)
+}
+
 // RemoteAddr returns the remote address of the network connection.
 func (p *Peer) RemoteAddr() net.Addr {
 	return p.rw.fd.RemoteAddr()
@@ -234,6 +250,7 @@ func newPeer(log log.Logger, conn *conn, protocols []Protocol) *Peer {
 		protoErr: make(chan error, len(protomap)+1), // protocols + pingLoop
 		closed:   make(chan struct{}),
 		log:      log.New("id", conn.node.ID(), "conn", conn.flags),
+		traffic:  newPeerTraffic(),
 	}
 	return p
 }
@@ -351,6 +368,7 @@ func (p *Peer) handle(msg Msg) error {
 			metrics.GetOrRegisterMeter(m, nil).Mark(int64(msg.meterSize))
 			metrics.GetOrRegisterMeter(m+"/packets", nil).Mark(1)
 		}
+		p.traffic.add(proto.cap(), msg.Code-proto.offset, msg.Size, true)
 		select {
 		case proto.in <- msg:
 			return nil
@@ -405,6 +423,7 @@ func (p *Peer) startProtocols(writeStart <-chan struct{}, writeErr chan<- error)
 		proto.closed = p.closed
 		proto.wstart = writeStart
 		proto.werr = writeErr
+		proto.traffic = p.traffic
 		var rw MsgReadWriter = proto
 		if p.events != nil {
 			rw = newMsgEventer(rw, p.events, p.ID(), proto.Name, p.Info().Network.RemoteAddress, p.Info().Network.LocalAddress)
@@ -443,6 +462,8 @@ type protoRW struct {
 	werr   chan<- error    // for write results
 	offset uint64
 	w      MsgWriter
+
+	traffic *peerTraffic // counts the written messages
 }
 
 func (rw *protoRW) WriteMsg(msg Msg) (err error) {
@@ -457,6 +478,9 @@ func (rw *protoRW) WriteMsg(msg Msg) (err error) {
 	select {
 	case <-rw.wstart:
 		err = rw.w.WriteMsg(msg)
+		if err == nil {
+			rw.traffic.add(msg.meterCap, msg.meterCode, msg.Size, false)
+		}
 		// Report write status back to Peer.run. It will initiate
 		// shutdown if the error is non-nil and unblock the next write
 		// otherwise. The calling protocol code should exit for errors
@@ -494,7 +518,8 @@ type PeerInfo struct {
 		Trusted       bool   `json:"trusted"`
 		Static        bool   `json:"static"`
 	} `json:"network"`
-	Protocols map[string]interface{} `json:"protocols"` // Sub-protocol specific metadata fields
+	Protocols map[string]interface{}             `json:"protocols"`         // Sub-protocol specific metadata fields
+	Traffic   map[string]map[string]*TrafficInfo `json:"traffic,omitempty"` // Messages exchanged, by sub-protocol and message code
 }
 
 // Info gathers and returns a collection of metadata known about a peer.
@@ -520,6 +545,7 @@ func (p *Peer) Info() *PeerInfo {
 	info.Network.Inbound = p.rw.is(inboundConn)
 	info.Network.Trusted = p.rw.is(trustedConn)
 	info.Network.Static = p.rw.is(staticDialedConn)
+	info.Traffic = p.traffic.info()
 
 	// Gather all the running protocol infos
 	for _, proto := range p.running {
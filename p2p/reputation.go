@@ -0,0 +1,435 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mbali/go-mbali/log"
+	"github.com/mbali/go-mbali/p2p/enode"
+	"github.com/mbali/go-mbali/p2p/netutil"
+)
+
+// Misbehavior weights for protocol handlers reporting peers. A peer is banned
+// once its accumulated score reaches the ban threshold, which defaults to the
+// weight of a critical offence.
+const (
+	MisbehaviorMinor    = 10  // e.g. unsolicited or duplicate responses
+	MisbehaviorMajor    = 50  // e.g. stalled requests, useless data
+	MisbehaviorCritical = 100 // e.g. undecodable messages, invalid blocks
+)
+
+const (
+	defaultBanThreshold   = MisbehaviorCritical
+	defaultBanDuration    = time.Hour
+	defaultMaxBanDuration = 30 * 24 * time.Hour
+
+	// Scores decay exponentially, halving over this period.
+	scoreHalfLife = 30 * time.Minute
+
+	// Number of banned nodes within an IP range which get the whole range
+	// banned. The ranges are /24 for IPv4 and /64 for IPv6.
+	netBanThreshold = 3
+
+	// Number of scores tracked before the negligible ones are pruned.
+	maxTrackedScores = 4096
+
+	// Time period for forgetting expired bans.
+	banExpiryCycle = time.Hour
+)
+
+var (
+	errBannedNode    = errors.New("node is banned")
+	errBannedNetwork = errors.New("network is banned")
+)
+
+// ReputationConfig configures the scoring of misbehaving peers.
+type ReputationConfig struct {
+	// Disabled turns off the automatic banning of misbehaving peers. Bans
+	// imposed through the admin API are still enforced.
+	Disabled bool `toml:",omitempty"`
+
+	// BanThreshold is the score at which a peer is banned. Zero defaults
+	// to MisbehaviorCritical.
+	BanThreshold int `toml:",omitempty"`
+
+	// BanDuration is the duration of the first ban of a node or a network,
+	// subsequent bans double it up to MaxBanDuration. Bans are forgotten once
+	// they expired more than twice MaxBanDuration ago, later bans start over
+	// at BanDuration.
+	BanDuration    time.Duration `toml:",omitempty"`
+	MaxBanDuration time.Duration `toml:",omitempty"`
+}
+
+func (cfg ReputationConfig) withDefaults() ReputationConfig {
+	if cfg.BanThreshold == 0 {
+		cfg.BanThreshold = defaultBanThreshold
+	}
+	if cfg.BanDuration == 0 {
+		cfg.BanDuration = defaultBanDuration
+	}
+	if cfg.MaxBanDuration == 0 {
+		cfg.MaxBanDuration = defaultMaxBanDuration
+	}
+	return cfg
+}
+
+// BanInfo describes an active ban of a node or of an IP network.
+type BanInfo struct {
+	ID      string    `json:"id,omitempty"`      // Banned node identifier
+	Network string    `json:"network,omitempty"` // Banned IP network, in CIDR notation
+	IP      string    `json:"ip,omitempty"`      // IP address of the node at the time of the ban
+	Until   time.Time `json:"until"`             // Time the ban expires at
+	Count   uint64    `json:"count"`             // Number of times the ban was imposed
+	Reason  string    `json:"reason"`            // Reason of the last ban
+}
+
+// peerScore is the decaying misbehavior score of a node.
+type peerScore struct {
+	value   float64
+	updated time.Time
+}
+
+// netBan is a ban of an IP network.
+type netBan struct {
+	network *net.IPNet
+	ban     *enode.Ban
+}
+
+// reputation tracks the misbehavior of peers and the bans of nodes and IP
+// networks. Bans are persisted in the node database.
+type reputation struct {
+	cfg ReputationConfig
+	db  *enode.DB
+	log log.Logger
+	now func() time.Time
+
+	mu       sync.Mutex
+	scores   map[enode.ID]*peerScore
+	nodeBans map[enode.ID]*enode.Ban
+	netBans  map[string]*netBan
+}
+
+// newReputation creates the reputation tracker, loading the persisted bans.
+func newReputation(cfg ReputationConfig, db *enode.DB, logger log.Logger) *reputation {
+	r := &reputation{
+		cfg:      cfg.withDefaults(),
+		db:       db,
+		log:      logger,
+		now:      time.Now,
+		scores:   make(map[enode.ID]*peerScore),
+		nodeBans: db.NodeBans(),
+		netBans:  make(map[string]*netBan),
+	}
+	for cidr, ban := range db.NetBans() {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			logger.Warn("Ignoring invalid network ban", "network", cidr, "err", err)
+			continue
+		}
+		r.netBans[network.String()] = &netBan{network: network, ban: ban}
+	}
+	r.expire()
+	return r
+}
+
+// expire forgets the bans which expired long enough ago to not escalate any
+// later ban, deleting them from the database.
+func (r *reputation) expire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := r.now().Add(-2 * r.cfg.MaxBanDuration)
+	for id, ban := range r.nodeBans {
+		if ban.Active(cutoff) {
+			continue
+		}
+		delete(r.nodeBans, id)
+		if err := r.db.DeleteNodeBan(id); err != nil {
+			r.log.Warn("Failed to delete expired node ban", "id", id, "err", err)
+		}
+	}
+	for cidr, entry := range r.netBans {
+		if entry.ban.Active(cutoff) {
+			continue
+		}
+		delete(r.netBans, cidr)
+		if err := r.db.DeleteNetBan(entry.network); err != nil {
+			r.log.Warn("Failed to delete expired network ban", "network", cidr, "err", err)
+		}
+	}
+}
+
+// report adds the weight of a misbehavior to the score of a node, banning it
+// once the threshold is reached. The return values tell whmbler the node and
+// whmbler an IP network were banned as a result.
+func (r *reputation) report(id enode.ID, ip net.IP, reason string, weight int) (nodeBanned, netBanned bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	score := r.score(id, now)
+	score.value += float64(weight)
+	r.log.Debug("Peer misbehaved", "id", id, "ip", ip, "reason", reason, "weight", weight, "score", score.value)
+
+	if r.cfg.Disabled || score.value < float64(r.cfg.BanThreshold) {
+		return false, false
+	}
+	delete(r.scores, id)
+	r.banNode(id, ip, 0, reason, now)
+
+	// Ban the whole range if it hosts too many banned nodes
+	network := banRange(ip)
+	if network == nil {
+		return true, false
+	}
+	if ban := r.netBans[network.String()]; ban != nil && ban.ban.Active(now) {
+		return true, false
+	}
+	var count int
+	for _, ban := range r.nodeBans {
+		if ban.Active(now) && network.Contains(ban.IP) {
+			count++
+		}
+	}
+	if count < netBanThreshold {
+		return true, false
+	}
+	r.banNet(network, 0, "too many banned nodes", now)
+	return true, true
+}
+
+// score returns the score of a node, decayed to the given time. Negligible
+// scores are pruned if too many nodes are tracked.
+func (r *reputation) score(id enode.ID, now time.Time) *peerScore {
+	if len(r.scores) >= maxTrackedScores {
+		for id, score := range r.scores {
+			if r.decay(score, now); score.value < 1 {
+				delete(r.scores, id)
+			}
+		}
+	}
+	score := r.scores[id]
+	if score == nil {
+		score = &peerScore{updated: now}
+		r.scores[id] = score
+	}
+	r.decay(score, now)
+	return score
+}
+
+// decay updates a score to the given time.
+func (r *reputation) decay(score *peerScore, now time.Time) {
+	if elapsed := now.Sub(score.updated); elapsed > 0 {
+		score.value *= math.Pow(0.5, float64(elapsed)/float64(scoreHalfLife))
+		score.updated = now
+	}
+}
+
+// escalate returns the duration of the count-th ban, doubling the initial
+// duration with each ban.
+func (r *reputation) escalate(count uint64) time.Duration {
+	duration := r.cfg.BanDuration
+	for i := uint64(1); i < count && duration < r.cfg.MaxBanDuration; i++ {
+		duration *= 2
+	}
+	if duration > r.cfg.MaxBanDuration {
+		duration = r.cfg.MaxBanDuration
+	}
+	return duration
+}
+
+// banNode bans a node for the given duration, or for an escalating duration
+// if zero. The caller must hold the lock.
+func (r *reputation) banNode(id enode.ID, ip net.IP, duration time.Duration, reason string, now time.Time) *enode.Ban {
+	ban := r.nodeBans[id]
+	if ban == nil {
+		ban = new(enode.Ban)
+		r.nodeBans[id] = ban
+	}
+	ban.Count++
+	if duration == 0 {
+		duration = r.escalate(ban.Count)
+	}
+	ban.Until = uint64(now.Add(duration).Unix())
+	ban.Reason = reason
+	if ip != nil {
+		ban.IP = ip
+	}
+	if err := r.db.UpdateNodeBan(id, ban); err != nil {
+		r.log.Warn("Failed to persist node ban", "id", id, "err", err)
+	}
+	r.log.Info("Banned node", "id", id, "ip", ban.IP, "duration", duration, "count", ban.Count, "reason", reason)
+	return ban
+}
+
+// banNet bans an IP network for the given duration, or for an escalating
+// duration if zero. The caller must hold the lock.
+func (r *reputation) banNet(network *net.IPNet, duration time.Duration, reason string, now time.Time) *enode.Ban {
+	entry := r.netBans[network.String()]
+	if entry == nil {
+		entry = &netBan{network: network, ban: new(enode.Ban)}
+		r.netBans[network.String()] = entry
+	}
+	ban := entry.ban
+	ban.Count++
+	if duration == 0 {
+		duration = r.escalate(ban.Count)
+	}
+	ban.Until = uint64(now.Add(duration).Unix())
+	ban.Reason = reason
+	if err := r.db.UpdateNetBan(network, ban); err != nil {
+		r.log.Warn("Failed to persist network ban", "network", network, "err", err)
+	}
+	r.log.Info("Banned network", "network", network, "duration", duration, "count", ban.Count, "reason", reason)
+	return ban
+}
+
+// addNodeBan bans a node on request.
+func (r *reputation) addNodeBan(id enode.ID, duration time.Duration, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.scores, id)
+	r.banNode(id, nil, duration, reason, r.now())
+}
+
+// addNetBan bans an IP network on request.
+func (r *reputation) addNetBan(network *net.IPNet, duration time.Duration, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.banNet(network, duration, reason, r.now())
+}
+
+// removeNodeBan lifts the ban of a node, also forgetting its past bans.
+func (r *reputation) removeNodeBan(id enode.ID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.nodeBans[id]; !ok {
+		return false
+	}
+	delete(r.nodeBans, id)
+	if err := r.db.DeleteNodeBan(id); err != nil {
+		r.log.Warn("Failed to delete node ban", "id", id, "err", err)
+	}
+	return true
+}
+
+// removeNetBan lifts the ban of an IP network, also forgetting its past bans.
+func (r *reputation) removeNetBan(network *net.IPNet) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.netBans[network.String()]; !ok {
+		return false
+	}
+	delete(r.netBans, network.String())
+	if err := r.db.DeleteNetBan(network); err != nil {
+		r.log.Warn("Failed to delete network ban", "network", network, "err", err)
+	}
+	return true
+}
+
+// checkIP returns an error if the IP address is within a banned network.
+func (r *reputation) checkIP(ip net.IP) error {
+	if ip == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	for _, entry := range r.netBans {
+		if entry.ban.Active(now) && entry.network.Contains(ip) {
+			return errBannedNetwork
+		}
+	}
+	return nil
+}
+
+// checkNode returns an error if the node or its IP address is banned.
+func (r *reputation) checkNode(id enode.ID, ip net.IP) error {
+	r.mu.Lock()
+	ban := r.nodeBans[id]
+	banned := ban != nil && ban.Active(r.now())
+	r.mu.Unlock()
+
+	if banned {
+		return errBannedNode
+	}
+	return r.checkIP(ip)
+}
+
+// bans returns the active bans, nodes first.
+func (r *reputation) bans() []*BanInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var (
+		now   = r.now()
+		nodes []*BanInfo
+		nets  []*BanInfo
+	)
+	for id, ban := range r.nodeBans {
+		if !ban.Active(now) {
+			continue
+		}
+		info := &BanInfo{
+			ID:     id.String(),
+			Until:  time.Unix(int64(ban.Until), 0),
+			Count:  ban.Count,
+			Reason: ban.Reason,
+		}
+		if ban.IP != nil {
+			info.IP = ban.IP.String()
+		}
+		nodes = append(nodes, info)
+	}
+	for cidr, entry := range r.netBans {
+		if !entry.ban.Active(now) {
+			continue
+		}
+		nets = append(nets, &BanInfo{
+			Network: cidr,
+			Until:   time.Unix(int64(entry.ban.Until), 0),
+			Count:   entry.ban.Count,
+			Reason:  entry.ban.Reason,
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	sort.Slice(nets, func(i, j int) bool { return nets[i].Network < nets[j].Network })
+	return append(nodes, nets...)
+}
+
+// banRange returns the IP range banned along with too many of its nodes, nil
+// for LAN addresses.
+func banRange(ip net.IP) *net.IPNet {
+	if ip == nil || netutil.IsLAN(ip) {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}
+	}
+	return &net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}
+}
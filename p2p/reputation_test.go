@@ -0,0 +1,213 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mbali/go-mbali/log"
+	"github.com/mbali/go-mbali/p2p/enode"
+)
+
+func newTestReputation(db *enode.DB, now *time.Time) *reputation {
+	r := newReputation(ReputationConfig{}, db, log.Root())
+	r.now = func() time.Time { return *now }
+	return r
+}
+
+func TestReputationScoring(t *testing.T) {
+	db, _ := enode.OpenDB("")
+	defer db.Close()
+
+	var (
+		now = time.Unix(1000000, 0)
+		r   = newTestReputation(db, &now)
+		id  = enode.ID{1}
+		ip  = net.IP{10, 0, 0, 1}
+	)
+	// Minor misbehaviors decay before reaching the threshold
+	for i := 0; i < 20; i++ {
+		if banned, _ := r.report(id, ip, "minor", MisbehaviorMinor); banned {
+			t.Fatalf("node banned after %d decaying minor misbehaviors", i+1)
+		}
+		now = now.Add(scoreHalfLife)
+	}
+	// Accumulated misbehaviors get the node banned
+	r.report(id, ip, "major", MisbehaviorMajor)
+	if banned, _ := r.report(id, ip, "major", MisbehaviorMajor); !banned {
+		t.Fatal("node not banned after reaching the threshold")
+	}
+	if err := r.checkNode(id, ip); err != errBannedNode {
+		t.Fatalf("wrong check result: have %v, want %v", err, errBannedNode)
+	}
+	// The ban expires after the initial duration, and the next one is longer
+	now = now.Add(defaultBanDuration)
+	if err := r.checkNode(id, ip); err != nil {
+		t.Fatalf("ban didn't expire: %v", err)
+	}
+	r.report(id, ip, "critical", MisbehaviorCritical)
+	now = now.Add(defaultBanDuration)
+	if err := r.checkNode(id, ip); err != errBannedNode {
+		t.Fatalf("second ban didn't escalate: %v", err)
+	}
+	now = now.Add(defaultBanDuration)
+	if err := r.checkNode(id, ip); err != nil {
+		t.Fatalf("second ban didn't expire: %v", err)
+	}
+}
+
+func TestReputationDisabled(t *testing.T) {
+	db, _ := enode.OpenDB("")
+	defer db.Close()
+
+	r := newReputation(ReputationConfig{Disabled: true}, db, log.Root())
+	if banned, _ := r.report(enode.ID{1}, nil, "critical", MisbehaviorCritical); banned {
+		t.Fatal("node banned with reputation disabled")
+	}
+	r.addNodeBan(enode.ID{1}, time.Hour, "admin")
+	if err := r.checkNode(enode.ID{1}, nil); err != errBannedNode {
+		t.Fatalf("admin ban not enforced: %v", err)
+	}
+}
+
+func TestReputationNetworkBan(t *testing.T) {
+	db, _ := enode.OpenDB("")
+	defer db.Close()
+
+	now := time.Unix(1000000, 0)
+	r := newTestReputation(db, &now)
+
+	// Too many banned nodes get their /24 range banned
+	for i := byte(1); i <= netBanThreshold; i++ {
+		_, netBanned := r.report(enode.ID{i}, net.IP{1, 2, 3, i}, "critical", MisbehaviorCritical)
+		if want := i == netBanThreshold; netBanned != want {
+			t.Fatalf("node %d: network ban mismatch: have %t, want %t", i, netBanned, want)
+		}
+	}
+	if err := r.checkIP(net.IP{1, 2, 3, 100}); err != errBannedNetwork {
+		t.Fatalf("wrong check result: have %v, want %v", err, errBannedNetwork)
+	}
+	if err := r.checkIP(net.IP{1, 2, 4, 1}); err != nil {
+		t.Fatalf("address outside of the banned range rejected: %v", err)
+	}
+	// LAN ranges are never banned
+	for i := byte(1); i <= netBanThreshold; i++ {
+		if _, netBanned := r.report(enode.ID{0xff, i}, net.IP{192, 168, 0, i}, "critical", MisbehaviorCritical); netBanned {
+			t.Fatal("LAN network banned")
+		}
+	}
+	// Lifting the ban allows the range again
+	_, network, _ := net.ParseCIDR("1.2.3.0/24")
+	if !r.removeNetBan(network) {
+		t.Fatal("network ban not found")
+	}
+	if err := r.checkIP(net.IP{1, 2, 3, 100}); err != nil {
+		t.Fatalf("network still banned: %v", err)
+	}
+}
+
+func TestReputationPersistence(t *testing.T) {
+	db, _ := enode.OpenDB("")
+	defer db.Close()
+
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	r := newReputation(ReputationConfig{}, db, log.Root())
+	r.addNodeBan(enode.ID{1}, time.Hour, "node")
+	r.addNodeBan(enode.ID{2}, time.Hour, "removed")
+	r.addNetBan(network, 0, "network")
+	r.removeNodeBan(enode.ID{2})
+
+	// The bans are restored from the database
+	r = newReputation(ReputationConfig{}, db, log.Root())
+	bans := r.bans()
+	if len(bans) != 2 {
+		t.Fatalf("wrong number of bans: have %d, want 2", len(bans))
+	}
+	if bans[0].ID != (enode.ID{1}).String() || bans[0].Reason != "node" {
+		t.Errorf("wrong node ban: %+v", bans[0])
+	}
+	if bans[1].Network != network.String() || bans[1].Reason != "network" {
+		t.Errorf("wrong network ban: %+v", bans[1])
+	}
+	if err := r.checkNode(enode.ID{2}, net.IP{10, 1, 2, 3}); err != errBannedNetwork {
+		t.Errorf("wrong check result: have %v, want %v", err, errBannedNetwork)
+	}
+}
+
+func TestReputationExpiry(t *testing.T) {
+	db, _ := enode.OpenDB("")
+	defer db.Close()
+
+	now := time.Unix(10000000, 0)
+	r := newTestReputation(db, &now)
+	_, network, _ := net.ParseCIDR("1.2.0.0/16")
+	r.addNodeBan(enode.ID{1}, time.Hour, "old")
+	r.addNetBan(network, time.Hour, "old")
+	now = now.Add(time.Hour + defaultMaxBanDuration)
+	r.addNodeBan(enode.ID{2}, time.Hour, "recent")
+
+	// Expired bans are kept for escalation for a while
+	r.expire()
+	if len(r.nodeBans) != 2 || len(r.netBans) != 1 {
+		t.Fatalf("bans forgotten too early: %d node bans, %d network bans", len(r.nodeBans), len(r.netBans))
+	}
+	now = now.Add(defaultMaxBanDuration)
+	r.expire()
+	if len(r.nodeBans) != 1 || r.nodeBans[enode.ID{2}] == nil || len(r.netBans) != 0 {
+		t.Fatalf("wrong bans after expiry: %d node bans, %d network bans", len(r.nodeBans), len(r.netBans))
+	}
+	if db.NodeBan(enode.ID{1}) != nil || db.NetBan(network) != nil {
+		t.Fatal("expired bans not deleted from the database")
+	}
+	// The next ban starts over at the initial duration
+	r.addNodeBan(enode.ID{1}, 0, "new")
+	if count := r.nodeBans[enode.ID{1}].Count; count != 1 {
+		t.Fatalf("wrong ban count: have %d, want 1", count)
+	}
+}
+
+func TestServerBanExemption(t *testing.T) {
+	db, _ := enode.OpenDB("")
+	defer db.Close()
+
+	srv := &Server{Config: Config{MaxPeers: 10}}
+	srv.reputation = newReputation(ReputationConfig{}, db, log.Root())
+	srv.localnode = enode.NewLocalNode(db, newkey())
+	_, network, _ := net.ParseCIDR("1.2.3.0/24")
+	srv.reputation.addNetBan(network, time.Hour, "test")
+
+	newConn := func(flags connFlag) *conn {
+		addr := &net.TCPAddr{IP: net.IP{1, 2, 3, 4}, Port: 30303}
+		return &conn{fd: &fakeAddrConn{remoteAddr: addr}, flags: flags, node: enode.NewV4(&newkey().PublicKey, addr.IP, addr.Port, 0)}
+	}
+	tests := []struct {
+		flags connFlag
+		want  error
+	}{
+		{inboundConn, errBannedNetwork},
+		{dynDialedConn, errBannedNetwork},
+		{inboundConn | trustedConn, nil},
+		{staticDialedConn, nil},
+	}
+	for i, test := range tests {
+		if err := srv.postHandshakeChecks(nil, 0, newConn(test.flags)); err != test.want {
+			t.Errorf("test %d: got error %v, want %v", i, err, test.want)
+		}
+	}
+}
@@ -64,7 +64,10 @@ const (
 	frameWriteTimeout = 20 * time.Second
 )
 
-var errServerStopped = errors.New("server stopped")
+var (
+	errServerStopped      = errors.New("server stopped")
+	errNegativeEgressRate = errors.New("negative egress rate")
+)
 
 // Config holds Server options.
 type Config struct {
@@ -85,6 +88,12 @@ type Config struct {
 	// Setting DialRatio to zero defaults it to 3.
 	DialRatio int `toml:",omitempty"`
 
+	// MaxEgressRate caps the bandwidth used to send subprotocol messages to
+	// all peers, in bytes per second. MaxPeerEgressRate caps it for each peer.
+	// Zero means unlimited.
+	MaxEgressRate     int `toml:",omitempty"`
+	MaxPeerEgressRate int `toml:",omitempty"`
+
 	// NoDiscovery can be used to disable the peer discovery mechanism.
 	// Disabling is useful for protocol debugging (manual topology).
 	NoDiscovery bool
@@ -119,10 +128,30 @@ type Config struct {
 	// IP networks contained in the list are considered.
 	NetRestrict *netutil.Netlist `toml:",omitempty"`
 
+	// Connectivity can be forbidden with certain IP networks. Hosts which
+	// match one of the IP networks contained in the list are not considered.
+	NetDeny *netutil.Netlist `toml:",omitempty"`
+
+	// NetListsFile is the path to a file holding IP allow and deny lists, in
+	// the format of netutil.LoadNetLists. The lists of the file replace both
+	// NetRestrict and NetDeny, and are reloaded whenever the file changes.
+	NetListsFile string `toml:",omitempty"`
+
 	// NodeDatabase is the path to the database containing the previously seen
 	// live nodes in the network.
 	NodeDatabase string `toml:",omitempty"`
 
+	// Reputation configures the scoring and banning of misbehaving peers.
+	// The bans are persisted in the node database.
+	Reputation ReputationConfig `toml:",omitempty"`
+
+	// Diversity limits the number of peers from the same IP subnet.
+	Diversity DiversityConfig `toml:",omitempty"`
+
+	// Capture configures the recording of the messages exchanged with peers,
+	// for debugging purposes.
+	Capture CaptureConfig `toml:",omitempty"`
+
 	// Protocols should contain the protocols supported
 	// by the server. Matching protocols are launched for
 	// each peer.
@@ -155,7 +184,10 @@ type Config struct {
 	// Logger is a custom logger to use with the p2p.Server.
 	Logger log.Logger `toml:",omitempty"`
 
-	clock mclock.Clock
+	// Clock is the time source for dial scheduling, inbound throttling and
+	// egress rate limiting. It defaults to the system clock. Simulations set
+	// it to a virtual clock.
+	Clock mclock.Clock `toml:"-"`
 }
 
 // Server manages all peer connections.
@@ -178,12 +210,15 @@ type Server struct {
 	peerFeed     event.Feed
 	log          log.Logger
 
-	nodedb    *enode.DB
-	localnode *enode.LocalNode
-	ntab      *discover.UDPv4
-	DiscV5    *discover.UDPv5
-	discmix   *enode.FairMix
-	dialsched *dialScheduler
+	nodedb     *enode.DB
+	reputation *reputation
+	egress     *egressLimiter
+	netFilter  *netutil.NetFilter
+	localnode  *enode.LocalNode
+	ntab       *discover.UDPv4
+	DiscV5     *discover.UDPv5
+	discmix    *enode.FairMix
+	dialsched  *dialScheduler
 
 	// Channels into the run loop.
 	quit                    chan struct{}
@@ -370,6 +405,119 @@ func (srv *Server) RemoveTrustedPeer(node *enode.Node) {
 	}
 }
 
+// BanNode bans the given node and disconnects it. The ban lasts for the given
+// duration, or for a duration escalating with each ban of the node if zero.
+// Trusted and static peers are exempt from bans.
+func (srv *Server) BanNode(id enode.ID, duration time.Duration, reason string) error {
+	if srv.reputation == nil {
+		return errServerStopped
+	}
+	srv.reputation.addNodeBan(id, duration, reason)
+	srv.dropBanned()
+	return nil
+}
+
+// BanNetwork bans the given IP network and disconnects its peers. The ban lasts
+// for the given duration, or for a duration escalating with each ban of the
+// network if zero.
+// Trusted and static peers are exempt from bans.
+func (srv *Server) BanNetwork(network *net.IPNet, duration time.Duration, reason string) error {
+	if srv.reputation == nil {
+		return errServerStopped
+	}
+	srv.reputation.addNetBan(network, duration, reason)
+	srv.dropBanned()
+	return nil
+}
+
+// UnbanNode lifts the ban of the given node, and forgets its past bans. It
+// returns whmbler the node was ever banned.
+func (srv *Server) UnbanNode(id enode.ID) bool {
+	if srv.reputation == nil {
+		return false
+	}
+	return srv.reputation.removeNodeBan(id)
+}
+
+// UnbanNetwork lifts the ban of the given IP network, and forgets its past
+// bans. It returns whmbler the network was ever banned.
+func (srv *Server) UnbanNetwork(network *net.IPNet) bool {
+	if srv.reputation == nil {
+		return false
+	}
+	return srv.reputation.removeNetBan(network)
+}
+
+// Bans returns the active bans of nodes and IP networks.
+func (srv *Server) Bans() []*BanInfo {
+	if srv.reputation == nil {
+		return nil
+	}
+	return srv.reputation.bans()
+}
+
+// SetEgressRates updates the caps of the bandwidth used to send subprotocol
+// messages to all peers and to each peer, in bytes per second. Zero means
+// unlimited.
+func (srv *Server) SetEgressRates(rate, peerRate int) error {
+	if rate < 0 || peerRate < 0 {
+		return errNegativeEgressRate
+	}
+	if srv.egress == nil {
+		return errServerStopped
+	}
+	srv.egress.setRates(rate, peerRate)
+	return nil
+}
+
+// EgressRates returns the caps of the bandwidth used to send subprotocol
+// messages to all peers and to each peer, in bytes per second.
+func (srv *Server) EgressRates() (rate, peerRate int) {
+	if srv.egress == nil {
+		return srv.MaxEgressRate, srv.MaxPeerEgressRate
+	}
+	return srv.egress.rates()
+}
+
+// reportPeer scores a misbehavior reported by the protocols of a peer,
+// disconnecting the peers banned as a result. Trusted and static peers are
+// never banned automatically.
+func (srv *Server) reportPeer(p *Peer, reason string, weight int) {
+	if p.rw.is(trustedConn | staticDialedConn) {
+		p.log.Debug("Trusted peer misbehaved", "reason", reason, "weight", weight)
+		return
+	}
+	nodeBanned, netBanned := srv.reputation.report(p.ID(), netutil.AddrIP(p.RemoteAddr()), reason, weight)
+	if nodeBanned {
+		p.Disconnect(DiscUselessPeer)
+	}
+	if netBanned {
+		// Reports come from protocol goroutines, don't block them on the
+		// main loop.
+		go srv.dropBanned()
+	}
+}
+
+// checkBanned returns an error if the node or its IP address is banned.
+func (srv *Server) checkBanned(n *enode.Node) error {
+	return srv.reputation.checkNode(n.ID(), n.IP())
+}
+
+// dropBanned disconnects the banned peers, except for trusted and static peers.
+func (srv *Server) dropBanned() {
+	srv.doPeerOp(func(peers map[enode.ID]*Peer) {
+		for _, p := range peers {
+			if p.rw.is(trustedConn | staticDialedConn) {
+				continue
+			}
+			if err := srv.reputation.checkNode(p.ID(), netutil.AddrIP(p.RemoteAddr())); err != nil {
+				p.log.Debug("Dropping banned peer", "err", err)
+				p.Disconnect(DiscUselessPeer)
+			}
+		}
+	})
+}
+
 // SubscribeEvents subscribes the given channel to peer events
 func (srv *Server) SubscribeEvents(ch chan *PeerEvent) event.Subscription {
 	return srv.peerFeed.Subscribe(ch)
@@ -444,8 +592,8 @@ func (srv *Server) Start() (err error) {
 	if srv.log == nil {
 		srv.log = log.Root()
 	}
-	if srv.clock == nil {
-		srv.clock = mclock.System{}
+	if srv.Clock == nil {
+		srv.Clock = mclock.System{}
 	}
 	if srv.NoDial && srv.ListenAddr == "" {
 		srv.log.Warn("P2P server will be useless, neither dialing nor listening")
@@ -458,6 +606,13 @@ func (srv *Server) Start() (err error) {
 	if srv.newTransport == nil {
 		srv.newTransport = newRLPX
 	}
+	if srv.MaxEgressRate < 0 || srv.MaxPeerEgressRate < 0 {
+		return errNegativeEgressRate
+	}
+	srv.egress = newEgressLimiter(srv.Clock, srv.MaxEgressRate, srv.MaxPeerEgressRate)
+	if err := srv.setupNetFilter(); err != nil {
+		return err
+	}
 	if srv.listenFunc == nil {
 		srv.listenFunc = net.Listen
 	}
@@ -483,6 +638,10 @@ func (srv *Server) Start() (err error) {
 	}
 	srv.setupDialScheduler()
 
+	if srv.NetListsFile != "" {
+		srv.loopWG.Add(1)
+		go srv.watchNetLists()
+	}
 	srv.loopWG.Add(1)
 	go srv.run()
 	return nil
@@ -503,6 +662,7 @@ func (srv *Server) setupLocalNode() error {
 		return err
 	}
 	srv.nodedb = db
+	srv.reputation = newReputation(srv.Reputation, db, srv.log)
 	srv.localnode = enode.NewLocalNode(db, srv.PrivateKey)
 	srv.localnode.SetFallbackIP(net.IP{127, 0, 0, 1})
 	// TODO: check conflicts
@@ -518,6 +678,9 @@ func (srv *Server) setupLocalNode() error {
 		// ExtIP doesn't block, set the IP right away.
 		ip, _ := srv.NAT.ExternalIP()
 		srv.localnode.SetStaticIP(ip)
+	case *nat.STUN:
+		// STUN reports the address of the socket it runs on, the query is
+		// started by setupDiscovery.
 	default:
 		// Ask the router about the IP. This takes a while and blocks startup,
 		// do it in the background.
@@ -545,7 +708,11 @@ func (srv *Server) setupDiscovery() error {
 	}
 
 	// Don't listen on UDP endpoint if DHT is disabled.
+	stun, _ := srv.NAT.(*nat.STUN)
 	if srv.NoDiscovery && !srv.DiscoveryV5 {
+		if stun != nil {
+			srv.querySTUN(stun, nil)
+		}
 		return nil
 	}
 
@@ -570,6 +737,14 @@ func (srv *Server) setupDiscovery() error {
 	}
 	srv.localnode.SetFallbackUDP(realaddr.Port)
 
+	// With STUN, the external address is queried on the discovery socket.
+	var dconn discover.UDPConn = conn
+	if stun != nil {
+		stunConn := nat.NewSTUNConn(conn)
+		dconn = stunConn
+		srv.querySTUN(stun, stunConn)
+	}
+
 	// Discovery V4
 	var unhandled chan discover.ReadPacket
 	var sconn *sharedUDPConn
@@ -579,13 +754,13 @@ func (srv *Server) setupDiscovery() error {
 			sconn = &sharedUDPConn{conn, unhandled}
 		}
 		cfg := discover.Config{
-			PrivateKey:  srv.PrivateKey,
-			NetRestrict: srv.NetRestrict,
-			Bootnodes:   srv.BootstrapNodes,
-			Unhandled:   unhandled,
-			Log:         srv.log,
+			PrivateKey: srv.PrivateKey,
+			NetFilter:  srv.netFilter,
+			Bootnodes:  srv.BootstrapNodes,
+			Unhandled:  unhandled,
+			Log:        srv.log,
 		}
-		ntab, err := discover.ListenV4(conn, srv.localnode, cfg)
+		ntab, err := discover.ListenV4(dconn, srv.localnode, cfg)
 		if err != nil {
 			return err
 		}
@@ -596,16 +771,16 @@ func (srv *Server) setupDiscovery() error {
 	// Discovery V5
 	if srv.DiscoveryV5 {
 		cfg := discover.Config{
-			PrivateKey:  srv.PrivateKey,
-			NetRestrict: srv.NetRestrict,
-			Bootnodes:   srv.BootstrapNodesV5,
-			Log:         srv.log,
+			PrivateKey: srv.PrivateKey,
+			NetFilter:  srv.netFilter,
+			Bootnodes:  srv.BootstrapNodesV5,
+			Log:        srv.log,
 		}
 		var err error
 		if sconn != nil {
 			srv.DiscV5, err = discover.ListenV5(sconn, srv.localnode, cfg)
 		} else {
-			srv.DiscV5, err = discover.ListenV5(conn, srv.localnode, cfg)
+			srv.DiscV5, err = discover.ListenV5(dconn, srv.localnode, cfg)
 		}
 		if err != nil {
 			return err
@@ -620,9 +795,14 @@ func (srv *Server) setupDialScheduler() {
 		maxDialPeers:   srv.maxDialedConns(),
 		maxActiveDials: srv.MaxPendingPeers,
 		log:            srv.Logger,
-		netRestrict:    srv.NetRestrict,
+		netFilter:      srv.netFilter,
+		banned:         srv.checkBanned,
 		dialer:         srv.Dialer,
-		clock:          srv.clock,
+		clock:          srv.Clock,
+	}
+	if !srv.Diversity.Disabled {
+		limits := srv.Diversity.Outbound.withDefaults(defaultOutboundSubnetLimits)
+		config.subnetLimits = &limits
 	}
 	if srv.ntab != nil {
 		config.resolver = srv.ntab
@@ -655,6 +835,39 @@ func (srv *Server) maxDialedConns() (limit int) {
 	return limit
 }
 
+// querySTUN asks the STUN servers for the external address in the background.
+// The result becomes the fallback endpoint of the local node, so predictions
+// from discovery can still override it. The mapped port is only meaningful for
+// a query on the discovery socket. If conn is nil, the query uses a temporary
+// socket and only the IP is used.
+func (srv *Server) querySTUN(stun *nat.STUN, conn *nat.STUNConn) {
+	srv.loopWG.Add(1)
+	go func() {
+		defer srv.loopWG.Done()
+
+		var (
+			addr *net.UDPAddr
+			err  error
+		)
+		if conn != nil {
+			addr, err = stun.QueryShared(conn)
+		} else {
+			var ip net.IP
+			ip, err = stun.ExternalIP()
+			addr = &net.UDPAddr{IP: ip}
+		}
+		if err != nil {
+			srv.log.Warn("STUN query failed", "err", err)
+			return
+		}
+		srv.log.Info("External address discovered with STUN", "addr", addr)
+		srv.localnode.SetFallbackIP(addr.IP)
+		if conn != nil && addr.Port != 0 {
+			srv.localnode.SetFallbackUDP(addr.Port)
+		}
+	}()
+}
+
 func (srv *Server) setupListening() error {
 	// Launch the listener.
 	listener, err := srv.listenFunc("tcp", srv.ListenAddr)
@@ -702,7 +915,9 @@ func (srv *Server) run() {
 		peers        = make(map[enode.ID]*Peer)
 		inboundCount = 0
 		trusted      = make(map[enode.ID]bool, len(srv.TrustedNodes))
+		expireBans   = time.NewTicker(banExpiryCycle)
 	)
+	defer expireBans.Stop()
 	// Put trusted nodes into a map to speed up checks.
 	// Trusted peers are loaded on startup or added via AddTrustedPeer RPC.
 	for _, n := range srv.TrustedNodes {
@@ -734,6 +949,10 @@ running:
 				p.rw.set(trustedConn, false)
 			}
 
+		case <-expireBans.C:
+			// Forget the bans which expired long ago.
+			srv.reputation.expire()
+
 		case op := <-srv.peerOp:
 			// This channel is used by Peers and PeerCount.
 			op(peers)
@@ -810,9 +1029,15 @@ func (srv *Server) postHandshakeChecks(peers map[enode.ID]*Peer, inboundCount in
 		return DiscAlreadyConnected
 	case c.node.ID() == srv.localnode.ID():
 		return DiscSelf
-	default:
-		return nil
 	}
+	// Bans are checked after the handshake, when it's known whmbler the peer
+	// is trusted or static. These are exempt from bans.
+	if !c.is(trustedConn | staticDialedConn) {
+		if err := srv.reputation.checkNode(c.node.ID(), netutil.AddrIP(c.fd.RemoteAddr())); err != nil {
+			return err
+		}
+	}
+	return srv.checkDiversity(peers, c)
 }
 
 func (srv *Server) addPeerChecks(peers map[enode.ID]*Peer, inboundCount int, c *conn) error {
@@ -901,12 +1126,12 @@ func (srv *Server) checkInboundConn(remoteIP net.IP) error {
 	if remoteIP == nil {
 		return nil
 	}
-	// Reject connections that do not match NetRestrict.
-	if srv.NetRestrict != nil && !srv.NetRestrict.Contains(remoteIP) {
-		return fmt.Errorf("not in netrestrict list")
+	// Reject connections that do not match the allow and deny lists.
+	if err := srv.netFilter.Check(remoteIP); err != nil {
+		return err
 	}
 	// Reject Internet peers that try too often.
-	now := srv.clock.Now()
+	now := srv.Clock.Now()
 	srv.inboundHistory.expire(now, nil)
 	if !netutil.IsLAN(remoteIP) && srv.inboundHistory.contains(remoteIP.String()) {
 		return fmt.Errorf("too many attempts")
@@ -925,6 +1150,9 @@ func (srv *Server) SetupConn(fd net.Conn, flags connFlag, dialDest *enode.Node)
 	} else {
 		c.transport = srv.newTransport(fd, dialDest.Pubkey())
 	}
+	if t, ok := c.transport.(*rlpxTransport); ok {
+		t.limiter = srv.egress
+	}
 
 	err := srv.setupConn(c, flags, dialDest)
 	if err != nil {
@@ -1013,6 +1241,12 @@ func (srv *Server) checkpoint(c *conn, stage chan<- *conn) error {
 
 func (srv *Server) launchPeer(c *conn) *Peer {
 	p := newPeer(srv.log, c, srv.Protocols)
+	p.reporter = srv.reportPeer
+	if srv.Capture.Dir != "" {
+		if err := p.startCapture(srv.Capture); err != nil {
+			p.log.Warn("Failed to start message capture", "err", err)
+		}
+	}
 	if srv.EnableMsgEvents {
 		// If message events are enabled, pass the peerFeed
 		// to the peer.
@@ -19,6 +19,7 @@ package p2p
 import (
 	"crypto/ecdsa"
 	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"io"
 	"math/rand"
@@ -32,6 +33,7 @@ import (
 	"github.com/mbali/go-mbali/log"
 	"github.com/mbali/go-mbali/p2p/enode"
 	"github.com/mbali/go-mbali/p2p/enr"
+	"github.com/mbali/go-mbali/p2p/nat"
 	"github.com/mbali/go-mbali/p2p/rlpx"
 )
 
@@ -571,6 +573,82 @@ func TestServerInboundThrottle(t *testing.T) {
 	}
 }
 
+func TestServerNegativeEgressRate(t *testing.T) {
+	srv := &Server{Config: Config{PrivateKey: newkey(), MaxPeers: 10, NoDial: true, MaxPeerEgressRate: -1}}
+	if err := srv.Start(); err != errNegativeEgressRate {
+		t.Fatalf("wrong error: %v", err)
+	}
+}
+
+// This test checks that the address reported by STUN ends up in the local ENR.
+func TestServerSTUN(t *testing.T) {
+	mapped := &net.UDPAddr{IP: net.IP{95, 33, 21, 2}, Port: 30399}
+	responder := startSTUNResponder(t, mapped)
+	defer responder.Close()
+
+	srv := &Server{
+		Config: Config{
+			PrivateKey: newkey(),
+			ListenAddr: "127.0.0.1:0",
+			MaxPeers:   10,
+			NoDial:     true,
+			NAT:        &nat.STUN{Servers: []string{responder.LocalAddr().String()}, Timeout: time.Second},
+			Logger:     testlog.Logger(t, log.LvlTrace),
+		},
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal("can't start: ", err)
+	}
+	defer srv.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		self := srv.Self()
+		if self.IP().Equal(mapped.IP) && self.UDP() == mapped.Port {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("STUN address not in ENR: ip %v, udp %d", self.IP(), self.UDP())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startSTUNResponder runs a STUN server on localhost which answers all binding
+// requests with the given mapped address.
+func startSTUNResponder(t *testing.T, mapped *net.UDPAddr) *net.UDPConn {
+	const magicCookie = 0x2112A442
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 1280)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n != 20 || binary.BigEndian.Uint16(buf) != 0x0001 {
+				continue
+			}
+			// Binding success response with an XOR-MAPPED-ADDRESS attribute.
+			resp := make([]byte, 32)
+			binary.BigEndian.PutUint16(resp[0:], 0x0101)
+			binary.BigEndian.PutUint16(resp[2:], 12)
+			copy(resp[4:], buf[4:20])
+			binary.BigEndian.PutUint16(resp[20:], 0x0020)
+			binary.BigEndian.PutUint16(resp[22:], 8)
+			resp[25] = 0x01
+			binary.BigEndian.PutUint16(resp[26:], uint16(mapped.Port)^(magicCookie>>16))
+			binary.BigEndian.PutUint32(resp[28:], binary.BigEndian.Uint32(mapped.IP.To4())^magicCookie)
+			conn.WriteToUDP(resp, from)
+		}
+	}()
+	return conn
+}
+
 func listenFakeAddr(network, laddr string, remoteAddr net.Addr) (net.Listener, error) {
 	l, err := net.Listen(network, laddr)
 	if err == nil {
@@ -41,6 +41,7 @@ type SimAdapter struct {
 	mtx        sync.RWMutex
 	nodes      map[enode.ID]*SimNode
 	lifecycles LifecycleConstructors
+	network    *SimNetwork
 }
 
 // NewSimAdapter creates a SimAdapter which is capable of running in-memory
@@ -55,6 +56,19 @@ func NewSimAdapter(services LifecycleConstructors) *SimAdapter {
 	}
 }
 
+// NewSimNetworkAdapter creates a SimAdapter which connects nodes through the given
+// simulated network instead of net.Pipe. The p2p servers of the nodes use the clock
+// of the network, so dial scheduling and bandwidth limits run on virtual time too.
+// Services which keep their own timers on the system clock don't follow virtual
+// time.
+func NewSimNetworkAdapter(services LifecycleConstructors, network *SimNetwork) *SimAdapter {
+	return &SimAdapter{
+		nodes:      make(map[enode.ID]*SimNode),
+		lifecycles: services,
+		network:    network,
+	}
+}
+
 // Name returns the name of the adapter for logging purposes
 func (s *SimAdapter) Name() string {
 	return "sim-adapter"
@@ -91,14 +105,19 @@ func (s *SimAdapter) NewNode(config *NodeConfig) (Node, error) {
 		return nil, err
 	}
 
+	p2pConfig := p2p.Config{
+		PrivateKey:      config.PrivateKey,
+		MaxPeers:        math.MaxInt32,
+		NoDiscovery:     true,
+		Dialer:          s,
+		EnableMsgEvents: config.EnableMsgEvents,
+	}
+	if s.network != nil {
+		p2pConfig.Dialer = &simNetworkDialer{adapter: s, id: id}
+		p2pConfig.Clock = s.network.Clock
+	}
 	n, err := node.New(&node.Config{
-		P2P: p2p.Config{
-			PrivateKey:      config.PrivateKey,
-			MaxPeers:        math.MaxInt32,
-			NoDiscovery:     true,
-			Dialer:          s,
-			EnableMsgEvents: config.EnableMsgEvents,
-		},
+		P2P:            p2pConfig,
 		ExternalSigner: config.ExternalSigner,
 		Logger:         log.New("node.id", id.String()),
 	})
@@ -120,13 +139,9 @@ func (s *SimAdapter) NewNode(config *NodeConfig) (Node, error) {
 // Dial implements the p2p.NodeDialer interface by connecting to the node using
 // an in-memory net.Pipe
 func (s *SimAdapter) Dial(ctx context.Context, dest *enode.Node) (conn net.Conn, err error) {
-	node, ok := s.GetNode(dest.ID())
-	if !ok {
-		return nil, fmt.Errorf("unknown node: %s", dest.ID())
-	}
-	srv := node.Server()
-	if srv == nil {
-		return nil, fmt.Errorf("node not running: %s", dest.ID())
+	srv, err := s.server(dest.ID())
+	if err != nil {
+		return nil, err
 	}
 	// SimAdapter.pipe is net.Pipe (NewSimAdapter)
 	pipe1, pipe2, err := s.pipe()
@@ -140,6 +155,38 @@ func (s *SimAdapter) Dial(ctx context.Context, dest *enode.Node) (conn net.Conn,
 	return pipe2, nil
 }
 
+// server returns the p2p server of a running node.
+func (s *SimAdapter) server(id enode.ID) (*p2p.Server, error) {
+	node, ok := s.GetNode(id)
+	if !ok {
+		return nil, fmt.Errorf("unknown node: %s", id)
+	}
+	srv := node.Server()
+	if srv == nil {
+		return nil, fmt.Errorf("node not running: %s", id)
+	}
+	return srv, nil
+}
+
+// simNetworkDialer dials on behalf of a single node of a SimAdapter with a simulated
+// network. It needs to know the dialing node to find the link configuration.
+type simNetworkDialer struct {
+	adapter *SimAdapter
+	id      enode.ID
+}
+
+// Dial implements the p2p.NodeDialer interface by connecting to the node through
+// the simulated network.
+func (d *simNetworkDialer) Dial(ctx context.Context, dest *enode.Node) (net.Conn, error) {
+	srv, err := d.adapter.server(dest.ID())
+	if err != nil {
+		return nil, err
+	}
+	local, remote := d.adapter.network.Pipe(d.id, dest.ID())
+	go srv.SetupConn(remote, 0, nil)
+	return local, nil
+}
+
 // DialRPC implements the RPCDialer interface by creating an in-memory RPC
 // client of the given node
 func (s *SimAdapter) DialRPC(id enode.ID) (*rpc.Client, error) {
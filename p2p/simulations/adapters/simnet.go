@@ -0,0 +1,425 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mbali/go-mbali/common/mclock"
+	"github.com/mbali/go-mbali/p2p/enode"
+)
+
+const (
+	simSegmentSize    = 1460                   // payload size of a simulated TCP segment
+	simMinRetransmit  = 200 * time.Millisecond // lower bound of the retransmission timeout
+	simMaxRetransmits = 8                      // a segment gets through after this many losses
+
+	simRunStep        = 10 * time.Millisecond  // virtual time advanced by one step of Run
+	simRunSettle      = time.Millisecond       // real time nodes get to react after each step
+	simRunIdleTimeout = 100 * time.Millisecond // max real time Run waits for delivered data to be read
+	simRunIdlePoll    = 50 * time.Microsecond  // interval of the idle check
+)
+
+// LinkConfig describes the properties of a simulated network link. The zero value
+// is a link without delay.
+type LinkConfig struct {
+	Latency   time.Duration // one-way delay
+	Bandwidth int           // bytes per second in each direction, zero means unlimited
+	Loss      float64       // probability that a segment is lost and has to be retransmitted
+}
+
+// transmitTime returns the time it takes to put n bytes on the link.
+func (l LinkConfig) transmitTime(n int) time.Duration {
+	if l.Bandwidth <= 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second / time.Duration(l.Bandwidth)
+}
+
+// SimNetwork is an in-memory network which runs on a simulated clock.
+//
+// Data written to a connection arrives at the other end after the transmission time
+// given by the link bandwidth plus the link latency. Like on a TCP connection, lost
+// segments are not dropped. Each loss stalls the stream by the retransmission
+// timeout instead. Losses are decided by random sources seeded from the network
+// seed and the link endpoints, so a simulation which writes the same data produces
+// the same delays on every run.
+//
+// Virtual time doesn't advance on its own, call Run to move it forward. Read
+// deadlines expire in virtual time as well. Node goroutines are still scheduled
+// by the Go runtime, so the network cannot guarantee that a simulation takes the
+// same course on every run.
+type SimNetwork struct {
+	Clock *mclock.Simulated
+
+	unread int64 // bytes delivered but not yet read, accessed atomically
+
+	mu          sync.Mutex
+	seed        int64
+	defaultLink LinkConfig
+	links       map[[2]enode.ID]LinkConfig
+	pipes       map[[2]enode.ID]uint64 // number of connections created per direction
+}
+
+// NewSimNetwork creates a network on the given clock. Links which aren't configured
+// with SetLink use defaultLink.
+func NewSimNetwork(clock *mclock.Simulated, seed int64, defaultLink LinkConfig) *SimNetwork {
+	return &SimNetwork{
+		Clock:       clock,
+		seed:        seed,
+		defaultLink: defaultLink,
+		links:       make(map[[2]enode.ID]LinkConfig),
+		pipes:       make(map[[2]enode.ID]uint64),
+	}
+}
+
+// SetLink configures the link between nodes a and b. The configuration applies to
+// connections created after the call.
+func (n *SimNetwork) SetLink(a, b enode.ID, config LinkConfig) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.links[linkKey(a, b)] = config
+}
+
+// Link returns the configuration of the link between nodes a and b.
+func (n *SimNetwork) Link(a, b enode.ID) LinkConfig {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if config, ok := n.links[linkKey(a, b)]; ok {
+		return config
+	}
+	return n.defaultLink
+}
+
+// Pipe creates a connection between nodes a and b. The first returned conn is the
+// end of a, the second one is the end of b.
+func (n *SimNetwork) Pipe(a, b enode.ID) (net.Conn, net.Conn) {
+	link := n.Link(a, b)
+	ca := &simConn{clock: n.Clock, link: link, local: simAddr(a), remote: simAddr(b), rand: n.newRand(a, b)}
+	cb := &simConn{clock: n.Clock, link: link, local: simAddr(b), remote: simAddr(a), rand: n.newRand(b, a)}
+	ca.in, cb.in = newSimQueue(n.Clock, &n.unread), newSimQueue(n.Clock, &n.unread)
+	ca.peer, cb.peer = cb, ca
+	return ca, cb
+}
+
+// newRand creates the random source for data sent from one node to another.
+func (n *SimNetwork) newRand(from, to enode.ID) *rand.Rand {
+	n.mu.Lock()
+	key := [2]enode.ID{from, to}
+	index := n.pipes[key]
+	n.pipes[key]++
+	n.mu.Unlock()
+
+	var buf [8]byte
+	h := fnv.New64a()
+	binary.BigEndian.PutUint64(buf[:], uint64(n.seed))
+	h.Write(buf[:])
+	h.Write(from[:])
+	h.Write(to[:])
+	binary.BigEndian.PutUint64(buf[:], index)
+	h.Write(buf[:])
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+// Run advances virtual time by d. Time moves in small steps. After each step, the
+// network waits until the nodes have read all delivered data, and then a little
+// longer to let them react to it. Nodes which don't read a connection for
+// simRunIdleTimeout of real time don't hold up the simulation.
+func (n *SimNetwork) Run(d time.Duration) {
+	for d > 0 {
+		step := simRunStep
+		if step > d {
+			step = d
+		}
+		n.Clock.Run(step)
+		n.waitIdle()
+		time.Sleep(simRunSettle)
+		d -= step
+	}
+}
+
+// waitIdle waits until all delivered data has been read.
+func (n *SimNetwork) waitIdle() {
+	deadline := time.Now().Add(simRunIdleTimeout)
+	for atomic.LoadInt64(&n.unread) > 0 && time.Now().Before(deadline) {
+		time.Sleep(simRunIdlePoll)
+	}
+}
+
+func linkKey(a, b enode.ID) [2]enode.ID {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] > b[i] {
+				a, b = b, a
+			}
+			break
+		}
+	}
+	return [2]enode.ID{a, b}
+}
+
+// simAddr is the address of a connection endpoint on the simulated network.
+type simAddr enode.ID
+
+func (a simAddr) Network() string { return "sim" }
+func (a simAddr) String() string  { return enode.ID(a).TerminalString() }
+
+// simConn is one end of a connection on the simulated network.
+type simConn struct {
+	clock         *mclock.Simulated
+	link          LinkConfig
+	local, remote simAddr
+	in            *simQueue // data sent by the remote end
+	peer          *simConn
+
+	wmu      sync.Mutex
+	rand     *rand.Rand
+	closed   bool
+	txFree   mclock.AbsTime // time when the link is free to send
+	arrival  mclock.AbsTime // arrival time of the latest write
+	inflight [][]byte       // data on the way to the peer, nil is end of stream
+}
+
+func (c *simConn) Read(b []byte) (int, error) {
+	return c.in.read(b)
+}
+
+// Write schedules the arrival of b at the other end and returns immediately.
+func (c *simConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closed || c.peer.in.isClosed() {
+		return 0, io.ErrClosedPipe
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	now := c.clock.Now()
+	start := c.txFree
+	if start < now {
+		start = now
+	}
+	c.txFree = start.Add(c.link.transmitTime(len(b)))
+	c.send(now, c.txFree.Add(c.link.Latency+c.lossDelay(len(b))), append([]byte(nil), b...))
+	return len(b), nil
+}
+
+// lossDelay returns the retransmission delay of a write with n bytes. The timeout
+// doubles for every further loss of the same segment.
+func (c *simConn) lossDelay(n int) time.Duration {
+	if c.link.Loss <= 0 {
+		return 0
+	}
+	rto := 2 * c.link.Latency
+	if rto < simMinRetransmit {
+		rto = simMinRetransmit
+	}
+	var delay time.Duration
+	for seg := 0; seg < n; seg += simSegmentSize {
+		for i := 0; i < simMaxRetransmits && c.rand.Float64() < c.link.Loss; i++ {
+			delay += rto << i
+		}
+	}
+	return delay
+}
+
+// send queues data for delivery at the given time. Data is delivered in the order
+// of send calls even if the clock fires timers with equal times in a different order.
+// This requires holding c.wmu.
+func (c *simConn) send(now, at mclock.AbsTime, data []byte) {
+	if at < c.arrival {
+		at = c.arrival
+	}
+	c.arrival = at
+	c.inflight = append(c.inflight, data)
+	if at <= now {
+		c.deliver()
+	} else {
+		c.clock.AfterFunc(time.Duration(at-now), func() {
+			c.wmu.Lock()
+			defer c.wmu.Unlock()
+			c.deliver()
+		})
+	}
+}
+
+func (c *simConn) deliver() {
+	data := c.inflight[0]
+	c.inflight = c.inflight[1:]
+	if data == nil {
+		c.peer.in.closeWrite()
+	} else {
+		c.peer.in.push(data)
+	}
+}
+
+// Close closes the connection. The other end reads io.EOF once all data written
+// before Close has arrived.
+func (c *simConn) Close() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	c.closed = true
+	c.in.close()
+	c.send(c.clock.Now(), 0, nil)
+	return nil
+}
+
+func (c *simConn) LocalAddr() net.Addr  { return c.local }
+func (c *simConn) RemoteAddr() net.Addr { return c.remote }
+
+// SetDeadline sets the read deadline. Writes never block, so there is no need for
+// a write deadline. Since net.Conn users compute deadlines from time.Now, the time
+// left until the deadline is measured in real time when it is set, and the read
+// times out once the simulated clock has advanced by that much.
+func (c *simConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *simConn) SetReadDeadline(t time.Time) error {
+	c.in.setDeadline(t)
+	return nil
+}
+
+func (c *simConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// simQueue buffers the data which has arrived at a connection endpoint.
+type simQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	clock   *mclock.Simulated
+	unread  *int64 // unread byte count of the network
+	buf     []byte
+	eof     bool // remote end closed
+	closed  bool // local end closed
+	expired bool // read deadline passed
+	timer   mclock.Timer
+	timerID uint64 // identifies the current deadline timer
+}
+
+func newSimQueue(clock *mclock.Simulated, unread *int64) *simQueue {
+	q := &simQueue{clock: clock, unread: unread}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *simQueue) push(data []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.buf = append(q.buf, data...)
+		atomic.AddInt64(q.unread, int64(len(data)))
+		q.cond.Broadcast()
+	}
+}
+
+func (q *simQueue) read(b []byte) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.buf) == 0 && !q.eof && !q.closed && !q.expired {
+		q.cond.Wait()
+	}
+	switch {
+	case q.closed:
+		return 0, io.ErrClosedPipe
+	case len(q.buf) > 0:
+		n := copy(b, q.buf)
+		q.buf = q.buf[n:]
+		atomic.AddInt64(q.unread, -int64(n))
+		return n, nil
+	case q.eof:
+		return 0, io.EOF
+	default:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func (q *simQueue) setDeadline(t time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	q.timerID++
+	q.expired = false
+	if !t.IsZero() {
+		if d := time.Until(t); d <= 0 {
+			q.expired = true
+		} else {
+			id := q.timerID
+			q.timer = q.clock.AfterFunc(d, func() {
+				q.mu.Lock()
+				defer q.mu.Unlock()
+
+				// Ignore timers of deadlines which were changed after firing.
+				if q.timerID == id {
+					q.expired = true
+					q.cond.Broadcast()
+				}
+			})
+		}
+	}
+	q.cond.Broadcast()
+}
+
+func (q *simQueue) closeWrite() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.eof = true
+	q.cond.Broadcast()
+}
+
+func (q *simQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	atomic.AddInt64(q.unread, -int64(len(q.buf)))
+	q.buf = nil
+	if q.timer != nil {
+		q.timer.Stop()
+	}
+	q.cond.Broadcast()
+}
+
+func (q *simQueue) isClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.closed
+}
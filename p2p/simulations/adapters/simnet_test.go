@@ -0,0 +1,233 @@
+// Copyright 2022 The go-mbali Authors
+// This file is part of the go-mbali library.
+//
+// The go-mbali library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mbali library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mbali library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mbali/go-mbali/common/mclock"
+	"github.com/mbali/go-mbali/crypto"
+	"github.com/mbali/go-mbali/node"
+	"github.com/mbali/go-mbali/p2p"
+	"github.com/mbali/go-mbali/p2p/enode"
+)
+
+// buffered returns the number of bytes which have arrived at c.
+func buffered(c *simConn) int {
+	c.in.mu.Lock()
+	defer c.in.mu.Unlock()
+	return len(c.in.buf)
+}
+
+func TestSimNetworkLatencyBandwidth(t *testing.T) {
+	var (
+		clock   = new(mclock.Simulated)
+		network = NewSimNetwork(clock, 1, LinkConfig{Latency: 50 * time.Millisecond, Bandwidth: 10000})
+		a, b    = enode.ID{1}, enode.ID{2}
+	)
+	ca, cb := network.Pipe(a, b)
+
+	// 1000 bytes take 100ms to transmit, the second write has to wait for the first.
+	ca.Write(make([]byte, 1000))
+	ca.Write(make([]byte, 1000))
+	clock.Run(149 * time.Millisecond)
+	if n := buffered(cb.(*simConn)); n != 0 {
+		t.Fatalf("%d bytes arrived before latency + transmission time", n)
+	}
+	clock.Run(time.Millisecond)
+	if n := buffered(cb.(*simConn)); n != 1000 {
+		t.Fatalf("%d bytes arrived after first write, want 1000", n)
+	}
+	clock.Run(100 * time.Millisecond)
+	if n := buffered(cb.(*simConn)); n != 2000 {
+		t.Fatalf("%d bytes arrived after second write, want 2000", n)
+	}
+
+	// Per-link configuration overrides the default.
+	network.SetLink(b, a, LinkConfig{})
+	ca, cb = network.Pipe(a, b)
+	ca.Write([]byte("hello"))
+	if n := buffered(cb.(*simConn)); n != 5 {
+		t.Fatalf("%d bytes arrived on link without delay, want 5", n)
+	}
+}
+
+func TestSimNetworkLoss(t *testing.T) {
+	arrival := func(seed int64, loss float64) []mclock.AbsTime {
+		var (
+			clock   = new(mclock.Simulated)
+			network = NewSimNetwork(clock, seed, LinkConfig{Latency: 10 * time.Millisecond, Loss: loss})
+			ca, _   = network.Pipe(enode.ID{1}, enode.ID{2})
+			times   []mclock.AbsTime
+		)
+		for i := 0; i < 50; i++ {
+			ca.Write(make([]byte, 3000))
+			times = append(times, ca.(*simConn).arrival)
+		}
+		return times
+	}
+
+	lossless := arrival(1, 0)
+	if lossless[len(lossless)-1] != mclock.AbsTime(10*time.Millisecond) {
+		t.Fatalf("wrong arrival time without loss: %v", lossless[len(lossless)-1])
+	}
+	lossy1, lossy2 := arrival(1, 0.2), arrival(1, 0.2)
+	for i := range lossy1 {
+		if lossy1[i] != lossy2[i] {
+			t.Fatalf("write %d: arrival times differ between runs with the same seed", i)
+		}
+		if i > 0 && lossy1[i] < lossy1[i-1] {
+			t.Fatalf("write %d arrives before write %d", i, i-1)
+		}
+	}
+	if lossy1[len(lossy1)-1] <= lossless[len(lossless)-1] {
+		t.Fatal("loss doesn't delay the stream")
+	}
+}
+
+func TestSimNetworkClose(t *testing.T) {
+	var (
+		clock   = new(mclock.Simulated)
+		network = NewSimNetwork(clock, 1, LinkConfig{Latency: 10 * time.Millisecond})
+	)
+	ca, cb := network.Pipe(enode.ID{1}, enode.ID{2})
+	ca.Write([]byte("data"))
+	ca.Close()
+	if _, err := ca.Write([]byte("more")); err != io.ErrClosedPipe {
+		t.Fatalf("wrong error for write after close: %v", err)
+	}
+
+	// The data arrives before the end of the stream.
+	clock.Run(10 * time.Millisecond)
+	buf := make([]byte, 10)
+	if n, err := cb.Read(buf); err != nil || !bytes.Equal(buf[:n], []byte("data")) {
+		t.Fatalf("wrong read result %q, %v", buf[:n], err)
+	}
+	if _, err := cb.Read(buf); err != io.EOF {
+		t.Fatalf("wrong error at end of stream: %v", err)
+	}
+
+	// Reads time out once the deadline has passed in virtual time.
+	cb.Close()
+	cb, _ = network.Pipe(enode.ID{2}, enode.ID{1})
+	cb.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	errc := make(chan error, 1)
+	go func() {
+		_, err := cb.Read(buf)
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		t.Fatalf("read returned before virtual deadline: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Run(10 * time.Millisecond)
+	if err := <-errc; err != os.ErrDeadlineExceeded {
+		t.Fatalf("wrong error after deadline: %v", err)
+	}
+}
+
+// simTimeService runs a protocol which sends the virtual send time to the peer.
+type simTimeService struct {
+	clock    mclock.Clock
+	received chan time.Duration
+}
+
+func (s *simTimeService) Start() error { return nil }
+func (s *simTimeService) Stop() error  { return nil }
+
+func (s *simTimeService) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    "simtime",
+		Version: 1,
+		Length:  1,
+		Run: func(peer *p2p.Peer, rw p2p.MsgReadWriter) error {
+			if err := p2p.Send(rw, 0, uint64(s.clock.Now())); err != nil {
+				return err
+			}
+			msg, err := rw.ReadMsg()
+			if err != nil {
+				return err
+			}
+			var sent uint64
+			if err := msg.Decode(&sent); err != nil {
+				return err
+			}
+			s.received <- time.Duration(uint64(s.clock.Now()) - sent)
+			_, err = rw.ReadMsg()
+			return err
+		},
+	}
+}
+
+// This test checks that nodes of the adapter exchange messages through the
+// simulated network.
+func TestSimNetworkAdapter(t *testing.T) {
+	var (
+		clock    = new(mclock.Simulated)
+		latency  = 100 * time.Millisecond
+		network  = NewSimNetwork(clock, 1, LinkConfig{Latency: latency})
+		received = make(chan time.Duration, 2)
+	)
+	adapter := NewSimNetworkAdapter(LifecycleConstructors{
+		"simtime": func(ctx *ServiceContext, stack *node.Node) (node.Lifecycle, error) {
+			s := &simTimeService{clock: clock, received: received}
+			stack.RegisterProtocols([]p2p.Protocol{s.protocol()})
+			return s, nil
+		},
+	}, network)
+
+	var nodes []Node
+	for i := 0; i < 2; i++ {
+		key, _ := crypto.GenerateKey()
+		config := &NodeConfig{ID: enode.PubkeyToIDV4(&key.PublicKey), PrivateKey: key, Port: 30303, Lifecycles: []string{"simtime"}}
+		n, err := adapter.NewNode(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Start(nil); err != nil {
+			t.Fatal(err)
+		}
+		defer n.Stop()
+		nodes = append(nodes, n)
+	}
+	nodes[0].(*SimNode).Server().AddPeer(nodes[1].(*SimNode).Node())
+
+	// The handshake takes a few round trips, give it plenty of virtual time.
+	deadline := clock.Now().Add(100 * latency)
+	for i := 0; i < 2; i++ {
+	wait:
+		for {
+			select {
+			case d := <-received:
+				if d < latency || d > latency+simRunStep {
+					t.Errorf("message took %v of virtual time, link latency is %v", d, latency)
+				}
+				break wait
+			default:
+				if clock.Now() > deadline {
+					t.Fatal("message not received")
+				}
+				network.Run(simRunStep)
+			}
+		}
+	}
+}
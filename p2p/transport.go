@@ -49,10 +49,16 @@ type rlpxTransport struct {
 	rmu, wmu sync.Mutex
 	wbuf     bytes.Buffer
 	conn     *rlpx.Conn
+
+	// Egress bandwidth caps, subprotocol messages are throttled if set.
+	limiter   *egressLimiter
+	bucket    tokenBucket
+	closing   chan struct{}
+	closeOnce sync.Once
 }
 
 func newRLPX(conn net.Conn, dialDest *ecdsa.PublicKey) transport {
-	return &rlpxTransport{conn: rlpx.NewConn(conn, dialDest)}
+	return &rlpxTransport{conn: rlpx.NewConn(conn, dialDest), closing: make(chan struct{})}
 }
 
 func (t *rlpxTransport) ReadMsg() (Msg, error) {
@@ -79,6 +85,20 @@ func (t *rlpxTransport) ReadMsg() (Msg, error) {
 }
 
 func (t *rlpxTransport) WriteMsg(msg Msg) error {
+	// Throttle the subprotocol messages exceeding the bandwidth caps. This
+	// happens before taking the write lock, so the base protocol messages
+	// don't queue up behind throttled writes.
+	if t.limiter != nil && msg.meterCap.Name != "" {
+		if wait := t.limiter.reserve(&t.bucket, int(msg.Size)); wait > 0 {
+			timer := t.limiter.clock.NewTimer(wait)
+			select {
+			case <-timer.C():
+			case <-t.closing:
+				timer.Stop()
+			}
+		}
+	}
+
 	t.wmu.Lock()
 	defer t.wmu.Unlock()
 
@@ -106,6 +126,9 @@ func (t *rlpxTransport) WriteMsg(msg Msg) error {
 }
 
 func (t *rlpxTransport) close(err error) {
+	// Abort throttled writes.
+	t.closeOnce.Do(func() { close(t.closing) })
+
 	t.wmu.Lock()
 	defer t.wmu.Unlock()
 
@@ -17,12 +17,15 @@
 package p2p
 
 import (
+	"bytes"
 	"errors"
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/mbali/go-mbali/common/mclock"
 	"github.com/mbali/go-mbali/crypto"
 	"github.com/mbali/go-mbali/p2p/simulations/pipes"
 )
@@ -104,6 +107,61 @@ func TestProtocolHandshake(t *testing.T) {
 	wg.Wait()
 }
 
+// This test checks that a throttled subprotocol message doesn't hold up base
+// protocol messages.
+func TestRLPXEgressThrottle(t *testing.T) {
+	prv0, _ := crypto.GenerateKey()
+	prv1, _ := crypto.GenerateKey()
+	fd0, fd1, err := pipes.TCPPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd0.Close()
+	defer fd1.Close()
+
+	var (
+		clock    = new(mclock.Simulated)
+		sender   = newRLPX(fd0, &prv1.PublicKey).(*rlpxTransport)
+		receiver = newRLPX(fd1, nil).(*rlpxTransport)
+		hsErr    = make(chan error, 1)
+	)
+	go func() {
+		_, err := receiver.doEncHandshake(prv1)
+		hsErr <- err
+	}()
+	if _, err := sender.doEncHandshake(prv0); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-hsErr; err != nil {
+		t.Fatal(err)
+	}
+	sender.limiter = newEgressLimiter(clock, 0, 100)
+
+	// The subprotocol message exceeds the peer cap by 200 bytes.
+	throttled := Msg{Code: 0x10, Size: 200, Payload: bytes.NewReader(make([]byte, 200)), meterCap: Cap{"a", 1}}
+	written := make(chan error, 1)
+	go func() { written <- sender.WriteMsg(throttled) }()
+	clock.WaitForTimers(1)
+
+	// Pings still go out while the message waits.
+	go func() { sender.WriteMsg(Msg{Code: pingMsg, Payload: bytes.NewReader(nil)}) }()
+	if msg, err := receiver.ReadMsg(); err != nil || msg.Code != pingMsg {
+		t.Fatalf("wrong message %d, %v", msg.Code, err)
+	}
+	select {
+	case err := <-written:
+		t.Fatalf("throttled write done early: %v", err)
+	default:
+	}
+	clock.Run(2 * time.Second)
+	if msg, err := receiver.ReadMsg(); err != nil || msg.Code != 0x10 {
+		t.Fatalf("wrong message %d, %v", msg.Code, err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestProtocolHandshakeErrors(t *testing.T) {
 	tests := []struct {
 		code uint64
@@ -17,9 +17,12 @@
 package params
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"math/big"
+	"sort"
+	"strconv"
 
 	"github.com/mbali/go-mbali/common"
 	"golang.org/x/crypto/sha3"
@@ -259,17 +262,17 @@ var (
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllmblashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, new(mblashConfig), nil}
+	AllmblashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, nil, nil, new(mblashConfig), nil}
 
 	// AllCliqueProtocolChanges contains every protocol change (EIPs) introduced
 	// and accepted by the mbali core developers into the Clique consensus.
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}}
+	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, nil, nil, nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}}
 
-	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, new(mblashConfig), nil}
-	TestRules       = TestChainConfig.Rules(new(big.Int), false)
+	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, nil, nil, new(mblashConfig), nil}
+	TestRules       = TestChainConfig.Rules(new(big.Int), false, 0)
 )
 
 // NetworkNames are user friendly names to use in the chain spec banner.
@@ -359,6 +362,19 @@ type ChainConfig struct {
 	ArrowGlacierBlock   *big.Int `json:"arrowGlacierBlock,omitempty"`   // Eip-4345 (bomb delay) switch block (nil = no fork, 0 = already activated)
 	MergeNetsplitBlock  *big.Int `json:"mergeNetsplitBlock,omitempty"`  // Virtual fork after The Merge to use as a network splitter
 
+	// BLS12381Block activates the EIP-2537 BLS12-381 precompiles. It is not part
+	// of the mainnet fork sequence and is meant for private networks only.
+	BLS12381Block *big.Int `json:"bls12381Block,omitempty"` // BLS12-381 precompile switch block (nil = no fork, 0 = already activated)
+
+	// CustomPrecompiles schedules natively implemented contracts registered by
+	// the embedding application. Nodes refuse to start if the set registered in
+	// the binary does not match the one recorded here.
+	CustomPrecompiles map[common.Address]*CustomPrecompile `json:"customPrecompiles,omitempty"`
+
+	// Fork scheduling switches from block numbers to timestamps here
+
+	ShanghaiTime *uint64 `json:"shanghaiTime,omitempty"` // Shanghai switch time (nil = no fork, 0 = already on shanghai)
+
 	// TerminalTotalDifficulty is the amount of total difficulty reached by
 	// the network that triggers the consensus upgrade.
 	TerminalTotalDifficulty *big.Int `json:"terminalTotalDifficulty,omitempty"`
@@ -376,6 +392,16 @@ func (c *mblashConfig) String() string {
 	return "mblash"
 }
 
+// MaxCustomPrecompiles is the maximum number of application defined precompiles.
+const MaxCustomPrecompiles = 64
+
+// CustomPrecompile is the chain config record of an application defined
+// precompiled contract.
+type CustomPrecompile struct {
+	Name  string   `json:"name"`  // Name the implementation was registered with
+	Block *big.Int `json:"block"` // Activation block (nil = never, 0 = from genesis)
+}
+
 // CliqueConfig is the consensus engine configs for proof-of-authority based sealing.
 type CliqueConfig struct {
 	Period uint64 `json:"period"` // Number of seconds between blocks to enforce
@@ -438,6 +464,12 @@ func (c *ChainConfig) String() string {
 	if c.ArrowGlacierBlock != nil {
 		banner += fmt.Sprintf(" - Arrow Glacier:               %-8v (https://github.com/mbali/execution-specs/blob/master/network-upgrades/mainnet-upgrades/arrow-glacier.md)\n", c.ArrowGlacierBlock)
 	}
+	if c.BLS12381Block != nil {
+		banner += fmt.Sprintf(" - BLS12-381 precompiles:       %-8v (EIP-2537, private network extension)\n", c.BLS12381Block)
+	}
+	for _, addr := range c.sortedCustomPrecompiles() {
+		banner += fmt.Sprintf(" - Custom precompile %-10v %-8v (%x)\n", c.CustomPrecompiles[addr].Name+":", c.CustomPrecompiles[addr].Block, addr)
+	}
 	banner += "\n"
 
 	// Add a special section for the merge as it's non-obvious
@@ -450,6 +482,12 @@ func (c *ChainConfig) String() string {
 		banner += fmt.Sprintf(" - Total terminal difficulty: %v\n", c.TerminalTotalDifficulty)
 		banner += fmt.Sprintf(" - Merge netsplit block:      %-8v", c.MergeNetsplitBlock)
 	}
+	// Create a list of timestamp based forks, scheduled after the merge
+	if c.ShanghaiTime != nil {
+		banner += "\n\n"
+		banner += "Post-Merge hard forks (timestamp based):\n"
+		banner += fmt.Sprintf(" - Shanghai:                    @%-10v (https://github.com/mbali/execution-specs/blob/master/network-upgrades/mainnet-upgrades/shanghai.md)", *c.ShanghaiTime)
+	}
 	return banner
 }
 
@@ -520,6 +558,55 @@ func (c *ChainConfig) IsArrowGlacier(num *big.Int) bool {
 	return isForked(c.ArrowGlacierBlock, num)
 }
 
+// IsBLS12381 returns whmbler num is either equal to the BLS12-381 precompile
+// (EIP-2537) activation block or greater.
+func (c *ChainConfig) IsBLS12381(num *big.Int) bool {
+	return isForked(c.BLS12381Block, num)
+}
+
+// ActiveCustomPrecompiles returns the set of application defined precompiles
+// activated at or before num. Bit i of the set stands for the i-th scheduled
+// custom precompile in ascending address order.
+func (c *ChainConfig) ActiveCustomPrecompiles(num *big.Int) uint64 {
+	var active uint64
+	for addr, p := range c.CustomPrecompiles {
+		if !isForked(p.Block, num) {
+			continue
+		}
+		// The index is computed without sorting to keep Rules allocation free.
+		index := 0
+		for other := range c.CustomPrecompiles {
+			if bytes.Compare(other[:], addr[:]) < 0 {
+				index++
+			}
+		}
+		if index < MaxCustomPrecompiles {
+			active |= 1 << uint(index)
+		}
+	}
+	return active
+}
+
+// sortedCustomPrecompiles returns the addresses of all scheduled custom
+// precompiles in ascending order.
+func (c *ChainConfig) sortedCustomPrecompiles() []common.Address {
+	if len(c.CustomPrecompiles) == 0 {
+		return nil
+	}
+	addrs := make([]common.Address, 0, len(c.CustomPrecompiles))
+	for addr := range c.CustomPrecompiles {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+	return addrs
+}
+
+// IsShanghai returns whmbler time is either equal to the Shanghai fork time or
+// greater, provided the block num is already past London.
+func (c *ChainConfig) IsShanghai(num *big.Int, time uint64) bool {
+	return c.IsLondon(num) && isTimestampForked(c.ShanghaiTime, time)
+}
+
 // IsTerminalPoWBlock returns whmbler the given block is the last block of PoW stage.
 func (c *ChainConfig) IsTerminalPoWBlock(parentTotalDiff *big.Int, totalDiff *big.Int) bool {
 	if c.TerminalTotalDifficulty == nil {
@@ -529,30 +616,43 @@ func (c *ChainConfig) IsTerminalPoWBlock(parentTotalDiff *big.Int, totalDiff *bi
 }
 
 // CheckCompatible checks whmbler scheduled fork transitions have been imported
-// with a mismatching chain configuration.
-func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, height uint64) *ConfigCompatError {
-	bhead := new(big.Int).SetUint64(height)
-
+// with a mismatching chain configuration. The height and time are the number
+// and timestamp of the local chain head.
+func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, height uint64, time uint64) *ConfigCompatError {
+	var (
+		bhead = new(big.Int).SetUint64(height)
+		btime = time
+	)
 	// Iterate checkCompatible to find the lowest conflict.
 	var lasterr *ConfigCompatError
 	for {
-		err := c.checkCompatible(newcfg, bhead)
-		if err == nil || (lasterr != nil && err.RewindTo == lasterr.RewindTo) {
+		err := c.checkCompatible(newcfg, bhead, btime)
+		if err == nil || (lasterr != nil && err.RewindTo == lasterr.RewindTo && err.RewindToTime == lasterr.RewindToTime) {
 			break
 		}
 		lasterr = err
-		bhead.SetUint64(err.RewindTo)
+
+		if err.RewindToTime > 0 {
+			btime = err.RewindToTime
+		} else {
+			bhead.SetUint64(err.RewindTo)
+		}
 	}
 	return lasterr
 }
 
 // CheckConfigForkOrder checks that we don't "skip" any forks, gombl isn't pluggable enough
-// to guarantee that forks can be implemented in a different order than on official networks
+// to guarantee that forks can be implemented in a different order than on official networks.
+//
+// BLS12381Block is exempt from the ordering. It only adds precompiles at addresses
+// which no fork of the sequence uses, so it can be scheduled independently of the
+// other forks. Its block is still part of the fork ID.
 func (c *ChainConfig) CheckConfigForkOrder() error {
 	type fork struct {
-		name     string
-		block    *big.Int
-		optional bool // if true, the fork may be nil and next fork is still allowed
+		name      string
+		block     *big.Int // forks up to the merge are block based
+		timestamp *uint64  // forks after the merge are scheduled by timestamp
+		optional  bool     // if true, the fork may be nil and next fork is still allowed
 	}
 	var lastFork fork
 	for _, cur := range []fork{
@@ -570,29 +670,48 @@ func (c *ChainConfig) CheckConfigForkOrder() error {
 		{name: "londonBlock", block: c.LondonBlock},
 		{name: "arrowGlacierBlock", block: c.ArrowGlacierBlock, optional: true},
 		{name: "mergeNetsplitBlock", block: c.MergeNetsplitBlock, optional: true},
+		{name: "shanghaiTime", timestamp: c.ShanghaiTime},
 	} {
 		if lastFork.name != "" {
-			// Next one must be higher number
-			if lastFork.block == nil && cur.block != nil {
-				return fmt.Errorf("unsupported fork ordering: %v not enabled, but %v enabled at %v",
-					lastFork.name, cur.name, cur.block)
-			}
-			if lastFork.block != nil && cur.block != nil {
-				if lastFork.block.Cmp(cur.block) > 0 {
-					return fmt.Errorf("unsupported fork ordering: %v enabled at %v, but %v enabled at %v",
+			switch {
+			// Non-optional forks must all be present in the chain config up to the last defined fork
+			case lastFork.block == nil && lastFork.timestamp == nil && (cur.block != nil || cur.timestamp != nil):
+				if cur.block != nil {
+					return fmt.Errorf("unsupported fork ordering: %v not enabled, but %v enabled at block %v",
+						lastFork.name, cur.name, cur.block)
+				}
+				return fmt.Errorf("unsupported fork ordering: %v not enabled, but %v enabled at timestamp %v",
+					lastFork.name, cur.name, *cur.timestamp)
+
+			// Fork (whmbler defined by block or timestamp) must follow the fork definition sequence
+			case (lastFork.block != nil && cur.block != nil) || (lastFork.timestamp != nil && cur.timestamp != nil):
+				if lastFork.block != nil && lastFork.block.Cmp(cur.block) > 0 {
+					return fmt.Errorf("unsupported fork ordering: %v enabled at block %v, but %v enabled at block %v",
 						lastFork.name, lastFork.block, cur.name, cur.block)
 				}
+				if lastFork.timestamp != nil && *lastFork.timestamp > *cur.timestamp {
+					return fmt.Errorf("unsupported fork ordering: %v enabled at timestamp %v, but %v enabled at timestamp %v",
+						lastFork.name, *lastFork.timestamp, cur.name, *cur.timestamp)
+				}
+
+			// Timestamp based forks can follow block based ones, but not the other way around
+			case lastFork.timestamp != nil && cur.block != nil:
+				return fmt.Errorf("unsupported fork ordering: %v used timestamp ordering, but %v reverted to block ordering",
+					lastFork.name, cur.name)
 			}
 		}
 		// If it was optional and not set, then ignore it
-		if !cur.optional || cur.block != nil {
+		if !cur.optional || (cur.block != nil || cur.timestamp != nil) {
 			lastFork = cur
 		}
 	}
+	if len(c.CustomPrecompiles) > MaxCustomPrecompiles {
+		return fmt.Errorf("too many custom precompiles: %d, limit is %d", len(c.CustomPrecompiles), MaxCustomPrecompiles)
+	}
 	return nil
 }
 
-func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *ConfigCompatError {
+func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int, headTimestamp uint64) *ConfigCompatError {
 	if isForkIncompatible(c.HomesteadBlock, newcfg.HomesteadBlock, head) {
 		return newCompatError("Homestead fork block", c.HomesteadBlock, newcfg.HomesteadBlock)
 	}
@@ -645,6 +764,46 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *Confi
 	if isForkIncompatible(c.MergeNetsplitBlock, newcfg.MergeNetsplitBlock, head) {
 		return newCompatError("Merge netsplit fork block", c.MergeNetsplitBlock, newcfg.MergeNetsplitBlock)
 	}
+	if isForkIncompatible(c.BLS12381Block, newcfg.BLS12381Block, head) {
+		return newCompatError("BLS12-381 precompile fork block", c.BLS12381Block, newcfg.BLS12381Block)
+	}
+	if err := checkCustomPrecompilesCompatible(c, newcfg, head); err != nil {
+		return err
+	}
+	if isForkTimestampIncompatible(c.ShanghaiTime, newcfg.ShanghaiTime, headTimestamp) {
+		return newTimestampCompatError("Shanghai fork timestamp", c.ShanghaiTime, newcfg.ShanghaiTime)
+	}
+	return nil
+}
+
+// checkCustomPrecompilesCompatible checks that no custom precompile already
+// active at head was added, removed, rescheduled or swapped for another one.
+func checkCustomPrecompilesCompatible(c, newcfg *ChainConfig, head *big.Int) *ConfigCompatError {
+	addrs := c.sortedCustomPrecompiles()
+	for _, addr := range newcfg.sortedCustomPrecompiles() {
+		if c.CustomPrecompiles[addr] == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	for _, addr := range addrs {
+		var (
+			stored, updated = c.CustomPrecompiles[addr], newcfg.CustomPrecompiles[addr]
+			s1, s2          *big.Int
+		)
+		if stored != nil {
+			s1 = stored.Block
+		}
+		if updated != nil {
+			s2 = updated.Block
+		}
+		what := fmt.Sprintf("custom precompile %x activation block", addr)
+		if isForkIncompatible(s1, s2, head) {
+			return newCompatError(what, s1, s2)
+		}
+		if stored != nil && updated != nil && stored.Name != updated.Name && isForked(s1, head) {
+			return newCompatError(what, s1, s2)
+		}
+	}
 	return nil
 }
 
@@ -672,14 +831,43 @@ func configNumEqual(x, y *big.Int) bool {
 	return x.Cmp(y) == 0
 }
 
+// isForkTimestampIncompatible returns true if a fork scheduled at timestamp s1
+// cannot be rescheduled to timestamp s2 because head is already past the fork.
+func isForkTimestampIncompatible(s1, s2 *uint64, head uint64) bool {
+	return (isTimestampForked(s1, head) || isTimestampForked(s2, head)) && !configTimestampEqual(s1, s2)
+}
+
+// isTimestampForked returns whmbler a fork scheduled at timestamp s is active
+// at the given head timestamp.
+func isTimestampForked(s *uint64, head uint64) bool {
+	if s == nil {
+		return false
+	}
+	return *s <= head
+}
+
+func configTimestampEqual(x, y *uint64) bool {
+	if x == nil {
+		return y == nil
+	}
+	if y == nil {
+		return x == nil
+	}
+	return *x == *y
+}
+
 // ConfigCompatError is raised if the locally-stored blockchain is initialised with a
 // ChainConfig that would alter the past.
 type ConfigCompatError struct {
 	What string
-	// block numbers of the stored and new configurations
+	// block numbers of the stored and new configurations if block based forking
 	StoredConfig, NewConfig *big.Int
+	// timestamps of the stored and new configurations if time based forking
+	StoredTime, NewTime *uint64
 	// the block number to which the local chain must be rewound to correct the error
 	RewindTo uint64
+	// the timestamp to which the local chain must be rewound to correct the error
+	RewindToTime uint64
 }
 
 func newCompatError(what string, storedblock, newblock *big.Int) *ConfigCompatError {
@@ -692,17 +880,45 @@ func newCompatError(what string, storedblock, newblock *big.Int) *ConfigCompatEr
 	default:
 		rew = newblock
 	}
-	err := &ConfigCompatError{what, storedblock, newblock, 0}
+	err := &ConfigCompatError{What: what, StoredConfig: storedblock, NewConfig: newblock}
 	if rew != nil && rew.Sign() > 0 {
 		err.RewindTo = rew.Uint64() - 1
 	}
 	return err
 }
 
+func newTimestampCompatError(what string, storedtime, newtime *uint64) *ConfigCompatError {
+	var rew *uint64
+	switch {
+	case storedtime == nil:
+		rew = newtime
+	case newtime == nil || *storedtime < *newtime:
+		rew = storedtime
+	default:
+		rew = newtime
+	}
+	err := &ConfigCompatError{What: what, StoredTime: storedtime, NewTime: newtime}
+	if rew != nil && *rew > 0 {
+		err.RewindToTime = *rew - 1
+	}
+	return err
+}
+
 func (err *ConfigCompatError) Error() string {
+	if err.StoredTime != nil || err.NewTime != nil {
+		return fmt.Sprintf("mismatching %s in database (have timestamp %s, want timestamp %s, rewindto timestamp %d)", err.What, formatTimestamp(err.StoredTime), formatTimestamp(err.NewTime), err.RewindToTime)
+	}
 	return fmt.Sprintf("mismatching %s in database (have %d, want %d, rewindto %d)", err.What, err.StoredConfig, err.NewConfig, err.RewindTo)
 }
 
+// formatTimestamp renders an optional fork timestamp for error messages.
+func formatTimestamp(time *uint64) string {
+	if time == nil {
+		return "nil"
+	}
+	return strconv.FormatUint(*time, 10)
+}
+
 // Rules wraps ChainConfig and is merely syntactic sugar or can be used for functions
 // that do not have or require information about the block.
 //
@@ -713,27 +929,35 @@ type Rules struct {
 	IsHomestead, IsEIP150, IsEIP155, IsEIP158               bool
 	IsByzantium, IsConstantinople, IsPetersburg, IsIstanbul bool
 	IsBerlin, IsLondon                                      bool
-	IsMerge                                                 bool
+	IsMerge, IsShanghai                                     bool
+	IsBLS12381                                              bool
+
+	// CustomPrecompiles is the set of application defined precompiles active
+	// at the block, see ChainConfig.ActiveCustomPrecompiles.
+	CustomPrecompiles uint64
 }
 
 // Rules ensures c's ChainID is not nil.
-func (c *ChainConfig) Rules(num *big.Int, isMerge bool) Rules {
+func (c *ChainConfig) Rules(num *big.Int, isMerge bool, timestamp uint64) Rules {
 	chainID := c.ChainID
 	if chainID == nil {
 		chainID = new(big.Int)
 	}
 	return Rules{
-		ChainID:          new(big.Int).Set(chainID),
-		IsHomestead:      c.IsHomestead(num),
-		IsEIP150:         c.IsEIP150(num),
-		IsEIP155:         c.IsEIP155(num),
-		IsEIP158:         c.IsEIP158(num),
-		IsByzantium:      c.IsByzantium(num),
-		IsConstantinople: c.IsConstantinople(num),
-		IsPetersburg:     c.IsPetersburg(num),
-		IsIstanbul:       c.IsIstanbul(num),
-		IsBerlin:         c.IsBerlin(num),
-		IsLondon:         c.IsLondon(num),
-		IsMerge:          isMerge,
+		ChainID:           new(big.Int).Set(chainID),
+		IsHomestead:       c.IsHomestead(num),
+		IsEIP150:          c.IsEIP150(num),
+		IsEIP155:          c.IsEIP155(num),
+		IsEIP158:          c.IsEIP158(num),
+		IsByzantium:       c.IsByzantium(num),
+		IsConstantinople:  c.IsConstantinople(num),
+		IsPetersburg:      c.IsPetersburg(num),
+		IsIstanbul:        c.IsIstanbul(num),
+		IsBerlin:          c.IsBerlin(num),
+		IsLondon:          c.IsLondon(num),
+		IsMerge:           isMerge,
+		IsShanghai:        c.IsShanghai(num, timestamp),
+		IsBLS12381:        c.IsBLS12381(num),
+		CustomPrecompiles: c.ActiveCustomPrecompiles(num),
 	}
 }
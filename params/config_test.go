@@ -20,13 +20,16 @@ import (
 	"math/big"
 	"reflect"
 	"testing"
+
+	"github.com/mbali/go-mbali/common"
 )
 
 func TestCheckCompatible(t *testing.T) {
 	type test struct {
-		stored, new *ChainConfig
-		head        uint64
-		wantErr     *ConfigCompatError
+		stored, new   *ChainConfig
+		head          uint64
+		headTimestamp uint64
+		wantErr       *ConfigCompatError
 	}
 	tests := []test{
 		{stored: AllmblashProtocolChanges, new: AllmblashProtocolChanges, head: 0, wantErr: nil},
@@ -59,6 +62,34 @@ func TestCheckCompatible(t *testing.T) {
 				RewindTo:     0,
 			},
 		},
+		{
+			stored: &ChainConfig{CustomPrecompiles: map[common.Address]*CustomPrecompile{{0x01}: {Name: "a", Block: big.NewInt(10)}}},
+			new:    &ChainConfig{},
+			head:   15,
+			wantErr: &ConfigCompatError{
+				What:         "custom precompile 0100000000000000000000000000000000000000 activation block",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    nil,
+				RewindTo:     9,
+			},
+		},
+		{
+			stored:  &ChainConfig{CustomPrecompiles: map[common.Address]*CustomPrecompile{{0x01}: {Name: "a", Block: big.NewInt(10)}}},
+			new:     &ChainConfig{CustomPrecompiles: map[common.Address]*CustomPrecompile{{0x01}: {Name: "a", Block: big.NewInt(20)}}},
+			head:    5,
+			wantErr: nil,
+		},
+		{
+			stored: &ChainConfig{BLS12381Block: big.NewInt(10)},
+			new:    &ChainConfig{BLS12381Block: big.NewInt(20)},
+			head:   15,
+			wantErr: &ConfigCompatError{
+				What:         "BLS12-381 precompile fork block",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    big.NewInt(20),
+				RewindTo:     9,
+			},
+		},
 		{
 			stored: &ChainConfig{HomesteadBlock: big.NewInt(30), EIP150Block: big.NewInt(10)},
 			new:    &ChainConfig{HomesteadBlock: big.NewInt(25), EIP150Block: big.NewInt(20)},
@@ -87,12 +118,121 @@ func TestCheckCompatible(t *testing.T) {
 				RewindTo:     30,
 			},
 		},
+		{
+			stored:        &ChainConfig{ShanghaiTime: newUint64(10)},
+			new:           &ChainConfig{ShanghaiTime: newUint64(20)},
+			headTimestamp: 9,
+			wantErr:       nil,
+		},
+		{
+			stored:        &ChainConfig{ShanghaiTime: newUint64(10)},
+			new:           &ChainConfig{ShanghaiTime: newUint64(20)},
+			headTimestamp: 25,
+			wantErr: &ConfigCompatError{
+				What:         "Shanghai fork timestamp",
+				StoredTime:   newUint64(10),
+				NewTime:      newUint64(20),
+				RewindToTime: 9,
+			},
+		},
+		{
+			stored:        &ChainConfig{BerlinBlock: big.NewInt(10), ShanghaiTime: newUint64(10)},
+			new:           &ChainConfig{BerlinBlock: big.NewInt(20), ShanghaiTime: newUint64(10)},
+			head:          30,
+			headTimestamp: 30,
+			wantErr: &ConfigCompatError{
+				What:         "Berlin fork block",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    big.NewInt(20),
+				RewindTo:     9,
+			},
+		},
 	}
 
 	for _, test := range tests {
-		err := test.stored.CheckCompatible(test.new, test.head)
+		err := test.stored.CheckCompatible(test.new, test.head, test.headTimestamp)
 		if !reflect.DeepEqual(err, test.wantErr) {
-			t.Errorf("error mismatch:\nstored: %v\nnew: %v\nhead: %v\nerr: %v\nwant: %v", test.stored, test.new, test.head, err, test.wantErr)
+			t.Errorf("error mismatch:\nstored: %v\nnew: %v\nhead: %v (time %v)\nerr: %v\nwant: %v", test.stored, test.new, test.head, test.headTimestamp, err, test.wantErr)
+		}
+	}
+}
+
+func TestCheckConfigForkOrder(t *testing.T) {
+	tests := []struct {
+		config  *ChainConfig
+		wantErr bool
+	}{
+		{config: AllmblashProtocolChanges, wantErr: false},
+		{config: &ChainConfig{HomesteadBlock: big.NewInt(0), EIP150Block: big.NewInt(1)}, wantErr: false},
+		{config: &ChainConfig{HomesteadBlock: big.NewInt(1), EIP150Block: big.NewInt(0)}, wantErr: true},
+		{config: &ChainConfig{EIP150Block: big.NewInt(1)}, wantErr: true},
+		{config: &ChainConfig{ShanghaiTime: newUint64(0)}, wantErr: true},
+		{config: londonWithShanghai(newUint64(10)), wantErr: false},
+		{config: londonWithShanghai(nil), wantErr: false},
+		{config: &ChainConfig{HomesteadBlock: big.NewInt(10), BLS12381Block: big.NewInt(0)}, wantErr: false},
+	}
+	for i, test := range tests {
+		err := test.config.CheckConfigForkOrder()
+		if (err != nil) != test.wantErr {
+			t.Errorf("test %d: error mismatch: have %v, want error %v", i, err, test.wantErr)
 		}
 	}
 }
+
+func TestIsShanghai(t *testing.T) {
+	config := londonWithShanghai(newUint64(10))
+	config.LondonBlock = big.NewInt(5)
+
+	tests := []struct {
+		number, time uint64
+		want         bool
+	}{
+		{4, 20, false}, // pre-London
+		{5, 9, false},  // pre-Shanghai
+		{5, 10, true},
+		{6, 20, true},
+	}
+	for _, test := range tests {
+		if have := config.IsShanghai(new(big.Int).SetUint64(test.number), test.time); have != test.want {
+			t.Errorf("block %d, time %d: IsShanghai mismatch: have %v, want %v", test.number, test.time, have, test.want)
+		}
+		if have := config.Rules(new(big.Int).SetUint64(test.number), false, test.time).IsShanghai; have != test.want {
+			t.Errorf("block %d, time %d: rules mismatch: have %v, want %v", test.number, test.time, have, test.want)
+		}
+	}
+}
+
+func TestActiveCustomPrecompiles(t *testing.T) {
+	config := &ChainConfig{CustomPrecompiles: map[common.Address]*CustomPrecompile{
+		{0x03}: {Name: "c", Block: big.NewInt(0)},
+		{0x01}: {Name: "a", Block: big.NewInt(10)},
+		{0x02}: {Name: "b", Block: big.NewInt(5)},
+	}}
+	tests := []struct {
+		number uint64
+		want   uint64
+	}{
+		{0, 0b100},
+		{5, 0b110},
+		{10, 0b111},
+	}
+	for _, test := range tests {
+		num := new(big.Int).SetUint64(test.number)
+		if have := config.ActiveCustomPrecompiles(num); have != test.want {
+			t.Errorf("block %d: active set mismatch: have %b, want %b", test.number, have, test.want)
+		}
+		if have := config.Rules(num, false, 0).CustomPrecompiles; have != test.want {
+			t.Errorf("block %d: rules mismatch: have %b, want %b", test.number, have, test.want)
+		}
+	}
+}
+
+// londonWithShanghai returns a config with all block based forks activated at
+// genesis and the given Shanghai timestamp.
+func londonWithShanghai(shanghai *uint64) *ChainConfig {
+	config := *AllmblashProtocolChanges
+	config.ShanghaiTime = shanghai
+	return &config
+}
+
+func newUint64(val uint64) *uint64 { return &val }
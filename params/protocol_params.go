@@ -71,6 +71,11 @@ const (
 	JumpdestGas   uint64 = 1     // Once per JUMPDEST operation.
 	EpochDuration uint64 = 30000 // Duration between proof-of-work epochs.
 
+	RjumpGas  uint64 = 2 // Once per RJUMP operation (EIP-4200).
+	RjumpiGas uint64 = 4 // Once per RJUMPI operation (EIP-4200).
+	CallfGas  uint64 = 5 // Once per CALLF operation (EIP-4750).
+	RetfGas   uint64 = 3 // Once per RETF operation (EIP-4750).
+
 	CreateDataGas         uint64 = 200   //
 	CallCreateDepth       uint64 = 1024  // Maximum depth of call/create stack.
 	ExpGas                uint64 = 10    // Once per EXP instruction
@@ -18,6 +18,8 @@ package tests
 
 import (
 	"testing"
+
+	"github.com/mbali/go-mbali/core/vm"
 )
 
 func TestBlockchain(t *testing.T) {
@@ -47,10 +49,10 @@ func TestBlockchain(t *testing.T) {
 	// using 4.6 TGas
 	bt.skipLoad(`.*randomStatetest94.json.*`)
 	bt.walk(t, blockTestDir, func(t *testing.T, name string, test *BlockTest) {
-		if err := bt.checkFailure(t, test.Run(false)); err != nil {
+		if err := bt.checkFailure(t, test.Run(false, vm.Config{}, nil)); err != nil {
 			t.Errorf("test without snapshotter failed: %v", err)
 		}
-		if err := bt.checkFailure(t, test.Run(true)); err != nil {
+		if err := bt.checkFailure(t, test.Run(true, vm.Config{}, nil)); err != nil {
 			t.Errorf("test with snapshotter failed: %v", err)
 		}
 	})
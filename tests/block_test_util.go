@@ -98,7 +98,16 @@ type btHeaderMarshaling struct {
 	BaseFeePerGas *math.HexOrDecimal256
 }
 
-func (t *BlockTest) Run(snapshotter bool) error {
+// Network returns the name of the fork rules the test runs under.
+func (t *BlockTest) Network() string {
+	return t.json.Network
+}
+
+// Run executes the test, importing the blocks into a fresh chain configured with
+// the given VM config and validating the resulting chain. If postCheck is set,
+// it is called with the outcome and the chain once the blocks were imported,
+// before the chain is torn down.
+func (t *BlockTest) Run(snapshotter bool, vmconfig vm.Config, postCheck func(error, *core.BlockChain)) (result error) {
 	config, ok := Forks[t.json.Network]
 	if !ok {
 		return UnsupportedForkError{t.json.Network}
@@ -127,12 +136,15 @@ func (t *BlockTest) Run(snapshotter bool) error {
 		cache.SnapshotLimit = 1
 		cache.SnapshotWait = true
 	}
-	chain, err := core.NewBlockChain(db, cache, config, engine, vm.Config{}, nil, nil)
+	chain, err := core.NewBlockChain(db, cache, config, engine, vmconfig, nil, nil)
 	if err != nil {
 		return err
 	}
 	defer chain.Stop()
 
+	if postCheck != nil {
+		defer func() { postCheck(result, chain) }()
+	}
 	validBlocks, err := t.insertBlocks(chain)
 	if err != nil {
 		return err